@@ -0,0 +1,110 @@
+package grab
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// partFilename returns the path of the temporary file of an AtomicWrite for
+// the given destination path. E.g. /tmp/example.zip.part for /tmp/example.zip.
+func partFilename(tempDir, filename string) string {
+	if tempDir == "" {
+		return filename + ".part"
+	}
+	return filepath.Join(tempDir, filepath.Base(filename)+".part")
+}
+
+// path returns the path of the local file which is written and validated for
+// the transfer of the Response. This is the temporary file of an AtomicWrite,
+// until it is committed to Response.Filename.
+func (c *Response) path() string {
+	if !c.Request.AtomicWrite || c.committed || c.Filename == "" {
+		return c.Filename
+	}
+	return partFilename(c.Request.TempDir, c.Filename)
+}
+
+// commitFile renames the temporary file of an AtomicWrite to the destination
+// path, once the transfer is complete and validated, stores the validators of
+// the remote file if Request.Conditional is set and stores the file in any
+// Client.Cache. The next stateFunc is decompressFile.
+func (c *Client) commitFile(resp *Response) stateFunc {
+	if resp.Request.writer != nil {
+		return c.closeResponse
+	}
+	if resp.Request.Conditional && resp.bytesTransferred() > 0 {
+		resp.err = writeValidators(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+	if name := resp.path(); name != resp.Filename {
+		if !resp.Request.NoCreateDirectories {
+			resp.err = mkdirp(resp.Request, resp.Filename)
+			if resp.err != nil {
+				return c.closeResponse
+			}
+		}
+		resp.err = renameFile(name, resp.Filename)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		resp.committed = true
+	}
+	resp.err = writeMetadata(resp)
+	if resp.err != nil {
+		return c.closeResponse
+	}
+	c.toCache(resp)
+	return c.decompressFile
+}
+
+// renameFile renames the file at oldpath to newpath, replacing newpath. If the
+// paths are on different file systems, the file is first copied to a temporary
+// file in the directory of newpath so that newpath is still replaced
+// atomically.
+func renameFile(oldpath, newpath string) error {
+	err := os.Rename(oldpath, newpath)
+	if err == nil {
+		return nil
+	}
+	if _, serr := os.Stat(oldpath); serr != nil {
+		return err
+	}
+	tmp := partFilename("", newpath)
+	if cerr := copyLocalFile(oldpath, tmp); cerr != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, newpath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(oldpath)
+}
+
+// copyLocalFile copies the file at src to dst, preserving its modification time.
+func copyLocalFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
+}
@@ -0,0 +1,133 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestAtomicWrite tests that files transferred with Request.AtomicWrite are
+// written to a temporary file which is renamed once validated.
+func TestAtomicWrite(t *testing.T) {
+	t.Run("Complete", func(t *testing.T) {
+		filename := ".testAtomicWrite"
+		defer os.Remove(filename)
+
+		req, _ := NewRequest(filename, ts.URL+"?size=4096")
+		req.AtomicWrite = true
+		req.AfterCopy = func(resp *Response) error {
+			if _, err := os.Stat(filename); !os.IsNotExist(err) {
+				t.Errorf("expected destination file not to exist before validation")
+			}
+			if _, err := os.Stat(filename + ".part"); err != nil {
+				t.Errorf("expected temporary file to exist: %v", err)
+			}
+			return nil
+		}
+		resp := DefaultClient.Do(req)
+		testComplete(t, resp)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if fi, err := os.Stat(filename); err != nil || fi.Size() != 4096 {
+			t.Errorf("expected complete destination file, got: %v", err)
+		}
+		if _, err := os.Stat(filename + ".part"); !os.IsNotExist(err) {
+			t.Errorf("expected temporary file to be removed")
+		}
+
+		// the complete destination file is not transferred again
+		req, _ = NewRequest(filename, ts.URL+"?size=4096")
+		req.AtomicWrite = true
+		resp = DefaultClient.Do(req)
+		testComplete(t, resp)
+		if resp.Err() != nil || !resp.DidResume || resp.BytesComplete() != 4096 {
+			t.Errorf("expected complete file to be resumed, got: %v", resp.Err())
+		}
+		if _, err := os.Stat(filename + ".part"); !os.IsNotExist(err) {
+			t.Errorf("expected no temporary file")
+		}
+	})
+
+	t.Run("BadChecksum", func(t *testing.T) {
+		filename := ".testAtomicWriteBadChecksum"
+		defer os.Remove(filename)
+		defer os.Remove(filename + ".part")
+
+		req, _ := NewRequest(filename, ts.URL+"?size=4096")
+		req.AtomicWrite = true
+		req.SetChecksum(sha256.New(), []byte{0}, false)
+		if err := DefaultClient.Do(req).Err(); err != ErrBadChecksum {
+			t.Fatalf("expected ErrBadChecksum, got: %v", err)
+		}
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("expected destination file not to exist")
+		}
+		if _, err := os.Stat(filename + ".part"); err != nil {
+			t.Errorf("expected temporary file to exist: %v", err)
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		filename := ".testAtomicWriteResume"
+		defer os.Remove(filename)
+
+		b := make([]byte, 1024)
+		for i := range b {
+			b[i] = byte(i)
+		}
+		if err := ioutil.WriteFile(filename+".part", b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		req, _ := NewRequest(filename, ts.URL+"?size=4096")
+		req.AtomicWrite = true
+		resp := DefaultClient.Do(req)
+		testComplete(t, resp)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if !resp.DidResume || resp.bytesTransferred() != 3072 {
+			t.Errorf("expected transfer to resume from temporary file")
+		}
+		testSize(t, resp.Filename, 4096)
+	})
+
+	t.Run("TempDir", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "grab")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		filename := ".testAtomicWriteTempDir"
+		defer os.Remove(filename)
+
+		req, _ := NewRequest(filename, ts.URL+"?size=4096")
+		req.AtomicWrite = true
+		req.TempDir = dir
+		req.AfterCopy = func(resp *Response) error {
+			if _, err := os.Stat(filepath.Join(dir, filename+".part")); err != nil {
+				t.Errorf("expected temporary file in TempDir: %v", err)
+			}
+			return nil
+		}
+		resp := DefaultClient.Do(req)
+		testComplete(t, resp)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		testSize(t, filename, 4096)
+	})
+}
+
+func testSize(t *testing.T, filename string, size int64) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		t.Errorf("error: %v", err)
+		return
+	}
+	if fi.Size() != size {
+		t.Errorf("expected %s to be %d bytes, got: %d", filename, size, fi.Size())
+	}
+}
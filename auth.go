@@ -0,0 +1,246 @@
+package grab
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// A CredentialProvider authenticates the HTTP requests sent to transfer files.
+// Credentials are requested before each HTTP request, including the requests
+// of each attempt and segment, so that expiring credentials can be refreshed
+// while a transfer is retried. Providers may be called concurrently.
+//
+// Providers are only called for requests to the host of the URL of the
+// Request and its subdomains. Requests to mirrors on other hosts are sent
+// without credentials, except those of NetrcCredentials, which are matched to
+// the host of each request.
+type CredentialProvider interface {
+	// Authenticate sets the credentials of the given HTTP request, such as
+	// its Authorization header. If Authenticate returns an error, the
+	// request is not sent, and the transfer fails with the same error.
+	Authenticate(ctx context.Context, req *http.Request) error
+}
+
+// A hostCredentialProvider is a CredentialProvider which scopes its
+// credentials to the host of each request, so that it may authenticate
+// requests to hosts other than that of the Request.
+type hostCredentialProvider interface {
+	// authenticateHost authenticates a request to a host other than that of
+	// the Request.
+	authenticateHost(ctx context.Context, req *http.Request) error
+}
+
+// authenticate returns a copy of the given HTTP request, authenticated by the
+// CredentialProvider of the Request of the given Response, or of the Client.
+func (c *Client) authenticate(resp *Response, hreq *http.Request) (*http.Request, error) {
+	p := resp.Request.Credentials
+	if p == nil {
+		p = c.Credentials
+	}
+	if p == nil {
+		return hreq, nil
+	}
+	authenticate := p.Authenticate
+	if resp.origin != "" && !sameOrSubdomain(hreq.URL.Hostname(), resp.origin) {
+		hp, ok := p.(hostCredentialProvider)
+		if !ok {
+			return hreq, nil
+		}
+		authenticate = hp.authenticateHost
+	}
+	hreq = hreq.Clone(hreq.Context())
+	if err := authenticate(hreq.Context(), hreq); err != nil {
+		return nil, err
+	}
+	return hreq, nil
+}
+
+// A TokenFunc is a CredentialProvider which authenticates requests with the
+// bearer token it returns, such as an OAuth 2.0 access token, so that
+// transfers from APIs which require refreshed tokens keep working across long
+// batches and retries. The function is called before each HTTP request, so it
+// should cache tokens until they expire. An oauth2.TokenSource, which caches
+// its tokens, may be adapted with:
+//
+//	grab.TokenFunc(func(ctx context.Context) (string, error) {
+//		t, err := ts.Token()
+//		if err != nil {
+//			return "", err
+//		}
+//		return t.AccessToken, nil
+//	})
+type TokenFunc func(ctx context.Context) (string, error)
+
+// Authenticate sets the Authorization header of the given HTTP request to the
+// bearer token returned by the function.
+func (f TokenFunc) Authenticate(ctx context.Context, req *http.Request) error {
+	token, err := f(ctx)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// SetBasicAuth sets the Authorization header of the HTTP requests of the
+// Request to use HTTP Basic Authentication with the given username and
+// password.
+func (r *Request) SetBasicAuth(username, password string) {
+	r.HTTPRequest.SetBasicAuth(username, password)
+}
+
+// SetBearerToken sets the Authorization header of the HTTP requests of the
+// Request to use the given bearer token, as with OAuth 2.0.
+func (r *Request) SetBearerToken(token string) {
+	r.HTTPRequest.Header.Set("Authorization", "Bearer "+token)
+}
+
+// NetrcCredentials returns a CredentialProvider which authenticates requests
+// with HTTP Basic Authentication, using the login and password of the machine
+// of the host of each request in the given .netrc file, as with curl --netrc,
+// or of its default machine. If path is empty, the file named by the NETRC
+// environment variable is read, or else .netrc in the home directory of the
+// user, or _netrc on Windows. Requests which already have an Authorization
+// header or credentials in their URL are not changed, nor are requests if the
+// file does not exist. The file is read for each request, so that it may be
+// changed by other processes. Requests to mirrors on hosts other than that of
+// the Request are only authenticated by their own machine, not the default.
+func NetrcCredentials(path string) CredentialProvider {
+	return netrcCredentials(path)
+}
+
+type netrcCredentials string
+
+func (c netrcCredentials) Authenticate(ctx context.Context, req *http.Request) error {
+	return c.authenticate(req, true)
+}
+
+func (c netrcCredentials) authenticateHost(ctx context.Context, req *http.Request) error {
+	return c.authenticate(req, false)
+}
+
+// authenticate sets the credentials of the machine of the host of the given
+// request, or if useDefault is set, of the default machine.
+func (c netrcCredentials) authenticate(req *http.Request, useDefault bool) error {
+	if req.Header.Get("Authorization") != "" || req.URL.User != nil {
+		return nil
+	}
+	path := string(c)
+	if path == "" {
+		path = netrcPath()
+	}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if login, password, ok := parseNetrcMachine(b, req.URL.Hostname(), useDefault); ok {
+		req.SetBasicAuth(login, password)
+	}
+	return nil
+}
+
+// netrcPath returns the path of the .netrc file of the user.
+func netrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	path := filepath.Join(home, ".netrc")
+	if runtime.GOOS == "windows" {
+		if _, err := os.Stat(path); err != nil {
+			path = filepath.Join(home, "_netrc")
+		}
+	}
+	return path
+}
+
+// parseNetrc returns the login and password of the given machine in the given
+// .netrc file, or of the default machine if the given machine is not listed.
+func parseNetrc(b []byte, machine string) (login, password string, ok bool) {
+	return parseNetrcMachine(b, machine, true)
+}
+
+// parseNetrcMachine returns the login and password of the given machine in the
+// given .netrc file. If useDefault is set, the default machine is used if the
+// given machine is not listed.
+func parseNetrcMachine(b []byte, machine string, useDefault bool) (login, password string, ok bool) {
+	type entry struct {
+		login, password string
+	}
+	var (
+		found, def *entry
+		cur        *entry
+	)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	inMacro := false
+	for s.Scan() {
+		line := s.Text()
+		if inMacro {
+			// macro definitions end with a blank line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			next := func() string {
+				if i+1 < len(fields) {
+					i++
+					return fields[i]
+				}
+				return ""
+			}
+			switch fields[i] {
+			case "machine":
+				cur = nil
+				if name := next(); name == machine && found == nil {
+					found = &entry{}
+					cur = found
+				}
+			case "default":
+				cur = nil
+				if def == nil {
+					def = &entry{}
+					cur = def
+				}
+			case "login":
+				if v := next(); cur != nil {
+					cur.login = v
+				}
+			case "password":
+				if v := next(); cur != nil {
+					cur.password = v
+				}
+			case "account":
+				next()
+			case "macdef":
+				next()
+				inMacro = true
+				i = len(fields)
+			default:
+				if strings.HasPrefix(fields[i], "#") {
+					i = len(fields)
+				}
+			}
+		}
+	}
+	if found == nil && useDefault {
+		found = def
+	}
+	if found == nil || found.login == "" && found.password == "" {
+		return "", "", false
+	}
+	return found.login, found.password, true
+}
@@ -0,0 +1,230 @@
+package grab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseNetrc(t *testing.T) {
+	netrc := `
+# comment
+machine example.com login alice password secret1
+machine other.example.com
+	login bob
+	password secret2
+	account ignored
+
+macdef init
+machine evil.example.com login mallory password x
+
+default login anonymous password guest
+`
+	tests := []struct {
+		machine, login, password string
+	}{
+		{"example.com", "alice", "secret1"},
+		{"other.example.com", "bob", "secret2"},
+		{"evil.example.com", "anonymous", "guest"},
+		{"unknown.example.com", "anonymous", "guest"},
+	}
+	for _, test := range tests {
+		login, password, ok := parseNetrc([]byte(netrc), test.machine)
+		if !ok || login != test.login || password != test.password {
+			t.Errorf("%s: expected %s:%s, got: %s:%s (%v)", test.machine, test.login, test.password, login, password, ok)
+		}
+	}
+	if _, _, ok := parseNetrc([]byte("machine example.com login a password b"), "other.com"); ok {
+		t.Errorf("expected no credentials without a default machine")
+	}
+}
+
+// TestCredentials tests that the HTTP requests of a transfer are
+// authenticated by the helpers and CredentialProviders of a Request.
+func TestCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "file")
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		username, password, ok := r.BasicAuth()
+		if r.Header.Get("Authorization") != "Bearer token2" && !(ok && username == "alice" && password == "secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer s.Close()
+	netrc := filepath.Join(dir, ".netrc")
+	if err := ioutil.WriteFile(netrc, []byte("machine 127.0.0.1 login alice password secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []func(req *Request){
+		func(req *Request) { req.SetBasicAuth("alice", "secret") },
+		func(req *Request) { req.SetBearerToken("token2") },
+		func(req *Request) { req.Credentials = NetrcCredentials(netrc) },
+		func(req *Request) {
+			// tokens are refreshed by each attempt
+			var n int32
+			req.Credentials = credentialFunc(func(ctx context.Context, hreq *http.Request) error {
+				if atomic.AddInt32(&n, 1) == 1 {
+					hreq.Header.Set("Authorization", "Bearer token1")
+				} else {
+					hreq.Header.Set("Authorization", "Bearer token2")
+				}
+				return nil
+			})
+			req.RetryPolicy = &RetryPolicy{
+				MaxAttempts:          2,
+				Backoff:              func(int) time.Duration { return 0 },
+				RetryableStatusCodes: []int{http.StatusUnauthorized},
+			}
+		},
+	}
+	for i, f := range tests {
+		os.Remove(filename)
+		req, _ := NewRequest(filename, s.URL)
+		f(req)
+		if err := DefaultClient.Do(req).Err(); err != nil {
+			t.Errorf("%d: %v", i, err)
+		}
+	}
+
+	// errors of credential providers fail the transfer
+	atomic.StoreInt32(&requests, 0)
+	fail := errors.New("no credentials")
+	req, _ := NewRequest(filename, s.URL)
+	req.Credentials = credentialFunc(func(context.Context, *http.Request) error { return fail })
+	if err := DefaultClient.Do(req).Err(); err != fail {
+		t.Errorf("expected %v, got: %v", fail, err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("expected no requests, got: %d", n)
+	}
+}
+
+// TestCredentialsMirrors tests that CredentialProviders do not authenticate
+// requests to mirrors on other hosts, except with the credentials of their own
+// machine in a .netrc file.
+func TestCredentialsMirrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "file")
+	var mu sync.Mutex
+	seen := make(map[string]string)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path] = r.Header.Get("Authorization")
+		mu.Unlock()
+		if r.URL.Path == "/primary" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer s.Close()
+	mirror, _ := url.Parse(fmt.Sprintf("http://localhost:%d/mirror", s.Listener.Addr().(*net.TCPAddr).Port))
+	netrc := func(name, content string) CredentialProvider {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return NetrcCredentials(path)
+	}
+
+	for i, test := range []struct {
+		credentials CredentialProvider
+		primary     string
+		mirror      string
+	}{
+		{TokenFunc(func(context.Context) (string, error) { return "secret", nil }), "Bearer secret", ""},
+		{netrc("default", "default login alice password secret\n"), "Basic YWxpY2U6c2VjcmV0", ""},
+		{netrc("machine", "machine localhost login bob password other\ndefault login alice password secret\n"), "Basic YWxpY2U6c2VjcmV0", "Basic Ym9iOm90aGVy"},
+	} {
+		seen = make(map[string]string)
+		os.Remove(filename)
+		req, _ := NewRequest(filename, s.URL+"/primary")
+		req.Credentials = test.credentials
+		req.Mirrors = []*url.URL{mirror}
+		if err := DefaultClient.Do(req).Err(); err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if seen["/primary"] != test.primary {
+			t.Errorf("%d: expected primary credentials %q, got: %q", i, test.primary, seen["/primary"])
+		}
+		if seen["/mirror"] != test.mirror {
+			t.Errorf("%d: expected mirror credentials %q, got: %q", i, test.mirror, seen["/mirror"])
+		}
+	}
+}
+
+type credentialFunc func(context.Context, *http.Request) error
+
+func (f credentialFunc) Authenticate(ctx context.Context, req *http.Request) error {
+	return f(ctx, req)
+}
+
+// TestTokenFunc tests that a TokenFunc of a Client authenticates each request
+// with a fresh bearer token, unless overridden by the Request.
+func TestTokenFunc(t *testing.T) {
+	filename := ".testTokenFunc"
+	defer os.Remove(filename)
+	var tokens []string
+	var mu sync.Mutex
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tokens = append(tokens, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.Write([]byte("hello"))
+	}))
+	defer s.Close()
+
+	var n int32
+	client := NewClient()
+	client.Credentials = TokenFunc(func(ctx context.Context) (string, error) {
+		return fmt.Sprintf("token%d", atomic.AddInt32(&n, 1)), nil
+	})
+	for i := 0; i < 2; i++ {
+		req, _ := NewRequest(filename, s.URL)
+		req.NoResume = true
+		if err := client.Do(req).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, _ := NewRequest(filename, s.URL)
+	req.NoResume = true
+	req.Credentials = TokenFunc(func(ctx context.Context) (string, error) {
+		return "override", nil
+	})
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{"Bearer token1", "Bearer token2", "Bearer override"}
+	if len(tokens) != len(expect) {
+		t.Fatalf("expected %v, got: %v", expect, tokens)
+	}
+	for i := range expect {
+		if tokens[i] != expect[i] {
+			t.Errorf("expected %s, got: %s", expect[i], tokens[i])
+		}
+	}
+}
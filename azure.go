@@ -0,0 +1,90 @@
+package grab
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AzureConfig configures the transfer of files at
+// azblob://account/container/blob URLs from Azure Blob Storage. See
+// Client.Azure.
+type AzureConfig struct {
+	// Endpoint is the base URL of the Blob service of the storage account,
+	// such as "http://127.0.0.1:10000/devstoreaccount1" for the Azurite
+	// emulator. If empty, "https://<account>.blob.core.windows.net" is used.
+	Endpoint string
+
+	// SAS is a shared access signature, such as "sv=...&sig=...", which is
+	// added to the query of each request.
+	SAS string
+
+	// Token, if not nil, returns an OAuth 2.0 access token for Azure Storage,
+	// which is sent as a bearer token with each request.
+	Token func(ctx context.Context) (string, error)
+}
+
+// azureVersion is the version of the Blob service REST API sent with each
+// request. Bearer tokens require version 2017-11-09 or later.
+const azureVersion = "2021-08-06"
+
+// azureRoundTrip sends the given request for an azblob:// URL to Azure Blob
+// Storage.
+func azureRoundTrip(c *Client, req *http.Request) (*http.Response, error) {
+	config := c.Azure
+	if config == nil {
+		config = &AzureConfig{}
+	}
+	account := req.URL.Host
+	blob := strings.TrimPrefix(req.URL.Path, "/")
+	if account == "" || !strings.Contains(blob, "/") {
+		return nil, fmt.Errorf("malformed Azure Blob URL: %s", req.URL)
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + account + ".blob.core.windows.net"
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + blob)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = req.URL.RawQuery
+	if sas := strings.TrimPrefix(config.SAS, "?"); sas != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += sas
+	}
+	hreq := req.Clone(req.Context())
+	hreq.URL = u
+	hreq.Host = u.Host
+	hreq.Header.Set("X-Ms-Version", azureVersion)
+	if config.Token != nil {
+		token, err := config.Token(req.Context())
+		if err != nil {
+			return nil, err
+		}
+		hreq.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.sendHTTP(hreq)
+}
+
+// azureChecksum returns the MD5 checksum of a blob, if it was set when the blob
+// was uploaded. Ranged responses give the checksum of the blob in the
+// x-ms-blob-content-md5 header, while the Content-MD5 header of a complete
+// response is the checksum of the blob.
+func azureChecksum(hresp *http.Response) *Checksum {
+	v := hresp.Header.Get("X-Ms-Blob-Content-Md5")
+	if v == "" && hresp.StatusCode == http.StatusOK {
+		v = hresp.Header.Get("Content-Md5")
+	}
+	sum, err := base64.StdEncoding.DecodeString(v)
+	if err != nil || len(sum) != md5.Size {
+		return nil
+	}
+	return &Checksum{Hash: md5.New(), Expected: sum}
+}
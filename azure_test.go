@@ -0,0 +1,63 @@
+package grab
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestAzure tests that blobs are transferred and resumed from Azure Blob
+// Storage with a shared access signature and validated using their MD5
+// checksum.
+func TestAzure(t *testing.T) {
+	filename := ".testAzure"
+	defer os.Remove(filename)
+
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	sum := md5.Sum(content)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sig") != "secret" || r.Header.Get("X-Ms-Version") == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != "/account/container/blob" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Ms-Blob-Content-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+		http.ServeContent(w, r, "", time.Unix(0, 0), bytes.NewReader(content))
+	}))
+	defer s.Close()
+
+	client := NewClient()
+	client.Azure = &AzureConfig{Endpoint: s.URL + "/account", SAS: "?sv=2021-08-06&sig=secret"}
+	if err := ioutil.WriteFile(filename, content[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := NewRequest(filename, "azblob://account/container/blob")
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if !resp.DidResume {
+		t.Errorf("expected transfer to resume")
+	}
+	if resp.Digest == nil || !resp.Digest.OK() {
+		t.Errorf("expected blob checksum to be validated, got: %v", resp.Digest)
+	}
+	testContent(t, filename, content)
+
+	req, _ = NewRequest(filename, "azblob://account/blob")
+	if err := client.Do(req).Err(); err == nil {
+		t.Errorf("expected error for URL without container")
+	}
+}
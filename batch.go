@@ -0,0 +1,228 @@
+package grab
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// hostSlots limits the number of concurrent transfers to each remote host.
+// The zero value is ready to use.
+type hostSlots struct {
+	mu       sync.Mutex
+	active   map[string]int
+	released chan struct{} // closed and replaced each time a slot is released
+}
+
+// acquire reserves a slot for a transfer to the given host, if fewer than
+// limit transfers to the host are active. Otherwise, acquire returns false and
+// a channel which is closed when any slot is next released. A limit less than
+// one means no limit and no slot is reserved.
+func (c *hostSlots) acquire(host string, limit int) (ok bool, released <-chan struct{}) {
+	if limit < 1 {
+		return true, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active == nil {
+		c.active = make(map[string]int)
+	}
+	if c.active[host] < limit {
+		c.active[host]++
+		return true, nil
+	}
+	if c.released == nil {
+		c.released = make(chan struct{})
+	}
+	return false, c.released
+}
+
+// release releases a slot reserved by acquire.
+func (c *hostSlots) release(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.active[host]--; c.active[host] <= 0 {
+		delete(c.active, host)
+	}
+	if c.released != nil {
+		close(c.released)
+		c.released = nil
+	}
+}
+
+// acquireNext reserves a slot for a transfer to the host of the next request
+// in the given queue, which is the queued request with the highest priority of
+// all requests to a host with an available slot. Requests with the same
+// priority are taken in queue order. It returns the index of the request in
+// the queue and the host for which a slot was reserved, or -1 and a channel
+// which is closed when any slot is next released.
+func (c *hostSlots) acquireNext(queue []*Request, limit int) (i int, host string, released <-chan struct{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	i = -1
+	priority := 0
+	for j, req := range queue {
+		p := req.Priority()
+		if i >= 0 && p <= priority {
+			continue
+		}
+		h := requestHost(req)
+		if limit > 0 && c.active[h] >= limit {
+			continue
+		}
+		i, host, priority = j, h, p
+	}
+	if i < 0 {
+		if c.released == nil {
+			c.released = make(chan struct{})
+		}
+		return -1, "", c.released
+	}
+	if limit > 0 {
+		if c.active == nil {
+			c.active = make(map[string]int)
+		}
+		c.active[host]++
+	}
+	return i, host, nil
+}
+
+// requestHost returns the remote host of the given Request, used to limit the
+// number of concurrent transfers to each host.
+func requestHost(req *Request) string {
+	if u := req.URL(); u != nil {
+		return strings.ToLower(u.Host)
+	}
+	return ""
+}
+
+// acquireHost blocks until a slot is available for a transfer to the remote
+// host of the given Request, according to Client.MaxTransfersPerHost, or the
+// given Context is canceled. It returns the host for which a slot was
+// reserved, or an empty string if no slot was required. The slot must be
+// released with releaseHost.
+func (c *Client) acquireHost(ctx context.Context, req *Request) (string, error) {
+	limit := c.MaxTransfersPerHost
+	if limit < 1 {
+		return "", nil
+	}
+	host := requestHost(req)
+	for {
+		ok, released := c.hosts.acquire(host, limit)
+		if ok {
+			return host, nil
+		}
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// releaseHost releases a slot reserved by acquireHost.
+func (c *Client) releaseHost(host string) {
+	if host != "" {
+		c.hosts.release(host)
+	}
+}
+
+// batchJob is a Request dispatched to a batch worker, with the host for which
+// a slot was reserved, and the BatchResponse and concurrencyTuner of its
+// batch, if any.
+type batchJob struct {
+	req   *Request
+	host  string
+	batch *BatchResponse
+	tuner *concurrencyTuner
+}
+
+// batchQueue is the queue of requests of a batch started with DoBatch, from
+// which workers take the next request to start as soon as they are idle.
+type batchQueue struct {
+	c     *Client
+	mu    sync.Mutex
+	queue []*Request
+
+	// ctx is canceled to cancel the batch. Queued requests are not started
+	// once ctx is canceled.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// resumed is closed when the queue is resumed, if it is paused.
+	resumed chan struct{}
+}
+
+func newBatchQueue(c *Client, requests []*Request) *batchQueue {
+	q := &batchQueue{c: c, queue: append([]*Request(nil), requests...)}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	return q
+}
+
+// next removes the next request from the queue, blocking until a slot is
+// available for a transfer to its host, if MaxTransfersPerHost is set. Queued
+// requests to a host with no available slots are deferred so that they do not
+// block requests to other hosts. next also blocks while the queue is paused.
+// next returns false once the queue is empty or canceled.
+func (q *batchQueue) next() (job batchJob, ok bool) {
+	for {
+		q.mu.Lock()
+		if len(q.queue) == 0 || q.ctx.Err() != nil {
+			q.mu.Unlock()
+			return batchJob{}, false
+		}
+		if resumed := q.resumed; resumed != nil {
+			q.mu.Unlock()
+			select {
+			case <-resumed:
+			case <-q.ctx.Done():
+			}
+			continue
+		}
+		limit := q.c.MaxTransfersPerHost
+		i, host, released := q.c.hosts.acquireNext(q.queue, limit)
+		if i >= 0 {
+			job.req = q.queue[i]
+			if limit > 0 {
+				job.host = host
+			}
+			q.queue = append(q.queue[:i], q.queue[i+1:]...)
+		}
+		q.mu.Unlock()
+		if i >= 0 {
+			return job, true
+		}
+		select {
+		case <-released:
+		case <-q.ctx.Done():
+		}
+	}
+}
+
+// pending returns the number of requests in the queue and whether the queue
+// is paused.
+func (q *batchQueue) pending() (n int, paused bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.queue), q.resumed != nil
+}
+
+// pause stops workers from taking requests from the queue until resume is
+// called.
+func (q *batchQueue) pause() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.resumed == nil {
+		q.resumed = make(chan struct{})
+	}
+}
+
+// resume allows workers to take requests from a paused queue.
+func (q *batchQueue) resume() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.resumed != nil {
+		close(q.resumed)
+		q.resumed = nil
+	}
+}
@@ -0,0 +1,360 @@
+package grab
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchResponse represents a batch of requests started with
+// Client.StartBatch. It aggregates the progress of every Response in the
+// batch so that the batch can be monitored as a whole.
+//
+// All BatchResponse method calls are thread-safe.
+type BatchResponse struct {
+	// Requests are the requests of the batch, in the order they were given.
+	Requests []*Request
+
+	// Responses receives the Response of each Request as soon as it is
+	// received from the remote server, as returned by Client.DoBatch. It is
+	// closed once all transfers have completed. The channel is buffered to
+	// receive every Response, so it need not be read.
+	Responses <-chan *Response
+
+	// Start specifies the time at which the batch started.
+	Start time.Time
+
+	// Done is closed once every transfer in the batch is finalized, either
+	// successfully or with errors.
+	Done chan struct{}
+
+	queue     *batchQueue
+	workers   int
+	tuner     *concurrencyTuner
+	store     *queueState // set by StartQueue
+	mu        sync.Mutex
+	responses []*Response
+	paused    bool
+	end       time.Time
+}
+
+// BatchSummary is a snapshot of the progress of a BatchResponse.
+type BatchSummary struct {
+	// Total is the number of requests in the batch.
+	Total int
+
+	// Queued is the number of requests which have not started.
+	Queued int
+
+	// Active is the number of transfers in progress.
+	Active int
+
+	// Completed is the number of transfers which completed successfully.
+	Completed int
+
+	// Failed is the number of transfers which failed or were canceled.
+	Failed int
+
+	// BytesComplete is the total number of bytes which have been copied to the
+	// destination of every started transfer, including resumed bytes.
+	BytesComplete int64
+
+	// Size is the expected total size of the batch. The size of each queued
+	// Request without a known Request.Size is estimated as the average size of
+	// the started transfers. Size is zero if no sizes are known.
+	Size int64
+
+	// BytesPerSecond is the combined transfer rate of the active transfers.
+	BytesPerSecond float64
+
+	// Workers is the number of concurrent workers of the batch, which changes
+	// over time if Client.AdaptiveConcurrency is set.
+	Workers int
+
+	// ETA is the estimated time at which the batch will complete, given the
+	// current BytesPerSecond and Size. If the batch has completed, ETA is the
+	// time at which the last transfer completed. If the transfer rate is
+	// unknown, ETA is zero.
+	ETA time.Time
+}
+
+// Progress returns the ratio of the expected total size of the batch that has
+// been downloaded. Multiply the returned value by 100 to return the
+// percentage completed.
+func (c BatchSummary) Progress() float64 {
+	if c.Size == 0 {
+		return 0
+	}
+	return float64(c.BytesComplete) / float64(c.Size)
+}
+
+// StartBatch is like DoBatch but returns a BatchResponse that can be used to
+// monitor the progress of the entire batch.
+func (c *Client) StartBatch(workers int, requests ...*Request) *BatchResponse {
+	b := newBatchResponse(requests)
+	b.Responses = c.doBatch(b, workers, requests)
+	return b
+}
+
+func newBatchResponse(requests []*Request) *BatchResponse {
+	return &BatchResponse{
+		Requests: requests,
+		Start:    time.Now(),
+		Done:     make(chan struct{}),
+	}
+}
+
+// add records a Response that was started by the batch. If the batch is
+// paused, the Response is paused.
+func (c *BatchResponse) add(resp *Response) {
+	c.mu.Lock()
+	c.responses = append(c.responses, resp)
+	if c.paused {
+		resp.Pause()
+	}
+	c.mu.Unlock()
+	c.save()
+}
+
+// done records that every transfer in the batch is finalized.
+func (c *BatchResponse) done() {
+	c.mu.Lock()
+	c.end = time.Now()
+	c.mu.Unlock()
+	c.save()
+	close(c.Done)
+}
+
+// Wait blocks until every transfer in the batch is completed.
+func (c *BatchResponse) Wait() {
+	<-c.Done
+}
+
+// IsComplete returns true if every transfer in the batch is finalized.
+func (c *BatchResponse) IsComplete() bool {
+	select {
+	case <-c.Done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Pause suspends every transfer in progress and stops queued requests from
+// starting until Resume is called. See Response.Pause.
+func (c *BatchResponse) Pause() {
+	c.queue.pause()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.paused = true
+	for _, resp := range c.responses {
+		resp.Pause()
+	}
+}
+
+// Resume resumes the transfers and queue of a batch suspended by Pause.
+func (c *BatchResponse) Resume() {
+	c.mu.Lock()
+	c.paused = false
+	for _, resp := range c.responses {
+		resp.Resume()
+	}
+	c.mu.Unlock()
+	c.queue.resume()
+}
+
+// IsPaused returns true if the batch is paused.
+func (c *BatchResponse) IsPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// Cancel cancels every transfer in progress and prevents queued requests from
+// starting. Completed files are kept and incomplete files may be resumed
+// later, such as from a BatchSnapshot. Cancel returns immediately; use Wait to
+// block until every transfer has stopped.
+func (c *BatchResponse) Cancel() {
+	c.queue.cancel()
+}
+
+// Summary returns a snapshot of the current progress of the batch.
+func (c *BatchResponse) Summary() BatchSummary {
+	c.mu.Lock()
+	responses := append([]*Response(nil), c.responses...)
+	end := c.end
+	c.mu.Unlock()
+
+	s := BatchSummary{Total: len(c.Requests), Workers: c.workers}
+	if c.tuner != nil {
+		s.Workers = c.tuner.workers()
+	}
+	started := make(map[*Request]bool, len(responses))
+	var knownSize int64
+	var known int
+	for _, resp := range responses {
+		started[resp.Request] = true
+		s.BytesComplete += resp.BytesComplete()
+		if resp.Size > 0 {
+			knownSize += resp.Size
+			known++
+		}
+		if !resp.IsComplete() {
+			s.Active++
+			s.BytesPerSecond += resp.BytesPerSecond()
+			continue
+		}
+		if resp.Err() != nil {
+			s.Failed++
+		} else {
+			s.Completed++
+		}
+	}
+
+	// estimate the size of queued requests
+	s.Size = knownSize
+	var unknown int
+	for _, req := range c.Requests {
+		if started[req] {
+			continue
+		}
+		s.Queued++
+		if req.Size > 0 {
+			s.Size += req.Size
+		} else {
+			unknown++
+		}
+	}
+	if unknown > 0 && known > 0 {
+		s.Size += int64(unknown) * (knownSize / int64(known))
+	}
+
+	if !end.IsZero() {
+		s.ETA = end
+	} else if s.BytesPerSecond > 0 && s.Size > s.BytesComplete {
+		secs := float64(s.Size-s.BytesComplete) / s.BytesPerSecond
+		s.ETA = time.Now().Add(time.Duration(secs * float64(time.Second)))
+	}
+	return s
+}
+
+// BatchItemState describes the state of a Request in a BatchSnapshot.
+type BatchItemState string
+
+// Batch item states.
+const (
+	BatchItemQueued   BatchItemState = "queued"
+	BatchItemActive   BatchItemState = "active"
+	BatchItemComplete BatchItemState = "complete"
+	BatchItemFailed   BatchItemState = "failed"
+)
+
+// BatchItem describes a Request in a BatchSnapshot.
+type BatchItem struct {
+	// URL is the URL of the Request.
+	URL string `json:"url"`
+
+	// Filename is the destination of the file transfer. For a queued Request,
+	// this is the Request.Filename, which may be a directory.
+	Filename string `json:"filename"`
+
+	// Size is the expected size of the file transfer, if known.
+	Size int64 `json:"size,omitempty"`
+
+	// Label is the Label of the Request.
+	Label string `json:"label,omitempty"`
+
+	// Priority is the priority of the Request. See Request.SetPriority.
+	Priority int `json:"priority,omitempty"`
+
+	// State is the state of the Request at the time of the snapshot.
+	State BatchItemState `json:"state"`
+
+	// BytesComplete is the number of bytes which had been copied to the
+	// destination at the time of the snapshot.
+	BytesComplete int64 `json:"bytesComplete,omitempty"`
+
+	// Error is the error of a failed transfer.
+	Error string `json:"error,omitempty"`
+}
+
+// BatchSnapshot is a record of the state of every Request in a batch which
+// may be persisted, such as with encoding/json, so that an interrupted batch
+// can be reloaded with Requests and started again.
+type BatchSnapshot struct {
+	// Items describes each Request in the batch, in the order they were given.
+	Items []BatchItem `json:"items"`
+}
+
+// Snapshot returns a snapshot of the state of every Request in the batch.
+func (c *BatchResponse) Snapshot() BatchSnapshot {
+	c.mu.Lock()
+	responses := make(map[*Request]*Response, len(c.responses))
+	for _, resp := range c.responses {
+		responses[resp.Request] = resp
+	}
+	c.mu.Unlock()
+
+	s := BatchSnapshot{Items: make([]BatchItem, 0, len(c.Requests))}
+	for _, req := range c.Requests {
+		item := BatchItem{
+			URL:      req.URL().String(),
+			Filename: req.Filename,
+			Size:     req.Size,
+			Label:    req.Label,
+			Priority: req.Priority(),
+			State:    BatchItemQueued,
+		}
+		if resp := responses[req]; resp != nil {
+			item.Filename = resp.Filename
+			item.BytesComplete = resp.BytesComplete()
+			if resp.Size > 0 {
+				item.Size = resp.Size
+			}
+			item.State = BatchItemActive
+			if resp.IsComplete() {
+				item.State = BatchItemComplete
+				if err := resp.Err(); err != nil {
+					item.State = BatchItemFailed
+					item.Error = err.Error()
+				}
+			}
+		}
+		s.Items = append(s.Items, item)
+	}
+	return s
+}
+
+// Requests returns a new Request for each item in the snapshot which did not
+// complete successfully, in their original order, so that the batch can be
+// started again. Partially completed files are resumed, if possible.
+//
+// Settings of the original requests which are not recorded in the snapshot,
+// such as checksums and HTTP headers, must be configured again.
+func (c BatchSnapshot) Requests() ([]*Request, error) {
+	reqs := make([]*Request, 0, len(c.Items))
+	for _, item := range c.Items {
+		if item.State == BatchItemComplete {
+			continue
+		}
+		req, err := item.request()
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// request returns a new Request for the item.
+func (c BatchItem) request() (*Request, error) {
+	req, err := NewRequest(c.Filename, c.URL)
+	if err != nil {
+		return nil, err
+	}
+	req.Label = c.Label
+	if c.State == BatchItemQueued {
+		req.Size = c.Size
+	}
+	req.SetPriority(c.Priority)
+	return req, nil
+}
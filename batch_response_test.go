@@ -0,0 +1,162 @@
+package grab
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestBatchResponse tests that the progress of a batch is aggregated from the
+// responses of each transfer.
+func TestBatchResponse(t *testing.T) {
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case "/block":
+			w.Header().Set("Content-Length", "2048")
+			if r.Method == "GET" {
+				w.Write(make([]byte, 1024))
+				w.(http.Flusher).Flush()
+				<-unblock
+				w.Write(make([]byte, 1024))
+			}
+			return
+		}
+		w.Header().Set("Content-Length", "1024")
+		w.Write(make([]byte, 1024))
+	}))
+	defer ts.Close()
+
+	reqs := make([]*Request, 0)
+	for _, name := range []string{"a", "missing", "block", "b"} {
+		req, _ := NewRequest(".testBatchResponse."+name, ts.URL+"/"+name)
+		reqs = append(reqs, req)
+	}
+	reqs[3].Size = 1024
+
+	b := DefaultClient.StartBatch(1, reqs...)
+	for i := 0; i < 3; i++ {
+		<-b.Responses
+	}
+
+	// wait for the blocked transfer to receive the first buffer
+	for {
+		s := b.Summary()
+		if s.BytesComplete == 2048 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s := b.Summary()
+	if s.Total != 4 || s.Queued != 1 || s.Active != 1 || s.Completed != 1 || s.Failed != 1 {
+		t.Errorf("unexpected summary of incomplete batch: %+v", s)
+	}
+	if s.Size != 2048+1024+1024 {
+		t.Errorf("expected size %d, got: %d", 2048+1024+1024, s.Size)
+	}
+	if b.IsComplete() {
+		t.Errorf("expected batch to be incomplete")
+	}
+
+	close(unblock)
+	b.Wait()
+	s = b.Summary()
+	if s.Queued != 0 || s.Active != 0 || s.Completed != 3 || s.Failed != 1 {
+		t.Errorf("unexpected summary of complete batch: %+v", s)
+	}
+	if s.BytesComplete != 2048+1024+1024 {
+		t.Errorf("expected %d bytes complete, got: %d", 2048+1024+1024, s.BytesComplete)
+	}
+	if s.ETA.IsZero() {
+		t.Errorf("expected ETA of complete batch")
+	}
+	for resp := range b.Responses {
+		os.Remove(resp.Filename)
+	}
+	for _, req := range reqs {
+		os.Remove(req.Filename)
+	}
+}
+
+// TestBatchResponseControl tests that a batch can be paused, resumed and
+// canceled, and reloaded from a snapshot.
+func TestBatchResponseControl(t *testing.T) {
+	ts := slowServer(1<<20, 1024)
+	defer ts.Close()
+	fast := newConcurrencyServer(nil)
+	defer fast.Close()
+
+	reqs := make([]*Request, 0)
+	for i, u := range []string{fast.URL, ts.URL, ts.URL, ts.URL} {
+		req, _ := NewRequest(fmt.Sprintf(".testBatchResponseControl.%d", i), u)
+		req.Label = fmt.Sprintf("%d", i)
+		reqs = append(reqs, req)
+	}
+	defer func() {
+		for _, req := range reqs {
+			os.Remove(req.Filename)
+		}
+	}()
+
+	b := DefaultClient.StartBatch(1, reqs...)
+	(<-b.Responses).Wait()
+	resp := <-b.Responses
+	b.Pause()
+	if !b.IsPaused() || !resp.IsPaused() {
+		t.Fatalf("expected batch to be paused")
+	}
+	time.Sleep(50 * time.Millisecond)
+	n := resp.BytesComplete()
+	time.Sleep(50 * time.Millisecond)
+	if resp.BytesComplete() != n {
+		t.Errorf("expected paused transfer to stop")
+	}
+
+	b.Resume()
+	if b.IsPaused() || resp.IsPaused() {
+		t.Errorf("expected batch to be resumed")
+	}
+	b.Cancel()
+	b.Wait()
+	if err := resp.Err(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+
+	snap := b.Snapshot()
+	expect := []BatchItemState{BatchItemComplete, BatchItemFailed, BatchItemQueued, BatchItemQueued}
+	for i, item := range snap.Items {
+		if item.State != expect[i] {
+			t.Errorf("expected item %d to be %s, got: %s", i, expect[i], item.State)
+		}
+	}
+
+	// reload the incomplete requests
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snap2 BatchSnapshot
+	if err := json.Unmarshal(data, &snap2); err != nil {
+		t.Fatal(err)
+	}
+	reqs2, err := snap2.Requests()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs2) != 3 {
+		t.Fatalf("expected 3 requests, got: %d", len(reqs2))
+	}
+	for i, req := range reqs2 {
+		if req.Label != reqs[i+1].Label || req.URL().String() != ts.URL {
+			t.Errorf("unexpected request %d: %s %s", i, req.Label, req.URL())
+		}
+	}
+}
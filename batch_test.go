@@ -0,0 +1,217 @@
+package grab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// concurrencyServer is a test server which records the maximum number of
+// concurrent GET requests it has served.
+type concurrencyServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	active int
+	max    int
+	total  *concurrencyServer // shared across servers, if not nil
+}
+
+func newConcurrencyServer(total *concurrencyServer) *concurrencyServer {
+	s := &concurrencyServer{total: total}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			s.enter()
+			defer s.leave()
+			time.Sleep(100 * time.Millisecond)
+		}
+		w.Header().Set("Content-Length", "1024")
+		w.Write(make([]byte, 1024))
+	}))
+	return s
+}
+
+func (c *concurrencyServer) enter() {
+	c.mu.Lock()
+	c.active++
+	if c.active > c.max {
+		c.max = c.active
+	}
+	c.mu.Unlock()
+	if c.total != nil {
+		c.total.enter()
+	}
+}
+
+func (c *concurrencyServer) leave() {
+	c.mu.Lock()
+	c.active--
+	c.mu.Unlock()
+	if c.total != nil {
+		c.total.leave()
+	}
+}
+
+// TestMaxTransfersPerHost tests that the number of concurrent batch transfers
+// to each remote host is limited, without blocking transfers to other hosts.
+func TestMaxTransfersPerHost(t *testing.T) {
+	total := &concurrencyServer{}
+	a := newConcurrencyServer(total)
+	defer a.Close()
+	b := newConcurrencyServer(total)
+	defer b.Close()
+
+	// requests to b are queued behind requests to a
+	reqs := make([]*Request, 0)
+	for i, u := range []string{a.URL, a.URL, a.URL, a.URL, a.URL, a.URL, b.URL, b.URL} {
+		req, _ := NewRequest(fmt.Sprintf(".testMaxTransfersPerHost.%d", i), u)
+		reqs = append(reqs, req)
+	}
+
+	client := NewClient()
+	client.MaxTransfersPerHost = 2
+	for resp := range client.DoBatch(4, reqs...) {
+		if err := resp.Err(); err != nil {
+			t.Errorf("error: %v", err)
+		}
+		os.Remove(resp.Filename)
+	}
+
+	if a.max > 2 || b.max > 2 {
+		t.Errorf("expected at most 2 concurrent transfers per host, got: %d, %d", a.max, b.max)
+	}
+	if total.max != 4 {
+		t.Errorf("expected 4 concurrent transfers across hosts, got: %d", total.max)
+	}
+
+	t.Run("DoChannel", func(t *testing.T) {
+		a.max = 0
+		reqch := make(chan *Request, 6)
+		respch := make(chan *Response, 6)
+		for i := 0; i < 6; i++ {
+			req, _ := NewRequest(fmt.Sprintf(".testMaxTransfersPerHostChannel.%d", i), a.URL)
+			reqch <- req
+		}
+		close(reqch)
+
+		client.MaxTransfersPerHost = 1
+		wg := sync.WaitGroup{}
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+			go func() {
+				client.DoChannel(reqch, respch)
+				wg.Done()
+			}()
+		}
+		wg.Wait()
+		close(respch)
+		for resp := range respch {
+			if err := resp.Err(); err != nil {
+				t.Errorf("error: %v", err)
+			}
+			os.Remove(resp.Filename)
+		}
+		if a.max != 1 {
+			t.Errorf("expected 1 concurrent transfer, got: %d", a.max)
+		}
+	})
+}
+
+// TestDoChannelContext tests that requests may be sent incrementally to a
+// worker, and that the worker and its transfer stop once the Context is
+// canceled.
+func TestDoChannelContext(t *testing.T) {
+	ts := slowServer(1<<20, 1024)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reqch := make(chan *Request)
+	respch := make(chan *Response)
+	errch := make(chan error, 1)
+	go func() {
+		errch <- DefaultClient.DoChannelContext(ctx, reqch, respch)
+	}()
+
+	// the first request completes before the next is sent
+	tsFast := newConcurrencyServer(nil)
+	defer tsFast.Close()
+	req, _ := NewRequest(".testDoChannelContext.0", tsFast.URL)
+	reqch <- req
+	resp := <-respch
+	testComplete(t, resp)
+	os.Remove(resp.Filename)
+
+	// the second request is canceled in progress
+	req, _ = NewRequest(".testDoChannelContext.1", ts.URL)
+	reqch <- req
+	resp = <-respch
+	cancel()
+	<-resp.Done
+	if err := resp.Err(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+	os.Remove(resp.Filename)
+
+	select {
+	case err := <-errch:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("worker did not stop")
+	}
+}
+
+// TestBatchPriority tests that batch requests are started in order of
+// priority, and that queued requests may be re-prioritized.
+func TestBatchPriority(t *testing.T) {
+	mu := sync.Mutex{}
+	started := make([]string, 0)
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			started = append(started, r.URL.Path)
+			mu.Unlock()
+		}
+		w.Header().Set("Content-Length", "1")
+		if r.Method == "GET" && r.URL.Path == "/block" {
+			// block the only worker until the remaining requests are queued
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-unblock
+		}
+		w.Write([]byte{0})
+	}))
+	defer ts.Close()
+
+	reqs := make([]*Request, 0)
+	for i, name := range []string{"block", "a", "b", "c", "d"} {
+		req, _ := NewRequest(".testBatchPriority."+name, ts.URL+"/"+name)
+		req.SetPriority([]int{5, 0, 0, 2, 0}[i])
+		reqs = append(reqs, req)
+	}
+
+	respch := DefaultClient.DoBatch(1, reqs...)
+	first := <-respch
+	reqs[4].SetPriority(4)
+	close(unblock)
+	for resp := range respch {
+		if err := resp.Err(); err != nil {
+			t.Errorf("error: %v", err)
+		}
+		os.Remove(resp.Filename)
+	}
+
+	os.Remove(first.Filename)
+
+	expect := []string{"/block", "/d", "/c", "/a", "/b"}
+	if fmt.Sprint(started) != fmt.Sprint(expect) {
+		t.Errorf("expected requests in order %v, got: %v", expect, started)
+	}
+}
@@ -0,0 +1,147 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// A Cache is a local directory of downloaded files, shared by every transfer
+// of a Client, so that files which are requested repeatedly, such as the
+// artifacts fetched by CI systems, are only downloaded once. To enable the
+// cache, set Client.Cache to a Cache created with NewCache.
+//
+// Files are keyed by the first checksum configured on a Request, if any, and
+// otherwise by a digest of the requested URL. If a requested file is cached
+// and its destination file does not exist, the cached file is copied to the
+// destination path instead of being downloaded. Files cached by URL are
+// assumed never to change; use checksums to key files by their content.
+//
+// All Cache method calls are thread-safe.
+type Cache struct {
+	// Dir is the directory in which files are cached. It is created if it does
+	// not exist.
+	Dir string
+
+	// Link specifies that cached files should be hard linked, rather than
+	// copied, to and from destination paths, where supported. Hard links save
+	// time and disk space, but destination files must then never be modified
+	// in place, as this would also modify the cached file.
+	Link bool
+}
+
+// NewCache returns a new Cache which stores files in the given directory.
+func NewCache(dir string) *Cache {
+	return &Cache{Dir: dir}
+}
+
+// key returns the key of the file requested by the given Request.
+func (c *Cache) key(req *Request) string {
+	if len(req.checksums) > 0 {
+		return "sum-" + hex.EncodeToString(req.checksums[0].Expected)
+	}
+	sum := sha256.Sum256([]byte(req.URL().String()))
+	return "url-" + hex.EncodeToString(sum[:])
+}
+
+// path returns the path of the cached file for the given Request.
+func (c *Cache) path(req *Request) string {
+	return filepath.Join(c.Dir, c.key(req))
+}
+
+// put stores the file at the given path in the cache for the given Request.
+func (c *Cache) put(req *Request, filename string) error {
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return err
+	}
+	name := c.path(req)
+	tmp := name + ".tmp"
+	os.Remove(tmp)
+	if err := c.link(filename, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// link hard links or copies the file at src to dst.
+func (c *Cache) link(src, dst string) error {
+	if c.Link && os.Link(src, dst) == nil {
+		return nil
+	}
+	return copyLocalFile(src, dst)
+}
+
+// fromCache copies the requested file of the given Response from Client.Cache
+// to the destination path, if it is cached. Any checksums of the Request are
+// validated before the cached file is used and invalid cached files are
+// removed. If the file is copied, Response.Cached is set and the next stateFunc
+// is verifySignature. If the file is not cached, fromCache returns nil.
+func (c *Client) fromCache(resp *Response) stateFunc {
+	req := resp.Request
+	if c.Cache == nil || req.NoCache || req.hasRange() || resp.cacheTried {
+		return nil
+	}
+	resp.cacheTried = true
+	name := c.Cache.path(req)
+	fi, err := os.Stat(name)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+
+	// validate the cached file
+	var checksums []Checksum
+	if len(req.checksums) > 0 {
+		hs := hashes(req.checksums)
+		for _, h := range hs {
+			h.Reset()
+		}
+		sums, err := checksum(req.Context(), name, hs)
+		for _, h := range hs {
+			h.Reset()
+		}
+		if err != nil {
+			return nil
+		}
+		checksums = make([]Checksum, len(req.checksums))
+		for i, sum := range req.checksums {
+			sum.Actual = sums[i]
+			checksums[i] = sum
+			if !sum.OK() {
+				c.logf(resp, slog.LevelWarn, "removing invalid cached file", "cache_file", name)
+				os.Remove(name)
+				return nil
+			}
+		}
+	}
+
+	if !req.NoCreateDirectories {
+		resp.err = mkdirp(req, resp.path())
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+	resp.err = c.Cache.link(name, resp.path())
+	if resp.err != nil {
+		return c.closeResponse
+	}
+	c.logf(resp, slog.LevelDebug, "copied file from cache", "cache_file", name)
+	resp.Cached = true
+	resp.Checksums = checksums
+	resp.Size = fi.Size()
+	resp.setBytesResumed(fi.Size())
+	return c.verifySignature
+}
+
+// toCache stores the downloaded file of the given Response in Client.Cache.
+// Errors are logged but do not fail the transfer.
+func (c *Client) toCache(resp *Response) {
+	if c.Cache == nil || resp.Request.NoCache || resp.Request.hasRange() || resp.bytesTransferred() == 0 {
+		return
+	}
+	if err := c.Cache.put(resp.Request, resp.Filename); err != nil {
+		c.logf(resp, slog.LevelWarn, "cannot cache file", "error", err)
+	}
+}
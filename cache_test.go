@@ -0,0 +1,98 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// TestCache tests that files are copied from the Cache of a Client instead of
+// being downloaded again.
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		ts.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer s.Close()
+
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	sum := sha256.Sum256(content)
+
+	for _, link := range []bool{false, true} {
+		cacheDir := filepath.Join(dir, "cache")
+		os.RemoveAll(cacheDir)
+		client := NewClient()
+		client.Cache = NewCache(cacheDir)
+		client.Cache.Link = link
+
+		get := func(filename, url string, checksum bool) *Response {
+			req, _ := NewRequest(filepath.Join(dir, filename), url)
+			if checksum {
+				req.SetChecksum(sha256.New(), sum[:], false)
+			}
+			resp := client.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			testSize(t, resp.Filename, 4096)
+			return resp
+		}
+
+		// keyed by URL
+		atomic.StoreInt32(&requests, 0)
+		if resp := get("a", s.URL+"?size=4096", false); resp.Cached {
+			t.Errorf("expected first transfer not to be cached")
+		}
+		resp := get("b", s.URL+"?size=4096", false)
+		if !resp.Cached || resp.BytesComplete() != 4096 {
+			t.Errorf("expected second transfer to be cached")
+		}
+		if n := atomic.LoadInt32(&requests); n != 1 {
+			t.Errorf("expected 1 request, got: %d", n)
+		}
+		a, _ := os.Stat(filepath.Join(dir, "a"))
+		b, _ := os.Stat(filepath.Join(dir, "b"))
+		if os.SameFile(a, b) != link {
+			t.Errorf("expected hard link to be %v", link)
+		}
+
+		// keyed by checksum
+		get("c", s.URL+"?size=4096&c", true)
+		resp = get("d", s.URL+"?size=4096&d", true)
+		if !resp.Cached || !resp.Checksums[0].OK() {
+			t.Errorf("expected transfer with same checksum to be cached")
+		}
+
+		// invalid cached files are replaced
+		name := client.Cache.path(resp.Request)
+		os.Remove(name)
+		if err := ioutil.WriteFile(name, make([]byte, 4096), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if resp := get("e", s.URL+"?size=4096", true); resp.Cached {
+			t.Errorf("expected invalid cached file not to be used")
+		}
+		if resp := get("f", s.URL+"?size=4096", true); !resp.Cached {
+			t.Errorf("expected cached file to be replaced")
+		}
+
+		for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+			os.Remove(filepath.Join(dir, name))
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package grab
+
+import (
+	"bytes"
+	"encoding"
+	"hash"
+	"io"
+	"os"
+	"sync"
+)
+
+// A Checksum describes the validation of a downloaded file using one hash
+// algorithm, as configured with Request.SetChecksum or Request.AddChecksum.
+type Checksum struct {
+	// Hash is the hash algorithm used to compute the checksum.
+	Hash hash.Hash
+
+	// Expected is the expected checksum of the downloaded file.
+	Expected []byte
+
+	// Actual is the checksum computed from the downloaded file. Actual is nil
+	// until the checksum has been computed.
+	Actual []byte
+
+	// alternatives are other accepted values of Expected, such as the
+	// digests of integrity metadata which lists several of the same
+	// algorithm. See Request.SetIntegrity.
+	alternatives [][]byte
+}
+
+// OK returns true if the computed checksum matches the expected checksum.
+func (c Checksum) OK() bool {
+	if c.Actual == nil {
+		return false
+	}
+	if bytes.Equal(c.Actual, c.Expected) {
+		return true
+	}
+	for _, sum := range c.alternatives {
+		if bytes.Equal(c.Actual, sum) {
+			return true
+		}
+	}
+	return false
+}
+
+// hashes returns the hash of each of the given checksums.
+func hashes(sums []Checksum) []hash.Hash {
+	hs := make([]hash.Hash, len(sums))
+	for i, sum := range sums {
+		hs[i] = sum.Hash
+	}
+	return hs
+}
+
+// hashWriter feeds the content of a transfer into the hashes of a Request as it
+// is written to the destination file, so that the checksums are known as soon
+// as the transfer completes.
+type hashWriter struct {
+	mu sync.Mutex
+	hs []hash.Hash
+	n  int64
+}
+
+func (c *hashWriter) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, h := range c.hs {
+		// hash.Hash never returns an error
+		h.Write(p)
+	}
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// snapshot returns the number of bytes written to the hashes and the
+// marshaled state of each hash. If any hash does not support
+// encoding.BinaryMarshaler, no states are returned.
+func (c *hashWriter) snapshot() (n int64, states [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	states = make([][]byte, len(c.hs))
+	for i, h := range c.hs {
+		m, ok := h.(encoding.BinaryMarshaler)
+		if !ok {
+			return c.n, nil
+		}
+		state, err := m.MarshalBinary()
+		if err != nil {
+			return c.n, nil
+		}
+		states[i] = state
+	}
+	return c.n, states
+}
+
+// sums returns the checksum of all bytes written to each hash.
+func (c *hashWriter) sums() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sums := make([][]byte, len(c.hs))
+	for i, h := range c.hs {
+		sums[i] = h.Sum(nil)
+	}
+	return sums
+}
+
+// restoreHashes restores the given hashes from their marshaled states, and
+// returns false if any hash could not be restored.
+func restoreHashes(hs []hash.Hash, states [][]byte) bool {
+	if len(states) != len(hs) {
+		return false
+	}
+	for i, h := range hs {
+		u, ok := h.(encoding.BinaryUnmarshaler)
+		if !ok {
+			return false
+		}
+		if err := u.UnmarshalBinary(states[i]); err != nil {
+			// state is for another hash algorithm
+			return false
+		}
+	}
+	return true
+}
+
+// primeChecksum resets the hashes of a Request before its content is streamed
+// into the hashes. If the transfer is resumed, the hashes are restored from the
+// state persisted by a previous transfer, or computed from the bytes already
+// in the destination file.
+func (c *Client) primeChecksum(resp *Response) error {
+	hs := resp.hasher.hs
+	for _, h := range hs {
+		h.Reset()
+	}
+	if resp.bytesResumed == 0 {
+		return nil
+	}
+	if restoreHashes(hs, resp.hashStates) {
+		return nil
+	}
+	for _, h := range hs {
+		h.Reset()
+	}
+
+	f, err := os.Open(resp.path())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := &hashWriter{hs: hs}
+	t := newTransfer(resp.ctx, nil, nil, w, io.LimitReader(f, resp.bytesResumed), 0)
+	n, err := t.copy()
+	if err != nil {
+		return err
+	}
+	if n != resp.bytesResumed {
+		return ErrBadLength
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package grab
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// A CircuitBreaker tracks the failures of the transfers of a Client to each
+// remote host, and skips hosts which are consistently failing, so that a large
+// batch does not spend its retries on a dead server or mirror.
+//
+// Once FailureThreshold consecutive attempts to a host fail, the circuit of
+// the host opens and requests to the host fail immediately with
+// ErrHostCircuitOpen, failing over to any Mirrors. After Cooldown, a single
+// attempt is let through to test the host. If it succeeds, the circuit closes.
+// Otherwise, it opens for another Cooldown. Retries of transfers which failed
+// because of an open circuit wait until the circuit lets an attempt through.
+//
+// A CircuitBreaker is safe for concurrent use and may be shared by multiple
+// Clients.
+type CircuitBreaker struct {
+	// FailureThreshold is the number of consecutive failed attempts to a host
+	// after which its circuit opens. Default: 5.
+	FailureThreshold int
+
+	// Cooldown is how long the circuit of a host stays open before an
+	// attempt is let through. Default: 30 seconds.
+	Cooldown time.Duration
+
+	// IsFailure, if not nil, decides whether the error of a failed attempt
+	// counts as a failure of the host. By default, the errors which are
+	// retried by a RetryPolicy with no ShouldRetry function are failures,
+	// such as network errors, timeouts, stalls and 5xx status codes.
+	IsFailure func(err error) bool
+
+	mu    sync.Mutex
+	hosts map[string]*circuit
+}
+
+// circuit is the state of the circuit of a host.
+type circuit struct {
+	failures  int
+	openUntil time.Time
+
+	// trial is the Response of the attempt which was let through once the
+	// circuit was open for the cooldown.
+	trial *Response
+}
+
+func (c *CircuitBreaker) threshold() int {
+	if c.FailureThreshold < 1 {
+		return 5
+	}
+	return c.FailureThreshold
+}
+
+func (c *CircuitBreaker) cooldown() time.Duration {
+	if c.Cooldown <= 0 {
+		return 30 * time.Second
+	}
+	return c.Cooldown
+}
+
+func (c *CircuitBreaker) isFailure(err error) bool {
+	if c.IsFailure != nil {
+		return c.IsFailure(err)
+	}
+	return (&RetryPolicy{}).retryable(err)
+}
+
+// allow returns true if a request of the transfer of the given Response may
+// be sent to the given host. Otherwise, it returns the time at which the
+// circuit of the host will let an attempt through.
+func (c *CircuitBreaker) allow(host string, resp *Response) (bool, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	h := c.hosts[host]
+	if h == nil || h.failures < c.threshold() || h.trial == resp {
+		return true, time.Time{}
+	}
+	now := time.Now()
+	if now.Before(h.openUntil) {
+		return false, h.openUntil
+	}
+	// half-open: let this attempt through and keep the circuit open for
+	// others, until the attempt succeeds or fails
+	h.trial = resp
+	h.openUntil = now.Add(c.cooldown())
+	return true, time.Time{}
+}
+
+// reopens returns the time at which the circuit of the given host will let an
+// attempt through, or the zero time if it is closed.
+func (c *CircuitBreaker) reopens(host string) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if h := c.hosts[host]; h != nil && h.failures >= c.threshold() {
+		return h.openUntil
+	}
+	return time.Time{}
+}
+
+// record records the result of an attempt to transfer a file from the given
+// host, and returns true if the attempt opened its circuit.
+func (c *CircuitBreaker) record(host string, err error) bool {
+	if errors.Is(err, ErrHostCircuitOpen) {
+		return false
+	}
+	if err != nil && !c.isFailure(err) {
+		// the host responded, such as with 404 Not Found
+		err = nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		delete(c.hosts, host)
+		return false
+	}
+	if c.hosts == nil {
+		c.hosts = make(map[string]*circuit)
+	}
+	h := c.hosts[host]
+	if h == nil {
+		h = &circuit{}
+		c.hosts[host] = h
+	}
+	h.failures++
+	h.trial = nil
+	if h.failures >= c.threshold() {
+		h.openUntil = time.Now().Add(c.cooldown())
+		return true
+	}
+	return false
+}
+
+// checkCircuit returns an error wrapping ErrHostCircuitOpen if the circuit of
+// the host of the given URL is open.
+func (c *Client) checkCircuit(resp *Response, u *url.URL) error {
+	if c.CircuitBreaker == nil {
+		return nil
+	}
+	if ok, until := c.CircuitBreaker.allow(u.Host, resp); !ok {
+		return fmt.Errorf("%w: %s until %s", ErrHostCircuitOpen, u.Host, until.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// recordCircuit records the result of the current attempt of the given
+// Response with the CircuitBreaker of the Client.
+func (c *Client) recordCircuit(resp *Response, err error) {
+	if c.CircuitBreaker == nil {
+		return
+	}
+	host := resp.Request.HTTPRequest.URL.Host
+	if c.CircuitBreaker.record(host, err) {
+		c.logf(resp, slog.LevelWarn, "host circuit open",
+			"host", host,
+			"error", err)
+	}
+}
@@ -0,0 +1,109 @@
+package grab
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestCircuitBreaker tests that transfers skip a failing host once its
+// circuit opens, and that the circuit closes once the host recovers.
+func TestCircuitBreaker(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var hits, down int32 = 0, 1
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer dead.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer mirror.Close()
+	mirrorURL, _ := url.Parse(mirror.URL + "/file")
+
+	client := NewClient()
+	client.CircuitBreaker = &CircuitBreaker{FailureThreshold: 2, Cooldown: 100 * time.Millisecond}
+	transfer := func(mirrors ...*url.URL) error {
+		req, _ := NewRequest(filepath.Join(dir, "file"), dead.URL+"/file")
+		req.NoResume = true
+		req.Mirrors = mirrors
+		return client.Do(req).Err()
+	}
+	expectHits := func(expect int32) {
+		t.Helper()
+		if n := atomic.SwapInt32(&hits, 0); n != expect {
+			t.Errorf("expected %d requests to the failing host, got: %d", expect, n)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := transfer(mirrorURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectHits(2)
+	if err := transfer(); !errors.Is(err, ErrHostCircuitOpen) || !errors.Is(err, ErrNetwork) {
+		t.Errorf("expected ErrHostCircuitOpen, got: %v", err)
+	}
+	expectHits(0)
+
+	// a single attempt is let through after the cooldown, which reopens
+	// the circuit if it fails
+	time.Sleep(150 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if err := transfer(mirrorURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectHits(1)
+
+	// retries wait for the circuit to let an attempt through
+	atomic.StoreInt32(&down, 0)
+	req, _ := NewRequest(filepath.Join(dir, "file"), dead.URL+"/file")
+	req.NoResume = true
+	req.RetryPolicy = &RetryPolicy{MaxAttempts: 2, Backoff: func(int) time.Duration { return 0 }}
+	start := time.Now()
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("expected retry to wait for the cooldown, took: %v", d)
+	}
+	expectHits(1)
+
+	// the circuit is closed
+	for i := 0; i < 3; i++ {
+		if err := transfer(mirrorURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectHits(3)
+
+	// responses such as 404 Not Found do not count as failures
+	client.CircuitBreaker = &CircuitBreaker{FailureThreshold: 1}
+	host := dead.Listener.Addr().String()
+	client.CircuitBreaker.record(host, StatusCodeError(http.StatusNotFound))
+	if !client.CircuitBreaker.reopens(host).IsZero() {
+		t.Errorf("expected circuit to be closed after 404 Not Found")
+	}
+	client.CircuitBreaker.record(host, StatusCodeError(http.StatusBadGateway))
+	if client.CircuitBreaker.reopens(host).IsZero() {
+		t.Errorf("expected circuit to be open after 502 Bad Gateway")
+	}
+}
@@ -1,12 +1,14 @@
 package grab
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
 	"sync"
 	"time"
 )
@@ -25,12 +27,192 @@ type Client struct {
 	// The user agent string may be overridden in the headers of each request.
 	UserAgent string
 
+	// DefaultHeaders are the headers which are sent with every HTTP request
+	// made by this client, unless the header is already set by the request or
+	// by a matching HeaderRule.
+	DefaultHeaders http.Header
+
+	// HeaderRules set headers for requests to matching hosts, such as the
+	// Referer and User-Agent that a CDN requires. Rules are applied in order
+	// and headers already set by a request or an earlier rule take
+	// precedence. See HeaderRule.
+	HeaderRules []HeaderRule
+
 	// BufferSize specifies the size in bytes of the buffer that is used for
 	// transferring all requested files. Larger buffers may result in faster
 	// throughput but will use more memory and result in less frequent updates
-	// to the transfer progress statistics. The BufferSize of each request can
-	// be overridden on each Request object. Default: 32KB.
+	// to the transfer progress statistics. Buffers are pooled and reused by
+	// subsequent transfers of the same buffer size. The BufferSize of each
+	// request can be overridden on each Request object. Default: 32KB.
 	BufferSize int
+
+	// RetryPolicy specifies how transfers which fail because of a transient
+	// error are retried. The RetryPolicy of each request can be overridden on
+	// each Request object. If nil, failed transfers are not retried.
+	RetryPolicy *RetryPolicy
+
+	// MaxTransfersPerHost limits the number of concurrent transfers to any one
+	// remote host in calls to DoBatch and DoChannel, to avoid overloading, or
+	// being banned by, a remote server. The limit is shared by all batches run
+	// by the Client and applies to the host of the URL of each Request, even if
+	// the transfer fails over to a mirror. If less than one, the number of
+	// transfers to each host is not limited.
+	MaxTransfersPerHost int
+
+	// BeforeRequest is called before each HTTP request is sent to transfer a
+	// file. It may be overridden by Request.BeforeRequest. See RequestHook.
+	BeforeRequest RequestHook
+
+	// AfterResponse is called with each HTTP response received while
+	// transferring a file. It may be overridden by Request.AfterResponse. See
+	// ResponseHook.
+	AfterResponse ResponseHook
+
+	// BeforeValidate is called once a file is transferred, or found to be
+	// complete already, before it is validated using any checksums or
+	// signature. It may be used to implement custom validation. It may be
+	// overridden by Request.BeforeValidate.
+	BeforeValidate Hook
+
+	// AfterComplete is called once a transfer is finalized, successfully or
+	// otherwise, after Response.Done is closed. It may be used to log or record
+	// metrics of each transfer. It may be overridden by Request.AfterComplete.
+	AfterComplete func(*Response)
+
+	// AdaptiveConcurrency, if not nil, tunes the number of workers of each
+	// batch started with DoBatch or StartBatch to its observed throughput and
+	// error rate. See AdaptiveConcurrency.
+	AdaptiveConcurrency *AdaptiveConcurrency
+
+	// CircuitBreaker, if not nil, tracks the failures of transfers to each
+	// remote host and skips hosts which are consistently failing. See
+	// CircuitBreaker.
+	CircuitBreaker *CircuitBreaker
+
+	// Metrics, if not nil, records metrics of every transfer sent by the
+	// Client. See NewMetrics.
+	Metrics *Metrics
+
+	// Tracer, if not nil, starts a span for every transfer sent by the Client
+	// and for each of its HTTP requests. See Tracer.
+	Tracer Tracer
+
+	// Logger, if not nil, logs events of every transfer sent by the Client,
+	// such as the start of each attempt, whether a transfer is resumed,
+	// redirects, retries and the result of validation.
+	Logger *slog.Logger
+
+	// Cache, if not nil, stores every file downloaded by the Client so that
+	// files which are requested again are copied from the cache instead of
+	// being downloaded. See Cache.
+	Cache *Cache
+
+	// S3 configures the transfer of files at s3://bucket/key URLs. If nil, the
+	// region and credentials are read from the standard AWS environment
+	// variables. See S3Config.
+	S3 *S3Config
+
+	// GCS configures the transfer of files at gs://bucket/object URLs. If nil,
+	// any access token is read from the environment. See GCSConfig.
+	GCS *GCSConfig
+
+	// Azure configures the transfer of files at
+	// azblob://account/container/blob URLs. If nil, requests are not
+	// authenticated. See AzureConfig.
+	Azure *AzureConfig
+
+	// OCI configures the transfer of blobs at oci://registry/repository URLs
+	// from OCI and Docker registries. If nil, registries are accessed
+	// anonymously, with HTTPS. See OCIConfig.
+	OCI *OCIConfig
+
+	// Credentials, if not nil, authenticates each HTTP request sent to the
+	// host of a Request to transfer a file, unless overridden by
+	// Request.Credentials, such as with NetrcCredentials. See
+	// CredentialProvider.
+	Credentials CredentialProvider
+
+	// HTTPSOnly specifies that requests, and redirects, to http:// and ftp://
+	// URLs should fail with ErrInsecure, so that files are only transferred
+	// over encrypted connections.
+	HTTPSOnly bool
+
+	// TLS, if not nil, configures the TLS connections of all HTTPS requests
+	// sent by the Client, such as the minimum TLS version and the trusted root
+	// certificate authorities. It may be extended by Request.TLS. See
+	// TLSPolicy.
+	TLS *TLSPolicy
+
+	// Resolver, if not nil, resolves the hostnames of the HTTP connections of
+	// the Client, including those of proxies, instead of the DNS resolver of
+	// the system, such as with static host mappings or DNS-over-HTTPS. It
+	// requires the Transport of HTTPClient to be a *http.Transport. See
+	// Resolver.
+	Resolver Resolver
+
+	// IPMode specifies whether the IPv4 or IPv6 addresses of remote hosts
+	// are used or preferred by the HTTP connections of the Client, without
+	// replacing its Transport. It requires the Transport of HTTPClient to be
+	// a *http.Transport. See IPMode.
+	IPMode IPMode
+
+	// FallbackDelay specifies how long to wait for a connection to the
+	// addresses of the preferred IP version of a host before racing a
+	// connection to the addresses of the other version, as with Happy
+	// Eyeballs. If zero, a delay of 300ms is used. If negative, the addresses
+	// of the other version are only dialed once all of the preferred
+	// addresses have failed.
+	FallbackDelay time.Duration
+
+	// HTTP3, if not nil, configures an opt-in HTTP/3 transport, which is used
+	// for HTTPS requests to servers that support it, with fallback to the
+	// Transport of HTTPClient. See HTTP3Config.
+	HTTP3 *HTTP3Config
+
+	// DialSFTP, if not nil, opens a session with the SFTP server of the given
+	// sftp:// URL, authenticating as the user of the URL with the keys of the
+	// caller. A new session is opened for each request of a transfer and is
+	// closed once the request is complete, unless the returned SFTPClient
+	// pools its sessions. Transfers of sftp:// URLs fail if DialSFTP is nil.
+	// See SFTPClient.
+	DialSFTP func(ctx context.Context, u *url.URL) (SFTPClient, error)
+
+	// schemes are the handlers registered with RegisterScheme.
+	schemes   map[string]http.RoundTripper
+	schemesMu sync.RWMutex
+
+	// ociTokens are the bearer tokens issued by OCI registries, keyed by
+	// registry host and repository.
+	ociTokens sync.Map
+
+	// transports are the transports cloned for each TLSPolicy, proxy and
+	// Unix domain socket, keyed by transportKey.
+	transports transportCache
+
+	// http3 records the hosts which support HTTP/3. See HTTP3Config.
+	http3 http3Hosts
+
+	// hosts counts the active batch transfers to each remote host.
+	hosts hostSlots
+
+	// filenames are the destination paths reserved by active transfers. See
+	// CollisionAutoRename.
+	filenames filenameSet
+
+	// bandwidth limits the combined transfer rate of all requests sent by this
+	// client. See SetGlobalRateLimit.
+	bandwidth     *TokenBucket
+	bandwidthOnce sync.Once
+
+	// fair shares the global rate limit among concurrent transfers by the
+	// Weight of each Request.
+	fair *fairQueue
+
+	// schedule sets the global rate limit by the time of day. See
+	// SetBandwidthSchedule.
+	schedule        *BandwidthSchedule
+	scheduleChecked time.Time
+	scheduleMu      sync.Mutex
 }
 
 // NewClient returns a new file download Client, using default configuration.
@@ -45,6 +227,66 @@ func NewClient() *Client {
 	}
 }
 
+// SetGlobalRateLimit limits the combined transfer rate of all requests sent by
+// this client to the given number of bytes per second. The budget is shared
+// across all concurrent transfers by weighted fair queuing, in proportion to
+// the Weight of each Request, and in addition to any RateLimiter configured
+// on each Request.
+//
+// The limit applies immediately to transfers that are already in progress. A
+// limit less than one removes the limit. Any BandwidthSchedule is removed.
+func (c *Client) SetGlobalRateLimit(bytesPerSecond int) {
+	c.scheduleMu.Lock()
+	c.schedule = nil
+	c.scheduleMu.Unlock()
+	c.setGlobalRate(bytesPerSecond)
+}
+
+// setGlobalRate sets the rate of the global rate limit of the client.
+func (c *Client) setGlobalRate(bytesPerSecond int) {
+	burst := bytesPerSecond / 10
+	if burst < globalRateQuantum {
+		burst = globalRateQuantum
+	}
+	c.globalLimiter().SetRate(bytesPerSecond, burst)
+}
+
+// globalRateQuantum is the largest number of bytes that any one transfer may
+// request from the global rate limit at a time.
+const globalRateQuantum = 4096
+
+// globalLimiter returns the TokenBucket that applies the global rate limit of
+// the client.
+func (c *Client) globalLimiter() *TokenBucket {
+	c.bandwidthOnce.Do(func() {
+		c.bandwidth = NewTokenBucket(0, 0)
+		c.fair = &fairQueue{lim: c.bandwidth}
+	})
+	return c.bandwidth
+}
+
+// globalQueue returns the fairQueue that shares the global rate limit of the
+// client among concurrent transfers.
+func (c *Client) globalQueue() *fairQueue {
+	c.globalLimiter()
+	return c.fair
+}
+
+// rateLimiter returns the RateLimiter for the transfer of the given Response,
+// combining the RateLimiter of the Request with the global rate limit of the
+// client, and any BandwidthSchedule.
+func (c *Client) rateLimiter(resp *Response) RateLimiter {
+	weight := float64(resp.Request.Weight)
+	if weight < 1 {
+		weight = 1
+	}
+	global := scheduleLimiter{c, &fairLimiter{q: c.globalQueue(), quantum: globalRateQuantum, weight: weight}}
+	if resp.Request.RateLimiter == nil {
+		return global
+	}
+	return multiLimiter{resp.Request.RateLimiter, global}
+}
+
 // DefaultClient is the default client and is used by all Get convenience
 // functions.
 var DefaultClient = NewClient()
@@ -62,21 +304,36 @@ var DefaultClient = NewClient()
 // will block the caller until the transfer is completed, successfully or
 // otherwise.
 func (c *Client) Do(req *Request) *Response {
-	// cancel will be called on all code-paths via closeResponse
-	ctx, cancel := context.WithCancel(req.Context())
 	resp := &Response{
 		Request:    req,
+		mirrors:    req.urls(),
+		header:     cloneHeader(req.HTTPRequest.Header),
+		origin:     req.HTTPRequest.URL.Hostname(),
 		Start:      time.Now(),
 		Done:       make(chan struct{}, 0),
-		Filename:   req.Filename,
-		ctx:        ctx,
-		cancel:     cancel,
+		Filename:   longPath(req.Filename),
+		gate:       &gate{},
+		attempts:   1,
 		bufferSize: req.BufferSize,
 	}
+
+	// cancel will be called on all code-paths via closeResponse
+	resp.ctx, resp.cancel = context.WithCancel(c.startSpan(resp, req.Context()))
 	if resp.bufferSize == 0 {
 		// default to Client.BufferSize
 		resp.bufferSize = c.BufferSize
 	}
+	resp.newAttempt()
+	c.Metrics.transferStarted()
+	c.logf(resp, slog.LevelDebug, "starting transfer", "filename", req.Filename)
+
+	if req.ProbeMirrors && len(req.Mirrors) > 0 {
+		resp.mirrors = c.probeMirrors(resp)
+		resp.Request.HTTPRequest = resp.mirrorRequest(resp.mirrors[0])
+	}
+	if req.DiscoverChecksum && len(req.checksums) == 0 {
+		c.discoverChecksum(resp)
+	}
 
 	// Run state-machine while caller is blocked to initialize the file transfer.
 	// Must never transition to the copyFile state - this happens next in another
@@ -84,8 +341,15 @@ func (c *Client) Do(req *Request) *Response {
 	c.run(resp, c.statFileInfo)
 
 	// Run copyFile in a new goroutine. copyFile will no-op if the transfer is
-	// already complete or failed.
-	go c.run(resp, c.copyFile)
+	// already complete or failed. If the transfer fails over to a mirror, the
+	// state-machine stops again once the next transfer is initialized and
+	// copyFile is run again.
+	go resp.watchBps()
+	go func() {
+		for !resp.IsComplete() {
+			c.run(resp, c.copyFile)
+		}
+	}()
 	return resp
 }
 
@@ -101,14 +365,73 @@ func (c *Client) Do(req *Request) *Response {
 // causing a server timeout. It is the caller's responsibility to ensure a
 // sufficient buffer size is used for the Response channel to prevent this.
 //
+// If MaxTransfersPerHost is set, the worker blocks until a transfer to the
+// remote host of each Request is permitted.
+//
 // If an error occurs during any of the file transfers it will be accessible via
 // the associated Response.Err function.
+//
+// To stop a worker before the Request channel is closed, use DoChannelContext.
 func (c *Client) DoChannel(reqch <-chan *Request, respch chan<- *Response) {
-	// TODO: enable cancelling of batch jobs
-	for req := range reqch {
-		resp := c.Do(req)
-		respch <- resp
-		<-resp.Done
+	c.DoChannelContext(context.Background(), reqch, respch)
+}
+
+// DoChannelContext is like DoChannel but stops receiving requests once the
+// given Context is canceled. This allows a producer, such as a web crawler or a
+// message queue consumer, to send requests incrementally until it is done or
+// the batch is abandoned.
+//
+// If the Context is canceled, any transfer that is in progress is canceled and
+// its Response is sent through the Response channel only if a receiver is
+// ready. The caller is blocked until the transfer has stopped.
+//
+// DoChannelContext returns nil once the Request channel is closed and all
+// transfers have completed, or the error of the Context if it was canceled.
+func (c *Client) DoChannelContext(ctx context.Context, reqch <-chan *Request, respch chan<- *Response) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case req, ok := <-reqch:
+			if !ok {
+				return nil
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			host, err := c.acquireHost(ctx, req)
+			if err != nil {
+				return err
+			}
+			c.doJob(ctx, batchJob{req: req, host: host}, respch)
+		}
+	}
+}
+
+// doJob executes the Request of the given batchJob and sends the Response
+// through the given Response channel. The caller is blocked until the transfer
+// has completed and the host slot of the job is released. If the given Context
+// is canceled, the transfer is canceled.
+func (c *Client) doJob(ctx context.Context, job batchJob, respch chan<- *Response) {
+	defer c.releaseHost(job.host)
+	resp := c.Do(job.req)
+	if job.batch != nil {
+		job.batch.add(resp)
+	}
+	if job.tuner != nil {
+		job.tuner.add(resp)
+	}
+	select {
+	case respch <- resp:
+	case <-ctx.Done():
+		resp.Cancel()
+		return
+	}
+	select {
+	case <-resp.Done:
+	case <-ctx.Done():
+		resp.Cancel()
 	}
 }
 
@@ -117,36 +440,82 @@ func (c *Client) DoChannel(reqch <-chan *Request, respch chan<- *Response) {
 // initiated.
 //
 // If the requested number of workers is less than one, a worker will be created
-// for every request. I.e. all requests will be executed concurrently.
+// for every request. I.e. all requests will be executed concurrently. If
+// AdaptiveConcurrency is set, the number of workers is tuned while the batch
+// runs, starting with the requested number.
+//
+// Requests are started in order of their priority, set with
+// Request.SetPriority, and then in the given order. If MaxTransfersPerHost is
+// set, requests to a remote host which has reached the limit are deferred, so
+// that workers are free to start requests to other hosts.
 //
 // If an error occurs during any of the file transfers it will be accessible via
 // call to the associated Response.Err.
 //
 // The returned Response channel is closed only after all of the given Requests
-// have completed, successfully or otherwise.
+// have completed, successfully or otherwise. To monitor the progress of the
+// entire batch, use StartBatch.
 func (c *Client) DoBatch(workers int, requests ...*Request) <-chan *Response {
-	if workers < 1 {
+	return c.doBatch(nil, workers, requests)
+}
+
+// doBatch executes the given requests for DoBatch or StartBatch. If b is not
+// nil, each Response is added to b and b is marked done once the batch has
+// completed.
+func (c *Client) doBatch(b *BatchResponse, workers int, requests []*Request) <-chan *Response {
+	var tuner *concurrencyTuner
+	if c.AdaptiveConcurrency != nil && len(requests) > 0 {
+		tuner = newConcurrencyTuner(c.AdaptiveConcurrency, workers, len(requests))
+		workers = tuner.workers()
+	} else if workers < 1 {
 		workers = len(requests)
 	}
-	reqch := make(chan *Request, len(requests))
+	queue := newBatchQueue(c, requests)
+	if b != nil {
+		b.queue = queue
+		b.workers = workers
+		b.tuner = tuner
+	}
 	respch := make(chan *Response, len(requests))
 	wg := sync.WaitGroup{}
+	work := func() {
+		defer wg.Done()
+		for tuner == nil || !tuner.shrink() {
+			job, ok := queue.next()
+			if !ok {
+				break
+			}
+			job.batch = b
+			job.tuner = tuner
+			c.doJob(queue.ctx, job, respch)
+			if b != nil {
+				b.save()
+			}
+		}
+	}
 	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go work()
+	}
+	if tuner != nil {
+		// the tuner holds the WaitGroup until it has stopped adding workers
 		wg.Add(1)
 		go func() {
-			c.DoChannel(reqch, respch)
-			wg.Done()
+			defer wg.Done()
+			tuner.run(queue, func() {
+				wg.Add(1)
+				go work()
+			})
 		}()
 	}
 
-	// queue requests
 	go func() {
-		for _, req := range requests {
-			reqch <- req
-		}
-		close(reqch)
 		wg.Wait()
+		queue.cancel()
 		close(respch)
+		if b != nil {
+			b.done()
+		}
 	}()
 	return respch
 }
@@ -182,19 +551,42 @@ func (c *Client) run(resp *Response, f stateFunc) {
 // Response.Filename.
 //
 // If the file does not exist, is a directory, or its name is unknown the next
-// stateFunc is headRequest.
+// stateFunc is headRequest. If the destination is an io.Writer set with
+// Request.SetWriter, the next stateFunc is getRequest.
 //
 // If the file exists, Response.fi is set and the next stateFunc is
 // validateLocal.
 //
 // If an error occurs, the next stateFunc is closeResponse.
 func (c *Client) statFileInfo(resp *Response) stateFunc {
+	if resp.Request.writer != nil {
+		if resp.Request.hasRange() {
+			return c.requestRange
+		}
+		return c.getRequest
+	}
 	if resp.Filename == "" {
 		return c.headRequest
 	}
-	fi, err := os.Stat(resp.Filename)
+	if resp.Request.collisionPolicy() == CollisionAutoRename && resp.reserved == "" {
+		if fi, err := os.Stat(resp.Filename); err != nil || !fi.IsDir() {
+			c.reserveFilename(resp)
+		}
+	}
+	fi, err := os.Stat(resp.path())
+	if os.IsNotExist(err) && resp.path() != resp.Filename {
+		// no temporary file exists but the destination may be complete already
+		fi, err = os.Stat(resp.Filename)
+		resp.committed = err == nil && !fi.IsDir()
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
+			if next := c.fromCache(resp); next != nil {
+				return next
+			}
+			if resp.Request.hasRange() {
+				return c.requestRange
+			}
 			return c.headRequest
 		}
 		resp.err = err
@@ -205,14 +597,23 @@ func (c *Client) statFileInfo(resp *Response) stateFunc {
 		return c.headRequest
 	}
 	resp.fi = fi
+
+	// load the state of any previously interrupted transfer
+	if resp.Request.PersistState && !resp.Request.NoResume {
+		resp.state, resp.err = readState(resp.path())
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
 	return c.validateLocal
 }
 
 // validateLocal compares a local copy of the downloaded file to the remote
 // file.
 //
-// An error is returned if the local file is larger than the remote file, or
-// Request.SkipExisting is true.
+// An error is returned if the local file is larger than the remote file. An
+// existing destination file is first handled according to the SkipPolicy and
+// CollisionPolicy of the Request. See skipExisting and handleCollision.
 //
 // If the existing file matches the length of the remote file, the next
 // stateFunc is checksumFile.
@@ -220,9 +621,42 @@ func (c *Client) statFileInfo(resp *Response) stateFunc {
 // If the local file is smaller than the remote file and the remote server is
 // known to support ranged requests, the next stateFunc is getRequest.
 func (c *Client) validateLocal(resp *Response) stateFunc {
-	if resp.Request.SkipExisting {
-		resp.err = ErrFileExists
-		return c.closeResponse
+	if resp.path() == resp.Filename {
+		if next := c.conditionalRequest(resp); next != nil {
+			return next
+		}
+		if next := c.skipExisting(resp); next != nil {
+			return next
+		}
+		if next := c.handleCollision(resp); next != nil {
+			return next
+		}
+	}
+	if resp.Request.hasRange() {
+		return c.requestRange
+	}
+	if resp.Request.hasDelta() && !resp.deltaTried {
+		if !resp.optionsKnown {
+			return c.headRequest
+		}
+		resp.deltaTried = true
+		if resp.CanResume {
+			return c.deltaRequest
+		}
+	}
+
+	if resp.state != nil {
+		return c.validateState
+	}
+
+	// a file partially written from another mirror must be the same version
+	if resp.written != nil {
+		if !resp.optionsKnown {
+			return c.headRequest
+		}
+		if !resp.written.matches(resp) {
+			return c.getRequest
+		}
 	}
 
 	// determine expected file size
@@ -234,9 +668,17 @@ func (c *Client) validateLocal(resp *Response) stateFunc {
 		return c.headRequest
 	}
 
+	if resp.committed && size != resp.fi.Size() {
+		// replace the incomplete destination file with a new temporary file
+		resp.committed = false
+		resp.fi = nil
+		return c.getRequest
+	}
+
 	if size == resp.fi.Size() {
+		c.logf(resp, slog.LevelDebug, "file already complete", "filename", resp.Filename)
 		resp.DidResume = true
-		resp.bytesResumed = resp.fi.Size()
+		resp.setBytesResumed(resp.fi.Size())
 		return c.checksumFile
 	}
 
@@ -250,51 +692,183 @@ func (c *Client) validateLocal(resp *Response) stateFunc {
 	}
 
 	if resp.CanResume {
-		resp.Request.HTTPRequest.Header.Set(
-			"Range",
-			fmt.Sprintf("bytes=%d-", resp.fi.Size()))
+		offset := resp.fi.Size()
+		if resp.Request.hasPieces() {
+			offset, resp.err = c.resumePieces(resp, offset)
+			if resp.err != nil {
+				return c.closeResponse
+			}
+		}
+		v := resp.written
+		if v == nil {
+			v = newValidators(resp)
+		}
+		setRange(resp.Request.HTTPRequest, offset, v)
+		c.logf(resp, slog.LevelDebug, "resuming transfer", "offset", offset)
 		resp.DidResume = true
-		resp.bytesResumed = resp.fi.Size()
+		resp.setBytesResumed(offset)
 		return c.getRequest
 	}
 	return c.headRequest
 }
 
+// validateState compares the persisted state of a previously interrupted
+// transfer to the remote file.
+//
+// If the remote file has not changed, the next stateFunc resumes the transfer
+// from the offsets recorded in the state. Otherwise, the state is discarded and
+// the next stateFunc is getRequest, which restarts the transfer.
+func (c *Client) validateState(resp *Response) stateFunc {
+	if !resp.optionsKnown {
+		return c.headRequest
+	}
+	st := resp.state
+	resp.state = nil
+
+	// segments are written out of order, so cannot be resumed into writers
+	segmented := len(st.Segments) > 0 && len(resp.Request.writers) > 0
+	if segmented || !st.matches(resp) || (!resp.CanResume && st.BytesWritten < st.Size) {
+		c.logf(resp, slog.LevelInfo, "discarding transfer state", "filename", resp.Filename)
+		resp.err = removeState(resp.path())
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		return c.getRequest
+	}
+
+	c.logf(resp, slog.LevelDebug, "resuming transfer from state",
+		"offset", st.BytesWritten,
+		"segments", len(st.Segments))
+	resp.DidResume = true
+	resp.DidResumeState = true
+	resp.setBytesResumed(st.BytesWritten)
+	resp.hashStates = st.HashStates
+	if st.BytesWritten == st.Size {
+		// transfer completed but the state was never removed
+		resp.err = os.Truncate(resp.path(), st.Size)
+		if resp.err == nil {
+			resp.err = removeState(resp.path())
+		}
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		return c.checksumFile
+	}
+	if len(st.Segments) > 0 {
+		resp.segments = st.segments()
+		return c.openSegments
+	}
+	setRange(resp.Request.HTTPRequest, st.BytesWritten, &validators{
+		etag:         st.ETag,
+		lastModified: st.LastModified,
+	})
+	return c.getRequest
+}
+
+// setRange sets the headers of the given http.Request to resume a transfer
+// from the given offset. If a strong validator of the partially transferred
+// file is known, an If-Range header is also set so that the remote server
+// sends the entire file if it has changed.
+func setRange(req *http.Request, offset int64, v *validators) {
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	if s := v.ifRange(); s != "" {
+		req.Header.Set("If-Range", s)
+	}
+}
+
 func (c *Client) checksumFile(resp *Response) stateFunc {
-	if resp.Request.hash == nil {
+	// run BeforeValidate hook
+	if f := c.beforeValidate(resp.Request); f != nil {
+		resp.err = f(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+
+	if resp.Request.hasPieces() && !resp.piecesChecked {
+		resp.piecesChecked = true
+		resp.err = c.checkPieces(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		if len(resp.RepairedPieces) > 0 {
+			// streamed checksums no longer match the repaired file
+			resp.hasher = nil
+		}
+	}
+
+	resp.err = c.checkDigest(resp)
+	if resp.err != nil {
 		return c.closeResponse
 	}
-	if resp.Filename == "" {
+
+	if len(resp.Request.checksums) == 0 {
+		return c.verifySignature
+	}
+	if resp.Filename == "" && resp.hasher == nil {
 		panic("filename not set")
 	}
 	req := resp.Request
 
-	// compare checksum
-	var sum []byte
-	sum, resp.err = checksum(req.Context(), resp.Filename, req.hash)
-	if resp.err != nil {
-		return c.closeResponse
+	// compute all checksums in a single pass, if not already streamed
+	var sums [][]byte
+	if resp.hasher != nil {
+		sums = resp.hasher.sums()
+	} else {
+		sums, resp.err = checksum(req.Context(), resp.path(), hashes(req.checksums))
+		if resp.err != nil {
+			return c.closeResponse
+		}
 	}
-	if !bytes.Equal(sum, req.checksum) {
+
+	// compare checksums
+	ok := true
+	resp.Checksums = make([]Checksum, len(req.checksums))
+	for i, sum := range req.checksums {
+		sum.Actual = sums[i]
+		resp.Checksums[i] = sum
+		if !sum.OK() {
+			ok = false
+		}
+	}
+	if ok {
+		c.logf(resp, slog.LevelDebug, "checksums validated", "filename", resp.Filename)
+	} else {
+		c.logf(resp, slog.LevelWarn, "checksum mismatch", "filename", resp.Filename)
+	}
+	if !ok {
 		resp.err = ErrBadChecksum
-		if req.deleteOnError {
-			if err := os.Remove(resp.Filename); err != nil {
+		if req.deleteOnError && req.writer == nil {
+			if err := os.Remove(resp.path()); err != nil {
 				// err should be os.PathError and include file path
 				resp.err = fmt.Errorf(
-					"cannot remove downloaded file with checksum mismatch: %v",
+					"cannot remove downloaded file with checksum mismatch: %w",
 					err)
 			}
 		}
+		return c.closeResponse
 	}
-	return c.closeResponse
+	return c.verifySignature
 }
 
-// doHTTPRequest sends a HTTP Request and returns the response
+// doHTTPRequest sends a HTTP Request and returns the response. Requests for
+// schemes registered with RegisterScheme are sent by their handler, and
+// requests for other URL schemes than http and https by their schemeHandler.
 func (c *Client) doHTTPRequest(req *http.Request) (*http.Response, error) {
+	if c.HTTPSOnly && insecureSchemes[req.URL.Scheme] {
+		return nil, fmt.Errorf("%w: %s", ErrInsecure, req.URL.Redacted())
+	}
+	req = c.setHeaders(req, "")
 	if c.UserAgent != "" && req.Header.Get("User-Agent") == "" {
 		req.Header.Set("User-Agent", c.UserAgent)
 	}
-	return c.HTTPClient.Do(req)
+	if h := c.registeredScheme(req.URL.Scheme); h != nil {
+		return h.RoundTrip(req)
+	}
+	if h, ok := schemeHandlers[req.URL.Scheme]; ok {
+		return h.roundTrip(c, req)
+	}
+	return c.sendHTTP(req)
 }
 
 func (c *Client) headRequest(resp *Response) stateFunc {
@@ -303,22 +877,29 @@ func (c *Client) headRequest(resp *Response) stateFunc {
 	}
 	resp.optionsKnown = true
 
-	if resp.Request.NoResume {
-		return c.getRequest
-	}
+	// segmented transfers need to know the capabilities of the remote server
+	if resp.Request.Segments < 2 {
+		if resp.Request.NoResume {
+			return c.getRequest
+		}
 
-	if resp.Filename != "" && resp.fi == nil {
-		// destination path is already known and does not exist
-		return c.getRequest
+		if resp.Filename != "" && resp.fi == nil {
+			// destination path is already known and does not exist
+			return c.getRequest
+		}
 	}
 
+	req := resp.Request.HTTPRequest
+	if resp.Request.VerifyDigest {
+		req = setWantDigest(req)
+	}
 	hreq := new(http.Request)
-	*hreq = *resp.Request.HTTPRequest
+	*hreq = *req
 	hreq.Method = "HEAD"
 
-	resp.HTTPResponse, resp.err = c.doHTTPRequest(hreq)
+	resp.HTTPResponse, resp.err = c.doTransferRequest(resp, hreq.WithContext(resp.attemptCtx))
 	if resp.err != nil {
-		return c.closeResponse
+		return c.nextMirror
 	}
 	resp.HTTPResponse.Body.Close()
 
@@ -330,29 +911,121 @@ func (c *Client) headRequest(resp *Response) stateFunc {
 }
 
 func (c *Client) getRequest(resp *Response) stateFunc {
-	resp.HTTPResponse, resp.err = c.doHTTPRequest(resp.Request.HTTPRequest)
+	if c.canSegment(resp) {
+		return c.openSegments
+	}
+
+	req := resp.Request.HTTPRequest
+	conditional := resp.conditional
+	if conditional != nil {
+		// only the first request is conditional
+		resp.conditional = nil
+		req = setConditional(req, conditional)
+	}
+	if resp.Request.DecodeContent {
+		req = setAcceptEncoding(req)
+	}
+	if resp.Request.VerifyDigest {
+		req = setWantDigest(req)
+	}
+	resp.HTTPResponse, resp.err = c.doTransferRequest(resp,
+		req.WithContext(resp.traceContext()))
 	if resp.err != nil {
-		return c.closeResponse
+		return c.nextMirror
+	}
+	if conditional != nil && resp.HTTPResponse.StatusCode == http.StatusNotModified {
+		return c.notModified
+	}
+
+	if resp.HTTPResponse.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		if resp.Request.hasRange() {
+			return c.rangeEnded
+		}
+		if resp.bytesResumed > 0 {
+			return c.rangeNotSatisfiable
+		}
 	}
 
 	// check status code
 	if !resp.Request.IgnoreBadStatusCodes {
 		if resp.HTTPResponse.StatusCode < 200 || resp.HTTPResponse.StatusCode > 299 {
 			resp.err = StatusCodeError(resp.HTTPResponse.StatusCode)
-			return c.closeResponse
+			return c.nextMirror
 		}
 	}
 
 	return c.readResponse
 }
 
+// rangeNotSatisfiable handles a 416 Range Not Satisfiable response to a
+// request to resume a transfer. The total size of the remote file, given in
+// the Content-Range header of the response, is compared to the local file.
+//
+// If the local file is already complete, the next stateFunc is checksumFile.
+// Otherwise, the transfer is restarted and the next stateFunc is getRequest.
+func (c *Client) rangeNotSatisfiable(resp *Response) stateFunc {
+	resp.closeResponseBody()
+	var size int64
+	if _, err := fmt.Sscanf(
+		resp.HTTPResponse.Header.Get("Content-Range"),
+		"bytes */%d",
+		&size); err == nil && size == resp.bytesResumed {
+		resp.Size = size
+		if resp.DidResumeState {
+			// discard any bytes beyond those recorded in the state
+			resp.err = os.Truncate(resp.path(), size)
+			if resp.err == nil {
+				resp.err = removeState(resp.path())
+			}
+			if resp.err != nil {
+				return c.closeResponse
+			}
+		}
+		return c.checksumFile
+	}
+
+	c.logf(resp, slog.LevelInfo, "resume range not satisfiable, restarting")
+	resp.discardResume()
+	resp.Request.HTTPRequest.Header.Del("Range")
+	resp.Request.HTTPRequest.Header.Del("If-Range")
+	return c.getRequest
+}
+
 func (c *Client) readResponse(resp *Response) stateFunc {
 	if resp.HTTPResponse == nil {
 		panic("Response.HTTPResponse is not ready")
 	}
 
+	resp.setRedirects()
+	if resp.err = checkContentType(resp); resp.err != nil {
+		return c.closeResponse
+	}
+
+	if resp.Request.hasRange() && resp.requestMethod() == "GET" {
+		if err := checkContentRange(resp); err != nil {
+			resp.err = err
+			return c.nextMirror
+		}
+	}
+
+	// a complete response to a ranged request means the remote file has
+	// changed, or the remote server ignored the Range header
+	if resp.bytesResumed > 0 &&
+		resp.requestMethod() == "GET" &&
+		resp.HTTPResponse.StatusCode == http.StatusOK {
+		c.logf(resp, slog.LevelInfo, "server did not resume transfer, restarting")
+		resp.discardResume()
+	}
+
+	if resp.requestMethod() == "GET" {
+		if resp.err = resp.setContentEncoding(); resp.err != nil {
+			return c.nextMirror
+		}
+	}
+
 	size := resp.HTTPResponse.ContentLength
-	if size <= 0 {
+	if size <= 0 || resp.ContentEncoding != "" {
+		// the decoded size of encoded content is unknown
 		size = resp.Request.Size
 	}
 
@@ -360,18 +1033,37 @@ func (c *Client) readResponse(resp *Response) stateFunc {
 	resp.Size = resp.bytesResumed + size
 	if size > 0 && resp.Request.Size > 0 && resp.Request.Size != resp.Size {
 		resp.err = ErrBadLength
+		return c.nextMirror
+	}
+	if limit := resp.Request.SizeLimit; limit > 0 && size > 0 && resp.Size > limit {
+		resp.err = ErrTooLarge
 		return c.closeResponse
 	}
 
-	// check filename
-	if resp.Filename == "" {
-		filename, err := guessFilename(resp.HTTPResponse)
-		if err != nil {
-			resp.err = err
+	if resp.Request.writer != nil {
+		if resp.err = c.inspect(resp); resp.err != nil {
 			return c.closeResponse
 		}
+		return c.openStream
+	}
+
+	// check filename
+	guessed := resp.Filename == ""
+	if guessed {
 		// Request.Filename will be empty or a directory
-		resp.Filename = filepath.Join(resp.Request.Filename, filename)
+		resp.Filename, resp.err = requestedFilename(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		resp.Filename = longPath(resp.Filename)
+	}
+	if resp.err = c.inspect(resp); resp.err != nil {
+		return c.closeResponse
+	}
+	if guessed && resp.requestMethod() == "GET" {
+		if next := c.guessedFilename(resp); next != nil {
+			return next
+		}
 	}
 
 	if resp.requestMethod() == "HEAD" {
@@ -389,11 +1081,15 @@ func (c *Client) readResponse(resp *Response) stateFunc {
 // Requires that Response.Filename and resp.DidResume are already be set.
 func (c *Client) openWriter(resp *Response) stateFunc {
 	if !resp.Request.NoCreateDirectories {
-		resp.err = mkdirp(resp.Filename)
+		resp.err = mkdirp(resp.Request, resp.path())
 		if resp.err != nil {
 			return c.closeResponse
 		}
 	}
+	resp.err = resp.checkSpace(resp.reclaimableBytes())
+	if resp.err != nil {
+		return c.closeResponse
+	}
 
 	// compute write flags
 	flag := os.O_CREATE | os.O_WRONLY
@@ -406,12 +1102,27 @@ func (c *Client) openWriter(resp *Response) stateFunc {
 	}
 
 	// open file
-	f, err := os.OpenFile(resp.Filename, flag, 0644)
+	f, err := os.OpenFile(resp.path(), flag, resp.Request.fileMode())
 	if err != nil {
 		resp.err = err
 		return c.closeResponse
 	}
-	resp.writer = f
+	resp.writer = newSyncFile(f, resp.Request)
+	resp.written = newValidators(resp)
+	if resp.Request.Preallocate && resp.Size > resp.bytesResumed {
+		resp.err = preallocate(f, resp.bytesResumed, resp.Size-resp.bytesResumed)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+
+	// discard any bytes beyond those recorded in a persisted state
+	if resp.DidResumeState {
+		resp.err = f.Truncate(resp.bytesResumed)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
 
 	// seek to start or end
 	whence := os.SEEK_SET
@@ -425,22 +1136,101 @@ func (c *Client) openWriter(resp *Response) stateFunc {
 
 	// init transfer
 	if resp.bufferSize < 1 {
-		resp.bufferSize = 32 * 1024
+		resp.bufferSize = defaultBufferSize
 	}
-	b := make([]byte, resp.bufferSize)
 
-	r, err := resp.Request.GetReader(resp.HTTPResponse.Body)
+	r, err := resp.bodyReader()
 	if err != nil {
 		resp.err = err
 		return c.closeResponse
 	}
 
-	resp.transfer = newTransfer(
-		resp.Request.Context(),
-		resp.Request.RateLimiter,
-		resp.writer,
+	// stream content into the checksum hash
+	var w io.Writer = resp.writer
+	if resp.Request.StreamChecksum && len(resp.Request.checksums) > 0 {
+		resp.hasher = &hashWriter{hs: hashes(resp.Request.checksums)}
+		w = io.MultiWriter(resp.writer, resp.hasher)
+	}
+	w = teeWriters(resp.Request, w)
+
+	resp.setTransfer(newTransfer(
+		resp.attemptCtx,
+		resp.gate,
+		c.rateLimiter(resp),
+		w,
 		r,
-		b)
+		resp.bufferSize))
+
+	// next step is copyFile, but this will be called later in another goroutine
+	return nil
+}
+
+// canSegment returns true if the file transfer for the given Response may be
+// split into multiple segments, transferred over concurrent connections.
+func (c *Client) canSegment(resp *Response) bool {
+	return resp.Request.Segments > 1 &&
+		!resp.noSegments &&
+		resp.Request.writer == nil &&
+		len(resp.Request.writers) == 0 &&
+		!resp.Request.hasRange() &&
+		resp.CanResume &&
+		resp.Size > 0 &&
+		resp.bytesResumed == 0 &&
+		resp.Filename != ""
+}
+
+// openSegments opens the destination file for a segmented transfer. The
+// connection for each segment is established later by copyFile.
+//
+// Requires that Response.Filename and Response.Size are already set.
+func (c *Client) openSegments(resp *Response) stateFunc {
+	if !resp.Request.NoCreateDirectories {
+		resp.err = mkdirp(resp.Request, resp.path())
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+	resp.err = resp.checkSpace(resp.reclaimableBytes())
+	if resp.err != nil {
+		return c.closeResponse
+	}
+
+	// resumed segments are written into the existing file
+	flag := os.O_CREATE | os.O_WRONLY
+	segs := resp.segments
+	if segs == nil {
+		flag |= os.O_TRUNC
+		segs = splitSegments(resp.Size, resp.Request.Segments)
+	}
+	f, err := os.OpenFile(resp.path(), flag, resp.Request.fileMode())
+	if err != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+	resp.writer = newSyncFile(f, resp.Request)
+	resp.written = newValidators(resp)
+	if resp.Request.Preallocate {
+		resp.err = preallocate(f, 0, resp.Size)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+
+	if resp.bufferSize < 1 {
+		resp.bufferSize = defaultBufferSize
+	}
+	resp.setTransfer(newSegmentedTransfer(
+		resp.attemptCtx,
+		resp.gate,
+		c.rateLimiter(resp),
+		func(req *http.Request) (*http.Response, error) {
+			return c.doTransferRequest(resp, req)
+		},
+		resp.Request.HTTPRequest,
+		resp.writer.(*syncFile),
+		resp.Request.GetReader,
+		resp.bufferSize,
+		segs))
 
 	// next step is copyFile, but this will be called later in another goroutine
 	return nil
@@ -463,16 +1253,89 @@ func (c *Client) copyFile(resp *Response) stateFunc {
 	if resp.transfer == nil {
 		panic("developer error: Response.transfer is not initialized")
 	}
-	go resp.watchBps()
+	if resp.hasher != nil {
+		resp.err = c.primeChecksum(resp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+	resp.err = c.primeWriters(resp)
+	if resp.err != nil {
+		return c.closeResponse
+	}
+	var stopState func()
+	if resp.Request.PersistState && resp.Request.writer == nil {
+		resp.err = writeState(resp.path(), resp.currentState())
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		stopState = resp.watchState()
+	}
+	stopStall := resp.watchStall()
+	stopSpace := resp.watchSpace()
+	stopProgress := resp.watchProgress()
 	_, resp.err = resp.transfer.copy()
+	c.Metrics.bytesDownloaded(resp.transfer.N())
+	stopProgress()
+	stopSpace()
+	stopStall()
+	if stopState != nil {
+		stopState()
+	}
+	if resp.err != nil && resp.isOutOfSpace(resp.err) {
+		resp.err = ErrNoSpace
+		c.saveProgress(resp)
+		return c.closeResponse
+	}
+	if errors.Is(resp.err, ErrServerNoRange) && !resp.noSegments {
+		if _, ok := resp.transfer.(*segmentedTransfer); ok {
+			return c.singleStream
+		}
+	}
+	if errors.Is(resp.err, ErrTooLarge) {
+		if resp.Request.writer == nil {
+			os.Remove(resp.path())
+			if resp.Request.PersistState {
+				removeState(resp.path())
+			}
+		}
+		return c.closeResponse
+	}
+	if resp.err != nil {
+		if resp.isStalled() {
+			resp.err = ErrStalled
+		}
+		if resp.transfer.N() > 0 &&
+			(resp.Request.writer != nil || len(resp.Request.writers) > 0) {
+			// content written to an io.Writer cannot be taken back
+			return c.closeResponse
+		}
+		c.saveProgress(resp)
+		return c.nextMirror
+	}
+	resp.err = closeWriter(resp)
 	if resp.err != nil {
 		return c.closeResponse
 	}
-	closeWriter(resp)
+	if resp.ContentEncoding != "" {
+		// the decoded size is known once the transfer is complete
+		n := resp.BytesComplete()
+		if resp.Request.Size > 0 && resp.Request.Size != n {
+			resp.err = ErrBadLength
+			return c.closeResponse
+		}
+		resp.Size = n
+	}
+	if resp.Request.PersistState && resp.Request.writer == nil {
+		resp.err = removeState(resp.path())
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
 
 	// set timestamp
-	if !resp.Request.IgnoreRemoteTime {
-		resp.err = setLastModified(resp.HTTPResponse, resp.Filename)
+	if !resp.Request.IgnoreRemoteTime && resp.Request.writer == nil {
+		resp.err = setLastModified(resp.HTTPResponse, resp.path())
 		if resp.err != nil {
 			return c.closeResponse
 		}
@@ -489,11 +1352,50 @@ func (c *Client) copyFile(resp *Response) stateFunc {
 	return c.checksumFile
 }
 
-func closeWriter(resp *Response) {
-	if resp.writer != nil {
-		resp.writer.Close()
-		resp.writer = nil
+// singleStream restarts a segmented transfer as a single stream after the
+// server answered a ranged request with the entire file, despite advertising
+// support for byte ranges. The content written by the segments is discarded.
+func (c *Client) singleStream(resp *Response) stateFunc {
+	c.logf(resp, slog.LevelInfo, "server ignored byte ranges, transferring as a single stream")
+	resp.noSegments = true
+	closeWriter(resp)
+	os.Remove(resp.path())
+	if resp.Request.PersistState {
+		removeState(resp.path())
+	}
+	c.reset(resp, resp.Request.HTTPRequest)
+	return c.statFileInfo
+}
+
+// saveProgress records the progress of a failed transfer so that it can be
+// resumed later. If Request.PersistState is set, the state is persisted to
+// disk. Otherwise, a segmented transfer is truncated to its last contiguous
+// byte, so that it can be resumed like any other incomplete file.
+func (c *Client) saveProgress(resp *Response) {
+	if resp.Request.PersistState {
+		// the transfer error takes precedence over any write error
+		writeState(resp.path(), resp.currentState())
+		return
 	}
+	t, ok := resp.transfer.(*segmentedTransfer)
+	if !ok {
+		return
+	}
+	if segs := t.remaining(); len(segs) > 0 {
+		if f, ok := resp.writer.(*syncFile); ok {
+			f.Truncate(segs[0].start)
+		}
+	}
+}
+
+// closeWriter closes the destination file of the given Response, if open.
+func closeWriter(resp *Response) error {
+	if resp.writer == nil {
+		return nil
+	}
+	err := resp.writer.Close()
+	resp.writer = nil
+	return err
 }
 
 // close finalizes the Response
@@ -505,12 +1407,25 @@ func (c *Client) closeResponse(resp *Response) stateFunc {
 	resp.fi = nil
 	closeWriter(resp)
 	resp.closeResponseBody()
+	if resp.err == nil && resp.HTTPResponse != nil {
+		c.recordCircuit(resp, nil)
+	}
+	resp.err = classify(resp.err)
 
 	resp.End = time.Now()
+	c.Metrics.transferFinished(resp)
+	c.endSpan(resp)
+	c.logResult(resp)
+	c.releaseFilename(resp)
 	close(resp.Done)
 	if resp.cancel != nil {
 		resp.cancel()
 	}
 
+	// run AfterComplete hook
+	if f := c.afterComplete(resp.Request); f != nil {
+		f(resp)
+	}
+
 	return nil
 }
@@ -1,6 +1,7 @@
 package grab
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
 	"crypto/sha1"
@@ -10,9 +11,15 @@ import (
 	"errors"
 	"fmt"
 	"hash"
+	"io"
+	"io/ioutil"
 	"math/rand"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -381,9 +388,12 @@ func TestNestedDirectory(t *testing.T) {
 
 		resp := DefaultClient.Do(req)
 		err := resp.Err()
-		if !os.IsNotExist(err) {
+		if !errors.Is(err, os.ErrNotExist) {
 			t.Errorf("expected: %v, got: %v", os.ErrNotExist, err)
 		}
+		if !errors.Is(err, ErrFilesystem) {
+			t.Errorf("expected filesystem error, got: %v", err)
+		}
 	})
 }
 
@@ -601,3 +611,629 @@ func TestHeadBadStatus(t *testing.T) {
 			resp.HTTPResponse.StatusCode)
 	}
 }
+
+// TestSegments tests that a file transfer can be split into multiple segments
+// that are transferred concurrently, or transferred over a single connection if
+// the remote server does not support ranged requests.
+func TestSegments(t *testing.T) {
+	size := 1048576
+	sum, _ := hex.DecodeString("fbbab289f7f94b25736c58be46a994c441fd02552cc6022352e3d86d2fab7c83")
+
+	testCases := []struct {
+		Name     string
+		URL      string
+		Segments int
+	}{
+		{"WithRangeSupport", fmt.Sprintf("?size=%d", size), 4},
+		{"WithOddSegments", fmt.Sprintf("?size=%d", size), 7},
+		{"WithoutRangeSupport", fmt.Sprintf("?size=%d&ranged=false", size), 4},
+		{"WithoutHEAD", fmt.Sprintf("?size=%d&nohead", size), 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			filename := ".testSegments" + tc.Name
+			defer os.Remove(filename)
+
+			req, _ := NewRequest(filename, ts.URL+tc.URL)
+			req.Segments = tc.Segments
+			req.SetChecksum(sha256.New(), sum, false)
+
+			resp := DefaultClient.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			testComplete(t, resp)
+			if _, ok := resp.transfer.(*segmentedTransfer); ok != resp.CanResume {
+				t.Errorf("expected segmented transfer: %v, got: %v", resp.CanResume, ok)
+			}
+		})
+	}
+
+	t.Run("Split", func(t *testing.T) {
+		for _, tc := range []struct {
+			size int64
+			n    int
+		}{
+			{1, 4}, {7, 3}, {1024, 4}, {1025, 8},
+		} {
+			segs := splitSegments(tc.size, tc.n)
+			var next int64
+			for _, seg := range segs {
+				if seg.start != next {
+					t.Errorf("expected segment to start at %d, got %d", next, seg.start)
+				}
+				next = seg.end + 1
+			}
+			if next != tc.size {
+				t.Errorf("expected segments to cover %d bytes, got %d", tc.size, next)
+			}
+		}
+	})
+}
+
+// failingReader returns an error after n bytes have been read.
+type failingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *failingReader) Read(p []byte) (int, error) {
+	if c.n <= 0 {
+		return 0, errTestInterrupt
+	}
+	if int64(len(p)) > c.n {
+		p = p[:c.n]
+	}
+	n, err := c.r.Read(p)
+	c.n -= int64(n)
+	return n, err
+}
+
+var errTestInterrupt = errors.New("test interrupt")
+
+// TestPersistState tests that an interrupted transfer can be resumed using the
+// state persisted alongside the destination file.
+func TestPersistState(t *testing.T) {
+	size := 1048576
+	sum, _ := hex.DecodeString("fbbab289f7f94b25736c58be46a994c441fd02552cc6022352e3d86d2fab7c83")
+	u := fmt.Sprintf("%s?size=%d&lastmod=123456789", ts.URL, size)
+
+	interrupt := func(t *testing.T, filename string, segments int) {
+		req, _ := NewRequest(filename, u)
+		req.PersistState = true
+		req.Segments = segments
+		req.GetReader = func(r io.Reader) (io.Reader, error) {
+			return &failingReader{r: r, n: 65536}, nil
+		}
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != errTestInterrupt {
+			t.Fatalf("expected %v, got: %v", errTestInterrupt, err)
+		}
+		st, err := readState(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st == nil {
+			t.Fatalf("expected state file for interrupted transfer")
+		}
+		if st.BytesWritten == 0 || st.BytesWritten == st.Size {
+			t.Fatalf("expected partial transfer in state, got %d bytes", st.BytesWritten)
+		}
+	}
+
+	resume := func(t *testing.T, filename string, expectState bool) {
+		req, _ := NewRequest(filename, u)
+		req.PersistState = true
+		req.SetChecksum(sha256.New(), sum, false)
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if resp.DidResumeState != expectState {
+			t.Errorf("expected Response.DidResumeState to be %v", expectState)
+		}
+		testComplete(t, resp)
+		if _, err := os.Stat(stateFilename(filename)); !os.IsNotExist(err) {
+			t.Errorf("expected state file to be removed, got: %v", err)
+		}
+	}
+
+	t.Run("SingleStream", func(t *testing.T) {
+		filename := ".testPersistStateSingle"
+		defer os.Remove(filename)
+		interrupt(t, filename, 0)
+		resume(t, filename, true)
+	})
+
+	t.Run("Segmented", func(t *testing.T) {
+		filename := ".testPersistStateSegmented"
+		defer os.Remove(filename)
+		interrupt(t, filename, 4)
+		resume(t, filename, true)
+	})
+
+	t.Run("WithChangedRemote", func(t *testing.T) {
+		filename := ".testPersistStateChanged"
+		defer os.Remove(filename)
+		interrupt(t, filename, 4)
+		st, _ := readState(filename)
+		st.LastModified = "Mon, 02 Jan 2006 15:04:05 GMT"
+		if err := writeState(filename, st); err != nil {
+			t.Fatal(err)
+		}
+		resume(t, filename, false)
+	})
+
+	t.Run("SegmentedWithoutState", func(t *testing.T) {
+		filename := ".testPersistStateNone"
+		defer os.Remove(filename)
+		req, _ := NewRequest(filename, u)
+		req.Segments = 4
+		req.GetReader = func(r io.Reader) (io.Reader, error) {
+			return &failingReader{r: r, n: 65536}, nil
+		}
+		if err := DefaultClient.Do(req).Err(); err != errTestInterrupt {
+			t.Fatalf("expected %v, got: %v", errTestInterrupt, err)
+		}
+
+		// the file should be truncated to its contiguous prefix and resumable
+		req, _ = NewRequest(filename, u)
+		req.SetChecksum(sha256.New(), sum, false)
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		testComplete(t, resp)
+	})
+}
+
+// TestMirrors tests that a failed transfer fails over to the mirrors of a
+// request, resuming any partial transfer if the remote file has not changed.
+func TestMirrors(t *testing.T) {
+	sum, _ := hex.DecodeString("fbbab289f7f94b25736c58be46a994c441fd02552cc6022352e3d86d2fab7c83")
+	mustParse := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			panic(err)
+		}
+		return u
+	}
+
+	// interruptOnce fails the first transfer after 64KB
+	interruptOnce := func(req *Request) {
+		called := false
+		req.GetReader = func(r io.Reader) (io.Reader, error) {
+			if called {
+				return r, nil
+			}
+			called = true
+			return &failingReader{r: r, n: 65536}, nil
+		}
+	}
+
+	testCases := []struct {
+		Name         string
+		URL          string
+		Mirror       string
+		Interrupt    bool
+		ExpectResume bool
+	}{
+		{"WithBadStatus", "/primary?status=503", "/mirror", false, false},
+		{"WithInterrupt", "/primary?lastmod=123456789", "/mirror?lastmod=123456789", true, true},
+		{"WithChangedRemote", "/primary?lastmod=123456789", "/mirror?lastmod=987654321", true, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			filename := ".testMirrors" + tc.Name
+			defer os.Remove(filename)
+
+			req, _ := NewRequest(filename, ts.URL+tc.URL)
+			req.Mirrors = []*url.URL{mustParse(ts.URL + tc.Mirror)}
+			req.SetChecksum(sha256.New(), sum, false)
+			if tc.Interrupt {
+				interruptOnce(req)
+			}
+			resp := DefaultClient.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			testComplete(t, resp)
+			if u := req.URL().String(); u != ts.URL+tc.Mirror {
+				t.Errorf("expected transfer from mirror %v, got %v", ts.URL+tc.Mirror, u)
+			}
+			if resp.DidResume != tc.ExpectResume {
+				t.Errorf("expected Response.DidResume to be %v", tc.ExpectResume)
+			}
+		})
+	}
+
+	t.Run("WithAllMirrorsFailing", func(t *testing.T) {
+		req, _ := NewRequest(".testMirrorsFailing", ts.URL+"/primary?status=503")
+		req.Mirrors = []*url.URL{mustParse(ts.URL + "/mirror?status=404")}
+		err := DefaultClient.Do(req).Err()
+		if expect := StatusCodeError(http.StatusNotFound); err != expect {
+			t.Errorf("expected %v, got %v", expect, err)
+		}
+	})
+
+	t.Run("WithCredentials", func(t *testing.T) {
+		// mirrors on other hosts do not receive the credentials of the
+		// original request
+		seen := make(map[string]string)
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/primary" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			seen[r.Host+r.URL.Path] = r.Header.Get("Authorization") + "," + r.Header.Get("Cookie") + "," + r.Header.Get("X-Custom")
+			w.Write([]byte("hello"))
+		}))
+		defer s.Close()
+		port := s.Listener.Addr().(*net.TCPAddr).Port
+		for _, mirror := range []string{
+			fmt.Sprintf("http://localhost:%d/other", port),
+			fmt.Sprintf("http://127.0.0.1:%d/same", port),
+		} {
+			filename := ".testMirrorsCredentials"
+			req, _ := NewRequest(filename, s.URL+"/primary")
+			req.NoResume = true
+			req.HTTPRequest.Header.Set("Authorization", "Bearer secret")
+			req.HTTPRequest.Header.Set("Cookie", "session=secret")
+			req.HTTPRequest.Header.Set("X-Custom", "custom")
+			req.Mirrors = []*url.URL{mustParse(mirror)}
+			err := DefaultClient.Do(req).Err()
+			os.Remove(filename)
+			if err != nil {
+				t.Fatalf("error: %v", err)
+			}
+		}
+		if h := seen[fmt.Sprintf("localhost:%d/other", port)]; h != ",,custom" {
+			t.Errorf("expected credentials to be removed for other hosts, got: %q", h)
+		}
+		if h := seen[fmt.Sprintf("127.0.0.1:%d/same", port)]; h != "Bearer secret,session=secret,custom" {
+			t.Errorf("expected credentials to be sent to the same host, got: %q", h)
+		}
+	})
+
+	t.Run("WithProbe", func(t *testing.T) {
+		filename := ".testMirrorsProbe"
+		defer os.Remove(filename)
+		req, _ := NewRequest(filename, ts.URL+"/slow?sleep=1000")
+		req.Mirrors = []*url.URL{
+			mustParse(ts.URL + "/broken?status=500"),
+			mustParse(ts.URL + "/fast"),
+		}
+		req.ProbeMirrors = true
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if u := req.URL().String(); u != ts.URL+"/fast" {
+			t.Errorf("expected transfer from fastest mirror, got %v", u)
+		}
+		expect := []string{ts.URL + "/fast", ts.URL + "/slow?sleep=1000", ts.URL + "/broken?status=500"}
+		for i, u := range resp.mirrors {
+			if u.String() != expect[i] {
+				t.Errorf("expected mirror %d to be %v, got %v", i, expect[i], u)
+			}
+		}
+	})
+}
+
+// TestStreamChecksum tests that checksums can be computed as a file is
+// transferred, including for resumed transfers.
+func TestStreamChecksum(t *testing.T) {
+	size := 1048576
+	sum, _ := hex.DecodeString("fbbab289f7f94b25736c58be46a994c441fd02552cc6022352e3d86d2fab7c83")
+	u := fmt.Sprintf("%s?size=%d", ts.URL, size)
+
+	t.Run("Match", func(t *testing.T) {
+		filename := ".testStreamChecksumMatch"
+		defer os.Remove(filename)
+		req, _ := NewRequest(filename, u)
+		req.StreamChecksum = true
+		req.SetChecksum(sha256.New(), sum, false)
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if resp.hasher == nil {
+			t.Errorf("expected checksum to be streamed")
+		}
+		testComplete(t, resp)
+	})
+
+	t.Run("Mismatch", func(t *testing.T) {
+		filename := ".testStreamChecksumMismatch"
+		defer os.Remove(filename)
+		req, _ := NewRequest(filename, u)
+		req.StreamChecksum = true
+		req.SetChecksum(sha256.New(), []byte{0x01, 0x02, 0x03, 0x04}, true)
+		if err := DefaultClient.Do(req).Err(); err != ErrBadChecksum {
+			t.Fatalf("expected %v, got: %v", ErrBadChecksum, err)
+		}
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("checksum failure not cleaned up: %s", filename)
+		}
+	})
+
+	t.Run("WithResume", func(t *testing.T) {
+		filename := ".testStreamChecksumResume"
+		defer os.Remove(filename)
+		req, _ := NewRequest(filename, fmt.Sprintf("%s?size=%d", ts.URL, size/2))
+		if err := DefaultClient.Do(req).Err(); err != nil {
+			t.Fatal(err)
+		}
+		req, _ = NewRequest(filename, u)
+		req.StreamChecksum = true
+		req.SetChecksum(sha256.New(), sum, false)
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if !resp.DidResume {
+			t.Errorf("expected Response.DidResume to be true")
+		}
+		testComplete(t, resp)
+	})
+
+	t.Run("WithPersistedHashState", func(t *testing.T) {
+		filename := ".testStreamChecksumState"
+		defer os.Remove(filename)
+		req, _ := NewRequest(filename, u)
+		req.PersistState = true
+		req.StreamChecksum = true
+		req.SetChecksum(sha256.New(), sum, false)
+		req.GetReader = func(r io.Reader) (io.Reader, error) {
+			return &failingReader{r: r, n: 65536}, nil
+		}
+		if err := DefaultClient.Do(req).Err(); err != errTestInterrupt {
+			t.Fatalf("expected %v, got: %v", errTestInterrupt, err)
+		}
+
+		// corrupt the first byte of the partial file - the persisted hash state
+		// should be used instead of reading the file
+		f, err := os.OpenFile(filename, os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteAt([]byte{0xff}, 0)
+		f.Close()
+
+		req, _ = NewRequest(filename, u)
+		req.PersistState = true
+		req.StreamChecksum = true
+		req.SetChecksum(sha256.New(), sum, false)
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if !resp.DidResumeState {
+			t.Errorf("expected Response.DidResumeState to be true")
+		}
+	})
+}
+
+// TestMultipleChecksums tests that multiple checksums can be validated in a
+// single pass, with the result of each reported in the Response.
+func TestMultipleChecksums(t *testing.T) {
+	sha256sum, _ := hex.DecodeString("fbbab289f7f94b25736c58be46a994c441fd02552cc6022352e3d86d2fab7c83")
+	md5sum, _ := hex.DecodeString("c35cc7d8d91728a0cb052831bc4ef372")
+	badsum := []byte{0x01, 0x02, 0x03, 0x04}
+
+	tests := []struct {
+		Name   string
+		Stream bool
+		MD5    []byte
+		Err    error
+	}{
+		{"Match", false, md5sum, nil},
+		{"MatchStreamed", true, md5sum, nil},
+		{"Mismatch", false, badsum, ErrBadChecksum},
+		{"MismatchStreamed", true, badsum, ErrBadChecksum},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			filename := ".testMultipleChecksums" + test.Name
+			defer os.Remove(filename)
+			req, _ := NewRequest(filename, ts.URL)
+			req.StreamChecksum = test.Stream
+			req.AddChecksum(sha256.New(), sha256sum, false)
+			req.AddChecksum(md5.New(), test.MD5, false)
+			resp := DefaultClient.Do(req)
+			if err := resp.Err(); err != test.Err {
+				t.Fatalf("expected error %v, got: %v", test.Err, err)
+			}
+			if len(resp.Checksums) != 2 {
+				t.Fatalf("expected 2 checksums, got: %d", len(resp.Checksums))
+			}
+			if !resp.Checksums[0].OK() {
+				t.Errorf("expected SHA-256 checksum to match, got: %x", resp.Checksums[0].Actual)
+			}
+			if ok := resp.Checksums[1].OK(); ok != (test.Err == nil) {
+				t.Errorf("expected MD5 checksum OK to be %v, got: %x", test.Err == nil, resp.Checksums[1].Actual)
+			}
+			if !bytes.Equal(resp.Checksums[1].Actual, md5sum) {
+				t.Errorf("expected MD5 checksum %x, got: %x", md5sum, resp.Checksums[1].Actual)
+			}
+		})
+	}
+
+	t.Run("SetChecksumReplaces", func(t *testing.T) {
+		req, _ := NewRequest("", ts.URL)
+		req.AddChecksum(md5.New(), md5sum, true)
+		req.SetChecksum(sha256.New(), sha256sum, false)
+		if len(req.checksums) != 1 || req.deleteOnError {
+			t.Errorf("expected SetChecksum to replace existing checksums")
+		}
+		req.SetChecksum(nil, nil, false)
+		if len(req.checksums) != 0 {
+			t.Errorf("expected SetChecksum(nil) to clear checksums")
+		}
+	})
+}
+
+// TestIfRange tests that resumed transfers send an If-Range header and are
+// restarted if the remote server responds with the entire file.
+func TestIfRange(t *testing.T) {
+	size := 4096
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	// the remote file changes between HEAD and GET
+	var ifRange string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == "HEAD" {
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+			return
+		}
+		ifRange = r.Header.Get("If-Range")
+		w.Header().Set("ETag", `"v2"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer s.Close()
+
+	filename := ".testIfRange"
+	defer os.Remove(filename)
+	if err := ioutil.WriteFile(filename, bytes.Repeat([]byte{0xff}, size/2), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := NewRequest(filename, s.URL)
+	resp := DefaultClient.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if ifRange != `"v1"` {
+		t.Errorf("expected If-Range: %q, got: %q", `"v1"`, ifRange)
+	}
+	if resp.DidResume {
+		t.Errorf("expected Response.DidResume to be false")
+	}
+	if resp.Size != int64(size) {
+		t.Errorf("expected size %d, got: %d", size, resp.Size)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Errorf("expected file to be restarted from zero")
+	}
+}
+
+// TestRangeNotSatisfiable tests that a 416 response to a resumed transfer
+// completes or restarts the transfer, according to the size of the remote file.
+func TestRangeNotSatisfiable(t *testing.T) {
+	size := 4096
+	content := make([]byte, size)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	// the remote server advertises a larger file than it serves
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == "HEAD" {
+			w.Header().Set("Content-Length", strconv.Itoa(size*2))
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		Name      string
+		Local     []byte
+		DidResume bool
+	}{
+		{"Complete", content, true},
+		{"Restart", bytes.Repeat([]byte{0xff}, size+size/2), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			filename := ".testRangeNotSatisfiable" + test.Name
+			defer os.Remove(filename)
+			if err := ioutil.WriteFile(filename, test.Local, 0644); err != nil {
+				t.Fatal(err)
+			}
+			req, _ := NewRequest(filename, s.URL)
+			resp := DefaultClient.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if resp.DidResume != test.DidResume {
+				t.Errorf("expected Response.DidResume to be %v", test.DidResume)
+			}
+			if resp.Size != int64(size) {
+				t.Errorf("expected size %d, got: %d", size, resp.Size)
+			}
+			b, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, content) {
+				t.Errorf("expected local file to match remote file")
+			}
+		})
+	}
+}
+
+// TestSizeLimit tests that transfers of files larger than Request.SizeLimit
+// fail with ErrTooLarge, whether or not the size is known in advance.
+func TestSizeLimit(t *testing.T) {
+	filename := ".testSizeLimit"
+	defer os.Remove(filename)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		if r.URL.Query().Get("chunked") == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+		}
+		if r.Method == "HEAD" {
+			return
+		}
+		for i := 0; i < size; i += 1024 {
+			n := size - i
+			if n > 1024 {
+				n = 1024
+			}
+			w.Write(make([]byte, n))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		query  string
+		expect error
+	}{
+		{"size=4096", nil},
+		{"size=4097", ErrTooLarge},
+		{"size=4096&chunked=1", nil},
+		{"size=4097&chunked=1", ErrTooLarge},
+		{"size=1048576&chunked=1", ErrTooLarge},
+	}
+	for _, test := range tests {
+		os.Remove(filename)
+		req, _ := NewRequest(filename, s.URL+"?"+test.query)
+		req.SizeLimit = 4096
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); !errors.Is(err, test.expect) {
+			t.Errorf("%s: expected error %v, got: %v", test.query, test.expect, err)
+			continue
+		}
+		if test.expect == nil {
+			testSize(t, resp.Filename, 4096)
+		} else if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("%s: expected incomplete file to be removed", test.query)
+		}
+	}
+}
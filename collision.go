@@ -0,0 +1,193 @@
+package grab
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// A CollisionPolicy specifies how a transfer proceeds if a file already
+// exists at its destination path.
+type CollisionPolicy int
+
+const (
+	// CollisionResume resumes the transfer of an existing file, if it is
+	// incomplete, or validates it if it is already complete. This is the
+	// default.
+	CollisionResume CollisionPolicy = iota
+
+	// CollisionOverwrite replaces an existing file with a new copy of the
+	// remote file.
+	CollisionOverwrite
+
+	// CollisionSkip leaves an existing file untouched, without validating it,
+	// and completes the transfer successfully with Response.Skipped set.
+	CollisionSkip
+
+	// CollisionError leaves an existing file untouched and fails the transfer
+	// with ErrFileExists.
+	CollisionError
+
+	// CollisionAutoRename transfers the file to a new path if a file already
+	// exists at the destination path, or if another transfer of the same
+	// Client is writing to it. A number is added to the filename, before the
+	// extension, as in "example (1).zip". Response.Filename is set to the new
+	// path.
+	CollisionAutoRename
+)
+
+// String returns the name of the policy.
+func (c CollisionPolicy) String() string {
+	switch c {
+	case CollisionResume:
+		return "resume"
+	case CollisionOverwrite:
+		return "overwrite"
+	case CollisionSkip:
+		return "skip"
+	case CollisionError:
+		return "error"
+	case CollisionAutoRename:
+		return "autorename"
+	}
+	return fmt.Sprintf("CollisionPolicy(%d)", int(c))
+}
+
+// collisionPolicy returns the CollisionPolicy of the Request.
+func (c *Request) collisionPolicy() CollisionPolicy {
+	if c.CollisionPolicy == CollisionResume && c.SkipExisting {
+		return CollisionError
+	}
+	return c.CollisionPolicy
+}
+
+// handleCollision applies the CollisionPolicy of the Request for the given
+// Response to an existing file at the destination path, described by
+// Response.fi. If the transfer should resume as usual, handleCollision
+// returns nil.
+func (c *Client) handleCollision(resp *Response) stateFunc {
+	switch resp.Request.collisionPolicy() {
+	case CollisionOverwrite:
+		return c.overwriteFile(resp)
+
+	case CollisionSkip:
+		return c.skipFile(resp)
+
+	case CollisionError:
+		resp.err = ErrFileExists
+		return c.closeResponse
+	}
+	return nil
+}
+
+// overwriteFile replaces the existing file at the destination path of the
+// given Response with a new copy of the remote file.
+func (c *Client) overwriteFile(resp *Response) stateFunc {
+	if resp.committed {
+		// write a new temporary file
+		resp.committed = false
+		resp.fi = nil
+	}
+	return c.getRequest
+}
+
+// skipFile completes the transfer of the given Response, leaving the existing
+// file at the destination path untouched.
+func (c *Client) skipFile(resp *Response) stateFunc {
+	resp.Skipped = true
+	resp.Size = resp.fi.Size()
+	resp.setBytesResumed(resp.fi.Size())
+	return c.closeResponse
+}
+
+// reserveFilename reserves a path for the transfer of the given Response, which
+// has a CollisionPolicy of CollisionAutoRename. The destination path is used if
+// no file exists at the path and it is not reserved by another transfer.
+// Otherwise, the first available numbered path is used.
+func (c *Client) reserveFilename(resp *Response) {
+	exists := func(name string) bool {
+		if _, err := os.Lstat(name); !os.IsNotExist(err) {
+			return true
+		}
+		if resp.Request.AtomicWrite {
+			_, err := os.Lstat(partFilename(resp.Request.TempDir, name))
+			return !os.IsNotExist(err)
+		}
+		return false
+	}
+	resp.Filename = c.filenames.reserve(resp.Filename, exists)
+	resp.reserved = resp.Filename
+}
+
+// releaseFilename releases any path reserved for the given Response.
+func (c *Client) releaseFilename(resp *Response) {
+	if resp.reserved != "" {
+		c.filenames.release(resp.reserved)
+		resp.reserved = ""
+	}
+}
+
+// filenameSet records the destination paths reserved by transfers with a
+// CollisionPolicy of CollisionAutoRename. The zero value is ready to use.
+type filenameSet struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+// reserve reserves and returns the given path, or the first numbered
+// alternative, which is not reserved and for which exists returns false.
+func (c *filenameSet) reserve(filename string, exists func(string) bool) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.names == nil {
+		c.names = make(map[string]bool)
+	}
+	name := filename
+	for n := 1; c.names[name] || exists(name); n++ {
+		name = numberedFilename(filename, n)
+	}
+	c.names[name] = true
+	return name
+}
+
+// release releases a path reserved by reserve.
+func (c *filenameSet) release(filename string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.names, filename)
+}
+
+// numberedFilename returns the given path with the given number added before
+// its extension. E.g. /tmp/example (1).zip for /tmp/example.zip.
+func numberedFilename(filename string, n int) string {
+	dir, base := filepath.Split(filename)
+	ext := filepath.Ext(base)
+	if ext == base {
+		// hidden files, such as .profile, have no extension
+		ext = ""
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(base, ext), n, ext))
+}
+
+// guessedFilename applies the CollisionPolicy of the Request for the given
+// Response to the filename guessed from a GET response, which was not checked
+// by statFileInfo. If the transfer should proceed, guessedFilename returns nil.
+func (c *Client) guessedFilename(resp *Response) stateFunc {
+	switch resp.Request.collisionPolicy() {
+	case CollisionAutoRename:
+		c.reserveFilename(resp)
+		return nil
+
+	case CollisionSkip, CollisionError:
+		if fi, err := os.Stat(resp.Filename); err == nil && !fi.IsDir() {
+			resp.fi = fi
+			return c.handleCollision(resp)
+		}
+	}
+
+	// the entire remote file replaces any existing file
+	resp.fi, _ = os.Stat(resp.path())
+	return nil
+}
@@ -0,0 +1,109 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestCollisionPolicy tests each CollisionPolicy with an existing file at the
+// destination path.
+func TestCollisionPolicy(t *testing.T) {
+	tests := []struct {
+		Policy   CollisionPolicy
+		Filename string
+		Size     int64
+		Skipped  bool
+		Err      error
+	}{
+		{CollisionResume, ".testCollision.bin", 4096, false, nil},
+		{CollisionOverwrite, ".testCollision.bin", 4096, false, nil},
+		{CollisionSkip, ".testCollision.bin", 1024, true, nil},
+		{CollisionError, ".testCollision.bin", 0, false, ErrFileExists},
+		{CollisionAutoRename, ".testCollision (1).bin", 4096, false, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.Policy.String(), func(t *testing.T) {
+			filename := ".testCollision.bin"
+			defer os.Remove(filename)
+			defer os.Remove(test.Filename)
+
+			// existing file differs from the remote file
+			if err := ioutil.WriteFile(filename, make([]byte, 1024), 0644); err != nil {
+				t.Fatal(err)
+			}
+			req, _ := NewRequest(filename, ts.URL+"?size=4096")
+			req.CollisionPolicy = test.Policy
+			resp := DefaultClient.Do(req)
+			if err := resp.Err(); err != test.Err {
+				t.Fatalf("expected error %v, got: %v", test.Err, err)
+			}
+			if resp.Skipped != test.Skipped {
+				t.Errorf("expected Skipped to be %v", test.Skipped)
+			}
+			if resp.Filename != test.Filename {
+				t.Errorf("expected filename %s, got: %s", test.Filename, resp.Filename)
+			}
+			if test.Err == nil {
+				testSize(t, test.Filename, test.Size)
+			}
+			if test.Policy == CollisionOverwrite {
+				b, _ := ioutil.ReadFile(filename)
+				for i := range b {
+					if b[i] != byte(i) {
+						t.Fatalf("unexpected byte at offset %d", i)
+					}
+				}
+			}
+			if test.Filename != filename {
+				testSize(t, filename, 1024)
+			}
+		})
+	}
+}
+
+// TestCollisionAutoRenameBatch tests that concurrent transfers to the same
+// destination path are renamed.
+func TestCollisionAutoRenameBatch(t *testing.T) {
+	filenames := map[string]bool{
+		".testAutoRename":     true,
+		".testAutoRename (1)": true,
+		".testAutoRename (2)": true,
+	}
+	for filename := range filenames {
+		defer os.Remove(filename)
+	}
+
+	reqs := make([]*Request, 3)
+	for i := range reqs {
+		reqs[i], _ = NewRequest(".testAutoRename", ts.URL+"?size=4096&sleep=100")
+		reqs[i].CollisionPolicy = CollisionAutoRename
+	}
+	for resp := range DefaultClient.DoBatch(3, reqs...) {
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if !filenames[resp.Filename] {
+			t.Errorf("unexpected or duplicate filename: %s", resp.Filename)
+		}
+		delete(filenames, resp.Filename)
+		testSize(t, resp.Filename, 4096)
+	}
+}
+
+func TestNumberedFilename(t *testing.T) {
+	tests := []struct {
+		Filename string
+		Expect   string
+	}{
+		{"example.zip", "example (2).zip"},
+		{"/tmp/example.tar.gz", "/tmp/example.tar (2).gz"},
+		{"example", "example (2)"},
+		{".profile", ".profile (2)"},
+	}
+	for _, test := range tests {
+		if actual := numberedFilename(test.Filename, 2); actual != test.Expect {
+			t.Errorf("expected %s, got: %s", test.Expect, actual)
+		}
+	}
+}
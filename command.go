@@ -0,0 +1,127 @@
+package grab
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"text/template"
+)
+
+// A CommandHook runs an external command once a file transfer is complete, so
+// that completed downloads can be passed to other programs, such as virus
+// scanners, installers or notification tools. Use CommandHook.AfterComplete as
+// the AfterComplete callback of a Client or Request.
+//
+// The name and each argument of the command are templates, executed with the
+// following fields of the completed transfer:
+//
+//	{{.Filename}}  the path of the downloaded file
+//	{{.URL}}       the URL from which the file was downloaded
+//	{{.Label}}     the Label of the Request
+//	{{.Size}}      the size of the file in bytes
+//	{{.Err}}       the error of a failed transfer, or an empty string
+//
+// The command is run directly, not by a shell, so the values of the fields are
+// never interpreted by a shell, even if they contain spaces or quotes.
+type CommandHook struct {
+	// Dir specifies the working directory of the command. If empty, the
+	// command runs in the current working directory.
+	Dir string
+
+	// Env specifies the environment of the command, in the form "key=value".
+	// If nil, the command uses the environment of the current process.
+	Env []string
+
+	// Stdout and Stderr specify the standard output and error of the command.
+	// If nil, the output is discarded.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// RunOnError specifies that the command should also run for transfers
+	// that failed. By default, the command only runs for successful transfers.
+	RunOnError bool
+
+	// OnError, if set, is called by AfterComplete if the command cannot be run
+	// or exits with an error.
+	OnError func(*Response, error)
+
+	name *template.Template
+	args []*template.Template
+}
+
+// commandData are the fields available to the templates of a CommandHook.
+type commandData struct {
+	Filename string
+	URL      string
+	Label    string
+	Size     int64
+	Err      string
+}
+
+// NewCommandHook returns a CommandHook which runs the named program with the
+// given arguments. An error is returned if the name or any argument is not a
+// valid template.
+func NewCommandHook(name string, args ...string) (*CommandHook, error) {
+	h := &CommandHook{}
+	var err error
+	if h.name, err = template.New("name").Parse(name); err != nil {
+		return nil, err
+	}
+	h.args = make([]*template.Template, len(args))
+	for i, arg := range args {
+		if h.args[i], err = template.New("arg").Parse(arg); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// Run runs the command for the given completed Response and waits for it to
+// exit. The command is killed if the Context of the Request is canceled.
+func (h *CommandHook) Run(resp *Response) error {
+	data := commandData{
+		Filename: resp.Filename,
+		URL:      resp.Request.URL().String(),
+		Label:    resp.Request.Label,
+		Size:     resp.Size,
+	}
+	if err := resp.Err(); err != nil {
+		data.Err = err.Error()
+	}
+	name, err := executeTemplate(h.name, data)
+	if err != nil {
+		return err
+	}
+	args := make([]string, len(h.args))
+	for i, t := range h.args {
+		if args[i], err = executeTemplate(t, data); err != nil {
+			return err
+		}
+	}
+
+	cmd := exec.CommandContext(resp.Request.Context(), name, args...)
+	cmd.Dir = h.Dir
+	cmd.Env = h.Env
+	cmd.Stdout = h.Stdout
+	cmd.Stderr = h.Stderr
+	return cmd.Run()
+}
+
+// AfterComplete runs the command for the given Response, unless the transfer
+// failed and RunOnError is not set. Any error is passed to OnError.
+func (h *CommandHook) AfterComplete(resp *Response) {
+	if resp.Err() != nil && !h.RunOnError {
+		return
+	}
+	if err := h.Run(resp); err != nil && h.OnError != nil {
+		h.OnError(resp, err)
+	}
+}
+
+func executeTemplate(t *template.Template, data commandData) (string, error) {
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,93 @@
+package grab
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestCommandHookProcess is not a real test. It is run as the command of
+// TestCommandHook.
+func TestCommandHookProcess(t *testing.T) {
+	if os.Getenv("GRAB_TEST_COMMAND") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	fmt.Print(strings.Join(args[1:], "|"))
+	if len(args) > 1 && args[1] == "fail" {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// TestCommandHook tests that commands are run with the fields of completed
+// transfers.
+func TestCommandHook(t *testing.T) {
+	filename := ".testCommandHook"
+	defer os.Remove(filename)
+
+	// AfterComplete is called after Response.Done is closed
+	do := func(req *Request, h *CommandHook) *Response {
+		done := make(chan struct{})
+		req.AfterComplete = func(resp *Response) {
+			h.AfterComplete(resp)
+			close(done)
+		}
+		resp := DefaultClient.Do(req)
+		<-done
+		return resp
+	}
+	newHook := func(args ...string) (*CommandHook, *bytes.Buffer) {
+		args = append([]string{"-test.run=^TestCommandHookProcess$", "--"}, args...)
+		h, err := NewCommandHook(os.Args[0], args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout := &bytes.Buffer{}
+		h.Stdout = stdout
+		h.Env = append(os.Environ(), "GRAB_TEST_COMMAND=1")
+		return h, stdout
+	}
+
+	h, stdout := newHook("{{.Filename}}", "{{.URL}}", "{{.Size}} bytes")
+	req, _ := NewRequest(filename, ts.URL+"?size=4096")
+	if err := do(req, h).Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	expect := fmt.Sprintf("%s|%s?size=4096|4096 bytes", filename, ts.URL)
+	if stdout.String() != expect {
+		t.Errorf("expected output %q, got: %q", expect, stdout.String())
+	}
+
+	// failed transfers
+	h, stdout = newHook("{{.Err}}")
+	req, _ = NewRequest(filename, ts.URL+"?status=404")
+	do(req, h)
+	if stdout.Len() != 0 {
+		t.Errorf("expected command not to run for failed transfer")
+	}
+	h.RunOnError = true
+	resp := do(req, h)
+	if stdout.String() != resp.Err().Error() {
+		t.Errorf("expected error output, got: %q", stdout.String())
+	}
+
+	// command errors
+	h, _ = newHook("fail")
+	var cmdErr error
+	h.OnError = func(resp *Response, err error) { cmdErr = err }
+	req, _ = NewRequest(filename, ts.URL+"?size=4096")
+	do(req, h)
+	if cmdErr == nil {
+		t.Errorf("expected command error")
+	}
+
+	if _, err := NewCommandHook("cmd", "{{.Filename"); err == nil {
+		t.Errorf("expected template error")
+	}
+}
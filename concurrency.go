@@ -0,0 +1,245 @@
+package grab
+
+import (
+	"sync"
+	"time"
+)
+
+// AdaptiveConcurrency configures an adaptive mode for the batches of a Client,
+// started with DoBatch or StartBatch, which tunes the number of concurrent
+// workers of each batch to its observed aggregate throughput and error rate,
+// instead of a fixed number of workers which must be guessed.
+//
+// A batch starts with the number of workers given to DoBatch, or MinWorkers if
+// it is less than one. Every Interval, a worker is added while requests are
+// queued, for as long as each added worker improves the throughput of the
+// batch. If an added worker does not improve the throughput, it is removed
+// again. If the ratio of transfers which failed during the interval, with
+// errors such as timeouts or 503 Service Unavailable, exceeds MaxErrorRate,
+// the number of workers is halved. Workers which are removed stop once their
+// current transfer is complete.
+type AdaptiveConcurrency struct {
+	// MinWorkers is the least number of workers of a batch. Default: 1.
+	MinWorkers int
+
+	// MaxWorkers is the greatest number of workers of a batch. Default: the
+	// number of requests in the batch.
+	MaxWorkers int
+
+	// Interval is how often the number of workers is adjusted. Longer
+	// intervals give more reliable measures of throughput. Default: 2
+	// seconds.
+	Interval time.Duration
+
+	// MaxErrorRate is the ratio of failed transfers, of those completed in an
+	// interval, above which the number of workers is reduced. Default: 0.1.
+	MaxErrorRate float64
+}
+
+const (
+	// tuneGain is the least relative improvement in throughput for which an
+	// added worker is kept.
+	tuneGain = 0.05
+
+	// tuneHold is the number of intervals for which no worker is added after
+	// the number of workers is reduced.
+	tuneHold = 3
+)
+
+// concurrencyTuner tunes the number of workers of a batch. See
+// AdaptiveConcurrency.
+type concurrencyTuner struct {
+	interval     time.Duration
+	maxErrorRate float64
+	min, max     int
+
+	mu sync.Mutex
+
+	// target is the desired number of workers and active is the number of
+	// running workers.
+	target, active int
+
+	// responses are the transfers of the batch which were incomplete at the
+	// last sample. done is the number of bytes transferred by the completed
+	// transfers, and total is the number of bytes transferred by the batch at
+	// the last sample.
+	responses []*Response
+	done      int64
+	total     int64
+
+	// rate is the throughput of the last interval. probing is true if a
+	// worker was added at the start of the interval.
+	rate    float64
+	probing bool
+	hold    int
+}
+
+// newConcurrencyTuner returns a concurrencyTuner for a batch of n requests
+// which starts with the given number of workers.
+func newConcurrencyTuner(config *AdaptiveConcurrency, workers, n int) *concurrencyTuner {
+	t := &concurrencyTuner{
+		interval:     config.Interval,
+		maxErrorRate: config.MaxErrorRate,
+		min:          config.MinWorkers,
+		max:          config.MaxWorkers,
+	}
+	if t.interval <= 0 {
+		t.interval = 2 * time.Second
+	}
+	if t.maxErrorRate <= 0 {
+		t.maxErrorRate = 0.1
+	}
+	if t.min < 1 {
+		t.min = 1
+	}
+	if t.max < 1 || t.max > n {
+		t.max = n
+	}
+	if t.max < t.min {
+		t.max = t.min
+	}
+	t.target = workers
+	if t.target < t.min {
+		t.target = t.min
+	}
+	if t.target > t.max {
+		t.target = t.max
+	}
+	t.active = t.target
+	return t
+}
+
+// workers returns the number of workers the batch should have.
+func (t *concurrencyTuner) workers() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.target
+}
+
+// add records a Response started by the batch.
+func (t *concurrencyTuner) add(resp *Response) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.responses = append(t.responses, resp)
+}
+
+// shrink returns true if the calling worker should stop because the batch has
+// more workers than it should.
+func (t *concurrencyTuner) shrink() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active > t.target {
+		t.active--
+		return true
+	}
+	return false
+}
+
+// run adjusts the number of workers of the batch of the given queue every
+// interval, calling spawn to start each added worker, until the queue is
+// empty or canceled.
+func (t *concurrencyTuner) run(q *batchQueue, spawn func()) {
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case now := <-ticker.C:
+			queued, paused := q.pending()
+			if queued == 0 {
+				return
+			}
+			n, completed, failed := t.sample()
+			d := now.Sub(last)
+			last = now
+			if paused {
+				continue
+			}
+			t.adjust(float64(n)/d.Seconds(), completed, failed)
+			for t.grow() {
+				spawn()
+			}
+		}
+	}
+}
+
+// sample returns the number of bytes transferred by the batch since the last
+// sample, and the number of transfers which completed or failed since then.
+func (t *concurrencyTuner) sample() (n int64, completed, failed int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	total := t.done
+	active := t.responses[:0]
+	for _, resp := range t.responses {
+		b := resp.bytesTransferred()
+		total += b
+		if !resp.IsComplete() {
+			active = append(active, resp)
+			continue
+		}
+		t.done += b
+		completed++
+		if err := resp.Err(); (&RetryPolicy{}).retryable(err) {
+			failed++
+		}
+	}
+	for i := len(active); i < len(t.responses); i++ {
+		t.responses[i] = nil
+	}
+	t.responses = active
+	n = total - t.total
+	if n < 0 {
+		// a transfer was restarted
+		n = 0
+	}
+	t.total = total
+	return n, completed, failed
+}
+
+// adjust sets the number of workers the batch should have, given the
+// throughput and the number of completed and failed transfers of the last
+// interval, while requests are queued.
+func (t *concurrencyTuner) adjust(rate float64, completed, failed int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case completed > 0 && float64(failed)/float64(completed) > t.maxErrorRate:
+		t.target /= 2
+		t.probing = false
+		t.hold = tuneHold
+
+	case t.probing && rate < t.rate*(1+tuneGain):
+		// the added worker did not help
+		t.target--
+		t.probing = false
+		t.hold = tuneHold
+
+	case t.hold > 0:
+		t.hold--
+		t.probing = false
+
+	case t.target < t.max:
+		t.target++
+		t.probing = true
+
+	default:
+		t.probing = false
+	}
+	if t.target < t.min {
+		t.target = t.min
+	}
+	t.rate = rate
+}
+
+// grow returns true if a worker should be started, and counts it as active.
+func (t *concurrencyTuner) grow() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active < t.target {
+		t.active++
+		return true
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package grab
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestAdaptiveConcurrency tests that workers are added to a batch while they
+// improve its throughput, against a server which rejects excess requests.
+func TestAdaptiveConcurrency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// each transfer is slow, so that throughput grows with concurrency, but
+	// the server rejects requests once more than 4 are active
+	var mu sync.Mutex
+	var active, max int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		n := active
+		if active > max {
+			max = active
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			active--
+			mu.Unlock()
+		}()
+		if n > 4 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write(make([]byte, 1024))
+	}))
+	defer s.Close()
+
+	reqs := make([]*Request, 200)
+	for i := range reqs {
+		reqs[i], _ = NewRequest(filepath.Join(dir, fmt.Sprintf("file%d", i)), s.URL)
+	}
+	client := NewClient()
+	client.AdaptiveConcurrency = &AdaptiveConcurrency{Interval: 50 * time.Millisecond}
+	b := client.StartBatch(1, reqs...)
+	workers := 1
+	for range b.Responses {
+		if n := b.Summary().Workers; n > workers {
+			workers = n
+		}
+	}
+	if workers < 3 {
+		t.Errorf("expected workers to be added, got at most: %d", workers)
+	}
+	mu.Lock()
+	if max < 3 {
+		t.Errorf("expected concurrent transfers, got at most: %d", max)
+	}
+	mu.Unlock()
+}
+
+func TestConcurrencyTunerAdjust(t *testing.T) {
+	tuner := newConcurrencyTuner(&AdaptiveConcurrency{MaxWorkers: 8}, 0, 100)
+	expect := func(workers int) {
+		t.Helper()
+		if n := tuner.workers(); n != workers {
+			t.Errorf("expected %d workers, got: %d", workers, n)
+		}
+	}
+	expect(1)
+
+	// workers are added while throughput improves
+	tuner.adjust(100, 0, 0)
+	expect(2)
+	tuner.adjust(200, 0, 0)
+	expect(3)
+	tuner.adjust(300, 0, 0)
+	expect(4)
+
+	// a worker which does not improve throughput is removed, and no workers
+	// are added for a while
+	tuner.adjust(301, 0, 0)
+	expect(3)
+	for i := 0; i < tuneHold; i++ {
+		tuner.adjust(300, 0, 0)
+		expect(3)
+	}
+	tuner.adjust(300, 0, 0)
+	expect(4)
+
+	// workers are halved while transfers fail
+	tuner.adjust(400, 10, 5)
+	expect(2)
+	tuner.adjust(100, 10, 5)
+	expect(1)
+	tuner.adjust(100, 10, 5)
+	expect(1)
+
+	// but not for a tolerable error rate
+	for i := 0; i < tuneHold; i++ {
+		tuner.adjust(100, 10, 1)
+	}
+	tuner.adjust(100, 10, 1)
+	expect(2)
+
+	// and not beyond MaxWorkers
+	for i := 0; i < 10; i++ {
+		tuner.adjust(float64(1000*(i+1)), 0, 0)
+	}
+	expect(8)
+}
@@ -0,0 +1,131 @@
+package grab
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// storedValidators are the validator headers of a remote file, stored once a
+// file is downloaded with Request.Conditional so that later requests for the
+// same file may be conditional.
+//
+// The validators are stored in a hidden sidecar file alongside the destination
+// file. See validatorsFilename.
+type storedValidators struct {
+	// URL is the requested URL of the remote file.
+	URL string `json:"url"`
+
+	// ETag and LastModified are the validators returned by the remote server.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+// validatorsFilename returns the path of the file in which the validators of
+// the given destination path are stored. E.g. /tmp/.example.zip.etag for
+// /tmp/example.zip.
+func validatorsFilename(filename string) string {
+	dir, base := filepath.Split(filename)
+	return filepath.Join(dir, "."+base+".etag")
+}
+
+// readValidators reads the stored validators of the given destination path.
+// If no validators are stored, or they cannot be read, nil is returned.
+func readValidators(filename string) *storedValidators {
+	b, err := ioutil.ReadFile(validatorsFilename(filename))
+	if err != nil {
+		return nil
+	}
+	v := &storedValidators{}
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// writeValidators stores the validators of the completed transfer of the given
+// Response. Any stale validators are removed if the remote server returned no
+// validators.
+func writeValidators(resp *Response) error {
+	name := validatorsFilename(resp.Filename)
+	v := &storedValidators{URL: resp.Request.URL().String()}
+	if resp.HTTPResponse != nil {
+		v.ETag = resp.HTTPResponse.Header.Get("ETag")
+		v.LastModified = resp.HTTPResponse.Header.Get("Last-Modified")
+	}
+	if v.ETag == "" && v.LastModified == "" {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// conditionalRequest prepares a conditional request for the remote file of
+// the given Response, if Request.Conditional is set and validators are stored
+// for the existing file at the destination path. The next stateFunc is
+// getRequest. If no conditional request can be made, conditionalRequest
+// returns nil.
+func (c *Client) conditionalRequest(resp *Response) stateFunc {
+	if !resp.Request.Conditional || resp.Request.hasRange() || resp.conditionalTried {
+		return nil
+	}
+	resp.conditionalTried = true
+	v := readValidators(resp.Filename)
+	if v == nil || v.URL != resp.Request.URL().String() {
+		return nil
+	}
+	resp.conditional = v
+
+	// the entire remote file replaces the existing file, unless not modified
+	resp.DidResume = false
+	if resp.committed {
+		resp.committed = false
+		resp.fi = nil
+	}
+	return c.getRequest
+}
+
+// setConditional sets the conditional headers of the given request, using the
+// stored validators of the given Response.
+func setConditional(req *http.Request, v *storedValidators) *http.Request {
+	hreq := new(http.Request)
+	*hreq = *req
+	hreq.Header = cloneHeader(req.Header)
+	if v.ETag != "" && hreq.Header.Get("If-None-Match") == "" {
+		hreq.Header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" && hreq.Header.Get("If-Modified-Since") == "" {
+		hreq.Header.Set("If-Modified-Since", v.LastModified)
+	}
+	return hreq
+}
+
+// notModified completes the transfer of the given Response after the remote
+// server responded to a conditional request with 304 Not Modified. The
+// existing file at the destination path is left untouched. The next stateFunc
+// is always closeResponse.
+func (c *Client) notModified(resp *Response) stateFunc {
+	resp.closeResponseBody()
+	fi, err := os.Stat(resp.Filename)
+	if err != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+	resp.committed = true
+	resp.NotModified = true
+	resp.Size = fi.Size()
+	resp.setBytesResumed(fi.Size())
+	return c.closeResponse
+}
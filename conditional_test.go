@@ -0,0 +1,73 @@
+package grab
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestConditional tests that files downloaded with Request.Conditional are only
+// transferred again if the remote file has changed.
+func TestConditional(t *testing.T) {
+	filename := ".testConditional"
+	defer os.Remove(filename)
+	defer os.Remove(validatorsFilename(filename))
+
+	content, etag := []byte("version 1"), `"v1"`
+	var ifNoneMatch string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = r.Header.Get("If-None-Match")
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, "", time.Unix(0, 0), bytes.NewReader(content))
+	}))
+	defer s.Close()
+
+	download := func() *Response {
+		req, _ := NewRequest(filename, s.URL)
+		req.Conditional = true
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		return resp
+	}
+	testContent := func(expect []byte) {
+		b, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, expect) {
+			t.Errorf("expected content %q, got: %q", expect, b)
+		}
+	}
+
+	if resp := download(); resp.NotModified {
+		t.Errorf("expected first download to transfer the file")
+	}
+	testContent(content)
+	if v := readValidators(filename); v == nil || v.ETag != etag {
+		t.Fatalf("expected validators to be stored, got: %v", v)
+	}
+
+	resp := download()
+	if !resp.NotModified || resp.BytesComplete() != int64(len(content)) {
+		t.Errorf("expected file not to be modified")
+	}
+	if ifNoneMatch != etag {
+		t.Errorf("expected If-None-Match header %s, got: %s", etag, ifNoneMatch)
+	}
+	testContent(content)
+
+	content, etag = []byte("version two"), `"v2"`
+	if resp := download(); resp.NotModified {
+		t.Errorf("expected modified file to be transferred")
+	}
+	testContent(content)
+	if v := readValidators(filename); v == nil || v.ETag != etag {
+		t.Errorf("expected validators to be updated, got: %v", v)
+	}
+}
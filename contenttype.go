@@ -0,0 +1,60 @@
+package grab
+
+import (
+	"mime"
+	"strings"
+)
+
+// checkContentType returns a ContentTypeError if the media type of the
+// response is not accepted by the Request.
+func checkContentType(resp *Response) error {
+	req := resp.Request
+	if len(req.AcceptContentTypes) == 0 && len(req.RejectContentTypes) == 0 && req.ContentTypeFunc == nil {
+		return nil
+	}
+	mediaType := "application/octet-stream"
+	if v := resp.HTTPResponse.Header.Get("Content-Type"); v != "" {
+		t, _, err := mime.ParseMediaType(v)
+		if err != nil {
+			return ContentTypeError(v)
+		}
+		mediaType = t
+	}
+	ok := len(req.AcceptContentTypes) == 0
+	for _, pattern := range req.AcceptContentTypes {
+		if matchMediaType(pattern, mediaType) {
+			ok = true
+			break
+		}
+	}
+	for _, pattern := range req.RejectContentTypes {
+		if matchMediaType(pattern, mediaType) {
+			ok = false
+			break
+		}
+	}
+	if ok && req.ContentTypeFunc != nil {
+		ok = req.ContentTypeFunc(mediaType, resp.HTTPResponse.Header)
+	}
+	if !ok {
+		return ContentTypeError(mediaType)
+	}
+	return nil
+}
+
+// matchMediaType returns true if the given media type matches the given
+// pattern, such as "image/png", "image/*" or "*/*". Parameters of the pattern
+// are ignored.
+func matchMediaType(pattern, mediaType string) bool {
+	if i := strings.Index(pattern, ";"); i >= 0 {
+		pattern = pattern[:i]
+	}
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	if pattern == "*/*" || pattern == "*" || pattern == mediaType {
+		return true
+	}
+	if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern && strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(mediaType, prefix)
+	}
+	return false
+}
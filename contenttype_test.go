@@ -0,0 +1,87 @@
+package grab
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestMatchMediaType(t *testing.T) {
+	tests := []struct {
+		pattern, mediaType string
+		expect             bool
+	}{
+		{"image/png", "image/png", true},
+		{"IMAGE/PNG", "image/png", true},
+		{"image/*", "image/png", true},
+		{"image/*", "imagex/png", false},
+		{"*/*", "text/html", true},
+		{"text/plain; charset=utf-8", "text/plain", true},
+		{"image/png", "image/jpeg", false},
+		{"image", "image/png", false},
+	}
+	for _, test := range tests {
+		if actual := matchMediaType(test.pattern, test.mediaType); actual != test.expect {
+			t.Errorf("%s %s: expected %v, got: %v", test.pattern, test.mediaType, test.expect, actual)
+		}
+	}
+}
+
+// TestContentTypes tests that transfers of files with a media type which is
+// not accepted by the Request fail with a ContentTypeError before the file
+// is written.
+func TestContentTypes(t *testing.T) {
+	filename := ".testContentTypes"
+	defer os.Remove(filename)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.URL.Query().Get("type"); v != "" {
+			w.Header().Set("Content-Type", v)
+		} else {
+			w.Header()["Content-Type"] = nil // no sniffing
+		}
+		w.Header().Set("X-Example", "ok")
+		w.Write([]byte("hello"))
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		contentType    string
+		accept, reject []string
+		f              func(string, http.Header) bool
+		ok             bool
+	}{
+		{"image/png", []string{"image/*"}, nil, nil, true},
+		{"text/html; charset=utf-8", []string{"image/*"}, nil, nil, false},
+		{"", []string{"application/octet-stream"}, nil, nil, true},
+		{"text/html", nil, []string{"text/html"}, nil, false},
+		{"image/svg+xml", []string{"image/*"}, []string{"image/svg+xml"}, nil, false},
+		{"image/png", nil, nil, func(mediaType string, h http.Header) bool {
+			return mediaType == "image/png" && h.Get("X-Example") == "ok"
+		}, true},
+		{"image/png", nil, nil, func(string, http.Header) bool { return false }, false},
+	}
+	for _, test := range tests {
+		os.Remove(filename)
+		req, _ := NewRequest(filename, s.URL+"?type="+url.QueryEscape(test.contentType))
+		req.AcceptContentTypes = test.accept
+		req.RejectContentTypes = test.reject
+		req.ContentTypeFunc = test.f
+		err := DefaultClient.Do(req).Err()
+		if test.ok {
+			if err != nil {
+				t.Errorf("%s: %v", test.contentType, err)
+			}
+			continue
+		}
+		var cterr ContentTypeError
+		if !errors.As(err, &cterr) || !errors.Is(err, ErrValidation) {
+			t.Errorf("%s: expected ContentTypeError, got: %v", test.contentType, err)
+		}
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no file to be written", test.contentType)
+		}
+	}
+}
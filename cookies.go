@@ -0,0 +1,268 @@
+package grab
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A CookieJar is a http.CookieJar which can be saved to and loaded from the
+// Netscape cookies.txt format of curl and wget, so that sessions of remote
+// servers, such as those of a login, persist across process restarts. Set it
+// as the Jar of Client.HTTPClient. Cookies are matched to requests by a jar of
+// net/http/cookiejar, without a public suffix list.
+//
+// CookieJars are safe for concurrent use by multiple goroutines.
+type CookieJar struct {
+	mu      sync.Mutex
+	jar     *cookiejar.Jar
+	entries map[cookieKey]*cookieEntry
+}
+
+// cookieKey identifies a cookie.
+type cookieKey struct {
+	domain, path, name string
+}
+
+// cookieEntry is a cookie, as stored in a cookies.txt file.
+type cookieEntry struct {
+	domain   string
+	hostOnly bool
+	path     string
+	secure   bool
+	httpOnly bool
+	expires  time.Time // zero for session cookies
+	name     string
+	value    string
+}
+
+// NewCookieJar returns a new, empty CookieJar.
+func NewCookieJar() *CookieJar {
+	jar, _ := cookiejar.New(nil)
+	return &CookieJar{jar: jar, entries: make(map[cookieKey]*cookieEntry)}
+}
+
+// Cookies implements http.CookieJar.
+func (c *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return c.jar.Cookies(u)
+}
+
+// SetCookies implements http.CookieJar.
+func (c *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.jar.SetCookies(u, cookies)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		// ignored by the jar
+		return
+	}
+	now := time.Now()
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, cookie := range cookies {
+		domain, hostOnly, ok := cookieDomain(host, cookie.Domain)
+		if !ok {
+			// rejected by the jar
+			continue
+		}
+		e := &cookieEntry{
+			domain:   domain,
+			hostOnly: hostOnly,
+			path:     cookie.Path,
+			secure:   cookie.Secure,
+			httpOnly: cookie.HttpOnly,
+			name:     cookie.Name,
+			value:    cookie.Value,
+		}
+		if e.path == "" || e.path[0] != '/' {
+			// the default path of RFC 6265, section 5.1.4
+			e.path = path.Dir(u.Path)
+			if u.Path == "" || u.Path[0] != '/' {
+				e.path = "/"
+			}
+		}
+		switch {
+		case cookie.MaxAge < 0:
+			e.expires = now.Add(-time.Second)
+		case cookie.MaxAge > 0:
+			e.expires = now.Add(time.Duration(cookie.MaxAge) * time.Second)
+		case !cookie.Expires.IsZero():
+			e.expires = cookie.Expires
+		}
+		key := cookieKey{e.domain, e.path, e.name}
+		if !e.expires.IsZero() && !e.expires.After(now) {
+			delete(c.entries, key)
+			continue
+		}
+		c.entries[key] = e
+	}
+}
+
+// cookieDomain returns the domain of a cookie with the given Domain attribute,
+// set by the given host, and whether it is a host-only cookie. It returns
+// false if the cookie is rejected, because the host does not domain-match its
+// Domain attribute, as checked by net/http/cookiejar.
+func cookieDomain(host, domain string) (string, bool, bool) {
+	if domain == "" {
+		return host, true, true
+	}
+	if net.ParseIP(host) != nil {
+		// cookies of IP addresses are host-only
+		return host, true, domain == host
+	}
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	if domain == "" || domain[0] == '.' || domain[len(domain)-1] == '.' {
+		return "", false, false
+	}
+	if host != domain && !strings.HasSuffix(host, "."+domain) {
+		return "", false, false
+	}
+	return domain, false, true
+}
+
+// Save writes the unexpired cookies of the jar, including session cookies,
+// to w in the Netscape cookies.txt format. Session cookies have an expiry of
+// zero.
+func (c *CookieJar) Save(w io.Writer) error {
+	c.mu.Lock()
+	entries := make([]*cookieEntry, 0, len(c.entries))
+	now := time.Now()
+	for key, e := range c.entries {
+		if !e.expires.IsZero() && !e.expires.After(now) {
+			delete(c.entries, key)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	c.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.domain != b.domain {
+			return a.domain < b.domain
+		}
+		if a.path != b.path {
+			return a.path < b.path
+		}
+		return a.name < b.name
+	})
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "# Netscape HTTP Cookie File")
+	for _, e := range entries {
+		domain := e.domain
+		if !e.hostOnly {
+			domain = "." + domain
+		}
+		if e.httpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expires int64
+		if !e.expires.IsZero() {
+			expires = e.expires.Unix()
+		}
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, netscapeBool(!e.hostOnly), e.path, netscapeBool(e.secure),
+			expires, e.name, e.value)
+	}
+	return bw.Flush()
+}
+
+// SaveFile saves the cookies of the jar to the given file, with the
+// permissions 0600, replacing the file atomically.
+func (c *CookieJar) SaveFile(filename string) error {
+	tmp := partFilename("", filename)
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := c.Save(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// Load adds the cookies of the Netscape cookies.txt file read from r to the
+// jar. Expired cookies are ignored.
+func (c *CookieJar) Load(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	now := time.Now()
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimRight(s.Text(), "\r")
+		httpOnly := false
+		if strings.HasPrefix(text, "#HttpOnly_") {
+			text, httpOnly = strings.TrimPrefix(text, "#HttpOnly_"), true
+		}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) == 6 {
+			// cookies without a value
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return fmt.Errorf("malformed cookies file at line %d", line)
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("malformed cookies file at line %d: %v", line, err)
+		}
+		domain := strings.ToLower(fields[0])
+		cookie := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if expires != 0 {
+			cookie.Expires = time.Unix(expires, 0)
+			if !cookie.Expires.After(now) {
+				continue
+			}
+		}
+		if strings.EqualFold(fields[1], "TRUE") || strings.HasPrefix(domain, ".") {
+			cookie.Domain = domain
+		}
+		u := &url.URL{Scheme: "http", Host: strings.TrimPrefix(domain, "."), Path: cookie.Path}
+		if cookie.Secure {
+			u.Scheme = "https"
+		}
+		c.SetCookies(u, []*http.Cookie{cookie})
+	}
+	return s.Err()
+}
+
+// LoadFile adds the cookies of the given Netscape cookies.txt file to the
+// jar.
+func (c *CookieJar) LoadFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.Load(f)
+}
+
+func netscapeBool(v bool) string {
+	if v {
+		return "TRUE"
+	}
+	return "FALSE"
+}
@@ -0,0 +1,151 @@
+package grab
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCookieJar tests that the cookies of a session persist across clients by
+// saving and loading a CookieJar.
+func TestCookieJar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/login" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/", MaxAge: 3600, HttpOnly: true})
+			http.SetCookie(w, &http.Cookie{Name: "lang", Value: "en"})
+			http.SetCookie(w, &http.Cookie{Name: "old", Value: "x", Path: "/", MaxAge: -1})
+			return
+		}
+		if c, err := r.Cookie("session"); err != nil || c.Value != "abc" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer s.Close()
+
+	client := NewClient()
+	jar := NewCookieJar()
+	client.HTTPClient.Jar = jar
+	req, _ := NewRequest(filepath.Join(dir, "login"), s.URL+"/login")
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatal(err)
+	}
+	cookies := filepath.Join(dir, "cookies.txt")
+	if err := jar.SaveFile(cookies); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(cookies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(s.URL)
+	for _, expect := range []string{
+		"# Netscape HTTP Cookie File\n",
+		"#HttpOnly_" + u.Hostname() + "\tFALSE\t/\tFALSE\t",
+		u.Hostname() + "\tFALSE\t/\tFALSE\t0\tlang\ten\n",
+	} {
+		if !strings.Contains(string(b), expect) {
+			t.Errorf("expected %q in:\n%s", expect, b)
+		}
+	}
+	if strings.Contains(string(b), "old") {
+		t.Errorf("expected deleted cookie to be omitted:\n%s", b)
+	}
+
+	client = NewClient()
+	jar = NewCookieJar()
+	if err := jar.LoadFile(cookies); err != nil {
+		t.Fatal(err)
+	}
+	client.HTTPClient.Jar = jar
+	req, _ = NewRequest(filepath.Join(dir, "file"), s.URL+"/file")
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// saving a loaded jar writes the same file
+	var buf bytes.Buffer
+	if err := jar.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(b) {
+		t.Errorf("expected:\n%s\ngot:\n%s", b, buf.String())
+	}
+}
+
+func TestCookieJarLoad(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Unix()
+	cookies := strings.Join([]string{
+		"# comment",
+		"",
+		".example.com\tTRUE\t/\tTRUE\t" + strconv.FormatInt(expires, 10) + "\tdomain\t1",
+		"www.example.com\tFALSE\t/docs\tFALSE\t0\thost\t2",
+		"www.example.com\tFALSE\t/\tFALSE\t1\texpired\t3",
+		"www.example.com\tFALSE\t/\tFALSE\t0\tempty",
+	}, "\n")
+	jar := NewCookieJar()
+	if err := jar.Load(strings.NewReader(cookies)); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"https://example.com/":         "domain=1",
+		"https://www.example.com/docs": "host=2; domain=1; empty=",
+		"http://www.example.com/docs":  "host=2; empty=",
+		"http://other.example.com/":    "",
+	}
+	for s, expect := range tests {
+		u, _ := url.Parse(s)
+		var names []string
+		for _, c := range jar.Cookies(u) {
+			names = append(names, c.Name+"="+c.Value)
+		}
+		if actual := strings.Join(names, "; "); actual != expect {
+			t.Errorf("%s: expected %q, got: %q", s, expect, actual)
+		}
+	}
+	if err := jar.Load(strings.NewReader("example.com\tFALSE\t/")); err == nil {
+		t.Errorf("expected error for malformed cookies file")
+	}
+}
+
+// TestCookieJarRejected tests that cookies rejected by the jar, such as those
+// set by one site for the domain of another, are not saved.
+func TestCookieJarRejected(t *testing.T) {
+	jar := NewCookieJar()
+	u, _ := url.Parse("https://evil.example/")
+	jar.SetCookies(u, []*http.Cookie{
+		{Name: "session", Value: "attacker", Domain: "bank.example"},
+		{Name: "ip", Value: "x", Domain: "127.0.0.1"},
+		{Name: "ok", Value: "1", Domain: ".evil.example"},
+	})
+	var buf bytes.Buffer
+	if err := jar.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if s := buf.String(); strings.Contains(s, "attacker") || strings.Contains(s, "127.0.0.1") || !strings.Contains(s, "\tok\t1") {
+		t.Errorf("expected only the accepted cookie, got:\n%s", s)
+	}
+
+	jar = NewCookieJar()
+	if err := jar.Load(&buf); err != nil {
+		t.Fatal(err)
+	}
+	u, _ = url.Parse("https://bank.example/")
+	if cookies := jar.Cookies(u); len(cookies) != 0 {
+		t.Errorf("expected no cookies for bank.example, got: %v", cookies)
+	}
+}
@@ -0,0 +1,99 @@
+package grab
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// parseDataURL decodes the media type and payload of a data: URL of RFC 2397,
+// such as data:text/plain;base64,SGVsbG8sIFdvcmxkIQ==. The media type
+// defaults to text/plain;charset=US-ASCII.
+func parseDataURL(u *url.URL) (mediaType string, data []byte, err error) {
+	v := u.Opaque
+	if u.RawQuery != "" {
+		v += "?" + u.RawQuery
+	}
+	if v == "" {
+		// data://... is not a valid data URL, but is parsed to a host
+		return "", nil, fmt.Errorf("malformed data URL")
+	}
+	meta, payload, ok := strings.Cut(v, ",")
+	if !ok {
+		return "", nil, fmt.Errorf("malformed data URL: no payload")
+	}
+	payload, err = url.PathUnescape(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("malformed data URL: %w", err)
+	}
+	meta, err = url.PathUnescape(meta)
+	if err != nil {
+		return "", nil, fmt.Errorf("malformed data URL: %w", err)
+	}
+	if strings.HasSuffix(strings.ToLower(meta), ";base64") {
+		meta = meta[:len(meta)-len(";base64")]
+		payload = strings.TrimRight(strings.Join(strings.Fields(payload), ""), "=")
+		if data, err = base64.RawStdEncoding.DecodeString(payload); err != nil {
+			if data, err = base64.RawURLEncoding.DecodeString(payload); err != nil {
+				return "", nil, fmt.Errorf("malformed data URL: %w", err)
+			}
+		}
+	} else {
+		data = []byte(payload)
+	}
+	mediaType = "text/plain;charset=US-ASCII"
+	if meta != "" {
+		if strings.HasPrefix(meta, ";") {
+			meta = "text/plain" + meta
+		}
+		if _, _, err := mime.ParseMediaType(meta); err != nil {
+			return "", nil, fmt.Errorf("malformed data URL media type: %s", meta)
+		}
+		mediaType = meta
+	}
+	return mediaType, data, nil
+}
+
+// dataRoundTrip responds to the given request for a data: URL with its
+// decoded payload, as a HTTP server would serve a file, so that inlined files
+// are written with the same semantics as remote files. Data URLs have no
+// filename, so the destination of the Request must be a file.
+func dataRoundTrip(c *Client, req *http.Request) (*http.Response, error) {
+	mediaType, data, err := parseDataURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	hresp, err := serveFile(req, func() (os.FileInfo, fileReader, error) {
+		return dataFileInfo(len(data)), dataReader{bytes.NewReader(data)}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	hresp.Header.Del("Last-Modified")
+	hresp.Header.Set("Content-Type", mediaType)
+	return hresp, nil
+}
+
+// dataReader is the payload of a data: URL, served by serveFile.
+type dataReader struct {
+	*bytes.Reader
+}
+
+func (c dataReader) Close() error { return nil }
+
+// dataFileInfo is the os.FileInfo of the payload of a data: URL, of the given
+// size.
+type dataFileInfo int64
+
+func (c dataFileInfo) Name() string       { return "" }
+func (c dataFileInfo) Size() int64        { return int64(c) }
+func (c dataFileInfo) Mode() os.FileMode  { return 0444 }
+func (c dataFileInfo) ModTime() time.Time { return time.Time{} }
+func (c dataFileInfo) IsDir() bool        { return false }
+func (c dataFileInfo) Sys() interface{}   { return nil }
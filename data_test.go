@@ -0,0 +1,79 @@
+package grab
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestParseDataURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		mediaType string
+		data      string
+	}{
+		{"data:,Hello%2C%20World%21", "text/plain;charset=US-ASCII", "Hello, World!"},
+		{"data:text/plain;base64,SGVsbG8sIFdvcmxkIQ==", "text/plain", "Hello, World!"},
+		{"data:text/plain;base64,SGVsbG8sIFdvcmxkIQ", "text/plain", "Hello, World!"},
+		{"data:;charset=utf-8,caf%C3%A9", "text/plain;charset=utf-8", "café"},
+		{"data:application/octet-stream;BASE64,AAEC", "application/octet-stream", "\x00\x01\x02"},
+		{"data:text/plain,a?b=c", "text/plain", "a?b=c"},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType, data, err := parseDataURL(u)
+		if err != nil {
+			t.Errorf("%s: error: %v", test.url, err)
+			continue
+		}
+		if mediaType != test.mediaType || string(data) != test.data {
+			t.Errorf("%s: expected %s %q, got: %s %q", test.url, test.mediaType, test.data, mediaType, data)
+		}
+	}
+	for _, v := range []string{"data:text/plain", "data:;base64,!!!", "data:bad type,x", "data://host/path"} {
+		u, _ := url.Parse(v)
+		if _, _, err := parseDataURL(u); err == nil {
+			t.Errorf("%s: expected error", v)
+		}
+	}
+}
+
+// TestDataURL tests that the payloads of data: URLs are written to the
+// destination file.
+func TestDataURL(t *testing.T) {
+	filename := ".testDataURL"
+	defer os.Remove(filename)
+
+	req, _ := NewRequest(filename, "data:text/plain;base64,SGVsbG8sIFdvcmxkIQ==")
+	resp := DefaultClient.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if resp.Size != 13 || resp.HTTPResponse.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("unexpected response: %d %s", resp.Size, resp.HTTPResponse.Header.Get("Content-Type"))
+	}
+	testContent(t, filename, []byte("Hello, World!"))
+
+	// resume
+	if err := ioutil.WriteFile(filename, []byte("Hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	req, _ = NewRequest(filename, "data:,Hello%2C%20World%21")
+	resp = DefaultClient.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if !resp.DidResume || resp.BytesComplete() != 13 {
+		t.Errorf("expected transfer to resume")
+	}
+	testContent(t, filename, []byte("Hello, World!"))
+
+	req, _ = NewRequest(".", "data:,x")
+	if err := DefaultClient.Do(req).Err(); err != ErrNoFilename {
+		t.Errorf("expected ErrNoFilename, got: %v", err)
+	}
+}
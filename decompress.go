@@ -0,0 +1,152 @@
+package grab
+
+import (
+	"compress/bzip2"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	decompressorsMu sync.RWMutex
+	decompressors   = map[string]ContentDecoder{
+		".gz":  decodeGzip,
+		".bz2": decodeBzip2,
+	}
+)
+
+func decodeBzip2(r io.Reader) (io.Reader, error) {
+	return bzip2.NewReader(r), nil
+}
+
+// RegisterDecompressor registers a ContentDecoder which decompresses files
+// with the given extension, such as ".xz" or ".zst", for use by transfers with
+// Request.Decompress. The ".gz" and ".bz2" extensions are registered by
+// default. Any decoder already registered for the extension is replaced.
+func RegisterDecompressor(ext string, d ContentDecoder) {
+	decompressorsMu.Lock()
+	defer decompressorsMu.Unlock()
+	decompressors[strings.ToLower(ext)] = d
+}
+
+// decompressor returns the ContentDecoder registered for the extension of the
+// given filename and the filename without the extension. If no decoder is
+// registered, decompressor returns nil.
+func decompressor(filename string) (ContentDecoder, string) {
+	ext := filepath.Ext(filename)
+	decompressorsMu.RLock()
+	defer decompressorsMu.RUnlock()
+	d := decompressors[strings.ToLower(ext)]
+	if d == nil {
+		return nil, ""
+	}
+	return d, strings.TrimSuffix(filename, ext)
+}
+
+// AddDecompressedChecksum adds a hashing algorithm and checksum value to
+// validate the decompressed content of a file transferred with Decompress, in
+// addition to any checksums of the compressed file configured with SetChecksum
+// or AddChecksum. The result of each checksum is available via
+// Response.DecompressedChecksums.
+//
+// To prevent corruption of the computed checksum, the given hash must not be
+// used by any other request or goroutines.
+func (r *Request) AddDecompressedChecksum(h hash.Hash, sum []byte) {
+	r.decompressedChecksums = append(r.decompressedChecksums, Checksum{Hash: h, Expected: sum})
+}
+
+// decompressFile decompresses the validated destination file of the given
+// Response, if Request.Decompress is set and a decompressor is registered for
+// its extension. The decompressed file is written alongside the compressed
+// file, without its extension, and Response.Filename is set to its path. The
+// next stateFunc is extractArchive.
+func (c *Client) decompressFile(resp *Response) stateFunc {
+	req := resp.Request
+	if !req.Decompress || req.writer != nil {
+		return c.extractArchive
+	}
+	d, name := decompressor(resp.Filename)
+	if d == nil || name == "" {
+		return c.extractArchive
+	}
+	resp.err = c.decompress(resp, d, name)
+	if resp.err != nil {
+		return c.closeResponse
+	}
+	if !req.KeepCompressed {
+		resp.err = os.Remove(resp.Filename)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+	resp.Filename = name
+	return c.extractArchive
+}
+
+// decompress decodes the destination file of the given Response into a
+// temporary file, validates any decompressed checksums and renames the
+// temporary file to the given filename.
+func (c *Client) decompress(resp *Response, d ContentDecoder, filename string) error {
+	req := resp.Request
+	src, err := os.Open(resp.Filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fi, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	r, err := d(src)
+	if err != nil {
+		return err
+	}
+
+	tmp := partFilename("", filename)
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	var w io.Writer = dst
+	var hw *hashWriter
+	if len(req.decompressedChecksums) > 0 {
+		hs := hashes(req.decompressedChecksums)
+		for _, h := range hs {
+			h.Reset()
+		}
+		hw = &hashWriter{hs: hs}
+		w = io.MultiWriter(dst, hw)
+	}
+	t := newTransfer(resp.ctx, nil, nil, w, r, resp.bufferSize)
+	if _, err := t.copy(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	// compare checksums
+	if hw != nil {
+		sums := hw.sums()
+		resp.DecompressedChecksums = make([]Checksum, len(req.decompressedChecksums))
+		for i, sum := range req.decompressedChecksums {
+			sum.Actual = sums[i]
+			resp.DecompressedChecksums[i] = sum
+			if !sum.OK() {
+				return ErrBadChecksum
+			}
+		}
+	}
+	if err := os.Chtimes(tmp, fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+	if err := setOwner(tmp, req.Owner); err != nil {
+		return err
+	}
+	return renameFile(tmp, filename)
+}
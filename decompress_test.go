@@ -0,0 +1,83 @@
+package grab
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestDecompress tests that files are decompressed after they are validated if
+// Request.Decompress is set.
+func TestDecompress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := bytes.Repeat([]byte("grab "), 4096)
+	gz := &bytes.Buffer{}
+	zw := gzip.NewWriter(gz)
+	zw.Write(content)
+	zw.Close()
+	sum, gzSum := sha256.Sum256(content), sha256.Sum256(gz.Bytes())
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(gz.Bytes())
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		Name           string
+		KeepCompressed bool
+		Sum            []byte
+		Err            error
+	}{
+		{"Remove", false, sum[:], nil},
+		{"Keep", true, sum[:], nil},
+		{"BadChecksum", false, make([]byte, sha256.Size), ErrBadChecksum},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			compressed := filepath.Join(dir, "example.log.gz")
+			filename := filepath.Join(dir, "example.log")
+			defer os.Remove(compressed)
+			defer os.Remove(filename)
+
+			req, _ := NewRequest(compressed, s.URL+"/example.log.gz")
+			req.Decompress = true
+			req.KeepCompressed = test.KeepCompressed
+			req.SetChecksum(sha256.New(), gzSum[:], false)
+			req.AddDecompressedChecksum(sha256.New(), test.Sum)
+			resp := DefaultClient.Do(req)
+			if err := resp.Err(); err != test.Err {
+				t.Fatalf("expected error %v, got: %v", test.Err, err)
+			}
+			if len(resp.DecompressedChecksums) != 1 {
+				t.Fatalf("expected decompressed checksum to be computed")
+			}
+			if test.Err != nil {
+				if _, err := os.Stat(filename); !os.IsNotExist(err) {
+					t.Errorf("expected invalid decompressed file to be removed")
+				}
+				return
+			}
+			if resp.Filename != filename {
+				t.Errorf("expected Filename %s, got: %s", filename, resp.Filename)
+			}
+			if b, _ := ioutil.ReadFile(filename); !bytes.Equal(b, content) {
+				t.Errorf("expected decompressed content")
+			}
+			_, err := os.Stat(compressed)
+			if test.KeepCompressed != (err == nil) {
+				t.Errorf("expected compressed file to be kept: %v", test.KeepCompressed)
+			}
+		})
+	}
+}
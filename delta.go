@@ -0,0 +1,340 @@
+package grab
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// A Delta describes a remote file as a sequence of fixed-size blocks, each
+// with a weak rolling checksum and a strong checksum, similar to the control
+// files of zsync. If the remote file has changed since it was last
+// downloaded, the blocks of the new version which are found anywhere in the
+// existing local file are copied from the local file and only the remaining
+// blocks are transferred from the remote server. Deltas are created from the
+// remote file with NewDelta and are typically published alongside it.
+type Delta struct {
+	// Size is the size in bytes of the remote file.
+	Size int64
+
+	// BlockSize is the size in bytes of each block. The last block may be
+	// smaller.
+	BlockSize int
+
+	// New returns a new hash.Hash of the algorithm used to compute the strong
+	// checksum of each block, such as sha256.New.
+	New func() hash.Hash
+
+	// Blocks lists the checksums of each block of the remote file, in order.
+	Blocks []DeltaBlock
+}
+
+// A DeltaBlock is the checksums of a single block of a Delta.
+type DeltaBlock struct {
+	// Weak is the rolling checksum of the block. See NewDelta.
+	Weak uint32
+
+	// Strong is the checksum of the block, computed with Delta.New.
+	Strong []byte
+}
+
+// NewDelta returns a Delta of the file read from the given io.Reader, with the
+// given block size and strong hash algorithm. Weak checksums are the rolling
+// checksum of rsync: the low 16 bits are the sum of all bytes in the block and
+// the high 16 bits are the sum of each byte multiplied by its distance from
+// the end of the block.
+func NewDelta(r io.Reader, blockSize int, newHash func() hash.Hash) (*Delta, error) {
+	d := &Delta{BlockSize: blockSize, New: newHash}
+	b := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, b)
+		if n > 0 {
+			h := newHash()
+			h.Write(b[:n])
+			d.Blocks = append(d.Blocks, DeltaBlock{
+				Weak:   newRollingSum(b[:n]).sum(),
+				Strong: h.Sum(nil),
+			})
+			d.Size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return d, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// blockSize returns the size of the block of the Delta with the given index.
+func (c *Delta) blockSize(i int) int64 {
+	off := int64(i) * int64(c.BlockSize)
+	if off+int64(c.BlockSize) > c.Size {
+		return c.Size - off
+	}
+	return int64(c.BlockSize)
+}
+
+// rollingSum is the weak rolling checksum of a window of bytes. Sums are
+// computed modulo 2^16 by masking, so the components may overflow freely.
+type rollingSum struct {
+	a, b uint32
+	n    uint32
+}
+
+func newRollingSum(p []byte) *rollingSum {
+	c := &rollingSum{n: uint32(len(p))}
+	for i, v := range p {
+		c.a += uint32(v)
+		c.b += uint32(len(p)-i) * uint32(v)
+	}
+	return c
+}
+
+// roll moves the window forward by one byte.
+func (c *rollingSum) roll(out, in byte) {
+	c.a += uint32(in) - uint32(out)
+	c.b += c.a - c.n*uint32(out)
+}
+
+func (c *rollingSum) sum() uint32 {
+	return c.a&0xffff | c.b<<16
+}
+
+// match searches the file at the given path for the blocks of the Delta and
+// returns the offset in the file of each block, or -1 for each block which
+// was not found. Every offset of the file is searched for the full-size
+// blocks. The last block, if smaller, is only compared with the same offset of
+// the file.
+func (c *Delta) match(ctx context.Context, name string) ([]int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	found := make([]int64, len(c.Blocks))
+	weak := make(map[uint32][]int, len(c.Blocks))
+	for i, blk := range c.Blocks {
+		found[i] = -1
+		if c.blockSize(i) == int64(c.BlockSize) {
+			weak[blk.Weak] = append(weak[blk.Weak], i)
+		}
+	}
+
+	L := c.BlockSize
+	win := make([]byte, L)
+	br := bufio.NewReaderSize(f, 64*1024)
+	var off int64 // offset of the window in the file
+	var p int     // offset of the first byte of the window in win
+	var rs *rollingSum
+	var rolled int
+	fill := func() bool {
+		if _, err := io.ReadFull(br, win); err != nil {
+			return false
+		}
+		p = 0
+		rs = newRollingSum(win)
+		return true
+	}
+	ok := fill()
+	for ok {
+		if rolled++; rolled%(1<<20) == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		matched := false
+		if idx := weak[rs.sum()]; len(idx) > 0 {
+			h := c.New()
+			h.Write(win[p:])
+			h.Write(win[:p])
+			strong := h.Sum(nil)
+			for _, i := range idx {
+				if found[i] < 0 && bytes.Equal(strong, c.Blocks[i].Strong) {
+					found[i] = off
+					matched = true
+				}
+			}
+		}
+		if matched {
+			off += int64(L)
+			ok = fill()
+			continue
+		}
+		v, err := br.ReadByte()
+		if err != nil {
+			break
+		}
+		rs.roll(win[p], v)
+		win[p] = v
+		p = (p + 1) % L
+		off++
+	}
+
+	// compare a smaller last block with the same offset only
+	if i := len(c.Blocks) - 1; i >= 0 && c.blockSize(i) < int64(L) {
+		off := int64(i) * int64(L)
+		b := make([]byte, c.blockSize(i))
+		if _, err := f.ReadAt(b, off); err == nil {
+			h := c.New()
+			h.Write(b)
+			if bytes.Equal(h.Sum(nil), c.Blocks[i].Strong) {
+				found[i] = off
+			}
+		}
+	}
+	return found, nil
+}
+
+// hasDelta returns true if the Delta of the given Request may be used to
+// update its existing destination file.
+func (r *Request) hasDelta() bool {
+	return r.Delta != nil &&
+		r.Delta.BlockSize > 0 &&
+		r.Delta.New != nil &&
+		r.writer == nil &&
+		len(r.writers) == 0 &&
+		!r.hasRange() &&
+		!r.PersistState &&
+		!r.DecodeContent
+}
+
+// deltaFilename returns the path of the temporary file in which a file is
+// assembled from a Delta, before it replaces the given destination path.
+func deltaFilename(filename string) string {
+	return filename + ".delta"
+}
+
+// deltaRequest prepares the transfer of the blocks of the Delta of the given
+// Request which are not found in the existing destination file. Blocks which
+// are found are first copied into a new temporary file. The missing blocks are
+// transferred later by copyFile, after which the temporary file replaces the
+// destination file. The existing file is left untouched if the transfer
+// fails.
+//
+// If the size of the remote file does not match the Delta, the Delta is
+// ignored and the next stateFunc is validateLocal.
+func (c *Client) deltaRequest(resp *Response) stateFunc {
+	d := resp.Request.Delta
+	if size := resp.HTTPResponse.ContentLength; (size > 0 && size != d.Size) ||
+		(resp.Request.Size > 0 && resp.Request.Size != d.Size) ||
+		len(d.Blocks) != int((d.Size+int64(d.BlockSize)-1)/int64(d.BlockSize)) {
+		c.logf(resp, slog.LevelWarn, "ignoring delta which does not match the remote file")
+		return c.validateLocal
+	}
+	basis := resp.path()
+	found, err := d.match(resp.ctx, basis)
+	if err != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+
+	// the destination file is replaced by the assembled file
+	resp.committed = false
+	resp.DidResume = false
+	name := resp.path()
+	tmp := deltaFilename(name)
+	if !resp.Request.NoCreateDirectories {
+		resp.err = mkdirp(resp.Request, tmp)
+		if resp.err != nil {
+			return c.closeResponse
+		}
+	}
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_RDWR, resp.Request.fileMode())
+	if err != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+	var reused int64
+	var segs []*segment
+	resp.err = func() error {
+		src, err := os.Open(basis)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		for i, off := range found {
+			start, n := int64(i)*int64(d.BlockSize), d.blockSize(i)
+			if off < 0 {
+				if k := len(segs) - 1; k >= 0 && segs[k].end+1 == start {
+					segs[k].end += n
+				} else {
+					segs = append(segs, &segment{start: start, end: start + n - 1})
+				}
+				continue
+			}
+			_, err := io.Copy(io.NewOffsetWriter(f, start), io.NewSectionReader(src, off, n))
+			if err != nil {
+				return err
+			}
+			reused += n
+		}
+		return f.Truncate(d.Size)
+	}()
+	if resp.err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return c.closeResponse
+	}
+	c.logf(resp, slog.LevelDebug, "updating file with delta",
+		"bytes_reused", reused,
+		"ranges", len(segs))
+
+	resp.Size = d.Size
+	resp.BytesReused = reused
+	resp.setBytesResumed(reused)
+	if resp.bufferSize < 1 {
+		resp.bufferSize = defaultBufferSize
+	}
+	resp.setTransfer(&deltaTransfer{
+		segmentedTransfer: newSegmentedTransfer(
+			resp.attemptCtx,
+			resp.gate,
+			c.rateLimiter(resp),
+			func(req *http.Request) (*http.Response, error) {
+				return c.doTransferRequest(resp, req)
+			},
+			resp.Request.HTTPRequest,
+			f,
+			resp.Request.GetReader,
+			resp.bufferSize,
+			segs),
+		f:    newSyncFile(f, resp.Request),
+		tmp:  tmp,
+		name: name,
+	})
+
+	// next step is copyFile, but this will be called later in another goroutine
+	return nil
+}
+
+// deltaTransfer transfers the missing blocks of a Delta, one byte range at a
+// time, into a temporary file which then replaces the destination file.
+type deltaTransfer struct {
+	*segmentedTransfer
+	f    *syncFile
+	tmp  string
+	name string
+}
+
+func (c *deltaTransfer) copy() (written int64, err error) {
+	for _, seg := range c.segments {
+		if err = c.copySegment(c.ctx, seg); err != nil {
+			break
+		}
+	}
+	if cerr := c.f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = renameFile(c.tmp, c.name)
+	}
+	if err != nil {
+		os.Remove(c.tmp)
+	}
+	return c.N(), err
+}
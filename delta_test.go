@@ -0,0 +1,108 @@
+package grab
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestRollingSum tests that rolling the weak checksum of a window matches the
+// checksum of the window computed directly.
+func TestRollingSum(t *testing.T) {
+	b := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(b)
+	rs := newRollingSum(b[:512])
+	for i := 1; i+512 <= len(b); i++ {
+		rs.roll(b[i-1], b[i+511])
+		if expect := newRollingSum(b[i : i+512]).sum(); rs.sum() != expect {
+			t.Fatalf("expected rolling sum %x at offset %d, got: %x", expect, i, rs.sum())
+		}
+	}
+}
+
+// TestDelta tests that only the changed blocks of a remote file are
+// transferred when a Delta is set.
+func TestDelta(t *testing.T) {
+	filename := ".testDelta"
+	defer os.Remove(filename)
+
+	old := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(old)
+
+	// insert and modify content, to move unchanged blocks to new offsets
+	content := append([]byte(nil), old[:10000]...)
+	content = append(content, []byte("inserted content")...)
+	content = append(content, old[10000:]...)
+	copy(content[40000:], bytes.Repeat([]byte{0}, 100))
+	sum := sha256.Sum256(content)
+
+	var ranged int64
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.Header.Get("Range") == "" {
+			t.Errorf("expected only ranged requests")
+		}
+		for _, v := range strings.Split(strings.TrimPrefix(r.Header.Get("Range"), "bytes="), ",") {
+			var start, end int64
+			if _, err := fmt.Sscanf(v, "%d-%d", &start, &end); err == nil {
+				atomic.AddInt64(&ranged, end-start+1)
+			}
+		}
+		http.ServeContent(w, r, "", time.Unix(0, 0), bytes.NewReader(content))
+	}))
+	defer s.Close()
+
+	d, err := NewDelta(bytes.NewReader(content), 1024, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Size != int64(len(content)) || len(d.Blocks) != 65 {
+		t.Fatalf("unexpected delta: %d bytes, %d blocks", d.Size, len(d.Blocks))
+	}
+
+	if err := ioutil.WriteFile(filename, old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := NewRequest(filename, s.URL)
+	req.Delta = d
+	req.SetChecksum(sha256.New(), sum[:], false)
+	resp := DefaultClient.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	testContent(t, filename, content)
+
+	// the block with the insertion, the modified blocks and the last block
+	if n := atomic.LoadInt64(&ranged); n == 0 || n > 5*1024 {
+		t.Errorf("expected only changed blocks to be transferred, got: %d bytes", n)
+	}
+	if resp.BytesReused+atomic.LoadInt64(&ranged) != int64(len(content)) {
+		t.Errorf("expected %d bytes to be reused, got: %d",
+			int64(len(content))-ranged, resp.BytesReused)
+	}
+	if resp.BytesComplete() != int64(len(content)) {
+		t.Errorf("expected %d bytes complete, got: %d", len(content), resp.BytesComplete())
+	}
+	if _, err := os.Stat(deltaFilename(filename)); !os.IsNotExist(err) {
+		t.Errorf("expected temporary file to be removed")
+	}
+
+	// an unchanged file is not transferred again
+	atomic.StoreInt64(&ranged, 0)
+	req, _ = NewRequest(filename, s.URL)
+	req.Delta = d
+	if resp := DefaultClient.Do(req); resp.Err() != nil || resp.BytesReused != d.Size {
+		t.Errorf("expected unchanged file to be reused, got: %v", resp.Err())
+	}
+	if n := atomic.LoadInt64(&ranged); n != 0 {
+		t.Errorf("expected no bytes to be transferred, got: %d", n)
+	}
+}
@@ -0,0 +1,208 @@
+package grab
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// An IPMode specifies which IP versions are used to connect to remote hosts
+// which have both IPv4 and IPv6 addresses.
+type IPMode int
+
+const (
+	// DualStack connects to the IPv4 and IPv6 addresses of each host, racing
+	// the first address of the other family after Client.FallbackDelay, as
+	// with Happy Eyeballs. This is the default.
+	DualStack IPMode = iota
+
+	// PreferIPv4 connects to the IPv4 addresses of each host first, and to
+	// its IPv6 addresses after Client.FallbackDelay.
+	PreferIPv4
+
+	// PreferIPv6 connects to the IPv6 addresses of each host first, and to
+	// its IPv4 addresses after Client.FallbackDelay.
+	PreferIPv6
+
+	// IPv4Only connects only to the IPv4 addresses of each host, such as to
+	// avoid mirrors which advertise broken AAAA records.
+	IPv4Only
+
+	// IPv6Only connects only to the IPv6 addresses of each host.
+	IPv6Only
+)
+
+// defaultFallbackDelay is the default Client.FallbackDelay, as with
+// net.Dialer.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+// customDial returns true if the Client replaces the dialer of its
+// transport.
+func (c *Client) customDial() bool {
+	return c.Resolver != nil || c.IPMode != DualStack || c.FallbackDelay != 0
+}
+
+// dialContext returns a DialContext function of a http.Transport which
+// resolves hostnames with the Resolver of the Client, if any, and then dials
+// their addresses with the given function, according to the IPMode and
+// FallbackDelay of the Client.
+func (c *Client) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if network == "tcp" {
+			switch c.IPMode {
+			case IPv4Only:
+				network = "tcp4"
+			case IPv6Only:
+				network = "tcp6"
+			}
+		}
+		r := c.Resolver
+		if r == nil && c.FallbackDelay == 0 && (c.IPMode == DualStack || network != "tcp") {
+			// the dialer of the transport resolves the host
+			return dial(ctx, network, addr)
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || net.ParseIP(host) != nil {
+			return dial(ctx, network, addr)
+		}
+		if r == nil {
+			r = net.DefaultResolver
+		}
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+		}
+		if len(addrs) == 0 {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}}
+		}
+		primaries, fallbacks := c.partitionAddrs(network, addrs)
+		if len(primaries) == 0 {
+			primaries, fallbacks = fallbacks, nil
+		}
+		if len(primaries) == 0 {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: &net.AddrError{Err: "no suitable address found", Addr: host}}
+		}
+		delay := c.FallbackDelay
+		if delay == 0 {
+			delay = defaultFallbackDelay
+		}
+		return dialParallel(ctx, dial, network, port, primaries, fallbacks, delay)
+	}
+}
+
+// partitionAddrs returns the addresses of the given list which may be dialed
+// on the given network, split into those which are dialed first and those of
+// the other IP version, which are dialed after the fallback delay. Addresses
+// which are not IP addresses are dialed first.
+func (c *Client) partitionAddrs(network string, addrs []string) (primaries, fallbacks []string) {
+	primaryIPv4 := true
+	switch {
+	case network == "tcp6" || c.IPMode == PreferIPv6:
+		primaryIPv4 = false
+	case network == "tcp4" || c.IPMode == PreferIPv4:
+	default:
+		for _, a := range addrs {
+			if ip := net.ParseIP(a); ip != nil {
+				primaryIPv4 = ip.To4() != nil
+				break
+			}
+		}
+	}
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		switch {
+		case ip == nil || (ip.To4() != nil) == primaryIPv4:
+			primaries = append(primaries, a)
+		case network == "tcp":
+			fallbacks = append(fallbacks, a)
+		}
+	}
+	return primaries, fallbacks
+}
+
+// dialParallel dials the given primary addresses in order and, if no
+// connection is established within the given delay, races the fallback
+// addresses against them. The first connection established is returned. If
+// delay is negative, the fallback addresses are dialed once every primary
+// address has failed.
+func dialParallel(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), network, port string, primaries, fallbacks []string, delay time.Duration) (net.Conn, error) {
+	if len(fallbacks) == 0 || delay < 0 {
+		return dialSerial(ctx, dial, network, port, append(primaries, fallbacks...))
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		conn    net.Conn
+		err     error
+		primary bool
+	}
+	results := make(chan result, 2)
+	start := func(addrs []string, primary bool) {
+		go func() {
+			conn, err := dialSerial(ctx, dial, network, port, addrs)
+			results <- result{conn, err, primary}
+		}()
+	}
+	start(primaries, true)
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	pending, fallback := 1, false
+	var primaryErr error
+	for {
+		select {
+		case <-timer.C:
+			if !fallback {
+				start(fallbacks, false)
+				pending, fallback = pending+1, true
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if pending > 0 {
+					// close the connection of the losing race, if any
+					go func() {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}()
+				}
+				return r.conn, nil
+			}
+			if r.primary {
+				primaryErr = r.err
+			}
+			if !fallback {
+				start(fallbacks, false)
+				pending, fallback = pending+1, true
+			}
+			if pending == 0 {
+				if primaryErr != nil {
+					return nil, primaryErr
+				}
+				return nil, r.err
+			}
+		}
+	}
+}
+
+// dialSerial dials each of the given addresses in order, until a connection
+// is established, and returns the error of the first address otherwise.
+func dialSerial(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), network, port string, addrs []string) (net.Conn, error) {
+	var firstErr error
+	for _, a := range addrs {
+		conn, err := dial(ctx, network, net.JoinHostPort(a, port))
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
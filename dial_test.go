@@ -0,0 +1,99 @@
+package grab
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestIPMode tests the addresses dialed by each IPMode of a Client, with a
+// dialer which fails to connect to IPv6 addresses.
+func TestIPMode(t *testing.T) {
+	resolver := &StaticResolver{Hosts: map[string][]string{
+		"dual.example.test": {"2001:db8::1", "192.0.2.1", "192.0.2.2"},
+		"v4.example.test":   {"192.0.2.1"},
+	}}
+	tests := []struct {
+		mode    IPMode
+		host    string
+		resolve bool
+		delay   time.Duration
+		expect  string
+		err     bool
+	}{
+		{DualStack, "dual.example.test", true, -1, "tcp [2001:db8::1]:80,tcp 192.0.2.1:80", false},
+		{DualStack, "dual.example.test", false, 0, "tcp dual.example.test:80", false},
+		{PreferIPv4, "dual.example.test", true, 0, "tcp 192.0.2.1:80", false},
+		{PreferIPv6, "dual.example.test", true, -1, "tcp [2001:db8::1]:80,tcp 192.0.2.1:80", false},
+		{IPv4Only, "dual.example.test", true, 0, "tcp4 192.0.2.1:80", false},
+		{IPv4Only, "dual.example.test", false, 0, "tcp4 dual.example.test:80", false},
+		{IPv6Only, "dual.example.test", true, 0, "tcp6 [2001:db8::1]:80", true},
+		{IPv6Only, "v4.example.test", true, 0, "", true},
+		{PreferIPv6, "v4.example.test", true, 0, "tcp 192.0.2.1:80", false},
+	}
+	for _, test := range tests {
+		var mu sync.Mutex
+		var dialed []string
+		dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			dialed = append(dialed, network+" "+addr)
+			mu.Unlock()
+			if strings.HasPrefix(addr, "[") {
+				return nil, errors.New("network is unreachable")
+			}
+			c, _ := net.Pipe()
+			return c, nil
+		}
+		client := &Client{IPMode: test.mode, FallbackDelay: test.delay}
+		if test.resolve {
+			client.Resolver = resolver
+		}
+		conn, err := client.dialContext(dial)(context.Background(), "tcp", net.JoinHostPort(test.host, "80"))
+		if test.err != (err != nil) {
+			t.Errorf("%d %s: unexpected error: %v", test.mode, test.host, err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+		mu.Lock()
+		if actual := strings.Join(dialed, ","); actual != test.expect {
+			t.Errorf("%d %s: expected dials %q, got: %q", test.mode, test.host, test.expect, actual)
+		}
+		mu.Unlock()
+	}
+}
+
+// TestFallbackDelay tests that a connection to the other IP version is raced
+// once the fallback delay has elapsed.
+func TestFallbackDelay(t *testing.T) {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if strings.HasPrefix(addr, "[") {
+			// a broken AAAA record
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		c, _ := net.Pipe()
+		return c, nil
+	}
+	client := &Client{
+		Resolver: &StaticResolver{Hosts: map[string][]string{
+			"example.test": {"2001:db8::1", "192.0.2.1"},
+		}},
+		FallbackDelay: 10 * time.Millisecond,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	conn, err := client.dialContext(dial)(ctx, "tcp", "example.test:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected fallback after 10ms, took: %v", d)
+	}
+}
@@ -0,0 +1,128 @@
+package grab
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// digestAlgorithms are the algorithms of the Repr-Digest (RFC 9530) and Digest
+// (RFC 3230) headers supported by Request.VerifyDigest, in descending order of
+// strength. The "sha" and "md5" algorithms are only defined by RFC 3230.
+var digestAlgorithms = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha-512", sha512.New},
+	{"sha-256", sha256.New},
+	{"sha", sha1.New},
+	{"md5", md5.New},
+}
+
+// setWantDigest returns a copy of the given request with Want-Repr-Digest and
+// Want-Digest headers which prefer the strongest supported algorithms, unless
+// the headers are already set.
+func setWantDigest(req *http.Request) *http.Request {
+	hreq := new(http.Request)
+	*hreq = *req
+	hreq.Header = cloneHeader(req.Header)
+	if hreq.Header.Get("Want-Repr-Digest") == "" {
+		hreq.Header.Set("Want-Repr-Digest", "sha-512=10, sha-256=9")
+	}
+	if hreq.Header.Get("Want-Digest") == "" {
+		hreq.Header.Set("Want-Digest", "sha-512, sha-256;q=0.9")
+	}
+	return hreq
+}
+
+// parseDigest returns the digest of the strongest supported algorithm in the
+// Repr-Digest header of the given http.Header or, if there is none, in the
+// Digest header. Malformed digests are ignored. If no supported digest is
+// found, nil is returned.
+func parseDigest(h http.Header) *Checksum {
+	digests := make(map[string][]byte)
+
+	// Repr-Digest: sha-256=:base64:, sha-512=:base64:
+	for _, member := range strings.Split(strings.Join(h.Values("Repr-Digest"), ","), ",") {
+		alg, v, ok := strings.Cut(strings.TrimSpace(member), "=")
+		v, _, _ = strings.Cut(v, ";")
+		if !ok || len(v) < 2 || v[0] != ':' || v[len(v)-1] != ':' {
+			continue
+		}
+		if b, err := base64.StdEncoding.DecodeString(v[1 : len(v)-1]); err == nil {
+			digests[strings.ToLower(alg)] = b
+		}
+	}
+	if len(digests) == 0 {
+		// Digest: SHA-256=base64, MD5=base64
+		for _, member := range strings.Split(strings.Join(h.Values("Digest"), ","), ",") {
+			alg, v, ok := strings.Cut(strings.TrimSpace(member), "=")
+			if !ok {
+				continue
+			}
+			if b, err := base64.StdEncoding.DecodeString(v); err == nil {
+				digests[strings.ToLower(alg)] = b
+			}
+		}
+	}
+
+	for _, alg := range digestAlgorithms {
+		b, ok := digests[alg.name]
+		if !ok {
+			continue
+		}
+		h := alg.new()
+		if len(b) == h.Size() {
+			return &Checksum{Hash: h, Expected: b}
+		}
+	}
+	return nil
+}
+
+// checkDigest validates the destination file of the given Response against
+// the digest of the remote file, given in the headers of Response.HTTPResponse,
+// if Request.VerifyDigest is set. Files transferred from object stores are
+// validated against the checksum given by the store. See objectChecksum.
+// Response.Digest
+// is set once the digest is computed. ErrBadChecksum is returned if the digest
+// does not match.
+func (c *Client) checkDigest(resp *Response) error {
+	req := resp.Request
+	if req.writer != nil ||
+		req.hasRange() ||
+		resp.HTTPResponse == nil ||
+		resp.HTTPResponse.Uncompressed ||
+		resp.ContentEncoding != "" {
+		// the digest does not describe the content of the destination file
+		return nil
+	}
+	var sum *Checksum
+	if req.VerifyDigest {
+		sum = parseDigest(resp.HTTPResponse.Header)
+	}
+	if sum == nil {
+		sum = objectChecksum(resp)
+	}
+	if sum == nil {
+		return nil
+	}
+	sums, err := checksum(req.Context(), resp.path(), []hash.Hash{sum.Hash})
+	if err != nil {
+		return err
+	}
+	sum.Actual = sums[0]
+	resp.Digest = sum
+	if !bytes.Equal(sum.Actual, sum.Expected) {
+		c.logf(resp, slog.LevelWarn, "digest mismatch", "filename", resp.Filename)
+		return ErrBadChecksum
+	}
+	c.logf(resp, slog.LevelDebug, "digest validated", "filename", resp.Filename)
+	return nil
+}
@@ -0,0 +1,101 @@
+package grab
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestParseDigest tests that the strongest supported digest is parsed from
+// Repr-Digest and Digest headers.
+func TestParseDigest(t *testing.T) {
+	sha256sum := sha256.Sum256([]byte("test"))
+	sha512sum := sha512.Sum512([]byte("test"))
+	b64 := base64.StdEncoding.EncodeToString
+	tests := []struct {
+		header, value string
+		expect        []byte
+	}{
+		{"Repr-Digest", "sha-256=:" + b64(sha256sum[:]) + ":", sha256sum[:]},
+		{"Repr-Digest", "sha-256=:" + b64(sha256sum[:]) + ":, sha-512=:" + b64(sha512sum[:]) + ":", sha512sum[:]},
+		{"Repr-Digest", "sha-512=:bad:, sha-256=:" + b64(sha256sum[:]) + ":", sha256sum[:]},
+		{"Repr-Digest", "unixsum=:MTIz:", nil},
+		{"Digest", "SHA-256=" + b64(sha256sum[:]), sha256sum[:]},
+		{"Digest", "MD5=" + b64(sha256sum[:]), nil},
+		{"Digest", "", nil},
+	}
+	for _, test := range tests {
+		h := http.Header{}
+		h.Set(test.header, test.value)
+		sum := parseDigest(h)
+		if test.expect == nil {
+			if sum != nil {
+				t.Errorf("%s: %s: expected no digest, got: %x", test.header, test.value, sum.Expected)
+			}
+			continue
+		}
+		if sum == nil || !bytes.Equal(sum.Expected, test.expect) {
+			t.Errorf("%s: %s: expected digest %x, got: %v", test.header, test.value, test.expect, sum)
+		}
+	}
+}
+
+// TestVerifyDigest tests that downloaded files are validated against the
+// digest headers sent by the remote server.
+func TestVerifyDigest(t *testing.T) {
+	filename := ".testVerifyDigest"
+	defer os.Remove(filename)
+
+	content := []byte("digest test content")
+	sha256sum := sha256.Sum256(content)
+	md5sum := md5.Sum(content)
+	b64 := base64.StdEncoding.EncodeToString
+
+	var want string
+	var header, value string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		want = r.Header.Get("Want-Repr-Digest")
+		w.Header().Set(header, value)
+		http.ServeContent(w, r, "", time.Unix(0, 0), bytes.NewReader(content))
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		header, value string
+		err           error
+	}{
+		{"Repr-Digest", "sha-256=:" + b64(sha256sum[:]) + ":", nil},
+		{"Digest", "MD5=" + b64(md5sum[:]), nil},
+		{"Repr-Digest", "sha-256=:" + b64(make([]byte, sha256.Size)) + ":", ErrBadChecksum},
+	}
+	for _, test := range tests {
+		os.Remove(filename)
+		header, value = test.header, test.value
+		req, _ := NewRequest(filename, s.URL)
+		req.VerifyDigest = true
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != test.err {
+			t.Errorf("%s: expected error %v, got: %v", test.value, test.err, err)
+		}
+		if resp.Digest == nil || resp.Digest.OK() != (test.err == nil) {
+			t.Errorf("%s: expected digest to be validated, got: %v", test.value, resp.Digest)
+		}
+		if want == "" {
+			t.Errorf("expected Want-Repr-Digest header")
+		}
+	}
+
+	// digests are ignored unless requested
+	os.Remove(filename)
+	req, _ := NewRequest(filename, s.URL)
+	if resp := DefaultClient.Do(req); resp.Err() != nil || resp.Digest != nil {
+		t.Errorf("expected digest to be ignored, got: %v", resp.Err())
+	}
+}
@@ -0,0 +1,96 @@
+package grab
+
+import (
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// diskSpaceInterval is the interval at which the free space of the destination
+// file system is checked during a transfer.
+var diskSpaceInterval = time.Second
+
+// freeSpace returns the number of bytes available to the current user on the
+// file system of the given directory. If this is not supported on the current
+// platform, ok is false.
+var freeSpace = platformFreeSpace
+
+// checkSpace returns ErrNoSpace if the destination file system of the given
+// Response does not have room for the remaining bytes of the transfer, plus
+// Request.MinFreeSpace, once the given number of bytes of an existing file are
+// reclaimed. If the free space cannot be determined, checkSpace returns nil.
+func (c *Response) checkSpace(reclaim int64) error {
+	if c.Request.NoSpaceCheck {
+		return nil
+	}
+	free, ok := freeSpace(filepath.Dir(c.path()))
+	if !ok {
+		return nil
+	}
+	if free+reclaim-c.remainingBytes() < c.Request.MinFreeSpace {
+		return ErrNoSpace
+	}
+	return nil
+}
+
+// reclaimableBytes returns the size of any existing destination file which
+// will be truncated, rather than resumed, when it is opened for writing.
+func (c *Response) reclaimableBytes() int64 {
+	if c.fi != nil && !c.DidResume {
+		return c.fi.Size()
+	}
+	return 0
+}
+
+// remainingBytes returns the number of bytes remaining to be written to the
+// destination file, or zero if the size of the file is unknown.
+func (c *Response) remainingBytes() int64 {
+	if c.Size <= 0 {
+		return 0
+	}
+	n := c.Size - c.BytesComplete()
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// watchSpace monitors the free space of the destination file system during an
+// in-progress transfer until the returned stop function is called. If there is
+// no longer room for the remainder of the transfer, plus Request.MinFreeSpace,
+// the current attempt is canceled and isOutOfSpace returns true, so that the
+// transfer fails gracefully rather than when a write fails.
+func (c *Response) watchSpace() (stop func()) {
+	if c.Request.NoSpaceCheck || c.Request.writer != nil {
+		return func() {}
+	}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(diskSpaceInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if c.checkSpace(0) != nil {
+					atomic.StoreInt32(&c.outOfSpace, 1)
+					c.cancelAttempt()
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// isOutOfSpace returns true if the current attempt was canceled by watchSpace
+// or failed because the destination file system is full.
+func (c *Response) isOutOfSpace(err error) bool {
+	return atomic.LoadInt32(&c.outOfSpace) == 1 || isNoSpaceError(err)
+}
@@ -0,0 +1,12 @@
+//go:build !unix && !windows
+
+package grab
+
+func platformFreeSpace(dir string) (int64, bool) {
+	return 0, false
+}
+
+// isNoSpaceError returns true if err was caused by a full file system.
+func isNoSpaceError(err error) bool {
+	return false
+}
@@ -0,0 +1,88 @@
+package grab
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// setFreeSpace replaces freeSpace with a function which returns the value of
+// the given free space and returns a function to restore it.
+func setFreeSpace(free *int64) (restore func()) {
+	freeSpace = func(string) (int64, bool) {
+		return atomic.LoadInt64(free), true
+	}
+	return func() { freeSpace = platformFreeSpace }
+}
+
+// TestNoSpace tests that transfers fail with ErrNoSpace if the destination
+// file system does not have enough free space.
+func TestNoSpace(t *testing.T) {
+	filename := ".testNoSpace"
+	defer os.Remove(filename)
+	free := int64(1024)
+	defer setFreeSpace(&free)()
+
+	t.Run("Preflight", func(t *testing.T) {
+		req, _ := NewRequest(filename, ts.URL+"?size=4096")
+		err := DefaultClient.Do(req).Err()
+		if err != ErrNoSpace || !errors.Is(err, ErrFilesystem) {
+			t.Fatalf("expected ErrNoSpace, got: %v", err)
+		}
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("expected destination file not to be created")
+		}
+
+		req, _ = NewRequest(filename, ts.URL+"?size=4096")
+		req.NoSpaceCheck = true
+		if err := DefaultClient.Do(req).Err(); err != nil {
+			t.Errorf("expected NoSpaceCheck to disable check, got: %v", err)
+		}
+		os.Remove(filename)
+	})
+
+	t.Run("MinFreeSpace", func(t *testing.T) {
+		atomic.StoreInt64(&free, 8192)
+		req, _ := NewRequest(filename, ts.URL+"?size=4096")
+		req.MinFreeSpace = 8192
+		if err := DefaultClient.Do(req).Err(); err != ErrNoSpace {
+			t.Fatalf("expected ErrNoSpace, got: %v", err)
+		}
+	})
+
+	t.Run("Monitor", func(t *testing.T) {
+		defer func(d time.Duration) { diskSpaceInterval = d }(diskSpaceInterval)
+		diskSpaceInterval = 10 * time.Millisecond
+		atomic.StoreInt64(&free, 1<<30)
+
+		// send the file slowly, until the client goes away
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", "1048576")
+			for i := 0; i < 1024; i++ {
+				if _, err := w.Write(make([]byte, 1024)); err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+				time.Sleep(10 * time.Millisecond)
+			}
+		}))
+		defer s.Close()
+
+		req, _ := NewRequest(filename, s.URL)
+		req.PersistState = true
+		resp := DefaultClient.Do(req)
+		time.Sleep(50 * time.Millisecond)
+		atomic.StoreInt64(&free, 1024)
+		if err := resp.Err(); err != ErrNoSpace {
+			t.Fatalf("expected ErrNoSpace, got: %v", err)
+		}
+		if _, err := os.Stat(stateFilename(filename)); err != nil {
+			t.Errorf("expected progress to be saved: %v", err)
+		}
+		removeState(filename)
+	})
+}
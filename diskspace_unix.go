@@ -0,0 +1,21 @@
+//go:build unix
+
+package grab
+
+import (
+	"errors"
+	"syscall"
+)
+
+func platformFreeSpace(dir string) (int64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return int64(st.Bavail) * int64(st.Bsize), true
+}
+
+// isNoSpaceError returns true if err was caused by a full file system.
+func isNoSpaceError(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
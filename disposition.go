@@ -0,0 +1,172 @@
+package grab
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// dispositionFilename returns the filename given by the value of a
+// Content-Disposition header, as specified by RFC 6266, or an empty string if
+// there is none.
+//
+// The extended filename* parameter of RFC 5987 is preferred over the filename
+// parameter, so that non-ASCII filenames are preserved. UTF-8, ISO-8859-1 and
+// US-ASCII encoded values are supported, as are the continuations of RFC 2231
+// (filename*0*=...; filename*1*=...). Unlike mime.ParseMediaType, malformed
+// parameters, such as unquoted filenames with spaces, are tolerated as they
+// are by browsers.
+func dispositionFilename(v string) string {
+	params := dispositionParams(v)
+	if s, ok := params["filename*"]; ok {
+		if name, ok := decodeExtValue(s); ok && name != "" {
+			return name
+		}
+	}
+
+	// RFC 2231 continuations
+	type part struct {
+		n       int
+		value   string
+		encoded bool
+	}
+	var parts []part
+	for k, s := range params {
+		rest := strings.TrimPrefix(k, "filename*")
+		if rest == k || rest == "" {
+			continue
+		}
+		encoded := strings.HasSuffix(rest, "*")
+		n, err := strconv.Atoi(strings.TrimSuffix(rest, "*"))
+		if err != nil || n < 0 {
+			continue
+		}
+		parts = append(parts, part{n, s, encoded})
+	}
+	if len(parts) > 0 {
+		sort.Slice(parts, func(i, j int) bool { return parts[i].n < parts[j].n })
+		var b strings.Builder
+		charset := ""
+		ok := true
+		for i, p := range parts {
+			if p.n != i {
+				ok = false
+				break
+			}
+			if !p.encoded {
+				b.WriteString(p.value)
+				continue
+			}
+			s := p.value
+			if i == 0 {
+				cs, rest, found := strings.Cut(s, "'")
+				_, rest, found2 := strings.Cut(rest, "'")
+				if !found || !found2 {
+					ok = false
+					break
+				}
+				charset, s = cs, rest
+			}
+			decoded, err := url.PathUnescape(s)
+			if err != nil {
+				ok = false
+				break
+			}
+			b.WriteString(decoded)
+		}
+		if ok {
+			if name, ok := decodeCharset(charset, b.String()); ok && name != "" {
+				return name
+			}
+		}
+	}
+	return params["filename"]
+}
+
+// dispositionParams returns the parameters of a Content-Disposition header,
+// with lower-cased names. Quoted values are unescaped and the first value of
+// duplicated parameters is used.
+func dispositionParams(v string) map[string]string {
+	params := make(map[string]string)
+	// skip the disposition type
+	_, v, ok := strings.Cut(v, ";")
+	for ok {
+		v = strings.TrimLeft(v, " \t;")
+		var k string
+		k, v, ok = strings.Cut(v, "=")
+		if !ok {
+			break
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimLeft(v, " \t")
+		var value string
+		if strings.HasPrefix(v, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(v) && v[i] != '"'; i++ {
+				if v[i] == '\\' && i+1 < len(v) {
+					i++
+				}
+				b.WriteByte(v[i])
+			}
+			value = b.String()
+			if i < len(v) {
+				i++
+			}
+			_, v, ok = strings.Cut(v[i:], ";")
+		} else {
+			value, v, ok = strings.Cut(v, ";")
+			value = strings.TrimSpace(value)
+		}
+		if _, dup := params[k]; !dup && k != "" {
+			params[k] = value
+		}
+	}
+	return params
+}
+
+// decodeExtValue decodes an ext-value of RFC 5987, formatted as the charset,
+// an optional language and the percent-encoded value, separated by single
+// quotes:
+//
+//	UTF-8'en'%e2%82%ac%20rates
+func decodeExtValue(s string) (string, bool) {
+	charset, rest, ok := strings.Cut(s, "'")
+	if !ok {
+		return "", false
+	}
+	_, encoded, ok := strings.Cut(rest, "'")
+	if !ok {
+		return "", false
+	}
+	decoded, err := url.PathUnescape(encoded)
+	if err != nil {
+		return "", false
+	}
+	return decodeCharset(charset, decoded)
+}
+
+// decodeCharset converts the given bytes of a string in the given charset to
+// UTF-8.
+func decodeCharset(charset, s string) (string, bool) {
+	switch strings.ToLower(charset) {
+	case "utf-8", "utf8":
+		return s, utf8.ValidString(s)
+	case "iso-8859-1", "latin1":
+		var b strings.Builder
+		for i := 0; i < len(s); i++ {
+			b.WriteRune(rune(s[i]))
+		}
+		return b.String(), true
+	case "us-ascii", "":
+		for i := 0; i < len(s); i++ {
+			if s[i] >= utf8.RuneSelf {
+				return "", false
+			}
+		}
+		return s, true
+	}
+	return "", false
+}
@@ -0,0 +1,41 @@
+package grab
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDispositionFilename(t *testing.T) {
+	tests := map[string]string{
+		`attachment; filename="example.zip"`:                                            "example.zip",
+		`attachment; filename=example.zip`:                                              "example.zip",
+		`attachment;filename="quoted \"name\".zip"`:                                     `quoted "name".zip`,
+		`attachment; filename=my file.zip`:                                              "my file.zip",
+		`attachment; filename*=UTF-8''%e2%82%ac%20rates.pdf`:                            "€ rates.pdf",
+		`attachment; filename="EURO rates.pdf"; filename*=utf-8''%E2%82%AC%20rates.pdf`: "€ rates.pdf",
+		`attachment; filename*=UTF-8'en'na%C3%AFve.txt; filename="naive.txt"`:           "naïve.txt",
+		`attachment; filename*=iso-8859-1''caf%E9.txt`:                                  "café.txt",
+		`attachment; filename*=utf-8''%E9.txt; filename="fallback.txt"`:                 "fallback.txt",
+		`attachment; filename*=koi8-r''%C1.txt; filename="fallback.txt"`:                "fallback.txt",
+		`attachment; filename*0*=UTF-8''%E6%97%A5; filename*1="本.txt"`:                  "日本.txt",
+		`attachment; filename="a.zip"; filename="b.zip"`:                                "a.zip",
+		`attachment; FILENAME="upper.zip"`:                                              "upper.zip",
+		`attachment`:                                                                    "",
+		`inline; size=10`:                                                               "",
+	}
+	for v, expect := range tests {
+		if actual := dispositionFilename(v); actual != expect {
+			t.Errorf("%s: expected %q, got: %q", v, expect, actual)
+		}
+	}
+
+	// non-ASCII filenames are preserved by guessFilename
+	req, _ := http.NewRequest("GET", "http://test.com/download?id=1", nil)
+	resp := &http.Response{
+		Request: req,
+		Header:  http.Header{"Content-Disposition": {`attachment; filename*=UTF-8''%E6%97%A5%E6%9C%AC.txt`}},
+	}
+	if name, err := guessFilename(resp); err != nil || name != "日本.txt" {
+		t.Errorf("expected 日本.txt, got: %q (%v)", name, err)
+	}
+}
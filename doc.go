@@ -59,5 +59,21 @@ You can monitor the progress of downloads while they are transferring:
 			return
 		}
 	}
+
+Errors returned by grab can be inspected using errors.Is and errors.As. Each
+error belongs to one of the classes ErrNetwork, ErrValidation or ErrFilesystem,
+unless it was caused by the cancelation of a Context:
+
+	switch err := resp.Err(); {
+	case errors.Is(err, grab.ErrBadChecksum):
+		// the file is corrupt
+	case errors.Is(err, grab.ErrNetwork):
+		// try again later
+	case errors.Is(err, grab.ErrFilesystem):
+		// check the destination path
+	}
+
+Network and filesystem errors wrap the underlying error, so errors.Is(err,
+os.ErrNotExist) should be used instead of os.IsNotExist(err).
 */
 package grab
@@ -0,0 +1,184 @@
+package grab
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// A ContentDecoder returns a reader which decodes content read from r that was
+// encoded with a Content-Encoding, such as gzip.
+type ContentDecoder func(r io.Reader) (io.Reader, error)
+
+var (
+	contentDecodersMu sync.RWMutex
+	contentDecoders   = map[string]ContentDecoder{
+		"gzip":    decodeGzip,
+		"x-gzip":  decodeGzip,
+		"deflate": decodeDeflate,
+	}
+)
+
+func decodeGzip(r io.Reader) (io.Reader, error) {
+	return gzip.NewReader(r)
+}
+
+func decodeDeflate(r io.Reader) (io.Reader, error) {
+	return zlib.NewReader(r)
+}
+
+// RegisterContentDecoder registers a ContentDecoder for the given
+// Content-Encoding, for use by transfers with Request.DecodeContent. The gzip
+// and deflate encodings are registered by default. Other encodings, such as
+// "br" or "zstd", may be registered using third-party decoders. Any decoder
+// already registered for the encoding is replaced.
+func RegisterContentDecoder(encoding string, d ContentDecoder) {
+	contentDecodersMu.Lock()
+	defer contentDecodersMu.Unlock()
+	contentDecoders[strings.ToLower(encoding)] = d
+}
+
+// contentDecoder returns the ContentDecoder registered for the given
+// Content-Encoding, or nil.
+func contentDecoder(encoding string) ContentDecoder {
+	contentDecodersMu.RLock()
+	defer contentDecodersMu.RUnlock()
+	return contentDecoders[strings.ToLower(strings.TrimSpace(encoding))]
+}
+
+// acceptEncoding returns the value of an Accept-Encoding header which lists
+// every registered Content-Encoding.
+func acceptEncoding() string {
+	contentDecodersMu.RLock()
+	defer contentDecodersMu.RUnlock()
+	a := make([]string, 0, len(contentDecoders))
+	for enc := range contentDecoders {
+		a = append(a, enc)
+	}
+	sort.Strings(a)
+	return strings.Join(a, ", ")
+}
+
+// setAcceptEncoding returns a copy of the given request with an Accept-Encoding
+// header that lists every registered Content-Encoding, unless the header is
+// already set. Ranged requests accept only the identity encoding, as ranges
+// of encoded content cannot be appended to the decoded destination file.
+func setAcceptEncoding(req *http.Request) *http.Request {
+	if req.Header.Get("Accept-Encoding") != "" {
+		return req
+	}
+	hreq := new(http.Request)
+	*hreq = *req
+	hreq.Header = cloneHeader(req.Header)
+	if hreq.Header.Get("Range") != "" {
+		hreq.Header.Set("Accept-Encoding", "identity")
+	} else {
+		hreq.Header.Set("Accept-Encoding", acceptEncoding())
+	}
+	return hreq
+}
+
+// setContentEncoding sets Response.ContentEncoding and Response.EncodedSize
+// if Request.DecodeContent is set and the remote server encoded the content
+// of the given Response. ErrUnsupportedEncoding is returned if no
+// ContentDecoder is registered for the encoding.
+func (c *Response) setContentEncoding() error {
+	enc := c.HTTPResponse.Header.Get("Content-Encoding")
+	if !c.Request.DecodeContent || enc == "" || strings.EqualFold(enc, "identity") {
+		return nil
+	}
+	for _, e := range strings.Split(enc, ",") {
+		if contentDecoder(e) == nil {
+			return ErrUnsupportedEncoding
+		}
+	}
+	if c.bytesResumed > 0 {
+		// encoded content cannot be appended to a decoded file
+		return ErrServerNoRange
+	}
+	c.ContentEncoding = enc
+	c.EncodedSize = c.HTTPResponse.ContentLength
+	return nil
+}
+
+// bodyReader returns a reader of the body of the HTTP response of the given
+// Response, decoded according to Response.ContentEncoding and
+// Request.GetReader.
+func (c *Response) bodyReader() (io.Reader, error) {
+	var r io.Reader = c.HTTPResponse.Body
+	if c.ContentEncoding != "" {
+		cr := &countingReader{r: r}
+		c.progressMu.Lock()
+		c.encoded = cr
+		c.progressMu.Unlock()
+
+		// encodings are listed in the order they were applied
+		r = cr
+		encs := strings.Split(c.ContentEncoding, ",")
+		for i := len(encs) - 1; i >= 0; i-- {
+			var err error
+			r, err = contentDecoder(encs[i])(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	r, err := c.Request.GetReader(r)
+	if err != nil || c.Request.SizeLimit <= 0 {
+		return r, err
+	}
+	return &sizeLimitReader{r: r, n: c.Request.SizeLimit - c.bytesResumed}, nil
+}
+
+// sizeLimitReader reads up to n bytes from r, and then fails with ErrTooLarge
+// if r is not at EOF.
+type sizeLimitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *sizeLimitReader) Read(p []byte) (int, error) {
+	if c.n < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > c.n+1 {
+		p = p[:c.n+1]
+	}
+	n, err := c.r.Read(p)
+	if int64(n) > c.n {
+		n, c.n = int(c.n), -1
+		return n, ErrTooLarge
+	}
+	c.n -= int64(n)
+	return n, err
+}
+
+// BytesEncoded returns the number of bytes of encoded content which have been
+// received from the remote server, if Response.ContentEncoding is set.
+// Otherwise, BytesEncoded returns zero.
+func (c *Response) BytesEncoded() int64 {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	if c.encoded == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&c.encoded.n)
+}
+
+// countingReader counts the bytes read from an io.Reader in a thread-safe
+// manner.
+type countingReader struct {
+	n int64 // must be 64bit aligned on 386
+	r io.Reader
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
@@ -0,0 +1,107 @@
+package grab
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestDecodeContent tests that encoded content is decoded by transfers with
+// Request.DecodeContent and that their progress is reported correctly.
+func TestDecodeContent(t *testing.T) {
+	filename := ".testDecodeContent"
+	defer os.Remove(filename)
+
+	content := bytes.Repeat([]byte("grab "), 65536)
+	gz := &bytes.Buffer{}
+	zw := gzip.NewWriter(gz)
+	zw.Write(content)
+	zw.Close()
+
+	var acceptEncoding string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		enc := r.URL.Query().Get("encoding")
+		body := gz.Bytes()
+		if strings.HasPrefix(enc, "rev") {
+			body = []byte("olleh")
+		}
+		w.Header().Set("Content-Encoding", enc)
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+		if r.Method == "GET" {
+			w.Write(body)
+		}
+	}))
+	defer s.Close()
+
+	t.Run("Gzip", func(t *testing.T) {
+		os.Remove(filename)
+		req, _ := NewRequest(filename, s.URL+"?encoding=gzip")
+		req.DecodeContent = true
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if !strings.Contains(acceptEncoding, "gzip") {
+			t.Errorf("expected gzip to be accepted, got: %q", acceptEncoding)
+		}
+		if resp.ContentEncoding != "gzip" {
+			t.Errorf("expected gzip content encoding, got: %q", resp.ContentEncoding)
+		}
+		if resp.EncodedSize != int64(gz.Len()) || resp.BytesEncoded() != int64(gz.Len()) {
+			t.Errorf("expected %d encoded bytes, got: %d/%d", gz.Len(), resp.BytesEncoded(), resp.EncodedSize)
+		}
+		if resp.Size != int64(len(content)) || resp.Progress() != 1 {
+			t.Errorf("expected decoded size %d, got: %d (%v)", len(content), resp.Size, resp.Progress())
+		}
+		b, _ := ioutil.ReadFile(filename)
+		if !bytes.Equal(b, content) {
+			t.Errorf("expected destination file to be decoded")
+		}
+	})
+
+	t.Run("Registered", func(t *testing.T) {
+		RegisterContentDecoder("rev", func(r io.Reader) (io.Reader, error) {
+			b, err := ioutil.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+				b[i], b[j] = b[j], b[i]
+			}
+			return bytes.NewReader(b), nil
+		})
+		defer func() {
+			contentDecodersMu.Lock()
+			delete(contentDecoders, "rev")
+			contentDecodersMu.Unlock()
+		}()
+
+		b := &bytes.Buffer{}
+		req, _ := NewRequest("", s.URL+"?encoding=rev")
+		req.SetWriter(b)
+		req.DecodeContent = true
+		if err := DefaultClient.Do(req).Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if b.String() != "hello" {
+			t.Errorf("expected registered decoder to be used, got: %q", b.String())
+		}
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		os.Remove(filename)
+		req, _ := NewRequest(filename, s.URL+"?encoding=revz")
+		req.DecodeContent = true
+		if err := DefaultClient.Do(req).Err(); err != ErrUnsupportedEncoding {
+			t.Errorf("expected ErrUnsupportedEncoding, got: %v", err)
+		}
+	})
+}
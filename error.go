@@ -1,30 +1,121 @@
 package grab
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+)
+
+// The following errors classify the cause of a failed file transfer. They are
+// not returned directly, but can be tested against any error returned by
+// grab using errors.Is. For example:
+//
+//	if errors.Is(resp.Err(), grab.ErrNetwork) {
+//		// retry later
+//	}
+var (
+	// ErrNetwork classifies errors caused by the remote server, or while
+	// communicating with it, including unexpected status codes.
+	ErrNetwork = errors.New("network error")
+
+	// ErrValidation classifies errors caused by a remote file or response
+	// which fails validation, such as a bad checksum or length.
+	ErrValidation = errors.New("validation error")
+
+	// ErrFilesystem classifies errors caused by local storage, such as a
+	// destination file that cannot be created or written.
+	ErrFilesystem = errors.New("filesystem error")
 )
 
 var (
 	// ErrBadLength indicates that the server response or an existing file does
 	// not match the expected content length.
-	ErrBadLength = errors.New("bad content length")
+	ErrBadLength = newError(ErrValidation, "bad content length")
 
 	// ErrBadChecksum indicates that a downloaded file failed to pass checksum
 	// validation.
-	ErrBadChecksum = errors.New("checksum mismatch")
+	ErrBadChecksum = newError(ErrValidation, "checksum mismatch")
+
+	// ErrBadSignature indicates that a downloaded file failed to pass
+	// verification of its detached signature.
+	ErrBadSignature = newError(ErrValidation, "signature verification failed")
+
+	// ErrTooLarge indicates that the remote file is larger than
+	// Request.SizeLimit.
+	ErrTooLarge = newError(ErrValidation, "file exceeds size limit")
+
+	// ErrRedirectPolicy indicates that a redirect was forbidden by the
+	// RedirectPolicy of a Request.
+	ErrRedirectPolicy = newError(ErrValidation, "redirect forbidden by policy")
+
+	// ErrInsecure indicates that a request or redirect to an insecure URL was
+	// refused by Client.HTTPSOnly.
+	ErrInsecure = newError(ErrValidation, "insecure URL refused")
+
+	// ErrPinMismatch indicates that the certificate chain of the remote server
+	// did not match any pin of a TLSPolicy.
+	ErrPinMismatch = newError(ErrValidation, "certificate pin mismatch")
 
 	// ErrNoFilename indicates that a reasonable filename could not be
 	// automatically determined using the URL or response headers from a server.
-	ErrNoFilename = errors.New("no filename could be determined")
+	ErrNoFilename = newError(ErrValidation, "no filename could be determined")
 
 	// ErrNoTimestamp indicates that a timestamp could not be automatically
 	// determined using the response headers from the remote server.
-	ErrNoTimestamp = errors.New("no timestamp could be determined for the remote file")
+	ErrNoTimestamp = newError(ErrValidation, "no timestamp could be determined for the remote file")
 
 	// ErrFileExists indicates that the destination path already exists.
-	ErrFileExists = errors.New("file exists")
+	ErrFileExists = newError(ErrFilesystem, "file exists")
+
+	// ErrNotDirectory indicates that a parent of the destination path exists,
+	// but is not a directory.
+	ErrNotDirectory = newError(ErrFilesystem, "destination directory is not a directory")
+
+	// ErrNoSpace indicates that the destination file system does not have
+	// enough free space for the file transfer. See Request.MinFreeSpace.
+	ErrNoSpace = newError(ErrFilesystem, "insufficient disk space")
+
+	// ErrStalled indicates that a file transfer was aborted because it received
+	// no data for longer than Request.StallTimeout, or its transfer rate fell
+	// below Request.MinimumSpeed.
+	ErrStalled = newError(ErrNetwork, "transfer stalled")
+
+	// ErrAttemptTimeout indicates that an attempt to transfer a file took
+	// longer than Request.AttemptTimeout.
+	ErrAttemptTimeout = newError(ErrNetwork, "attempt timed out")
+
+	// ErrUnsupportedEncoding indicates that the remote server encoded the
+	// content of the file with a Content-Encoding for which no ContentDecoder
+	// is registered. See Request.DecodeContent.
+	ErrUnsupportedEncoding = newError(ErrValidation, "unsupported content encoding")
+
+	// ErrUnsupportedArchive indicates that the format of a file to be
+	// extracted into Request.ExtractDir could not be determined from its
+	// extension.
+	ErrUnsupportedArchive = newError(ErrValidation, "unsupported archive format")
+
+	// ErrUnsafeArchive indicates that an archive to be extracted into
+	// Request.ExtractDir contains an entry with an absolute path, or a path
+	// outside of the extraction directory.
+	ErrUnsafeArchive = newError(ErrValidation, "archive entry outside of extraction directory")
+
+	// ErrHostCircuitOpen indicates that a request was not sent because the
+	// remote host has failed too many consecutive attempts and its circuit is
+	// open. See CircuitBreaker.
+	ErrHostCircuitOpen = newError(ErrNetwork, "host circuit open")
+
+	// ErrRetryBudgetExhausted indicates that a failed transfer was not
+	// retried because the RetryBudget of its RetryPolicy was exhausted. It
+	// wraps the error of the last attempt.
+	ErrRetryBudgetExhausted = newError(ErrNetwork, "retry budget exhausted")
+
+	// ErrServerNoRange indicates that the remote server did not honor a request
+	// for a range of bytes of the remote file.
+	ErrServerNoRange = newError(ErrNetwork, "server does not support ranged requests")
 )
 
 // StatusCodeError indicates that the server response had a status code that
@@ -35,8 +126,85 @@ func (err StatusCodeError) Error() string {
 	return fmt.Sprintf("server returned %d %s", err, http.StatusText(int(err)))
 }
 
-// IsStatusCodeError returns true if the given error is of type StatusCodeError.
+// Is returns true if target is ErrNetwork.
+func (err StatusCodeError) Is(target error) bool {
+	return target == ErrNetwork
+}
+
+// IsStatusCodeError returns true if the given error is, or wraps, an error of
+// type StatusCodeError.
 func IsStatusCodeError(err error) bool {
-	_, ok := err.(StatusCodeError)
-	return ok
+	var serr StatusCodeError
+	return errors.As(err, &serr)
+}
+
+// ContentTypeError indicates that the media type of the remote file was not
+// accepted by Request.AcceptContentTypes, Request.RejectContentTypes or
+// Request.ContentTypeFunc.
+type ContentTypeError string
+
+func (err ContentTypeError) Error() string {
+	return fmt.Sprintf("unacceptable content type: %s", string(err))
+}
+
+// Is returns true if target is ErrValidation.
+func (err ContentTypeError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// classError is an error which belongs to one of the error classes ErrNetwork,
+// ErrValidation or ErrFilesystem.
+type classError struct {
+	class error
+	err   error
+}
+
+// newError returns an error with the given text, which belongs to the given
+// error class.
+func newError(class error, text string) error {
+	return &classError{class: class, err: errors.New(text)}
+}
+
+func (err *classError) Error() string {
+	return err.err.Error()
+}
+
+func (err *classError) Unwrap() error {
+	return err.err
+}
+
+func (err *classError) Is(target error) bool {
+	return target == err.class
+}
+
+// classify wraps the given error with its error class, if it is a network or
+// filesystem error that is not already classified. Errors caused by the
+// cancelation of a Context are not classified.
+func classify(err error) error {
+	if err == nil ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrNetwork) ||
+		errors.Is(err, ErrValidation) ||
+		errors.Is(err, ErrFilesystem) {
+		return err
+	}
+	var (
+		urlErr     *url.Error
+		opErr      *net.OpError
+		pathErr    *os.PathError
+		linkErr    *os.LinkError
+		syscallErr *os.SyscallError
+		netErr     net.Error
+	)
+	switch {
+	case errors.As(err, &urlErr), errors.As(err, &opErr):
+		// checked first, as network errors may wrap an os.SyscallError
+		return &classError{class: ErrNetwork, err: err}
+	case errors.As(err, &pathErr), errors.As(err, &linkErr), errors.As(err, &syscallErr):
+		return &classError{class: ErrFilesystem, err: err}
+	case errors.As(err, &netErr):
+		return &classError{class: ErrNetwork, err: err}
+	}
+	return err
 }
@@ -0,0 +1,66 @@
+package grab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestErrorClasses tests that errors returned by grab can be classified using
+// errors.Is.
+func TestErrorClasses(t *testing.T) {
+	tests := []struct {
+		Err   error
+		Class error
+	}{
+		{ErrBadLength, ErrValidation},
+		{ErrBadChecksum, ErrValidation},
+		{ErrBadSignature, ErrValidation},
+		{ErrTooLarge, ErrValidation},
+		{ErrRedirectPolicy, ErrValidation},
+		{ErrInsecure, ErrValidation},
+		{ErrPinMismatch, ErrValidation},
+		{ErrNoFilename, ErrValidation},
+		{ErrNoTimestamp, ErrValidation},
+		{ErrFileExists, ErrFilesystem},
+		{ErrNotDirectory, ErrFilesystem},
+		{ErrStalled, ErrNetwork},
+		{ErrAttemptTimeout, ErrNetwork},
+		{ErrHostCircuitOpen, ErrNetwork},
+		{ErrRetryBudgetExhausted, ErrNetwork},
+		{ErrServerNoRange, ErrNetwork},
+		{StatusCodeError(http.StatusNotFound), ErrNetwork},
+		{ContentTypeError("text/html"), ErrValidation},
+		{fmt.Errorf("wrapped: %w", ErrBadChecksum), ErrValidation},
+		{classify(&os.PathError{Op: "open", Path: "x", Err: os.ErrNotExist}), ErrFilesystem},
+	}
+	classes := []error{ErrNetwork, ErrValidation, ErrFilesystem}
+	for _, test := range tests {
+		for _, class := range classes {
+			if is := errors.Is(test.Err, class); is != (class == test.Class) {
+				t.Errorf("expected errors.Is(%v, %v) to be %v", test.Err, class, !is)
+			}
+		}
+	}
+
+	if err := classify(context.Canceled); err != context.Canceled {
+		t.Errorf("expected context errors not to be classified, got: %v", err)
+	}
+	if !IsStatusCodeError(fmt.Errorf("wrapped: %w", StatusCodeError(http.StatusNotFound))) {
+		t.Errorf("expected IsStatusCodeError to unwrap errors")
+	}
+
+	t.Run("Network", func(t *testing.T) {
+		s := httptest.NewServer(http.NotFoundHandler())
+		url := s.URL
+		s.Close()
+		_, err := Get(".testErrorClassesNetwork", url)
+		if !errors.Is(err, ErrNetwork) {
+			t.Errorf("expected network error, got: %v", err)
+		}
+	})
+}
@@ -21,9 +21,9 @@ func ExampleClient_Do() {
 }
 
 // This example uses DoChannel to create a Producer/Consumer model for
-// downloading multiple files concurrently. This is similar to how DoBatch uses
-// DoChannel under the hood except that it allows the caller to continually send
-// new requests until they wish to close the request channel.
+// downloading multiple files concurrently. This is similar to DoBatch except
+// that it allows the caller to continually send new requests until they wish to
+// close the request channel.
 func ExampleClient_DoChannel() {
 	// create a request and a buffered response channel
 	reqch := make(chan *Request)
@@ -0,0 +1,172 @@
+package grab
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// archiveTarExts are the extensions of compressed tar archives, mapped to the
+// extension of their decompressor.
+var archiveTarExts = map[string]string{
+	".tgz":  ".gz",
+	".tbz":  ".bz2",
+	".tbz2": ".bz2",
+}
+
+// extractArchive extracts the validated destination file of the given
+// Response into Request.ExtractDir, if set. The next stateFunc is always
+// closeResponse.
+func (c *Client) extractArchive(resp *Response) stateFunc {
+	req := resp.Request
+	if req.ExtractDir == "" || req.writer != nil {
+		return c.closeResponse
+	}
+	if err := os.MkdirAll(req.ExtractDir, 0755); err != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+	name := strings.ToLower(resp.Filename)
+	if strings.HasSuffix(name, ".zip") {
+		resp.err = c.extractZip(resp)
+		return c.closeResponse
+	}
+
+	// find the decompressor of a tar archive
+	var d ContentDecoder
+	ext := filepath.Ext(name)
+	if dext, ok := archiveTarExts[ext]; ok {
+		d, _ = decompressor(dext)
+	} else if ext != ".tar" {
+		var inner string
+		d, inner = decompressor(name)
+		if d == nil || filepath.Ext(inner) != ".tar" {
+			resp.err = ErrUnsupportedArchive
+			return c.closeResponse
+		}
+	}
+	resp.err = c.extractTar(resp, d)
+	return c.closeResponse
+}
+
+// extractTar extracts the tar archive of the given Response, decompressed
+// with the given decoder, if any.
+func (c *Client) extractTar(resp *Response, d ContentDecoder) error {
+	f, err := os.Open(resp.Filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if d != nil {
+		if r, err = d(r); err != nil {
+			return err
+		}
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.extractEntry(resp, hdr.Name, hdr.FileInfo(), tr); err != nil {
+			return err
+		}
+	}
+}
+
+// extractZip extracts the zip archive of the given Response.
+func (c *Client) extractZip(resp *Response) error {
+	zr, err := zip.OpenReader(resp.Filename)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		if err := c.extractZipEntry(resp, zf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) extractZipEntry(resp *Response, zf *zip.File) error {
+	fi := zf.FileInfo()
+	if !fi.Mode().IsRegular() {
+		return c.extractEntry(resp, zf.Name, fi, nil)
+	}
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return c.extractEntry(resp, zf.Name, fi, r)
+}
+
+// extractEntry extracts a single directory or regular file with the given name
+// and content from an archive into Request.ExtractDir. Entries rejected by
+// Request.ExtractFilter, and any links or special files, are skipped.
+// ErrUnsafeArchive is returned if the entry would be extracted outside of
+// Request.ExtractDir.
+func (c *Client) extractEntry(resp *Response, name string, fi os.FileInfo, r io.Reader) error {
+	if err := resp.ctx.Err(); err != nil {
+		return err
+	}
+	req := resp.Request
+	mode := fi.Mode()
+	if !mode.IsDir() && !mode.IsRegular() {
+		return nil
+	}
+	if req.ExtractFilter != nil && !req.ExtractFilter(name, fi) {
+		return nil
+	}
+	path, err := extractPath(req.ExtractDir, name)
+	if err != nil {
+		return err
+	}
+	if mode.IsDir() {
+		return os.MkdirAll(path, 0755)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	t := newTransfer(resp.ctx, nil, nil, f, r, resp.bufferSize)
+	if _, err := t.copy(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(path, fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+	resp.ExtractedFiles = append(resp.ExtractedFiles, path)
+	return nil
+}
+
+// extractPath returns the path in the given directory at which the archive
+// entry with the given name is extracted. ErrUnsafeArchive is returned for
+// absolute names and names which traverse outside of the directory.
+func extractPath(dir, name string) (string, error) {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", ErrUnsafeArchive
+	}
+	name = filepath.Clean(name)
+	if name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+		return "", ErrUnsafeArchive
+	}
+	return filepath.Join(dir, name), nil
+}
@@ -0,0 +1,117 @@
+package grab
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestExtractArchive tests that archives are extracted into Request.ExtractDir.
+func TestExtractArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"dir/a.txt": "a",
+		"b.txt":     "b",
+		"c.txt":     "c",
+	}
+	names := []string{"dir/a.txt", "b.txt", "c.txt"}
+	tarArchive := func(names ...string) []byte {
+		buf := &bytes.Buffer{}
+		zw := gzip.NewWriter(buf)
+		tw := tar.NewWriter(zw)
+		for _, name := range names {
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(files[name]))})
+			tw.Write([]byte(files[name]))
+		}
+		tw.WriteHeader(&tar.Header{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"})
+		tw.Close()
+		zw.Close()
+		return buf.Bytes()
+	}
+	zipArchive := func(names ...string) []byte {
+		buf := &bytes.Buffer{}
+		zw := zip.NewWriter(buf)
+		for _, name := range names {
+			w, _ := zw.Create(name)
+			w.Write([]byte(files[name]))
+		}
+		zw.Close()
+		return buf.Bytes()
+	}
+
+	archives := map[string][]byte{
+		"/example.tar.gz": tarArchive(names...),
+		"/example.tgz":    tarArchive(names...),
+		"/example.zip":    zipArchive(names...),
+		"/evil.tar.gz":    tarArchive("../evil.txt"),
+		"/evil.zip":       zipArchive("../evil.txt"),
+		"/example.rar":    []byte("rar"),
+	}
+	files["../evil.txt"] = "evil"
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archives[r.URL.Path])
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		Path string
+		Err  error
+	}{
+		{"/example.tar.gz", nil},
+		{"/example.tgz", nil},
+		{"/example.zip", nil},
+		{"/evil.tar.gz", ErrUnsafeArchive},
+		{"/evil.zip", ErrUnsafeArchive},
+		{"/example.rar", ErrUnsupportedArchive},
+	}
+	for _, test := range tests {
+		t.Run(strings.TrimPrefix(test.Path, "/"), func(t *testing.T) {
+			extractDir := filepath.Join(dir, "extract")
+			os.RemoveAll(extractDir)
+			req, _ := NewRequest(dir, s.URL+test.Path)
+			req.ExtractDir = extractDir
+			req.ExtractFilter = func(name string, fi os.FileInfo) bool {
+				return name != "c.txt"
+			}
+			resp := DefaultClient.Do(req)
+			defer os.Remove(resp.Filename)
+			if err := resp.Err(); err != test.Err {
+				t.Fatalf("expected error %v, got: %v", test.Err, err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "evil.txt")); err == nil {
+				t.Fatalf("expected unsafe entry not to be extracted")
+			}
+			if test.Err != nil {
+				return
+			}
+			expect := []string{
+				filepath.Join(extractDir, "b.txt"),
+				filepath.Join(extractDir, "dir", "a.txt"),
+			}
+			sort.Strings(resp.ExtractedFiles)
+			if strings.Join(resp.ExtractedFiles, ",") != strings.Join(expect, ",") {
+				t.Errorf("expected extracted files %v, got: %v", expect, resp.ExtractedFiles)
+			}
+			if b, _ := ioutil.ReadFile(expect[1]); string(b) != "a" {
+				t.Errorf("expected extracted content, got: %q", b)
+			}
+			if _, err := os.Lstat(filepath.Join(extractDir, "link")); err == nil {
+				t.Errorf("expected links not to be extracted")
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+package grab
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// fileTransport is a http.RoundTripper for file:// URLs, which serves files
+// from local storage the way a HTTP server would, so that local files are
+// copied with the same progress, checksum and resume semantics as remote
+// files. GET and HEAD requests are supported, as are single byte ranges, the
+// If-Range header and the Last-Modified header.
+type fileTransport struct{}
+
+// localPath returns the local path of the given file:// URL.
+func localPath(req *http.Request) (string, error) {
+	if h := req.URL.Host; h != "" && h != "localhost" {
+		return "", fmt.Errorf("file URL is not local: %s", req.URL)
+	}
+	p := req.URL.Path
+	if runtime.GOOS == "windows" && len(p) > 2 && p[0] == '/' && p[2] == ':' {
+		// file:///C:/path
+		p = p[1:]
+	}
+	return filepath.FromSlash(p), nil
+}
+
+func (fileTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name, err := localPath(req)
+	if err != nil {
+		return nil, err
+	}
+	return serveFile(req, func() (os.FileInfo, fileReader, error) {
+		f, err := os.Open(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+		return fi, f, nil
+	})
+}
+
+// fileReader is a file opened by serveFile.
+type fileReader interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// newTransportResponse returns a new http.Response to the given request, for
+// the http.RoundTrippers of protocols other than HTTP.
+func newTransportResponse(req *http.Request) *http.Response {
+	return &http.Response{
+		Proto:      "HTTP/1.0",
+		ProtoMajor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// withStatus sets the status of the given http.Response and returns it.
+func withStatus(resp *http.Response, code int) (*http.Response, error) {
+	resp.StatusCode = code
+	resp.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+	return resp, nil
+}
+
+// serveFile responds to the given GET or HEAD request with the file returned
+// by the given open function, as a HTTP server would. The open function need
+// not open directories. Missing files and directories are not found and
+// permission errors are forbidden. Single byte ranges are served if the
+// If-Range header, if any, matches the modification time of the file.
+func serveFile(req *http.Request, open func() (os.FileInfo, fileReader, error)) (*http.Response, error) {
+	resp := newTransportResponse(req)
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return withStatus(resp, http.StatusMethodNotAllowed)
+	}
+	fi, f, err := open()
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return withStatus(resp, http.StatusNotFound)
+		}
+		if errors.Is(err, fs.ErrPermission) {
+			return withStatus(resp, http.StatusForbidden)
+		}
+		return nil, err
+	}
+	if fi.IsDir() {
+		if f != nil {
+			f.Close()
+		}
+		return withStatus(resp, http.StatusNotFound)
+	}
+
+	size := fi.Size()
+	lastmod := fi.ModTime().UTC().Format(http.TimeFormat)
+	resp.Header.Set("Accept-Ranges", "bytes")
+	resp.Header.Set("Last-Modified", lastmod)
+	start, end := int64(0), size-1
+	code := http.StatusOK
+	if v := req.Header.Get("Range"); v != "" {
+		if ir := req.Header.Get("If-Range"); ir == "" || ir == lastmod {
+			var ok bool
+			start, end, ok = parseByteRange(v, size)
+			if !ok {
+				f.Close()
+				resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+				return withStatus(resp, http.StatusRequestedRangeNotSatisfiable)
+			}
+			resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+			code = http.StatusPartialContent
+		}
+	}
+	resp.ContentLength = end - start + 1
+	resp.Header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	if req.Method == "HEAD" {
+		f.Close()
+	} else {
+		resp.Body = &fileBody{SectionReader: io.NewSectionReader(f, start, resp.ContentLength), f: f, start: start}
+	}
+	return withStatus(resp, code)
+}
+
+// fileBody is the body of a response of serveFile, which reads a section of
+// the file.
+type fileBody struct {
+	*io.SectionReader
+	f     fileReader
+	start int64
+}
+
+func (c *fileBody) Close() error {
+	return c.f.Close()
+}
+
+// zeroCopySource returns a reader of the unread remainder of the section from
+// the file itself, if it is an *os.File, so that the kernel may copy it to the
+// destination directly. See zeroCopyReader.
+func (c *fileBody) zeroCopySource() io.Reader {
+	f, ok := c.f.(*os.File)
+	if !ok {
+		return nil
+	}
+	off, err := c.SectionReader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	if _, err := f.Seek(c.start+off, io.SeekStart); err != nil {
+		return nil
+	}
+	return &io.LimitedReader{R: f, N: c.Size() - off}
+}
+
+// parseByteRange parses a Range header of a single byte range, such as
+// bytes=100- or bytes=100-199, of a file of the given size. If the range is
+// malformed or not satisfiable, ok is false.
+func parseByteRange(v string, size int64) (start, end int64, ok bool) {
+	v = strings.TrimPrefix(v, "bytes=")
+	s, e, found := strings.Cut(v, "-")
+	if !found || strings.Contains(e, ",") {
+		return 0, 0, false
+	}
+	if s == "" {
+		// suffix range
+		n, err := strconv.ParseInt(e, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, size > 0
+	}
+	start, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end = size - 1
+	if e != "" {
+		if end, err = strconv.ParseInt(e, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
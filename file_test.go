@@ -0,0 +1,107 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestFileURL tests that files at file:// URLs are copied from local storage,
+// validated and resumed like remote files.
+func TestFileURL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	src := filepath.Join(dir, "source file")
+	if err := ioutil.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	u := (&url.URL{Scheme: "file", Path: filepath.ToSlash(src)}).String()
+	sum := sha256.Sum256(content)
+
+	t.Run("Copy", func(t *testing.T) {
+		dst := filepath.Join(dir, "copy")
+		if err := os.Mkdir(dst, 0755); err != nil {
+			t.Fatal(err)
+		}
+		req, _ := NewRequest(dst, u)
+		req.SetChecksum(sha256.New(), sum[:], false)
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if expect := filepath.Join(dst, "source file"); resp.Filename != expect {
+			t.Errorf("expected filename %s, got: %s", expect, resp.Filename)
+		}
+		if resp.bytesTransferred() != 4096 {
+			t.Errorf("expected 4096 bytes transferred, got: %d", resp.bytesTransferred())
+		}
+		testContent(t, resp.Filename, content)
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		dst := filepath.Join(dir, "resumed")
+		if err := ioutil.WriteFile(dst, content[:1000], 0644); err != nil {
+			t.Fatal(err)
+		}
+		req, _ := NewRequest(dst, u)
+		req.SetChecksum(sha256.New(), sum[:], false)
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if !resp.DidResume || resp.bytesTransferred() != 3096 {
+			t.Errorf("expected transfer to resume, transferred: %d", resp.bytesTransferred())
+		}
+		testContent(t, dst, content)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		req, _ := NewRequest(filepath.Join(dir, "missing"), u+".missing")
+		if err := DefaultClient.Do(req).Err(); !IsStatusCodeError(err) {
+			t.Errorf("expected status code error, got: %v", err)
+		}
+	})
+
+	t.Run("Remote", func(t *testing.T) {
+		req, _ := NewRequest(filepath.Join(dir, "remote"), "file://example.com/file")
+		if err := DefaultClient.Do(req).Err(); err == nil {
+			t.Errorf("expected error for remote file URL")
+		}
+	})
+}
+
+// TestParseByteRange tests the parsing of single byte ranges.
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		v          string
+		start, end int64
+		ok         bool
+	}{
+		{"bytes=0-", 0, 99, true},
+		{"bytes=10-19", 10, 19, true},
+		{"bytes=10-1000", 10, 99, true},
+		{"bytes=-10", 90, 99, true},
+		{"bytes=100-", 0, 0, false},
+		{"bytes=20-10", 0, 0, false},
+		{"bytes=0-1,5-6", 0, 0, false},
+		{"bytes=x-", 0, 0, false},
+	}
+	for _, test := range tests {
+		start, end, ok := parseByteRange(test.v, 100)
+		if ok != test.ok || (ok && (start != test.start || end != test.end)) {
+			t.Errorf("%s: expected %d-%d %v, got: %d-%d %v",
+				test.v, test.start, test.end, test.ok, start, end, ok)
+		}
+	}
+}
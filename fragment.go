@@ -0,0 +1,55 @@
+package grab
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/url"
+)
+
+// fragmentHashes are the hash algorithms recognized in URL fragments, such as
+// https://example.com/file.iso#sha256=..., as used by pip and Puppet.
+var fragmentHashes = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha512", sha512.New},
+	{"sha384", sha512.New384},
+	{"sha256", sha256.New},
+	{"sha224", sha256.New224},
+	{"sha1", sha1.New},
+	{"md5", md5.New},
+}
+
+// addFragmentChecksums adds a checksum to the given Request for each hash
+// algorithm named in the fragment of the given URL, such as sha256=<hex>.
+// Fragments are never sent to the remote server. Other fragment parameters
+// are ignored, while a malformed checksum of a recognized algorithm is an
+// error.
+func addFragmentChecksums(req *Request, u *url.URL) error {
+	if u.Fragment == "" {
+		return nil
+	}
+	q, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		// not a list of parameters
+		return nil
+	}
+	for _, alg := range fragmentHashes {
+		v := q.Get(alg.name)
+		if v == "" {
+			continue
+		}
+		h := alg.new()
+		sum, err := hex.DecodeString(v)
+		if err != nil || len(sum) != h.Size() {
+			return fmt.Errorf("malformed %s checksum in URL fragment: %s", alg.name, v)
+		}
+		req.AddChecksum(h, sum, false)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package grab
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// TestFragmentChecksum tests that checksums given in URL fragments are used to
+// validate downloaded files.
+func TestFragmentChecksum(t *testing.T) {
+	filename := ".testFragmentChecksum"
+	defer os.Remove(filename)
+
+	content := make([]byte, 1024)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	sha256sum := sha256.Sum256(content)
+	md5sum := md5.Sum(content)
+
+	tests := []struct {
+		fragment string
+		sums     int
+		err      error
+	}{
+		{fmt.Sprintf("sha256=%x", sha256sum), 1, nil},
+		{fmt.Sprintf("md5=%x&sha256=%x", md5sum, sha256sum), 2, nil},
+		{fmt.Sprintf("egg=example&sha256=%x", sha256sum), 1, nil},
+		{fmt.Sprintf("sha256=%x", make([]byte, sha256.Size)), 1, ErrBadChecksum},
+		{"section", 0, nil},
+	}
+	for _, test := range tests {
+		os.Remove(filename)
+		req, err := NewRequest(filename, ts.URL+"?size=1024#"+test.fragment)
+		if err != nil {
+			t.Fatalf("%s: error: %v", test.fragment, err)
+		}
+		if len(req.checksums) != test.sums {
+			t.Errorf("%s: expected %d checksums, got: %d", test.fragment, test.sums, len(req.checksums))
+		}
+		if err := DefaultClient.Do(req).Err(); err != test.err {
+			t.Errorf("%s: expected error %v, got: %v", test.fragment, test.err, err)
+		}
+	}
+
+	if _, err := NewRequest(filename, ts.URL+"#sha256=bad"); err == nil {
+		t.Errorf("expected error for malformed checksum")
+	}
+}
@@ -0,0 +1,310 @@
+package grab
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ftpTransport is a http.RoundTripper for ftp:// and ftps:// URLs, which
+// translates GET and HEAD requests into FTP commands so that files on FTP
+// servers are transferred with the same progress, checksum and resume
+// semantics as files on HTTP servers. The size of each file is retrieved with
+// SIZE and its modification time with MDTM, and ranged requests are resumed
+// with REST. ftps:// URLs use implicit TLS, on port 990 by default, for both
+// the control and data connections.
+//
+// Credentials are given in the userinfo of the URL. Anonymous login is used
+// otherwise. FTP replies are mapped to HTTP status codes so that missing files
+// fail with a StatusCodeError.
+type ftpTransport struct{}
+
+// ftpConn is the control connection of a FTP session.
+type ftpConn struct {
+	*textproto.Conn
+	nc  net.Conn
+	tls *tls.Config
+}
+
+// cmd sends a command and reads the reply, which must have a status code of
+// the given class, such as 2 for 2xx replies.
+func (c *ftpConn) cmd(class int, format string, args ...interface{}) (int, string, error) {
+	if _, err := c.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.ReadResponse(class)
+}
+
+// dialFTP opens and logs into a FTP session with the server of the given URL.
+func dialFTP(ctx context.Context, u *url.URL) (*ftpConn, error) {
+	host, port := u.Hostname(), u.Port()
+	var config *tls.Config
+	if u.Scheme == "ftps" {
+		config = &tls.Config{
+			ServerName:         host,
+			ClientSessionCache: tls.NewLRUClientSessionCache(1),
+		}
+	}
+	if port == "" {
+		port = "21"
+		if config != nil {
+			port = "990"
+		}
+	}
+	d := &net.Dialer{}
+	nc, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		nc = tls.Client(nc, config)
+	}
+	c := &ftpConn{Conn: textproto.NewConn(nc), nc: nc, tls: config}
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	defer stop()
+	if err := c.login(u); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *ftpConn) login(u *url.URL) error {
+	if _, _, err := c.ReadResponse(2); err != nil {
+		return err
+	}
+	user, pass := "anonymous", "anonymous@"
+	if u.User != nil {
+		user = u.User.Username()
+		pass, _ = u.User.Password()
+	}
+	code, msg, err := c.cmd(0, "USER %s", user)
+	if err != nil {
+		return err
+	}
+	if code == 331 {
+		code, msg, err = c.cmd(0, "PASS %s", pass)
+		if err != nil {
+			return err
+		}
+	}
+	if code != 230 && code != 202 {
+		return &textproto.Error{Code: code, Msg: msg}
+	}
+	if c.tls != nil {
+		if _, _, err := c.cmd(2, "PBSZ 0"); err != nil {
+			return err
+		}
+		if _, _, err := c.cmd(2, "PROT P"); err != nil {
+			return err
+		}
+	}
+	_, _, err = c.cmd(2, "TYPE I")
+	return err
+}
+
+// dialData opens a passive data connection, using EPSV or, if the server does
+// not support it, PASV.
+func (c *ftpConn) dialData(ctx context.Context) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(c.nc.RemoteAddr().String())
+	var port int
+	code, msg, err := c.cmd(0, "EPSV")
+	if err != nil {
+		return nil, err
+	}
+	if code == 229 {
+		// 229 Entering Extended Passive Mode (|||port|)
+		i := strings.Index(msg, "(|||")
+		if i < 0 {
+			return nil, fmt.Errorf("malformed EPSV reply: %s", msg)
+		}
+		fmt.Sscanf(msg[i+4:], "%d|)", &port)
+	} else {
+		// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+		_, msg, err = c.cmd(2, "PASV")
+		if err != nil {
+			return nil, err
+		}
+		i := strings.Index(msg, "(")
+		var h [4]int
+		var p1, p2 int
+		if i < 0 {
+			return nil, fmt.Errorf("malformed PASV reply: %s", msg)
+		}
+		fmt.Sscanf(msg[i:], "(%d,%d,%d,%d,%d,%d)", &h[0], &h[1], &h[2], &h[3], &p1, &p2)
+		port = p1<<8 | p2
+	}
+	if port <= 0 || port > 65535 {
+		return nil, fmt.Errorf("malformed passive mode reply: %s", msg)
+	}
+	d := &net.Dialer{}
+	nc, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	if c.tls != nil {
+		nc = tls.Client(nc, c.tls)
+	}
+	return nc, nil
+}
+
+// ftpStatus maps a FTP reply code to a HTTP status code.
+func ftpStatus(code int) int {
+	switch code {
+	case 530, 532:
+		return http.StatusForbidden
+	case 550, 551, 553:
+		return http.StatusNotFound
+	default:
+		if code >= 400 && code < 500 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusBadGateway
+	}
+}
+
+// ftpSafe returns true if the path and credentials of the given URL, which are
+// sent as arguments of FTP commands, contain no control characters.
+func ftpSafe(u *url.URL) bool {
+	args := []string{u.Path}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		args = append(args, u.User.Username(), pass)
+	}
+	for _, arg := range args {
+		if strings.IndexFunc(arg, unicode.IsControl) >= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (ftpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := newTransportResponse(req)
+	status := func(code int) (*http.Response, error) {
+		return withStatus(resp, code)
+	}
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return status(http.StatusMethodNotAllowed)
+	}
+
+	if !ftpSafe(req.URL) {
+		// control characters would inject commands
+		return status(http.StatusBadRequest)
+	}
+
+	ctx := req.Context()
+	c, err := dialFTP(ctx, req.URL)
+	if err != nil {
+		if e, ok := err.(*textproto.Error); ok {
+			return status(ftpStatus(e.Code))
+		}
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { c.nc.Close() })
+	ok := false
+	defer func() {
+		if !ok {
+			stop()
+			c.Close()
+		}
+	}()
+
+	name := req.URL.Path
+	size := int64(-1)
+	if code, msg, err := c.cmd(0, "SIZE %s", name); err != nil {
+		return nil, err
+	} else if code == 213 {
+		size, _ = strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+	} else if code == 550 {
+		return status(ftpStatus(code))
+	}
+	var lastmod string
+	if code, msg, err := c.cmd(0, "MDTM %s", name); err != nil {
+		return nil, err
+	} else if code == 213 {
+		if t, err := time.Parse("20060102150405", strings.TrimSpace(msg)); err == nil {
+			lastmod = t.UTC().Format(http.TimeFormat)
+			resp.Header.Set("Last-Modified", lastmod)
+		}
+	}
+
+	code := http.StatusOK
+	start, end := int64(0), size-1
+	if size >= 0 {
+		resp.Header.Set("Accept-Ranges", "bytes")
+		if v := req.Header.Get("Range"); v != "" {
+			if ir := req.Header.Get("If-Range"); ir == "" || ir == lastmod {
+				var ok bool
+				start, end, ok = parseByteRange(v, size)
+				if !ok {
+					resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+					c.cmd(0, "QUIT")
+					return status(http.StatusRequestedRangeNotSatisfiable)
+				}
+				resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+				code = http.StatusPartialContent
+			}
+		}
+		resp.ContentLength = end - start + 1
+		resp.Header.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	} else {
+		resp.ContentLength = -1
+	}
+	if req.Method == "HEAD" {
+		c.cmd(0, "QUIT")
+		return status(code)
+	}
+
+	dc, err := c.dialData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if start > 0 {
+		if _, _, err := c.cmd(3, "REST %d", start); err != nil {
+			dc.Close()
+			return nil, err
+		}
+	}
+	if _, _, err := c.cmd(1, "RETR %s", name); err != nil {
+		dc.Close()
+		if e, ok := err.(*textproto.Error); ok {
+			return status(ftpStatus(e.Code))
+		}
+		return nil, err
+	}
+	var r io.Reader = dc
+	if resp.ContentLength >= 0 {
+		r = io.LimitReader(dc, resp.ContentLength)
+	}
+	ok = true
+	resp.Body = &ftpBody{Reader: r, data: dc, ctrl: c, stop: stop}
+	return status(code)
+}
+
+// ftpBody is the content of a file retrieved with RETR. Closing the body
+// closes the data connection and the FTP session.
+type ftpBody struct {
+	io.Reader
+	data net.Conn
+	ctrl *ftpConn
+	stop func() bool
+}
+
+func (c *ftpBody) Close() error {
+	c.stop()
+	err := c.data.Close()
+	c.ctrl.ReadResponse(2)
+	c.ctrl.Cmd("QUIT")
+	c.ctrl.Close()
+	return err
+}
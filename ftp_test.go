@@ -0,0 +1,153 @@
+package grab
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newFTPServer starts a minimal FTP server which serves the given files over
+// passive connections and returns its address.
+func newFTPServer(t *testing.T, files map[string][]byte) (addr string, close func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveFTP(c, files)
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+func serveFTP(c net.Conn, files map[string][]byte) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(c, format+"\r\n", args...)
+	}
+	reply("220 ready")
+	var data net.Listener
+	var offset int64
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch cmd {
+		case "USER":
+			reply("331 password required")
+		case "PASS":
+			reply("230 logged in")
+		case "TYPE":
+			reply("200 binary")
+		case "SIZE":
+			if b, ok := files[arg]; ok {
+				reply("213 %d", len(b))
+			} else {
+				reply("550 not found")
+			}
+		case "MDTM":
+			reply("213 20200102030405")
+		case "EPSV":
+			if data, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+				reply("425 cannot open data connection")
+				continue
+			}
+			reply("229 Entering Extended Passive Mode (|||%d|)", data.Addr().(*net.TCPAddr).Port)
+		case "REST":
+			offset, _ = strconv.ParseInt(arg, 10, 64)
+			reply("350 restarting")
+		case "RETR":
+			b, ok := files[arg]
+			if !ok {
+				reply("550 not found")
+				continue
+			}
+			reply("150 opening data connection")
+			dc, err := data.Accept()
+			data.Close()
+			if err != nil {
+				return
+			}
+			dc.Write(b[offset:])
+			dc.Close()
+			offset = 0
+			reply("226 transfer complete")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+// TestFTP tests that files are transferred and resumed from FTP servers.
+func TestFTP(t *testing.T) {
+	filename := ".testFTP"
+	defer os.Remove(filename)
+
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	sum := sha256.Sum256(content)
+	addr, close := newFTPServer(t, map[string][]byte{"/pub/file": content})
+	defer close()
+
+	req, _ := NewRequest(filename, "ftp://"+addr+"/pub/file")
+	req.SetChecksum(sha256.New(), sum[:], false)
+	resp := DefaultClient.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	testContent(t, filename, content)
+	if fi, err := os.Stat(filename); err != nil || fi.ModTime().Year() != 2020 {
+		t.Errorf("expected modification time from MDTM")
+	}
+
+	// resume
+	if err := os.Truncate(filename, 1000); err != nil {
+		t.Fatal(err)
+	}
+	req, _ = NewRequest(filename, "ftp://user:secret@"+addr+"/pub/file")
+	req.SetChecksum(sha256.New(), sum[:], false)
+	resp = DefaultClient.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if !resp.DidResume || resp.bytesTransferred() != 3096 {
+		t.Errorf("expected transfer to resume, transferred: %d", resp.bytesTransferred())
+	}
+	testContent(t, filename, content)
+
+	// missing file
+	req, _ = NewRequest(filename+".missing", "ftp://"+addr+"/pub/missing")
+	if err := DefaultClient.Do(req).Err(); !IsStatusCodeError(err) {
+		t.Errorf("expected status code error, got: %v", err)
+	}
+
+	// control characters, which would inject commands, are refused
+	for _, u := range []string{
+		"ftp://" + addr + "/pub/missing%0d%0aDELE%20/pub/file",
+		"ftp://user%0d%0aDELE%20%2Fpub%2Ffile:secret@" + addr + "/pub/file",
+		"ftp://user:secret%0aDELE%20%2Fpub%2Ffile@" + addr + "/pub/file",
+	} {
+		req, _ = NewRequest(filename+".invalid", u)
+		if err := DefaultClient.Do(req).Err(); err != StatusCodeError(400) {
+			t.Errorf("%s: expected status code 400, got: %v", u, err)
+		}
+	}
+}
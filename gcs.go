@@ -0,0 +1,87 @@
+package grab
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// GCSConfig configures the transfer of files at gs://bucket/object URLs from
+// Google Cloud Storage. See Client.GCS.
+type GCSConfig struct {
+	// Endpoint is the base URL of the XML API of Cloud Storage. If empty,
+	// "https://storage.googleapis.com" is used.
+	Endpoint string
+
+	// Token, if not nil, returns an OAuth 2.0 access token, which is sent as a
+	// bearer token with each request. If nil, the GOOGLE_OAUTH_ACCESS_TOKEN
+	// environment variable is used. If no token is found, requests are not
+	// authenticated, which allows access to public objects only.
+	Token func(ctx context.Context) (string, error)
+}
+
+// gcsRoundTrip sends the given request for a gs:// URL to Cloud Storage.
+func gcsRoundTrip(c *Client, req *http.Request) (*http.Response, error) {
+	config := c.GCS
+	if config == nil {
+		config = &GCSConfig{}
+	}
+	bucket, object := req.URL.Host, strings.TrimPrefix(req.URL.Path, "/")
+	if bucket == "" || object == "" {
+		return nil, fmt.Errorf("malformed GCS URL: %s", req.URL)
+	}
+	endpoint := config.Endpoint
+	if endpoint == "" {
+		endpoint = "https://storage.googleapis.com"
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + object)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = req.URL.RawQuery
+	hreq := req.Clone(req.Context())
+	hreq.URL = u
+	hreq.Host = u.Host
+
+	token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
+	if config.Token != nil {
+		if token, err = config.Token(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+	if token != "" {
+		hreq.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.sendHTTP(hreq)
+}
+
+// gcsChecksum returns the MD5 or, for composite objects, the CRC32C checksum
+// of a Cloud Storage object, given in the x-goog-hash headers of the given
+// response.
+func gcsChecksum(hresp *http.Response) *Checksum {
+	sums := make(map[string][]byte)
+	for _, v := range hresp.Header.Values("X-Goog-Hash") {
+		for _, member := range strings.Split(v, ",") {
+			alg, b64, ok := strings.Cut(strings.TrimSpace(member), "=")
+			if !ok {
+				continue
+			}
+			if b, err := base64.StdEncoding.DecodeString(b64); err == nil {
+				sums[alg] = b
+			}
+		}
+	}
+	if sum := sums["md5"]; len(sum) == md5.Size {
+		return &Checksum{Hash: md5.New(), Expected: sum}
+	}
+	if sum := sums["crc32c"]; len(sum) == crc32.Size {
+		return &Checksum{Hash: crc32.New(crc32.MakeTable(crc32.Castagnoli)), Expected: sum}
+	}
+	return nil
+}
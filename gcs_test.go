@@ -0,0 +1,84 @@
+package grab
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestGCS tests that objects are transferred from Cloud Storage with a bearer
+// token and validated using their x-goog-hash headers.
+func TestGCS(t *testing.T) {
+	filename := ".testGCS"
+	defer os.Remove(filename)
+
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	md5sum := md5.Sum(content)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(content, crc32.MakeTable(crc32.Castagnoli)))
+	b64 := base64.StdEncoding.EncodeToString
+
+	var hashes []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/bucket/dir/object" {
+			http.NotFound(w, r)
+			return
+		}
+		for _, v := range hashes {
+			w.Header().Add("X-Goog-Hash", v)
+		}
+		http.ServeContent(w, r, "", time.Unix(0, 0), bytes.NewReader(content))
+	}))
+	defer s.Close()
+
+	client := NewClient()
+	client.GCS = &GCSConfig{
+		Endpoint: s.URL,
+		Token: func(ctx context.Context) (string, error) {
+			return "token", nil
+		},
+	}
+	tests := []struct {
+		hashes []string
+		alg    int
+		err    error
+	}{
+		{[]string{"crc32c=" + b64(crc), "md5=" + b64(md5sum[:])}, md5.Size, nil},
+		{[]string{"crc32c=" + b64(crc)}, crc32.Size, nil},
+		{[]string{"crc32c=AAAAAA=="}, crc32.Size, ErrBadChecksum},
+		{nil, 0, nil},
+	}
+	for _, test := range tests {
+		os.Remove(filename)
+		hashes = test.hashes
+		req, _ := NewRequest(filename, "gs://bucket/dir/object")
+		resp := client.Do(req)
+		if err := resp.Err(); err != test.err {
+			t.Errorf("%v: expected error %v, got: %v", test.hashes, test.err, err)
+		}
+		if test.alg == 0 {
+			if resp.Digest != nil {
+				t.Errorf("expected no checksum, got: %v", resp.Digest)
+			}
+			continue
+		}
+		if resp.Digest == nil || len(resp.Digest.Expected) != test.alg {
+			t.Errorf("%v: expected %d byte checksum, got: %v", test.hashes, test.alg, resp.Digest)
+		}
+	}
+}
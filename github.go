@@ -0,0 +1,183 @@
+package grab
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// GitHubOptions configures the resolution of GitHub release assets with
+// GitHubRequests.
+type GitHubOptions struct {
+	// Token is a GitHub access token used to authenticate with the GitHub API
+	// and to download assets of private repositories. If empty, the
+	// GITHUB_TOKEN environment variable is used, if set.
+	Token string
+
+	// BaseURL is the base URL of the GitHub API, such as
+	// "https://github.example.com/api/v3" for GitHub Enterprise Server. If
+	// empty, "https://api.github.com" is used.
+	BaseURL string
+
+	// Prerelease specifies that the latest release may be a prerelease.
+	Prerelease bool
+
+	// Draft specifies that draft releases may be resolved. Draft releases are
+	// only visible with a token with push access to the repository.
+	Draft bool
+}
+
+// githubRelease is a release returned by the GitHub API.
+type githubRelease struct {
+	TagName    string        `json:"tag_name"`
+	Draft      bool          `json:"draft"`
+	Prerelease bool          `json:"prerelease"`
+	Assets     []githubAsset `json:"assets"`
+}
+
+// githubAsset is an asset of a release returned by the GitHub API.
+type githubAsset struct {
+	Name               string `json:"name"`
+	Size               int64  `json:"size"`
+	URL                string `json:"url"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+	Digest             string `json:"digest"`
+}
+
+// GitHubRequests resolves the GitHub release assets described by the given
+// spec into Requests, using the DefaultClient. See Client.GitHubRequests.
+func GitHubRequests(dst, spec string, opts *GitHubOptions) ([]*Request, error) {
+	return DefaultClient.GitHubRequests(context.Background(), dst, spec, opts)
+}
+
+// GitHubRequests resolves the GitHub release assets described by the given
+// spec into Requests, to be downloaded into the given destination directory.
+//
+// The spec is formatted as "owner/repo@tag:pattern". The tag may be omitted,
+// or be "latest", to resolve the latest release which is neither a draft nor,
+// unless GitHubOptions.Prerelease is set, a prerelease. The pattern is matched
+// with the name of each asset of the release, using the syntax of path.Match,
+// and may be omitted to resolve every asset.
+//
+// Request.Size is set to the size of each asset and, if the GitHub API gives
+// the SHA256 digest of an asset, the checksum is added with AddChecksum. If a
+// token is given, assets are downloaded through the GitHub API, to support
+// private repositories. An error is returned if no asset matches.
+func (c *Client) GitHubRequests(ctx context.Context, dst, spec string, opts *GitHubOptions) ([]*Request, error) {
+	if opts == nil {
+		opts = &GitHubOptions{}
+	}
+	repo, pattern, _ := strings.Cut(spec, ":")
+	repo, tag, _ := strings.Cut(repo, "@")
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("malformed GitHub release: %s", spec)
+	}
+	if pattern == "" {
+		pattern = "*"
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	token := opts.Token
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+	base := strings.TrimSuffix(opts.BaseURL, "/")
+	if base == "" {
+		base = "https://api.github.com"
+	}
+	get := func(endpoint string, v interface{}) error {
+		hreq, err := http.NewRequest("GET", base+endpoint, nil)
+		if err != nil {
+			return err
+		}
+		hreq.Header.Set("Accept", "application/vnd.github+json")
+		if token != "" {
+			hreq.Header.Set("Authorization", "Bearer "+token)
+		}
+		hresp, err := c.doHTTPRequest(hreq.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer hresp.Body.Close()
+		if hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+			return StatusCodeError(hresp.StatusCode)
+		}
+		return json.NewDecoder(hresp.Body).Decode(v)
+	}
+
+	// resolve the release
+	prefix := "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(name)
+	var release *githubRelease
+	var releases []githubRelease
+	if tag != "" && tag != "latest" && !opts.Draft {
+		release = &githubRelease{}
+		if err := get(prefix+"/releases/tags/"+url.PathEscape(tag), release); err != nil {
+			return nil, err
+		}
+	} else if err := get(prefix+"/releases?per_page=100", &releases); err != nil {
+		return nil, err
+	}
+	for i, r := range releases {
+		if r.Draft && !opts.Draft {
+			continue
+		}
+		if tag == "" || tag == "latest" {
+			if r.Prerelease && !opts.Prerelease {
+				continue
+			}
+		} else if r.TagName != tag {
+			continue
+		}
+		release = &releases[i]
+		break
+	}
+	if release == nil {
+		return nil, fmt.Errorf("no matching GitHub release: %s", spec)
+	}
+
+	// match assets
+	var reqs []*Request
+	for _, asset := range release.Assets {
+		if ok, _ := path.Match(pattern, asset.Name); !ok {
+			continue
+		}
+		filename, err := extractPath(dst, asset.Name)
+		if err != nil {
+			return nil, fmt.Errorf("unsafe GitHub asset name: %s", asset.Name)
+		}
+		u := asset.BrowserDownloadURL
+		if token != "" && asset.URL != "" {
+			u = asset.URL
+		}
+		req, err := NewRequest(filename, u)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" && asset.URL != "" {
+			// the API redirects to the asset, without the Authorization header
+			req.HTTPRequest.Header.Set("Accept", "application/octet-stream")
+			req.HTTPRequest.Header.Set("Authorization", "Bearer "+token)
+		}
+		req.Label = asset.Name
+		req.Size = asset.Size
+		if v := strings.TrimPrefix(asset.Digest, "sha256:"); v != asset.Digest {
+			if sum, err := hex.DecodeString(v); err == nil && len(sum) == sha256.Size {
+				req.AddChecksum(sha256.New(), sum, false)
+			}
+		}
+		reqs = append(reqs, req)
+	}
+	if len(reqs) == 0 {
+		return nil, fmt.Errorf("no GitHub release asset matches %s in %s", pattern, release.TagName)
+	}
+	return reqs, nil
+}
@@ -0,0 +1,148 @@
+package grab
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestGitHubRequests tests that GitHub release assets are resolved into
+// Requests and downloaded.
+func TestGitHubRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := []byte("example release asset")
+	sum := sha256.Sum256(content)
+	var s *httptest.Server
+	release := func(tag string, draft, prerelease bool) githubRelease {
+		return githubRelease{
+			TagName:    tag,
+			Draft:      draft,
+			Prerelease: prerelease,
+			Assets: []githubAsset{
+				{
+					Name:               "example-linux.tar.gz",
+					Size:               int64(len(content)),
+					URL:                s.URL + "/repos/o/r/releases/assets/1",
+					BrowserDownloadURL: s.URL + "/download/" + tag + "/example-linux.tar.gz",
+					Digest:             "sha256:" + hex.EncodeToString(sum[:]),
+				},
+				{
+					Name:               "example-darwin.tar.gz",
+					BrowserDownloadURL: s.URL + "/download/" + tag + "/example-darwin.tar.gz",
+				},
+			},
+		}
+	}
+	var auth, accept string
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		releases := []githubRelease{
+			release("v3", true, false),
+			release("v2", false, true),
+			release("v1", false, false),
+		}
+		for _, rel := range releases {
+			if !rel.Draft && r.URL.Path == "/repos/o/r/releases/tags/"+rel.TagName {
+				json.NewEncoder(w).Encode(rel)
+				return
+			}
+		}
+		switch r.URL.Path {
+		case "/repos/o/r/releases":
+			json.NewEncoder(w).Encode(releases)
+		case "/repos/o/r/releases/assets/1":
+			auth, accept = r.Header.Get("Authorization"), r.Header.Get("Accept")
+			w.Write(content)
+		case "/download/v1/example-linux.tar.gz":
+			w.Write(content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	resolve := func(spec string, opts GitHubOptions) []*Request {
+		opts.BaseURL = s.URL
+		reqs, err := DefaultClient.GitHubRequests(context.Background(), dir, spec, &opts)
+		if err != nil {
+			t.Fatalf("%s: error: %v", spec, err)
+		}
+		return reqs
+	}
+	tags := map[string]string{
+		"o/r":               "v1",
+		"o/r@latest":        "v1",
+		"o/r@v2":            "v2",
+		"o/r:*-linux.*":     "v1",
+		"o/r@v3:*-linux.*":  "",
+		"o/r@nope":          "",
+		"o/r:*.zip":         "",
+		"o/r@v2:*-linux.*":  "v2",
+		"o/r@v1:*-darwin.*": "v1",
+	}
+	for spec, tag := range tags {
+		opts := &GitHubOptions{BaseURL: s.URL, Token: "-"}
+		_, err := DefaultClient.GitHubRequests(context.Background(), dir, spec, opts)
+		if (err == nil) != (tag != "") {
+			t.Errorf("%s: expected release %q, got error: %v", spec, tag, err)
+		}
+	}
+	if _, err := GitHubRequests(dir, "o", nil); err == nil {
+		t.Errorf("expected error for malformed spec")
+	}
+
+	t.Run("Filters", func(t *testing.T) {
+		reqs := resolve("o/r", GitHubOptions{Prerelease: true})
+		if reqs[0].URL().String() != s.URL+"/download/v2/example-linux.tar.gz" {
+			t.Errorf("expected prerelease, got: %s", reqs[0].URL())
+		}
+		reqs = resolve("o/r@v3", GitHubOptions{Draft: true})
+		if reqs[0].URL().String() != s.URL+"/download/v3/example-linux.tar.gz" {
+			t.Errorf("expected draft, got: %s", reqs[0].URL())
+		}
+	})
+
+	t.Run("Download", func(t *testing.T) {
+		t.Setenv("GITHUB_TOKEN", "")
+		reqs := resolve("o/r:*-linux.*", GitHubOptions{})
+		if len(reqs) != 1 {
+			t.Fatalf("expected 1 request, got: %d", len(reqs))
+		}
+		req := reqs[0]
+		if req.Filename != filepath.Join(dir, "example-linux.tar.gz") || req.Size != int64(len(content)) || req.Label != "example-linux.tar.gz" {
+			t.Errorf("unexpected request: %s %d %s", req.Filename, req.Size, req.Label)
+		}
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if len(resp.Checksums) != 1 || !resp.Checksums[0].OK() {
+			t.Errorf("expected asset digest to be validated")
+		}
+		testContent(t, resp.Filename, content)
+		os.Remove(resp.Filename)
+	})
+
+	t.Run("Token", func(t *testing.T) {
+		reqs := resolve("o/r:*-linux.*", GitHubOptions{Token: "secret"})
+		resp := DefaultClient.Do(reqs[0])
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if auth != "Bearer secret" || accept != "application/octet-stream" {
+			t.Errorf("expected asset API request, got: %q %q", auth, accept)
+		}
+		testContent(t, resp.Filename, content)
+	})
+}
@@ -1,6 +1,7 @@
 package grab
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 )
@@ -24,6 +25,26 @@ func Get(dst, urlStr string) (*Response, error) {
 	return resp, resp.Err()
 }
 
+// GetBytes sends a HTTP request and returns the content of the requested URL
+// in memory. The caller is blocked until the download is completed,
+// successfully or otherwise.
+//
+// For large files or control over the transfer, create a Request and call
+// Request.SetWriter instead.
+func GetBytes(urlStr string) ([]byte, error) {
+	req, err := NewRequest("", urlStr)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bytes.Buffer{}
+	req.SetWriter(b)
+	if err := DefaultClient.Do(req).Err(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
 // GetBatch sends multiple HTTP requests and downloads the content of the
 // requested URLs to the given destination directory using the given number of
 // concurrent worker goroutines.
@@ -118,18 +118,30 @@ var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http
 		}
 	}
 
-	// compute offset
+	// compute offset and end of range
 	offset := 0
-	if rangeh := r.Header.Get("Range"); rangeh != "" {
-		if _, err := fmt.Sscanf(rangeh, "bytes=%d-", &offset); err != nil {
+	end := size
+	if rangeh := r.Header.Get("Range"); ranged && rangeh != "" {
+		var last int
+		if n, _ := fmt.Sscanf(rangeh, "bytes=%d-%d", &offset, &last); n == 2 {
+			end = last + 1
+		} else if _, err := fmt.Sscanf(rangeh, "bytes=%d-", &offset); err != nil {
 			panic(err)
 		}
 
 		// make sure range is in range
 		if offset >= size {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
 			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
 			return
 		}
+		if end > size {
+			end = size
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, end-1, size))
+		if statusCode == http.StatusOK {
+			statusCode = http.StatusPartialContent
+		}
 	}
 
 	// delay response
@@ -138,7 +150,7 @@ var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http
 	}
 
 	// set response headers
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", size-offset))
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", end-offset))
 	if ranged {
 		w.Header().Set("Accept-Ranges", "bytes")
 	}
@@ -148,8 +160,11 @@ var ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http
 	if r.Method == "GET" {
 		// use buffered io to reduce overhead on the reader
 		bw := bufio.NewWriterSize(w, 4096)
-		for i := offset; i < size; i++ {
-			bw.Write([]byte{byte(i)})
+		for i := offset; i < end; i++ {
+			if err := bw.WriteByte(byte(i)); err != nil {
+				// client went away
+				return
+			}
 			if throttle != nil {
 				<-throttle.C
 			}
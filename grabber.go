@@ -0,0 +1,28 @@
+package grab
+
+import "context"
+
+// A Grabber sends file transfer requests. It is implemented by Client.
+//
+// Code which downloads files may depend on a Grabber rather than a Client so
+// that it can be tested without a remote server, such as with the test double
+// in package grabtest.
+type Grabber interface {
+	// Do sends a file transfer request and returns a file transfer response.
+	// See Client.Do.
+	Do(req *Request) *Response
+
+	// DoBatch executes all the given requests using the given number of
+	// concurrent workers. See Client.DoBatch.
+	DoBatch(workers int, requests ...*Request) <-chan *Response
+
+	// DoChannel executes all requests sent through the given Request channel,
+	// one at a time. See Client.DoChannel.
+	DoChannel(reqch <-chan *Request, respch chan<- *Response)
+
+	// DoChannelContext is like DoChannel but stops once the given Context is
+	// canceled. See Client.DoChannelContext.
+	DoChannelContext(ctx context.Context, reqch <-chan *Request, respch chan<- *Response) error
+}
+
+var _ Grabber = (*Client)(nil)
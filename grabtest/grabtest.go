@@ -0,0 +1,129 @@
+/*
+Package grabtest provides utilities for testing code which uses package grab.
+
+Client is a test double for grab.Client which serves files from memory, so that
+download flows can be tested without a remote server:
+
+	client := grabtest.NewClient()
+	client.HandleFile("http://example.com/example.zip", []byte("..."))
+	client.HandleStatus("http://example.com/missing.zip", http.StatusNotFound)
+
+	// pass client to code which depends on a grab.Grabber
+	err := download(client, "http://example.com/example.zip")
+	// ...
+
+	for _, req := range client.Requests() {
+		// inspect the HTTP requests that were sent
+	}
+
+NewServer starts an HTTP server which serves a generated file, with knobs to
+simulate the behavior of real servers, such as throttling, random failures and
+interrupted transfers:
+
+	ts := grabtest.NewServer(&grabtest.Handler{
+		Size:        1 << 20,
+		ETag:        `"abc"`,
+		Rate:        256 << 10,
+		FailureRate: 0.2,
+	})
+	defer ts.Close()
+*/
+package grabtest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/cavaliercoder/grab"
+)
+
+// Client is a grab.Client which sends all HTTP requests to an in-memory
+// Transport. It implements grab.Grabber.
+type Client struct {
+	*grab.Client
+	*Transport
+}
+
+// NewClient returns a new Client with an empty Transport. Requests for URLs
+// without a handler fail with 404 Not Found.
+func NewClient() *Client {
+	t := NewTransport()
+	c := grab.NewClient()
+	c.HTTPClient = &http.Client{Transport: t}
+	return &Client{Client: c, Transport: t}
+}
+
+// Transport is an http.RoundTripper which serves each request in memory
+// using the http.Handler registered for its URL, and records every request it
+// receives. It is safe for concurrent use by multiple goroutines.
+type Transport struct {
+	mu       sync.Mutex
+	handlers map[string]http.Handler
+	requests []*http.Request
+}
+
+// NewTransport returns a new Transport with no handlers.
+func NewTransport() *Transport {
+	return &Transport{handlers: make(map[string]http.Handler)}
+}
+
+// Handle registers the handler for the given URL. If a request URL has no
+// exact match, the handler of the URL without its query string is used.
+func (c *Transport) Handle(url string, h http.Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.handlers[url] = h
+}
+
+// HandleFile registers a handler which serves the given content for the given
+// URL, with support for HEAD and ranged requests.
+func (c *Transport) HandleFile(url string, content []byte) {
+	c.Handle(url, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+// HandleStatus registers a handler which responds to requests for the given URL
+// with the given status code and no content.
+func (c *Transport) HandleStatus(url string, statusCode int) {
+	c.Handle(url, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statusCode)
+	}))
+}
+
+// Requests returns every HTTP request received by the Transport, in the order
+// they were received.
+func (c *Transport) Requests() []*http.Request {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*http.Request(nil), c.requests...)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (c *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if err := r.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.requests = append(c.requests, r)
+	h, ok := c.handlers[r.URL.String()]
+	if !ok {
+		u := *r.URL
+		u.RawQuery = ""
+		h, ok = c.handlers[u.String()]
+	}
+	c.mu.Unlock()
+	if !ok {
+		h = http.NotFoundHandler()
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	resp := w.Result()
+	resp.Request = r
+	return resp, nil
+}
@@ -0,0 +1,65 @@
+package grabtest
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cavaliercoder/grab"
+)
+
+// download is an example of code that depends on a grab.Grabber.
+func download(g grab.Grabber, dst, url string) (string, error) {
+	req, err := grab.NewRequest(dst, url)
+	if err != nil {
+		return "", err
+	}
+	resp := g.Do(req)
+	return resp.Filename, resp.Err()
+}
+
+func TestClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grabtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := NewClient()
+	client.HandleFile("http://example.com/example.zip", []byte("hello world"))
+	client.HandleStatus("http://example.com/forbidden.zip", http.StatusForbidden)
+
+	filename, err := download(client, dir, "http://example.com/example.zip?token=1")
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if filename != filepath.Join(dir, "example.zip") {
+		t.Errorf("unexpected filename: %s", filename)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello world" {
+		t.Errorf("unexpected content: %q", b)
+	}
+
+	_, err = download(client, dir, "http://example.com/forbidden.zip")
+	var code grab.StatusCodeError
+	if !errors.As(err, &code) || code != http.StatusForbidden {
+		t.Errorf("expected status code error 403, got: %v", err)
+	}
+
+	_, err = download(client, dir, "http://example.com/missing.zip")
+	if !errors.As(err, &code) || code != http.StatusNotFound {
+		t.Errorf("expected status code error 404, got: %v", err)
+	}
+
+	reqs := client.Requests()
+	if len(reqs) == 0 || reqs[0].URL.String() != "http://example.com/example.zip?token=1" {
+		t.Errorf("expected requests to be recorded")
+	}
+}
@@ -0,0 +1,211 @@
+package grabtest
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Handler is an http.Handler which serves a generated file of configurable
+// size, for testing download clients. Byte i of the file is byte(i).
+//
+// The fields of a Handler must not be modified while it is serving requests.
+type Handler struct {
+	// Size is the size of the file in bytes. Default: 1MB.
+	Size int64
+
+	// NoRanges specifies that the handler does not advertise or accept byte
+	// range requests.
+	NoRanges bool
+
+	// IgnoreRanges specifies that the handler advertises support for byte
+	// range requests, but serves the entire file in response to them.
+	IgnoreRanges bool
+
+	// NoHead specifies that HEAD requests fail with 405 Method Not Allowed.
+	NoHead bool
+
+	// ETag and LastModified set the validators of the file. If-Range
+	// requests which do not match a validator are served the entire file.
+	ETag         string
+	LastModified time.Time
+
+	// Filename sets the filename in a Content-Disposition header.
+	Filename string
+
+	// Rate limits the transfer rate of each response body to the given number
+	// of bytes per second. If zero, the rate is not limited.
+	Rate int
+
+	// Latency delays each response before the headers are sent.
+	Latency time.Duration
+
+	// FailureRate is the probability, from 0 to 1, that each GET request fails
+	// with FailureStatus instead of serving the file.
+	FailureRate float64
+
+	// FailureStatus is the status code of failed requests. Default: 503.
+	FailureStatus int
+
+	// FailAfter specifies that the connection of every GET request is closed
+	// after the given number of bytes of the file have been sent, simulating an
+	// interrupted transfer. A transfer resumed from beyond FailAfter succeeds.
+	// If zero, the file is sent in full.
+	FailAfter int64
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	requests int
+}
+
+// NewServer starts and returns a new test server which serves the given
+// Handler. If h is nil, a Handler with default settings is used. The caller
+// should call Close when finished, to shut it down.
+func NewServer(h *Handler) *httptest.Server {
+	if h == nil {
+		h = &Handler{}
+	}
+	return httptest.NewServer(h)
+}
+
+// Requests returns the number of requests the Handler has received.
+func (c *Handler) Requests() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.requests
+}
+
+// fail reports whether the current request should fail, according to
+// FailureRate.
+func (c *Handler) fail() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	if c.FailureRate <= 0 {
+		return false
+	}
+	if c.rand == nil {
+		c.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	return c.rand.Float64() < c.FailureRate
+}
+
+// ServeHTTP implements http.Handler.
+func (c *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	failed := c.fail()
+	if c.NoHead && r.Method == "HEAD" {
+		http.Error(w, "HEAD method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if c.Latency > 0 {
+		time.Sleep(c.Latency)
+	}
+	if failed && r.Method == "GET" {
+		status := c.FailureStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	size := c.Size
+	if size == 0 {
+		size = 1 << 20
+	}
+	if c.ETag != "" {
+		w.Header().Set("ETag", c.ETag)
+	}
+	if !c.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", c.LastModified.UTC().Format(http.TimeFormat))
+	}
+	if c.Filename != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment;filename=%q", c.Filename))
+	}
+	if !c.NoRanges {
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+
+	// compute offset and end of range
+	status := http.StatusOK
+	offset, end := int64(0), size
+	if rangeh := r.Header.Get("Range"); !c.NoRanges && !c.IgnoreRanges && rangeh != "" && c.ifRange(r) {
+		var last int64
+		if n, _ := fmt.Sscanf(rangeh, "bytes=%d-%d", &offset, &last); n == 2 {
+			end = last + 1
+		} else if _, err := fmt.Sscanf(rangeh, "bytes=%d-", &offset); err != nil {
+			http.Error(w, "invalid range", http.StatusBadRequest)
+			return
+		}
+		if offset >= size {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if end > size {
+			end = size
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, end-1, size))
+		status = http.StatusPartialContent
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(end-offset, 10))
+	w.WriteHeader(status)
+	if r.Method != "GET" {
+		return
+	}
+	if c.FailAfter > 0 && offset < c.FailAfter && c.FailAfter < end {
+		end = c.FailAfter
+	}
+	c.serveContent(w, offset, end)
+}
+
+// ifRange reports whether a ranged request may be served a partial response,
+// according to its If-Range header.
+func (c *Handler) ifRange(r *http.Request) bool {
+	v := r.Header.Get("If-Range")
+	if v == "" {
+		return true
+	}
+	if c.ETag != "" && v == c.ETag {
+		return true
+	}
+	if t, err := http.ParseTime(v); err == nil && !c.LastModified.IsZero() {
+		return c.LastModified.Unix() == t.Unix()
+	}
+	return false
+}
+
+// serveContent writes bytes offset to end of the file, according to Rate.
+func (c *Handler) serveContent(w http.ResponseWriter, offset, end int64) {
+	chunk := int64(32 * 1024)
+	if c.Rate > 0 {
+		// write ten chunks per second
+		chunk = int64(c.Rate/10) + 1
+	}
+	buf := make([]byte, chunk)
+	for offset < end {
+		n := end - offset
+		if n > chunk {
+			n = chunk
+		}
+		if c.Rate > 0 {
+			time.Sleep(time.Duration(n) * time.Second / time.Duration(c.Rate))
+		}
+		for i := int64(0); i < n; i++ {
+			buf[i] = byte(offset + i)
+		}
+		if _, err := w.Write(buf[:n]); err != nil {
+			// client went away
+			return
+		}
+		if f, ok := w.(http.Flusher); ok && c.Rate > 0 {
+			f.Flush()
+		}
+		offset += n
+	}
+}
@@ -0,0 +1,112 @@
+package grabtest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func get(t *testing.T, url string, header http.Header) (*http.Response, []byte, error) {
+	req, _ := http.NewRequest("GET", url, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	return resp, b, err
+}
+
+func TestServer(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		ts := NewServer(nil)
+		defer ts.Close()
+		resp, b, err := get(t, ts.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) != 1<<20 || b[1000] != byte(1000%256) {
+			t.Errorf("unexpected content of length %d", len(b))
+		}
+		if resp.Header.Get("Accept-Ranges") != "bytes" {
+			t.Errorf("expected Accept-Ranges header")
+		}
+	})
+
+	t.Run("Range", func(t *testing.T) {
+		h := &Handler{Size: 100, ETag: `"abc"`}
+		ts := NewServer(h)
+		defer ts.Close()
+		resp, b, _ := get(t, ts.URL, http.Header{"Range": {"bytes=10-"}, "If-Range": {`"abc"`}})
+		if resp.StatusCode != http.StatusPartialContent || len(b) != 90 || b[0] != 10 {
+			t.Errorf("unexpected ranged response: %d %d", resp.StatusCode, len(b))
+		}
+		resp, b, _ = get(t, ts.URL, http.Header{"Range": {"bytes=10-"}, "If-Range": {`"xyz"`}})
+		if resp.StatusCode != http.StatusOK || len(b) != 100 {
+			t.Errorf("expected full response for mismatched If-Range, got: %d %d", resp.StatusCode, len(b))
+		}
+		resp, _, _ = get(t, ts.URL, http.Header{"Range": {"bytes=100-"}})
+		if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || resp.Header.Get("Content-Range") != "bytes */100" {
+			t.Errorf("expected 416, got: %d", resp.StatusCode)
+		}
+		if n := h.Requests(); n != 3 {
+			t.Errorf("expected 3 requests, got: %d", n)
+		}
+	})
+
+	t.Run("NoRanges", func(t *testing.T) {
+		ts := NewServer(&Handler{Size: 100, NoRanges: true})
+		defer ts.Close()
+		resp, b, _ := get(t, ts.URL, http.Header{"Range": {"bytes=10-"}})
+		if resp.StatusCode != http.StatusOK || len(b) != 100 || resp.Header.Get("Accept-Ranges") != "" {
+			t.Errorf("expected full response, got: %d %d", resp.StatusCode, len(b))
+		}
+	})
+
+	t.Run("IgnoreRanges", func(t *testing.T) {
+		ts := NewServer(&Handler{Size: 100, IgnoreRanges: true})
+		defer ts.Close()
+		resp, b, _ := get(t, ts.URL, http.Header{"Range": {"bytes=10-"}})
+		if resp.StatusCode != http.StatusOK || len(b) != 100 || resp.Header.Get("Accept-Ranges") != "bytes" {
+			t.Errorf("expected full response, got: %d %d", resp.StatusCode, len(b))
+		}
+	})
+
+	t.Run("Failures", func(t *testing.T) {
+		ts := NewServer(&Handler{Size: 100, FailureRate: 1, FailureStatus: http.StatusTooManyRequests})
+		defer ts.Close()
+		resp, _, _ := get(t, ts.URL, nil)
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Errorf("expected 429, got: %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("FailAfter", func(t *testing.T) {
+		ts := NewServer(&Handler{Size: 100, FailAfter: 50})
+		defer ts.Close()
+		_, b, err := get(t, ts.URL, nil)
+		if err == nil || len(b) != 50 {
+			t.Errorf("expected interrupted transfer after 50 bytes, got: %d, %v", len(b), err)
+		}
+		resp, b, err := get(t, ts.URL, http.Header{"Range": {"bytes=50-"}})
+		if err != nil || resp.StatusCode != http.StatusPartialContent || len(b) != 50 {
+			t.Errorf("expected resumed transfer to succeed, got: %d, %v", len(b), err)
+		}
+	})
+
+	t.Run("Rate", func(t *testing.T) {
+		ts := NewServer(&Handler{Size: 1000, Rate: 5000})
+		defer ts.Close()
+		start := time.Now()
+		if _, b, err := get(t, ts.URL, nil); err != nil || len(b) != 1000 {
+			t.Fatalf("unexpected response: %d, %v", len(b), err)
+		}
+		if d := time.Since(start); d < 150*time.Millisecond {
+			t.Errorf("expected throttled transfer, took: %v", d)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package grab_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/cavaliercoder/grab"
+	"github.com/cavaliercoder/grab/grabtest"
+)
+
+// TestGrabtestServer tests that interrupted transfers from a grabtest server
+// are retried and resumed.
+func TestGrabtestServer(t *testing.T) {
+	h := &grabtest.Handler{Size: 4096, ETag: `"abc"`, FailAfter: 1024}
+	ts := grabtest.NewServer(h)
+	defer ts.Close()
+
+	filename := ".testGrabtestServer"
+	defer os.Remove(filename)
+	req, err := grab.NewRequestWithOptions(filename, ts.URL, grab.WithRetries(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.RetryPolicy.Backoff = func(int) time.Duration { return 0 }
+
+	resp := grab.NewClient().Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if resp.Attempts() != 2 || !resp.DidResume || resp.BytesComplete() != 4096 {
+		t.Errorf("expected resumed transfer on second attempt, got: %d attempts, resumed: %v, %d bytes",
+			resp.Attempts(), resp.DidResume, resp.BytesComplete())
+	}
+}
+
+// TestGrabtestIgnoreRanges tests that a segmented transfer from a grabtest
+// server which ignores byte ranges falls back to a single stream.
+func TestGrabtestIgnoreRanges(t *testing.T) {
+	h := &grabtest.Handler{Size: 4096, IgnoreRanges: true}
+	ts := grabtest.NewServer(h)
+	defer ts.Close()
+
+	filename := ".testGrabtestIgnoreRanges"
+	defer os.Remove(filename)
+	req, err := grab.NewRequest(filename, ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Segments = 4
+
+	resp := grab.NewClient().Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 4096 {
+		t.Fatalf("expected 4096 bytes, got: %d", len(b))
+	}
+	for i, c := range b {
+		if c != byte(i) {
+			t.Fatalf("unexpected byte at offset %d: %d", i, c)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package grab
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// A HeaderRule sets the headers of the HTTP requests sent by a Client to
+// matching hosts, since many CDNs refuse downloads without the Referer or
+// User-Agent of a browser.
+type HeaderRule struct {
+	// Host is the path.Match pattern of the hostname of matching requests,
+	// such as "cdn.example.com" or "*.example.com", which does not match
+	// example.com itself. Hostnames are compared in lower case, without any
+	// port.
+	Host string
+
+	// Header are the headers to set on matching requests, unless already
+	// set by the request.
+	Header http.Header
+
+	// AutoReferer specifies that the Referer header of matching requests is
+	// set to the Request.PageURL of the file, or, if it is empty, to the
+	// origin of the requested URL, such as https://cdn.example.com/. As with
+	// browsers, a HTTPS page is not sent as the Referer of a plain HTTP
+	// request, which is sent the origin instead.
+	AutoReferer bool
+}
+
+// match returns true if the rule applies to the given hostname.
+func (c *HeaderRule) match(host string) bool {
+	ok, _ := path.Match(strings.ToLower(c.Host), strings.ToLower(host))
+	return ok
+}
+
+// setHeaders returns the given HTTP request, or a copy of it, with the headers
+// of any matching HeaderRule and the DefaultHeaders of the Client which are
+// not already set. The given page URL is sent as the Referer of rules with
+// AutoReferer.
+func (c *Client) setHeaders(req *http.Request, page string) *http.Request {
+	if len(c.DefaultHeaders) == 0 && len(c.HeaderRules) == 0 {
+		return req
+	}
+	header := make(http.Header)
+	for i := range c.HeaderRules {
+		rule := &c.HeaderRules[i]
+		if !rule.match(req.URL.Hostname()) {
+			continue
+		}
+		addHeaders(header, rule.Header)
+		if rule.AutoReferer && header.Get("Referer") == "" {
+			header.Set("Referer", autoReferer(req.URL, page))
+		}
+	}
+	addHeaders(header, c.DefaultHeaders)
+
+	cloned := false
+	for k, v := range header {
+		if _, ok := req.Header[k]; ok {
+			continue
+		}
+		if !cloned {
+			req = req.Clone(req.Context())
+			cloned = true
+		}
+		req.Header[k] = v
+	}
+	return req
+}
+
+// addHeaders adds the values of each header in src which is not already set
+// in dst.
+func addHeaders(dst, src http.Header) {
+	for k, v := range src {
+		k = http.CanonicalHeaderKey(k)
+		if _, ok := dst[k]; !ok && len(v) > 0 {
+			dst[k] = append([]string(nil), v...)
+		}
+	}
+}
+
+// autoReferer returns the Referer of a request for the given URL, linked from
+// the given page, or the origin of the URL if page is empty or may not be
+// disclosed.
+func autoReferer(u *url.URL, page string) string {
+	p, err := url.Parse(page)
+	if page == "" || err != nil || p.Scheme == "https" && u.Scheme != "https" {
+		return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/"}).String()
+	}
+	p.User, p.Fragment, p.RawFragment = nil, "", ""
+	return p.String()
+}
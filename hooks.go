@@ -0,0 +1,159 @@
+package grab
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// A RequestHook is a user provided callback function that is called before
+// each HTTP request is sent to a remote server to transfer the file of the
+// given Response, including HEAD requests, the requests of each segment of a
+// segmented transfer and requests to mirrors. The hook may modify the HTTP
+// request, such as to sign it with authentication headers. The hook may be
+// called concurrently by the segments of a segmented transfer.
+//
+// If a RequestHook returns an error, the request is not sent, the transfer is
+// canceled and the same error is returned on the Response object. The caveats
+// of Hook also apply to RequestHook.
+type RequestHook func(*Response, *http.Request) error
+
+// A ResponseHook is a user provided callback function that is called with
+// each HTTP response received from a remote server to transfer the file of the
+// given Response, before its status code is checked.
+//
+// If a ResponseHook returns an error, the transfer is canceled and the same
+// error is returned on the Response object. The caveats of Hook also apply to
+// ResponseHook.
+type ResponseHook func(*Response, *http.Response) error
+
+// An InspectHook is a user provided callback function that is called once per
+// transfer, with the first response of the remote server which describes the
+// file, once its status code, headers and Response.Size are known, but before
+// anything is written. It returns the destination path of the file, which is
+// given as filename, or another path to write the file elsewhere. The filename
+// is empty if the destination of the Request is an io.Writer, in which case
+// the returned path is ignored.
+//
+// If an InspectHook returns an error, the transfer is canceled and the same
+// error is returned on the Response object, so that custom acceptance policies
+// can be enforced without another request. The caveats of Hook also apply to
+// InspectHook.
+type InspectHook func(resp *Response, filename string) (string, error)
+
+// hookError wraps the error returned by a RequestHook or ResponseHook so that
+// it can be distinguished from the errors of HTTP requests, which may be
+// retried.
+type hookError struct {
+	err error
+}
+
+func (c hookError) Error() string { return c.err.Error() }
+func (c hookError) Unwrap() error { return c.err }
+
+// unwrapHookError returns the error returned by a hook, if err was caused by a
+// RequestHook or ResponseHook.
+func unwrapHookError(err error) (error, bool) {
+	var h hookError
+	if errors.As(err, &h) {
+		return h.err, true
+	}
+	return err, false
+}
+
+// beforeRequest returns the RequestHook for the given Request.
+func (c *Client) beforeRequest(req *Request) RequestHook {
+	if req.BeforeRequest != nil {
+		return req.BeforeRequest
+	}
+	return c.BeforeRequest
+}
+
+// afterResponse returns the ResponseHook for the given Request.
+func (c *Client) afterResponse(req *Request) ResponseHook {
+	if req.AfterResponse != nil {
+		return req.AfterResponse
+	}
+	return c.AfterResponse
+}
+
+// beforeValidate returns the BeforeValidate Hook for the given Request.
+func (c *Client) beforeValidate(req *Request) Hook {
+	if req.BeforeValidate != nil {
+		return req.BeforeValidate
+	}
+	return c.BeforeValidate
+}
+
+// afterComplete returns the AfterComplete callback for the given Request.
+func (c *Client) afterComplete(req *Request) func(*Response) {
+	if req.AfterComplete != nil {
+		return req.AfterComplete
+	}
+	return c.AfterComplete
+}
+
+// doTransferRequest sends a HTTP request to transfer the file of the given
+// Response, with the headers of the Client and any Referer of the Request,
+// authenticated by any CredentialProvider, calling any RequestHook and
+// ResponseHook. Errors returned by credential providers and hooks are
+// wrapped in hookError. Each request is traced by any Tracer. Requests to
+// hosts whose circuit is open fail with ErrHostCircuitOpen.
+func (c *Client) doTransferRequest(resp *Response, req *http.Request) (hresp *http.Response, err error) {
+	if err := c.checkCircuit(resp, req.URL); err != nil {
+		return nil, err
+	}
+	req, endSpan := c.startHTTPSpan(resp, withRequest(req, resp.Request))
+	defer func() { endSpan(hresp, err) }()
+	req = c.setHeaders(req, resp.Request.PageURL)
+	if req, err = c.authenticate(resp, req); err != nil {
+		return nil, hookError{err}
+	}
+	if f := c.beforeRequest(resp.Request); f != nil {
+		if err := f(resp, req); err != nil {
+			return nil, hookError{err}
+		}
+	}
+	c.logf(resp, slog.LevelDebug, "sending request",
+		"method", req.Method,
+		"request_url", req.URL.String(),
+		"attempt", resp.Attempts())
+	hresp, err = c.doHTTPRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	c.logRedirect(resp, req, hresp)
+	if f := c.afterResponse(resp.Request); f != nil {
+		if err := f(resp, hresp); err != nil {
+			hresp.Body.Close()
+			return nil, hookError{err}
+		}
+	}
+	return hresp, nil
+}
+
+// inspect calls the InspectHook of the given Request, if it has not been
+// called yet. If the hook returns a new destination path, Response.Filename is
+// replaced. Any existing file at the new path is replaced by the transfer.
+func (c *Client) inspect(resp *Response) error {
+	f := resp.Request.Inspect
+	if f == nil || resp.inspected {
+		return nil
+	}
+	resp.inspected = true
+	filename, err := f(resp, resp.Filename)
+	if err != nil {
+		return err
+	}
+	if resp.Request.writer != nil || filename == resp.Filename {
+		return nil
+	}
+	if filename == "" {
+		return ErrNoFilename
+	}
+	c.logf(resp, slog.LevelDebug, "destination changed by inspect hook", "filename", filename)
+	resp.Filename = longPath(filename)
+	resp.fi, _ = os.Stat(resp.path())
+	return nil
+}
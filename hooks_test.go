@@ -0,0 +1,174 @@
+package grab
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+)
+
+// TestHooks tests that the hooks of a Client and Request are called at each
+// stage of a transfer.
+func TestHooks(t *testing.T) {
+	authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		ts.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer authServer.Close()
+
+	t.Run("Client", func(t *testing.T) {
+		filename := ".testHooksClient"
+		defer os.Remove(filename)
+
+		mu := sync.Mutex{}
+		calls := make([]string, 0)
+		record := func(s string) {
+			mu.Lock()
+			calls = append(calls, s)
+			mu.Unlock()
+		}
+		client := NewClient()
+		client.BeforeRequest = func(resp *Response, req *http.Request) error {
+			req.Header.Set("Authorization", "Bearer token")
+			record("BeforeRequest " + req.Method)
+			return nil
+		}
+		client.AfterResponse = func(resp *Response, hresp *http.Response) error {
+			record(fmt.Sprintf("AfterResponse %d", hresp.StatusCode))
+			return nil
+		}
+		client.BeforeValidate = func(resp *Response) error {
+			record("BeforeValidate")
+			return nil
+		}
+		done := make(chan struct{})
+		client.AfterComplete = func(resp *Response) {
+			if !resp.IsComplete() {
+				t.Errorf("expected Response to be complete")
+			}
+			record("AfterComplete")
+			close(done)
+		}
+
+		req, _ := NewRequest(filename, authServer.URL+"?size=1024")
+		resp := client.Do(req)
+		testComplete(t, resp)
+		<-done
+
+		expect := "[BeforeRequest GET AfterResponse 200 BeforeValidate AfterComplete]"
+		if fmt.Sprint(calls) != expect {
+			t.Errorf("expected hooks %s, got: %v", expect, calls)
+		}
+	})
+
+	t.Run("RequestOverride", func(t *testing.T) {
+		filename := ".testHooksRequestOverride"
+		defer os.Remove(filename)
+
+		client := NewClient()
+		client.BeforeRequest = func(resp *Response, req *http.Request) error {
+			t.Errorf("expected client hook to be overridden")
+			return nil
+		}
+		req, _ := NewRequest(filename, authServer.URL+"?size=1024")
+		req.BeforeRequest = func(resp *Response, req *http.Request) error {
+			req.Header.Set("Authorization", "Bearer token")
+			return nil
+		}
+		testComplete(t, client.Do(req))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		filename := ".testHooksErrors"
+		defer os.Remove(filename)
+
+		hookErr := errors.New("hook error")
+		client := NewClient()
+		client.RetryPolicy = &RetryPolicy{MaxAttempts: 3}
+		var count int
+		client.AfterResponse = func(resp *Response, hresp *http.Response) error {
+			count++
+			return hookErr
+		}
+		req, _ := NewRequest(filename, ts.URL+"?size=1024")
+		req.Mirrors = []*url.URL{req.URL()}
+		if err := client.Do(req).Err(); err != hookErr {
+			t.Errorf("expected hook error, got: %v", err)
+		}
+		if count != 1 {
+			t.Errorf("expected transfer not to be retried, got %d responses", count)
+		}
+
+		client = NewClient()
+		client.BeforeValidate = func(resp *Response) error {
+			return hookErr
+		}
+		req, _ = NewRequest(filename, ts.URL+"?size=1024")
+		if err := client.Do(req).Err(); err != hookErr {
+			t.Errorf("expected hook error, got: %v", err)
+		}
+	})
+}
+
+// TestInspectHook tests that Request.Inspect is called once before the file
+// is written, and may change the destination path or cancel the transfer.
+func TestInspectHook(t *testing.T) {
+	dir := ".testInspectHook"
+	defer os.RemoveAll(dir)
+
+	for _, head := range []bool{false, true} {
+		for _, filename := range []string{dir + "/", dir + "/example.bin"} {
+			os.RemoveAll(dir)
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			u := ts.URL + "/example.bin?size=1024&filename=example.bin"
+			if !head {
+				u += "&nohead"
+			}
+			req, _ := NewRequest(filename, u)
+			req.NoResume = !head
+			calls := 0
+			req.Inspect = func(resp *Response, filename string) (string, error) {
+				calls++
+				if resp.Size != 1024 || resp.HTTPResponse.StatusCode != http.StatusOK {
+					t.Errorf("unexpected response: %d %d", resp.HTTPResponse.StatusCode, resp.Size)
+				}
+				return filename + ".renamed", nil
+			}
+			resp := DefaultClient.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatal(err)
+			}
+			if calls != 1 {
+				t.Errorf("expected one call, got: %d", calls)
+			}
+			expect := dir + "/example.bin.renamed"
+			if resp.Filename != expect {
+				t.Errorf("expected %s, got: %s", expect, resp.Filename)
+			}
+			testSize(t, expect, 1024)
+		}
+	}
+
+	// veto
+	os.RemoveAll(dir)
+	veto := errors.New("vetoed")
+	req, _ := NewRequest(dir+"/example.bin", ts.URL+"/example.bin?size=1024")
+	req.Inspect = func(resp *Response, filename string) (string, error) {
+		return "", veto
+	}
+	if err := DefaultClient.Do(req).Err(); err != veto {
+		t.Errorf("expected %v, got: %v", veto, err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be written")
+	}
+}
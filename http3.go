@@ -0,0 +1,170 @@
+package grab
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTP3Config configures an opt-in HTTP/3 transport of a Client, which
+// transfers files over QUIC from servers that support it, since QUIC improves
+// throughput on lossy links. Requests fall back to the HTTP/2 or HTTP/1.1
+// transport of the Client if a HTTP/3 request fails.
+//
+// The Go standard library does not implement QUIC, so Transport must be
+// provided by a QUIC implementation, such as the *http3.Transport of
+// github.com/quic-go/quic-go/http3.
+//
+// HTTP/3 is not used for requests which are subject to a TLSPolicy, a proxy,
+// a Resolver, or an IPMode or FallbackDelay of the Client, since these are
+// enforced by the dialer of the TCP transport.
+type HTTP3Config struct {
+	// Transport sends HTTP/3 requests. If nil, HTTP/3 is not used.
+	Transport http.RoundTripper
+
+	// Always specifies that HTTP/3 is attempted for every HTTPS request.
+	// Otherwise, as with browsers, HTTP/3 is only used for hosts which have
+	// advertised it in the Alt-Svc header of a previous response on the same
+	// port.
+	Always bool
+
+	// BrokenDuration specifies how long requests to a host are sent over
+	// TCP after a HTTP/3 request to the host fails. Default: 5 minutes.
+	BrokenDuration time.Duration
+}
+
+// http3Hosts records the hosts which advertise HTTP/3 and those for which
+// HTTP/3 is broken, keyed by host and port.
+type http3Hosts struct {
+	mu     sync.Mutex
+	alt    map[string]time.Time
+	broken map[string]time.Time
+}
+
+// use returns true if a HTTP/3 request should be attempted to the given
+// address.
+func (c *http3Hosts) use(addr string, always bool) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if t, ok := c.broken[addr]; ok {
+		if now.Before(t) {
+			return false
+		}
+		delete(c.broken, addr)
+	}
+	if always {
+		return true
+	}
+	t, ok := c.alt[addr]
+	if ok && !now.Before(t) {
+		delete(c.alt, addr)
+		return false
+	}
+	return ok
+}
+
+// markBroken records that HTTP/3 requests to the given address fail.
+func (c *http3Hosts) markBroken(addr string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.broken == nil {
+		c.broken = make(map[string]time.Time)
+	}
+	c.broken[addr] = time.Now().Add(d)
+}
+
+// observe records whether the given Alt-Svc header of a response from the
+// given address advertises HTTP/3.
+func (c *http3Hosts) observe(addr string, altSvc string) {
+	if altSvc == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if strings.TrimSpace(altSvc) == "clear" {
+		delete(c.alt, addr)
+		return
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	if maxAge, ok := parseAltSvcH3(altSvc, port); ok {
+		if c.alt == nil {
+			c.alt = make(map[string]time.Time)
+		}
+		c.alt[addr] = time.Now().Add(maxAge)
+	}
+}
+
+// parseAltSvcH3 returns the max age of any alternative service of the given
+// Alt-Svc header, as specified by RFC 7838, which offers HTTP/3 on the same
+// host and the given port.
+func parseAltSvcH3(altSvc, port string) (time.Duration, bool) {
+	for _, alt := range strings.Split(altSvc, ",") {
+		params := strings.Split(alt, ";")
+		proto, authority, ok := strings.Cut(strings.TrimSpace(params[0]), "=")
+		if !ok || proto != "h3" {
+			continue
+		}
+		host, altPort, err := net.SplitHostPort(strings.Trim(authority, `"`))
+		if err != nil || host != "" || altPort != port {
+			continue
+		}
+		maxAge := 24 * time.Hour
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if k == "ma" {
+				if n, err := strconv.ParseInt(strings.Trim(v, `"`), 10, 64); err == nil {
+					maxAge = time.Duration(n) * time.Second
+				}
+			}
+		}
+		return maxAge, maxAge > 0
+	}
+	return 0, false
+}
+
+// http3Fallback is a http.RoundTripper which sends HTTPS requests with the
+// HTTP/3 transport of a Client, if the host supports it, and other requests,
+// or requests which fail, with the given TCP transport.
+type http3Fallback struct {
+	c   *Client
+	tcp http.RoundTripper
+}
+
+func (t *http3Fallback) RoundTrip(req *http.Request) (*http.Response, error) {
+	config := t.c.HTTP3
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		addr = net.JoinHostPort(req.URL.Hostname(), "443")
+	}
+	if req.URL.Scheme == "https" && t.c.http3.use(addr, config.Always) {
+		resp, err := config.Transport.RoundTrip(req)
+		if err == nil || req.Context().Err() != nil {
+			return resp, err
+		}
+		d := config.BrokenDuration
+		if d <= 0 {
+			d = 5 * time.Minute
+		}
+		t.c.http3.markBroken(addr, d)
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, err
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+	resp, err := t.tcp.RoundTrip(req)
+	if err == nil && req.URL.Scheme == "https" {
+		t.c.http3.observe(addr, resp.Header.Get("Alt-Svc"))
+	}
+	return resp, err
+}
@@ -0,0 +1,127 @@
+package grab
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testHTTP3Transport is a fake HTTP/3 transport, which sends requests with
+// the given transport, or fails if broken is set.
+type testHTTP3Transport struct {
+	transport http.RoundTripper
+	broken    bool
+	requests  int
+}
+
+func (c *testHTTP3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.requests++
+	if c.broken {
+		return nil, errors.New("timeout: no recent network activity")
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Proto", "h3")
+	return c.transport.RoundTrip(req)
+}
+
+// TestHTTP3 tests that HTTP/3 is used for hosts which advertise it with
+// Alt-Svc, and that failed HTTP/3 requests fall back to TCP.
+func TestHTTP3(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", r.URL.Query().Get("alt"))
+		proto := r.Header.Get("X-Proto")
+		if proto == "" {
+			proto = "tcp"
+		}
+		w.Write([]byte(proto))
+	}))
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+	port := u.Port()
+
+	tests := []struct {
+		alt    string
+		always bool
+		broken bool
+		expect []string
+	}{
+		{"", false, false, []string{"tcp", "tcp"}},
+		{`h3=":` + port + `"; ma=3600, h2=":` + port + `"`, false, false, []string{"tcp", "h3", "h3"}},
+		{`h3=":1"`, false, false, []string{"tcp", "tcp"}},
+		{`h3="other.example.com:` + port + `"`, false, false, []string{"tcp", "tcp"}},
+		{`h3=":` + port + `"; ma=0`, false, false, []string{"tcp", "tcp"}},
+		{"", true, false, []string{"h3", "h3"}},
+		{"", true, true, []string{"tcp", "tcp"}},
+	}
+	for i, test := range tests {
+		h3 := &testHTTP3Transport{transport: s.Client().Transport, broken: test.broken}
+		client := NewClient()
+		client.HTTPClient = s.Client()
+		client.HTTP3 = &HTTP3Config{Transport: h3, Always: test.always}
+		var actual []string
+		for j := range test.expect {
+			req, _ := NewRequest(filepath.Join(dir, "file"), s.URL+"/file?alt="+url.QueryEscape(test.alt))
+			req.NoResume = true
+			resp := client.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatalf("%d/%d: %v", i, j, err)
+			}
+			b, _ := ioutil.ReadFile(resp.Filename)
+			actual = append(actual, string(b))
+		}
+		if strings.Join(actual, ",") != strings.Join(test.expect, ",") {
+			t.Errorf("%d: expected protocols %v, got: %v", i, test.expect, actual)
+		}
+		if test.broken && h3.requests != 1 {
+			t.Errorf("%d: expected broken HTTP/3 to be attempted once, got: %d", i, h3.requests)
+		}
+	}
+
+	// requests with a TLSPolicy are not sent over HTTP/3
+	h3 := &testHTTP3Transport{transport: s.Client().Transport}
+	client := NewClient()
+	client.HTTPClient = s.Client()
+	client.HTTP3 = &HTTP3Config{Transport: h3, Always: true}
+	req, _ := NewRequest(filepath.Join(dir, "file"), s.URL+"/file")
+	req.NoResume = true
+	req.TLS = &TLSPolicy{MinVersion: 0x0303}
+	if err := client.Do(req).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if h3.requests != 0 {
+		t.Errorf("expected HTTP/3 not to be used with a TLSPolicy")
+	}
+}
+
+func TestParseAltSvcH3(t *testing.T) {
+	tests := []struct {
+		altSvc string
+		maxAge time.Duration
+		ok     bool
+	}{
+		{`h3=":443"`, 24 * time.Hour, true},
+		{`h3-29=":443", h3=":443"; ma=60; persist=1`, time.Minute, true},
+		{`h3=":8443"`, 0, false},
+		{`h2=":443"`, 0, false},
+		{`h3="alt.example.com:443"`, 0, false},
+		{`h3`, 0, false},
+	}
+	for _, test := range tests {
+		maxAge, ok := parseAltSvcH3(test.altSvc, "443")
+		if maxAge != test.maxAge || ok != test.ok {
+			t.Errorf("%s: expected %v %v, got: %v %v", test.altSvc, test.maxAge, test.ok, maxAge, ok)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package grab
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// IndexOptions configures the recursive download of HTML directory indexes
+// with IndexRequests.
+type IndexOptions struct {
+	// MaxDepth is the maximum depth of the directories which are listed,
+	// where the given directory has a depth of one, as with wget -l. If zero,
+	// subdirectories are listed without limit.
+	MaxDepth int
+
+	// Include, if not empty, lists the path.Match patterns of the names of
+	// the files to download, such as "*.rpm". Other files are skipped.
+	Include []string
+
+	// Exclude lists the path.Match patterns of the names of files to skip.
+	Exclude []string
+
+	// Match, if not nil, is matched with the path of each file relative to
+	// the given directory, with forward slashes. Files which do not match are
+	// skipped.
+	Match *regexp.Regexp
+}
+
+// match returns true if the file at the given relative path is to be
+// downloaded.
+func (c *IndexOptions) match(rel string) bool {
+	name := path.Base(rel)
+	if len(c.Include) > 0 {
+		ok := false
+		for _, pattern := range c.Include {
+			if m, _ := path.Match(pattern, name); m {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	for _, pattern := range c.Exclude {
+		if m, _ := path.Match(pattern, name); m {
+			return false
+		}
+	}
+	return c.Match == nil || c.Match.MatchString(rel)
+}
+
+// indexHref matches the href attributes of the anchors of an HTML document.
+var indexHref = regexp.MustCompile(`(?is)<a\s[^>]*?\bhref\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+
+// parseIndexLinks returns the URLs linked by the anchors of the given HTML
+// document, resolved against the given base URL. Malformed links are
+// ignored.
+func parseIndexLinks(b []byte, base *url.URL) []*url.URL {
+	var links []*url.URL
+	for _, m := range indexHref.FindAllSubmatch(b, -1) {
+		href := string(m[1]) + string(m[2]) + string(m[3])
+		u, err := base.Parse(html.UnescapeString(strings.TrimSpace(href)))
+		if err != nil {
+			continue
+		}
+		links = append(links, u)
+	}
+	return links
+}
+
+// IndexRequests lists the HTML directory index at the given URL, recursively,
+// and returns a Request for each file, to be downloaded into the same
+// relative path of the given destination directory, using the DefaultClient.
+// See Client.IndexRequests.
+func IndexRequests(dst, urlStr string, opts *IndexOptions) ([]*Request, error) {
+	return DefaultClient.IndexRequests(context.Background(), dst, urlStr, opts)
+}
+
+// IndexRequests lists the HTML directory index at the given URL, such as the
+// autoindex pages of nginx and Apache, and returns a Request for each file
+// which matches the given options, to be downloaded into the same relative
+// path of the given destination directory, as with wget -r -np. Links ending
+// with a slash are listed as subdirectories, up to IndexOptions.MaxDepth.
+//
+// Only links to the given directory and its subdirectories are followed, so
+// parent directories, other hosts and the sorting links of Apache indexes are
+// ignored. Each directory is listed once. Transfer the returned Requests with
+// DoBatch to mirror the directory tree.
+func (c *Client) IndexRequests(ctx context.Context, dst, urlStr string, opts *IndexOptions) ([]*Request, error) {
+	if opts == nil {
+		opts = &IndexOptions{}
+	}
+	root, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(root.Path, "/") {
+		root.Path += "/"
+	}
+	root.RawPath, root.RawQuery, root.Fragment = "", "", ""
+
+	type dir struct {
+		u     *url.URL
+		depth int
+	}
+	var reqs []*Request
+	seen := map[string]bool{root.String(): true}
+	queue := []dir{{root, 1}}
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+		links, err := c.listIndex(ctx, d.u)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range links {
+			if u.Scheme != root.Scheme || u.Host != root.Host || u.RawQuery != "" ||
+				!strings.HasPrefix(u.Path, root.Path) || u.Path == root.Path {
+				continue
+			}
+			u.Fragment, u.RawFragment = "", ""
+			if seen[u.String()] {
+				continue
+			}
+			seen[u.String()] = true
+			rel := strings.TrimPrefix(u.Path, root.Path)
+			if strings.HasSuffix(u.Path, "/") {
+				if opts.MaxDepth == 0 || d.depth < opts.MaxDepth {
+					queue = append(queue, dir{u, d.depth + 1})
+				}
+				continue
+			}
+			if !opts.match(rel) {
+				continue
+			}
+			filename, err := extractPath(dst, rel)
+			if err != nil {
+				return nil, fmt.Errorf("unsafe path in directory index: %s", rel)
+			}
+			req, err := NewRequest(filename, u.String())
+			if err != nil {
+				return nil, err
+			}
+			req.Label = rel
+			req.PageURL = d.u.String()
+			reqs = append(reqs, req.WithContext(ctx))
+		}
+	}
+	return reqs, nil
+}
+
+// listIndex returns the links of the HTML directory index at the given URL.
+func (c *Client) listIndex(ctx context.Context, u *url.URL) ([]*url.URL, error) {
+	hreq, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	hresp, err := c.doHTTPRequest(hreq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+		return nil, fmt.Errorf("error listing %s: %w", u, StatusCodeError(hresp.StatusCode))
+	}
+	if ct := hresp.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "text/html") {
+		return nil, fmt.Errorf("not a HTML directory index: %s", u)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(hresp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	return parseIndexLinks(b, hresp.Request.URL), nil
+}
@@ -0,0 +1,100 @@
+package grab
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestIndexRequests tests that files linked from HTML directory indexes are
+// requested recursively and mirrored into the destination directory.
+func TestIndexRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pages := map[string]string{
+		// nginx autoindex
+		"/pub/": `<html><head><title>Index of /pub/</title></head><body>
+<h1>Index of /pub/</h1><hr><pre><a href="../">../</a>
+<a href="sub/">sub/</a>                                               01-Jan-2024 00:00       -
+<a href="a.txt">a.txt</a>                                              01-Jan-2024 00:00       5
+<a href="b%20c.rpm">b c.rpm</a>                                            01-Jan-2024 00:00       5
+<a href="https://example.com/other.txt">other.txt</a>
+</pre><hr></body></html>`,
+		// Apache autoindex
+		"/pub/sub/": `<html><body><h1>Index of /pub/sub</h1>
+<table><tr><th><a href="?C=N;O=D">Name</a></th><th><a HREF='?C=M;O=A'>Last modified</a></th></tr>
+<tr><td><a href="/pub/">Parent Directory</a></td></tr>
+<tr><td><a href="d.rpm">d.rpm</a></td></tr>
+<tr><td><a href="/pub/sub/deep/">deep/</a></td></tr>
+<tr><td><a href="d.rpm#frag">d.rpm</a></td></tr>
+<tr><td><a href=e.txt>e.txt</a></td></tr>
+</table></body></html>`,
+		"/pub/sub/deep/": `<a href="f.rpm">f.rpm</a> <a href="../../">up</a> <a href="/pub/sub/">loop</a>`,
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if page, ok := pages[r.URL.Path]; ok {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, page)
+			return
+		}
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		opts   IndexOptions
+		expect []string
+	}{
+		{IndexOptions{}, []string{"a.txt", "b c.rpm", "sub/d.rpm", "sub/deep/f.rpm", "sub/e.txt"}},
+		{IndexOptions{MaxDepth: 1}, []string{"a.txt", "b c.rpm"}},
+		{IndexOptions{MaxDepth: 2, Include: []string{"*.rpm"}}, []string{"b c.rpm", "sub/d.rpm"}},
+		{IndexOptions{Exclude: []string{"*.txt", "f.*"}}, []string{"b c.rpm", "sub/d.rpm"}},
+		{IndexOptions{Match: regexp.MustCompile(`^sub/`)}, []string{"sub/d.rpm", "sub/deep/f.rpm", "sub/e.txt"}},
+	}
+	for _, test := range tests {
+		opts := test.opts
+		reqs, err := IndexRequests(dir, s.URL+"/pub", &opts)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		var labels []string
+		for _, req := range reqs {
+			labels = append(labels, req.Label)
+			if req.Filename != filepath.Join(dir, filepath.FromSlash(req.Label)) {
+				t.Errorf("unexpected filename for %s: %s", req.Label, req.Filename)
+			}
+			if page := s.URL + "/pub/" + path.Dir(req.Label) + "/"; req.PageURL != strings.Replace(page, "/./", "/", 1) {
+				t.Errorf("unexpected page URL for %s: %s", req.Label, req.PageURL)
+			}
+		}
+		sort.Strings(labels)
+		if strings.Join(labels, ",") != strings.Join(test.expect, ",") {
+			t.Errorf("expected files %v, got: %v", test.expect, labels)
+		}
+	}
+
+	reqs, _ := IndexRequests(dir, s.URL+"/pub/", nil)
+	for resp := range DefaultClient.DoBatch(2, reqs...) {
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	}
+	testContent(t, filepath.Join(dir, "sub", "deep", "f.rpm"), []byte("/pub/sub/deep/f.rpm"))
+	testContent(t, filepath.Join(dir, "b c.rpm"), []byte("/pub/b c.rpm"))
+
+	if _, err := IndexRequests(dir, s.URL+"/pub/a.txt/", nil); err == nil {
+		t.Errorf("expected error for file which is not an index")
+	}
+}
@@ -0,0 +1,79 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// integrityHashes are the hash algorithms supported in Subresource Integrity
+// metadata, in ascending order of strength.
+var integrityHashes = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha256", sha256.New},
+	{"sha384", sha512.New384},
+	{"sha512", sha512.New},
+}
+
+// SetIntegrity sets the expected checksum of the downloaded file using
+// Subresource Integrity metadata, as found in the integrity attribute of HTML
+// elements and in package manifests such as package-lock.json. For example:
+//
+//	sha384-oqVuAfXRKap7fdgcCY5uykM6+R9GqQ8K/uxy9rx7HNQlGYl1kPzQho1wx4JwY8wC
+//
+// The metadata may list multiple space separated digests. As in web browsers,
+// only the digests of the strongest supported algorithm are used, and the
+// file is valid if it matches any of these, such as while a key is rotated.
+// Options following a '?' are ignored.
+//
+// SetIntegrity replaces any checksums previously configured with SetChecksum
+// or AddChecksum. Malformed digests are ignored. An error is returned if the
+// metadata contains no valid digests of a supported algorithm (sha256, sha384
+// or sha512).
+func (r *Request) SetIntegrity(integrity string, deleteOnError bool) error {
+	best := -1
+	var sums [][]byte
+	for _, token := range strings.Fields(integrity) {
+		if i := strings.IndexByte(token, '?'); i >= 0 {
+			token = token[:i]
+		}
+		parts := strings.SplitN(token, "-", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		for i, alg := range integrityHashes {
+			if i < best || !strings.EqualFold(parts[0], alg.name) {
+				continue
+			}
+			b, err := decodeIntegrity(parts[1])
+			if err != nil || len(b) != alg.new().Size() {
+				// ignore malformed digests, as in web browsers
+				continue
+			}
+			if i > best {
+				best, sums = i, nil
+			}
+			sums = append(sums, b)
+		}
+	}
+	if best < 0 {
+		return fmt.Errorf("no valid digest in integrity metadata: %q", integrity)
+	}
+	r.SetChecksum(integrityHashes[best].new(), sums[0], deleteOnError)
+	r.checksums[0].alternatives = sums[1:]
+	return nil
+}
+
+// decodeIntegrity decodes the base64 encoded digest of an integrity token,
+// with or without padding.
+func decodeIntegrity(s string) ([]byte, error) {
+	if strings.HasSuffix(s, "=") {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -0,0 +1,56 @@
+package grab
+
+import (
+	"os"
+	"testing"
+)
+
+// TestSetIntegrity tests that downloads are validated using Subresource
+// Integrity metadata.
+func TestSetIntegrity(t *testing.T) {
+	sha256sum := "sha256-+7qyiff5SyVzbFi+RqmUxEH9AlUsxgIjUuPYbS+rfIM="
+	sha384sum := "sha384-ng8AtyVcHCETaxxlLAkRdZfzEKDp7UkcJMUStKCyuHPttG8X9CtiHFsGNwWl2G5s"
+	sha512sum := "sha512-rB0Je06m9q17pkAnW5rCkOSCjNdgoOv3bVVUY6T1Bfld9PYRYpU5ot0YSOfBMEYzuqGCZGKzyHUhwMbjRptnrw=="
+	badsum := "sha512-AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=="
+
+	tests := []struct {
+		Name      string
+		Integrity string
+		ParseErr  bool
+		Err       error
+	}{
+		{"SHA256", sha256sum, false, nil},
+		{"SHA384", sha384sum, false, nil},
+		{"SHA512", sha512sum, false, nil},
+		{"Unpadded", "sha512-rB0Je06m9q17pkAnW5rCkOSCjNdgoOv3bVVUY6T1Bfld9PYRYpU5ot0YSOfBMEYzuqGCZGKzyHUhwMbjRptnrw", false, nil},
+		{"Options", sha384sum + "?ct=application/octet-stream", false, nil},
+		{"Strongest", "sha256-AAAA " + sha512sum + " md5-AAAA", false, nil},
+		{"StrongestMismatch", sha256sum + " " + badsum, false, ErrBadChecksum},
+		{"AnyStrongest", badsum + " " + sha512sum, false, nil},
+		{"AnyStrongestMismatch", badsum + " " + badsum, false, ErrBadChecksum},
+		{"Unsupported", "md5-w1zH19kXKMC8BSgxvE7zcg==", true, nil},
+		{"Malformed", "sha256-!!!!", true, nil},
+		{"Empty", "", true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			filename := ".testSetIntegrity" + test.Name
+			defer os.Remove(filename)
+			req, _ := NewRequest(filename, ts.URL)
+			err := req.SetIntegrity(test.Integrity, true)
+			if test.ParseErr {
+				if err == nil {
+					t.Fatalf("expected error parsing integrity: %q", test.Integrity)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("error parsing integrity: %v", err)
+			}
+			if err := DefaultClient.Do(req).Err(); err != test.Err {
+				t.Fatalf("expected error %v, got: %v", test.Err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package grab
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode"
+)
+
+// A ListEntry is a file to download, as listed in the URL lists and
+// manifests read by ParseRequestList.
+type ListEntry struct {
+	// URL is the URL of the file.
+	URL string `json:"url"`
+
+	// Dst is the destination path of the file, relative to the destination
+	// directory of the list. If empty, the filename is determined by the
+	// remote server or the URL.
+	Dst string `json:"dst,omitempty"`
+
+	// Checksum is the expected checksum of the file, formatted as
+	// <algorithm>:<hex>, such as sha256:2c26b46b..., where the algorithm is
+	// one of sha512, sha384, sha256, sha224, sha1 or md5.
+	Checksum string `json:"checksum,omitempty"`
+
+	// Headers are the HTTP headers to send with each request for the file.
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Request returns a new Request for the entry, to be downloaded into the
+// given destination directory. An error is returned if the destination path
+// of the entry is absolute or outside of the destination directory, or if its
+// checksum is malformed.
+func (c ListEntry) Request(dst string) (*Request, error) {
+	if c.URL == "" {
+		return nil, fmt.Errorf("list entry has no URL")
+	}
+	filename := dst
+	if c.Dst != "" {
+		var err error
+		if filename, err = extractPath(dst, c.Dst); err != nil {
+			return nil, fmt.Errorf("unsafe destination path in list: %s", c.Dst)
+		}
+	}
+	req, err := NewRequest(filename, c.URL)
+	if err != nil {
+		return nil, err
+	}
+	if c.Checksum != "" {
+		alg, v, ok := strings.Cut(c.Checksum, ":")
+		if !ok {
+			alg, v, ok = strings.Cut(c.Checksum, "=")
+		}
+		alg = strings.ToLower(strings.Replace(alg, "-", "", 1))
+		found := false
+		for _, h := range fragmentHashes {
+			if !ok || h.name != alg {
+				continue
+			}
+			hh := h.new()
+			sum, err := hex.DecodeString(strings.TrimSpace(v))
+			if err != nil || len(sum) != hh.Size() {
+				break
+			}
+			req.AddChecksum(hh, sum, false)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("malformed checksum in list: %s", c.Checksum)
+		}
+	}
+	for k, v := range c.Headers {
+		req.HTTPRequest.Header.Set(k, v)
+	}
+	return req, nil
+}
+
+// ParseRequestList reads a list of files to download from r and returns a
+// Request for each file, to be downloaded into the given destination
+// directory. The format of the list is detected from its content:
+//
+// A JSON manifest is an array of objects with the fields of ListEntry, such
+// as [{"url": "https://example.com/a.iso", "dst": "iso/a.iso",
+// "checksum": "sha256:...", "headers": {"Authorization": "..."}}].
+//
+// A YAML manifest is a sequence of mappings with the same keys, each
+// starting with "- ". Only this subset of YAML is supported: plain, single
+// or double quoted scalars, one mapping per item, and a nested mapping for
+// headers. Items may also be a bare URL.
+//
+// Otherwise, the list is a plain text URL list, with one URL per line,
+// optionally followed by whitespace and a destination path. Blank lines and
+// lines beginning with '#' are ignored.
+func ParseRequestList(r io.Reader, dst string) ([]*Request, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var entries []ListEntry
+	text := bytes.TrimLeftFunc(b, unicode.IsSpace)
+	switch {
+	case bytes.HasPrefix(text, []byte("[")):
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.DisallowUnknownFields()
+		if err := d.Decode(&entries); err != nil {
+			return nil, fmt.Errorf("error reading JSON list: %w", err)
+		}
+	case isYAMLList(b):
+		if entries, err = parseYAMLList(b); err != nil {
+			return nil, err
+		}
+	default:
+		if entries, err = parseURLList(b); err != nil {
+			return nil, err
+		}
+	}
+	reqs := make([]*Request, 0, len(entries))
+	for _, e := range entries {
+		req, err := e.Request(dst)
+		if err != nil {
+			return nil, err
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// parseURLList parses a plain text URL list.
+func parseURLList(b []byte) ([]ListEntry, error) {
+	var entries []ListEntry
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; s.Scan(); line++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) > 2 {
+			return nil, fmt.Errorf("malformed URL list at line %d", line)
+		}
+		e := ListEntry{URL: fields[0]}
+		if len(fields) == 2 {
+			e.Dst = fields[1]
+		}
+		entries = append(entries, e)
+	}
+	return entries, s.Err()
+}
+
+// isYAMLList returns true if the first line of the given document, other than
+// blank lines, comments and a document marker, is an item of a YAML sequence.
+func isYAMLList(b []byte) bool {
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || text == "---" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		return text == "-" || strings.HasPrefix(text, "- ")
+	}
+	return false
+}
+
+// parseYAMLList parses the subset of YAML described by ParseRequestList.
+func parseYAMLList(b []byte) ([]ListEntry, error) {
+	var entries []ListEntry
+	var e *ListEntry
+	itemIndent, headersIndent := -1, -1
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimRight(s.Text(), " \t\r")
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("malformed YAML list at line %d: tab indentation", line)
+		}
+		indent := len(text) - len(trimmed)
+		if trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			if itemIndent >= 0 && indent != itemIndent {
+				return nil, fmt.Errorf("malformed YAML list at line %d", line)
+			}
+			itemIndent, headersIndent = indent, -1
+			entries = append(entries, ListEntry{})
+			e = &entries[len(entries)-1]
+			rest := strings.TrimLeft(strings.TrimPrefix(trimmed, "-"), " ")
+			if rest == "" {
+				continue
+			}
+			indent += len(trimmed) - len(rest)
+			trimmed = rest
+			if k, _, _ := yamlKeyValue(rest); k == "" {
+				// a bare URL
+				v, err := yamlScalar(rest)
+				if err != nil {
+					return nil, fmt.Errorf("malformed YAML list at line %d: %v", line, err)
+				}
+				e.URL = v
+				continue
+			}
+		} else if e == nil || indent <= itemIndent {
+			return nil, fmt.Errorf("malformed YAML list at line %d", line)
+		}
+
+		k, v, ok := yamlKeyValue(trimmed)
+		if !ok {
+			return nil, fmt.Errorf("malformed YAML list at line %d", line)
+		}
+		value, err := yamlScalar(v)
+		if err != nil {
+			return nil, fmt.Errorf("malformed YAML list at line %d: %v", line, err)
+		}
+		if headersIndent >= 0 && indent > headersIndent {
+			e.Headers[k] = value
+			continue
+		}
+		headersIndent = -1
+		switch k {
+		case "url":
+			e.URL = value
+		case "dst":
+			e.Dst = value
+		case "checksum":
+			e.Checksum = value
+		case "headers":
+			if value != "" {
+				return nil, fmt.Errorf("malformed YAML list at line %d: headers must be a mapping", line)
+			}
+			headersIndent = indent
+			if e.Headers == nil {
+				e.Headers = make(map[string]string)
+			}
+		default:
+			return nil, fmt.Errorf("unknown key in YAML list at line %d: %s", line, k)
+		}
+	}
+	return entries, s.Err()
+}
+
+// yamlKeyValue splits a YAML mapping entry, such as "url: https://...", into
+// its key and value. Keys may not contain spaces or quotes, so that URLs are
+// not mistaken for mapping entries.
+func yamlKeyValue(s string) (key, value string, ok bool) {
+	i := strings.Index(s, ":")
+	if i <= 0 || strings.ContainsAny(s[:i], " \t\"'") || i+1 < len(s) && s[i+1] != ' ' {
+		return "", "", false
+	}
+	return s[:i], strings.TrimSpace(s[i+1:]), true
+}
+
+// yamlScalar returns the value of a plain, single or double quoted YAML
+// scalar, without any trailing comment.
+func yamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := 1
+		for ; end < len(s) && s[end] != '"'; end++ {
+			if s[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(s) || !yamlTrailer(s[end+1:]) {
+			return "", fmt.Errorf("malformed double quoted scalar")
+		}
+		var v string
+		if err := json.Unmarshal([]byte(s[:end+1]), &v); err != nil {
+			return "", fmt.Errorf("malformed double quoted scalar")
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			if !yamlTrailer(s[i+1:]) {
+				break
+			}
+			return b.String(), nil
+		}
+		return "", fmt.Errorf("malformed single quoted scalar")
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// yamlTrailer returns true if s, following a quoted scalar, is empty or a
+// comment.
+func yamlTrailer(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || strings.HasPrefix(s, "#")
+}
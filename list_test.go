@@ -0,0 +1,83 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseRequestList(t *testing.T) {
+	sum := sha256.Sum256([]byte("example"))
+	hexsum := hex.EncodeToString(sum[:])
+	dst := filepath.Join("tmp", "dst")
+	lists := map[string]string{
+		"URL list": `
+# mirror job
+https://example.com/a.iso
+  https://example.com/b.iso   dir/b.iso
+`,
+		"JSON": `[
+  {"url": "https://example.com/a.iso", "checksum": "sha256:` + hexsum + `", "headers": {"Authorization": "Bearer x"}},
+  {"url": "https://example.com/b.iso", "dst": "dir/b.iso"}
+]`,
+		"YAML": `---
+# mirror job
+- url: https://example.com/a.iso # comment
+  checksum: "sha256:` + hexsum + `"
+  headers:
+    Authorization: 'Bearer x'
+- url: 'https://example.com/b.iso'
+  dst: dir/b.iso
+`,
+	}
+	for name, list := range lists {
+		reqs, err := ParseRequestList(strings.NewReader(list), dst)
+		if err != nil {
+			t.Errorf("%s: error: %v", name, err)
+			continue
+		}
+		if len(reqs) != 2 {
+			t.Errorf("%s: expected 2 requests, got: %d", name, len(reqs))
+			continue
+		}
+		if reqs[0].URL().String() != "https://example.com/a.iso" || reqs[0].Filename != dst {
+			t.Errorf("%s: unexpected request: %s %s", name, reqs[0].URL(), reqs[0].Filename)
+		}
+		if reqs[1].URL().String() != "https://example.com/b.iso" || reqs[1].Filename != filepath.Join(dst, "dir", "b.iso") {
+			t.Errorf("%s: unexpected request: %s %s", name, reqs[1].URL(), reqs[1].Filename)
+		}
+		if name == "URL list" {
+			continue
+		}
+		if len(reqs[0].checksums) != 1 || hex.EncodeToString(reqs[0].checksums[0].Expected) != hexsum {
+			t.Errorf("%s: expected checksum", name)
+		}
+		if v := reqs[0].HTTPRequest.Header.Get("Authorization"); v != "Bearer x" {
+			t.Errorf("%s: expected header, got: %q", name, v)
+		}
+	}
+
+	reqs, err := ParseRequestList(strings.NewReader("- https://example.com/a.iso\n- \"https://example.com/b.iso\"\n"), dst)
+	if err != nil || len(reqs) != 2 || reqs[1].URL().String() != "https://example.com/b.iso" {
+		t.Errorf("expected YAML list of URLs, got: %v", err)
+	}
+
+	for _, list := range []string{
+		"https://example.com/a b c",
+		`[{"url": "https://example.com/a", "size": 1}]`,
+		`[{"url": "https://example.com/a", "dst": "../a"}]`,
+		`[{"url": "https://example.com/a", "checksum": "sha256:00"}]`,
+		`[{"url": "https://example.com/a", "checksum": "crc32:00000000"}]`,
+		`[{"dst": "a"}]`,
+		"- url: https://example.com/a\n  size: 1\n",
+		"- url: https://example.com/a\nheaders:\n",
+		"- url: \"https://example.com/a\n",
+		"- url: https://example.com/a\n  headers: x\n",
+	} {
+		if _, err := ParseRequestList(strings.NewReader(list), dst); err == nil {
+			t.Errorf("expected error for list: %q", list)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package grab
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// logf logs an event of the transfer of the given Response to Client.Logger,
+// if set. The URL of the Request is added to the given attributes.
+func (c *Client) logf(resp *Response, level slog.Level, msg string, args ...interface{}) {
+	if c.Logger == nil {
+		return
+	}
+	ctx := resp.ctx
+	if ctx == nil {
+		ctx = resp.Request.Context()
+	}
+	if !c.Logger.Enabled(ctx, level) {
+		return
+	}
+	args = append([]interface{}{"url", resp.Request.URL().String()}, args...)
+	c.Logger.Log(ctx, level, msg, args...)
+}
+
+// logRedirect logs any redirect followed by the HTTP client to receive the
+// given response to the given request.
+func (c *Client) logRedirect(resp *Response, req *http.Request, hresp *http.Response) {
+	if hresp.Request == nil || hresp.Request.URL.String() == req.URL.String() {
+		return
+	}
+	c.logf(resp, slog.LevelDebug, "request redirected",
+		"method", req.Method,
+		"location", hresp.Request.URL.String())
+}
+
+// logResult logs the result of a finalized transfer.
+func (c *Client) logResult(resp *Response) {
+	if resp.err != nil {
+		c.logf(resp, slog.LevelWarn, "transfer failed",
+			"filename", resp.Filename,
+			"attempts", resp.Attempts(),
+			"error", resp.err)
+		return
+	}
+	c.logf(resp, slog.LevelInfo, "transfer complete",
+		"filename", resp.Filename,
+		"size", resp.Size,
+		"bytes_transferred", resp.bytesTransferred(),
+		"attempts", resp.Attempts(),
+		"resumed", resp.DidResume,
+		"duration", resp.End.Sub(resp.Start))
+}
@@ -0,0 +1,64 @@
+package grab
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLogger tests that events of a transfer are logged to Client.Logger.
+func TestLogger(t *testing.T) {
+	filename := ".testLogger"
+	defer os.Remove(filename)
+
+	// fail the first request, then redirect to the test server
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, ts.URL+"?size=4096", http.StatusFound)
+	}))
+	defer s.Close()
+
+	buf := &bytes.Buffer{}
+	client := NewClient()
+	client.Logger = slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}))
+	client.RetryPolicy = &RetryPolicy{MaxAttempts: 2, Backoff: func(int) time.Duration { return 0 }}
+
+	req, _ := NewRequest(filename, s.URL)
+	testComplete(t, client.Do(req))
+
+	req, _ = NewRequest(filename, s.URL)
+	req.Size = 4096
+	req.SetChecksum(sha256.New(), []byte{0}, false)
+	if err := client.Do(req).Err(); err != ErrBadChecksum {
+		t.Fatalf("expected ErrBadChecksum, got: %v", err)
+	}
+
+	log := buf.String()
+	for _, msg := range []string{
+		`level=DEBUG msg="starting transfer"`,
+		`level=DEBUG msg="sending request" url=` + s.URL,
+		`level=INFO msg="retrying transfer" url=` + s.URL + ` attempt=2`,
+		`level=DEBUG msg="request redirected"`,
+		`level=INFO msg="transfer complete"`,
+		`level=DEBUG msg="file already complete"`,
+		`level=WARN msg="checksum mismatch"`,
+		`level=WARN msg="transfer failed"`,
+	} {
+		if !strings.Contains(log, msg) {
+			t.Errorf("expected log to contain %s, got:\n%s", msg, log)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+
+package grab
+
+// longPath returns the given path unchanged, as only Windows limits the length
+// of paths to MAX_PATH.
+func longPath(name string) string {
+	return name
+}
@@ -0,0 +1,28 @@
+package grab
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is the length of the longest path which every Windows API accepts,
+// being MAX_PATH less the 8.3 filename which must fit in a directory path.
+const maxPath = 260 - 12
+
+// longPath returns the given path in its extended-length form, with the \\?\
+// prefix, if it is too long for MAX_PATH, so that destinations in deep
+// directory trees may be created. UNC paths, such as \\server\share\file, are
+// given the \\?\UNC\ prefix. Other paths are returned unchanged.
+func longPath(name string) string {
+	if len(name) < maxPath || strings.HasPrefix(name, `\\?\`) || strings.HasPrefix(name, `\\.\`) {
+		return name
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
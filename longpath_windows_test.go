@@ -0,0 +1,25 @@
+package grab
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestLongPath tests the extended-length form of paths exceeding MAX_PATH.
+func TestLongPath(t *testing.T) {
+	long := strings.Repeat(`\directory`, 30) + `\file.zip`
+	tests := map[string]string{
+		`C:\example.zip`:              `C:\example.zip`,
+		`\\server\share\example.zip`:  `\\server\share\example.zip`,
+		`C:` + long:                   `\\?\C:` + long,
+		`C:\a\..` + long:              `\\?\C:` + long,
+		`\\server\share` + long:       `\\?\UNC\server\share` + long,
+		`\\?\C:` + long:               `\\?\C:` + long,
+		`\\?\UNC\server\share` + long: `\\?\UNC\server\share` + long,
+	}
+	for name, expect := range tests {
+		if actual := longPath(name); actual != expect {
+			t.Errorf("%s: expected %s, got: %s", name, expect, actual)
+		}
+	}
+}
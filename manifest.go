@@ -0,0 +1,216 @@
+package grab
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// A ChecksumManifest is a list of expected checksums for a set of files, as
+// published in GNU-style checksum files such as SHA256SUMS or MD5SUMS and
+// created with tools such as sha256sum or md5sum.
+//
+// A ChecksumManifest may be used to attach the expected checksum of each file
+// to a batch of Requests, by matching the filename of each Request with the
+// filenames listed in the manifest.
+type ChecksumManifest struct {
+	// New returns a new hash.Hash of the algorithm used to compute the
+	// checksums in the manifest, such as sha256.New.
+	New func() hash.Hash
+
+	// Sums maps each filename listed in the manifest to its expected
+	// checksum. Filenames are relative paths, separated by forward slashes.
+	Sums map[string][]byte
+}
+
+// ParseChecksumManifest parses a GNU-style checksum file from the given
+// io.Reader. Each line of the file must contain a hex encoded checksum,
+// followed by a space, a mode character, which is a space or '*' (binary
+// mode), and a filename. Filenames are used as is, including any leading or
+// trailing spaces. Lines beginning with '\' have filenames in which
+// backslashes and newlines are escaped as "\\" and "\n", as written by
+// sha256sum for filenames which contain them. Blank lines and lines beginning
+// with '#' are ignored.
+//
+// The given function must return a new hash.Hash of the algorithm used to
+// compute the checksums, such as sha256.New.
+func ParseChecksumManifest(r io.Reader, newHash func() hash.Hash) (*ChecksumManifest, error) {
+	m := &ChecksumManifest{
+		New:  newHash,
+		Sums: make(map[string][]byte),
+	}
+	size := newHash().Size()
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimRight(s.Text(), "\r")
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		escaped := strings.HasPrefix(text, "\\")
+		if escaped {
+			text = text[1:]
+		}
+		fields := strings.SplitN(text, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("malformed checksum manifest at line %d", line)
+		}
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil || len(sum) != size {
+			return nil, fmt.Errorf("malformed checksum at line %d", line)
+		}
+		name := fields[1]
+		if strings.HasPrefix(name, " ") || strings.HasPrefix(name, "*") {
+			// mode character
+			name = name[1:]
+		}
+		if escaped {
+			var ok bool
+			if name, ok = unescapeManifestName(name); !ok {
+				return nil, fmt.Errorf("malformed filename at line %d", line)
+			}
+		}
+		if name == "" {
+			return nil, fmt.Errorf("malformed checksum manifest at line %d", line)
+		}
+		m.Sums[cleanManifestName(name)] = sum
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// unescapeManifestName returns the given escaped filename of a checksum file
+// with its escape sequences replaced, or false if it has an invalid escape
+// sequence.
+func unescapeManifestName(name string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] != '\\' {
+			b.WriteByte(name[i])
+			continue
+		}
+		i++
+		if i == len(name) {
+			return "", false
+		}
+		switch name[i] {
+		case '\\':
+			b.WriteByte('\\')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+// GetChecksumManifest downloads and parses the GNU-style checksum file at the
+// given URL using the DefaultClient. See ParseChecksumManifest.
+func GetChecksumManifest(urlStr string, newHash func() hash.Hash) (*ChecksumManifest, error) {
+	return DefaultClient.GetChecksumManifest(context.Background(), urlStr, newHash)
+}
+
+// GetChecksumManifest downloads and parses the GNU-style checksum file at the
+// given URL. The manifest is read into memory and is not saved to local
+// storage. See ParseChecksumManifest.
+func (c *Client) GetChecksumManifest(ctx context.Context, urlStr string, newHash func() hash.Hash) (*ChecksumManifest, error) {
+	hreq, err := http.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	hresp, err := c.doHTTPRequest(hreq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+		return nil, StatusCodeError(hresp.StatusCode)
+	}
+	return ParseChecksumManifest(hresp.Body, newHash)
+}
+
+// Lookup returns the expected checksum of the given filename. If the filename
+// is not listed in the manifest, the base name of the filename is matched
+// against the base name of each listed file, if it is unambiguous.
+func (m *ChecksumManifest) Lookup(filename string) ([]byte, bool) {
+	name := cleanManifestName(filename)
+	if sum, ok := m.Sums[name]; ok {
+		return sum, true
+	}
+	base := path.Base(name)
+	var match []byte
+	for k, sum := range m.Sums {
+		if path.Base(k) != base {
+			continue
+		}
+		if match != nil {
+			// ambiguous
+			return nil, false
+		}
+		match = sum
+	}
+	return match, match != nil
+}
+
+// Apply adds the expected checksum of each of the given Requests to the
+// Request, using AddChecksum. Requests are matched with the manifest using the
+// base name of Request.Filename or, if Filename is empty or a directory, the
+// last element of the path of the request URL. Filenames given by the remote
+// server in a Content-Disposition header are not known until the transfer
+// starts and are not matched.
+//
+// Apply returns the number of Requests which were matched with a checksum.
+func (m *ChecksumManifest) Apply(deleteOnError bool, reqs ...*Request) int {
+	n := 0
+	for _, req := range reqs {
+		name := requestFilename(req)
+		if name == "" {
+			continue
+		}
+		sum, ok := m.Lookup(name)
+		if !ok {
+			continue
+		}
+		req.AddChecksum(m.New(), sum, deleteOnError)
+		n++
+	}
+	return n
+}
+
+// cleanManifestName normalizes a filename listed in a checksum manifest.
+func cleanManifestName(name string) string {
+	name = path.Clean(filepath.ToSlash(name))
+	return strings.TrimPrefix(name, "./")
+}
+
+// requestFilename returns the base name of the file that the given Request is
+// expected to be saved as, without contacting the remote server, or an empty
+// string if it cannot be determined.
+func requestFilename(req *Request) string {
+	if req.Filename != "" && !strings.HasSuffix(req.Filename, string(os.PathSeparator)) {
+		if fi, err := os.Stat(req.Filename); err != nil || !fi.IsDir() {
+			return filepath.Base(req.Filename)
+		}
+	}
+	u := req.URL()
+	if u == nil {
+		return ""
+	}
+	name, err := normalizeFilename(u.Path)
+	if err != nil {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,145 @@
+package grab
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestParseChecksumManifest tests that GNU-style checksum files are parsed
+// correctly.
+func TestParseChecksumManifest(t *testing.T) {
+	manifest := `# comment
+fbbab289f7f94b25736c58be46a994c441fd02552cc6022352e3d86d2fab7c83  file1.bin
+a1a2a3a4a5a6a7a8a9a0b1b2b3b4b5b6b7b8b9b0c1c2c3c4c5c6c7c8c9c0d1d2 *./dir/file2.bin
+
+e1a2a3a4a5a6a7a8a9a0b1b2b3b4b5b6b7b8b9b0c1c2c3c4c5c6c7c8c9c0d1d2  other/file2.bin
+`
+	m, err := ParseChecksumManifest(strings.NewReader(manifest), sha256.New)
+	if err != nil {
+		t.Fatalf("error parsing manifest: %v", err)
+	}
+	if len(m.Sums) != 3 {
+		t.Fatalf("expected 3 checksums, got: %d", len(m.Sums))
+	}
+
+	tests := []struct {
+		Filename string
+		Expect   string
+	}{
+		{"file1.bin", "fbbab289f7f94b25736c58be46a994c441fd02552cc6022352e3d86d2fab7c83"},
+		{"/tmp/file1.bin", "fbbab289f7f94b25736c58be46a994c441fd02552cc6022352e3d86d2fab7c83"},
+		{"dir/file2.bin", "a1a2a3a4a5a6a7a8a9a0b1b2b3b4b5b6b7b8b9b0c1c2c3c4c5c6c7c8c9c0d1d2"},
+		{"./other/file2.bin", "e1a2a3a4a5a6a7a8a9a0b1b2b3b4b5b6b7b8b9b0c1c2c3c4c5c6c7c8c9c0d1d2"},
+		{"file2.bin", ""}, // ambiguous
+		{"missing.bin", ""},
+	}
+	for _, test := range tests {
+		sum, ok := m.Lookup(test.Filename)
+		if ok != (test.Expect != "") {
+			t.Errorf("expected match for %s to be %v", test.Filename, test.Expect != "")
+			continue
+		}
+		if actual := hex.EncodeToString(sum); actual != test.Expect {
+			t.Errorf("expected checksum %s for %s, got: %s", test.Expect, test.Filename, actual)
+		}
+	}
+
+	t.Run("Names", func(t *testing.T) {
+		sum := "fbbab289f7f94b25736c58be46a994c441fd02552cc6022352e3d86d2fab7c83"
+		manifest := strings.Join([]string{
+			sum + "   leading.bin",
+			sum + " *trailing.bin  ",
+			sum + " single.bin\r",
+			"\\" + sum + "  dir\\\\new\\nline.bin",
+			"\\" + sum + " *back\\\\slash.bin",
+		}, "\n")
+		m, err := ParseChecksumManifest(strings.NewReader(manifest), sha256.New)
+		if err != nil {
+			t.Fatalf("error parsing manifest: %v", err)
+		}
+		for _, name := range []string{
+			" leading.bin",
+			"trailing.bin  ",
+			"single.bin",
+			"dir\\new\nline.bin",
+			"back\\slash.bin",
+		} {
+			if _, ok := m.Sums[name]; !ok {
+				t.Errorf("expected checksum for %q in: %q", name, m.Sums)
+			}
+		}
+		if len(m.Sums) != 5 {
+			t.Errorf("expected 5 checksums, got: %d", len(m.Sums))
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		for _, manifest := range []string{
+			"\\fbbab289f7f94b25736c58be46a994c441fd02552cc6022352e3d86d2fab7c83  bad\\escape.bin",
+			"fbbab289f7f94b25736c58be46a994c441fd02552cc6022352e3d86d2fab7c83",
+			"zzzz  file1.bin",
+			"c35cc7d8d91728a0cb052831bc4ef372  file1.bin", // MD5 for SHA-256
+		} {
+			if _, err := ParseChecksumManifest(strings.NewReader(manifest), sha256.New); err == nil {
+				t.Errorf("expected error parsing manifest: %q", manifest)
+			}
+		}
+	})
+}
+
+// TestChecksumManifestApply tests that checksums from a remote manifest are
+// applied to a batch of Requests and validated.
+func TestChecksumManifestApply(t *testing.T) {
+	manifest := fmt.Sprintf("%s  %s\n%s  %s\n",
+		"c35cc7d8d91728a0cb052831bc4ef372", "good.bin",
+		"00000000000000000000000000000000", "bad.bin")
+	ms := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, manifest)
+	}))
+	defer ms.Close()
+
+	m, err := GetChecksumManifest(ms.URL+"/MD5SUMS", md5.New)
+	if err != nil {
+		t.Fatalf("error getting manifest: %v", err)
+	}
+
+	good, _ := NewRequest("", ts.URL+"/good.bin")
+	bad, _ := NewRequest("bad.bin", ts.URL+"/data")
+	none, _ := NewRequest("", ts.URL+"/none.bin")
+	defer os.Remove("good.bin")
+	defer os.Remove("bad.bin")
+	defer os.Remove("none.bin")
+	if n := m.Apply(true, good, bad, none); n != 2 {
+		t.Fatalf("expected 2 requests to match, got: %d", n)
+	}
+
+	for resp := range DefaultClient.DoBatch(0, good, bad, none) {
+		switch resp.Request {
+		case good, none:
+			if err := resp.Err(); err != nil {
+				t.Errorf("error downloading %s: %v", resp.Filename, err)
+			}
+		case bad:
+			if err := resp.Err(); err != ErrBadChecksum {
+				t.Errorf("expected %v, got: %v", ErrBadChecksum, err)
+			}
+		}
+	}
+	if _, err := os.Stat("bad.bin"); !os.IsNotExist(err) {
+		t.Errorf("checksum failure not cleaned up: bad.bin")
+	}
+
+	t.Run("BadStatus", func(t *testing.T) {
+		_, err := GetChecksumManifest(ts.URL+"?status=404", md5.New)
+		if !IsStatusCodeError(err) {
+			t.Errorf("expected status code error, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,737 @@
+package grab
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A MediaPlaylist lists the segments of a media stream, as published in HLS
+// playlists (.m3u8 files of RFC 8216) or MPEG-DASH manifests (.mpd files) by
+// video streaming services. The segments of a stream are concatenated to
+// reproduce the stream as a single file.
+//
+// Use Client.GetMedia to download a stream into a single file, or
+// MediaPlaylist.Requests to create a Request for each segment.
+type MediaPlaylist struct {
+	// Segments are the segments of the stream, in order. The first segment is
+	// the initialization segment of fragmented MP4 streams.
+	Segments []MediaSegment
+
+	// Variants lists the media playlists of the alternative renditions of
+	// the stream, in the order of a HLS master playlist. Variants is only set
+	// for master playlists, which have no Segments.
+	Variants []MediaVariant
+}
+
+// A MediaSegment is a segment of a media stream.
+type MediaSegment struct {
+	// URL is the URL of the file containing the segment.
+	URL *url.URL
+
+	// Offset and Length are the byte range of the segment within the file, if
+	// Length is greater than zero. Otherwise, the segment is the entire file.
+	Offset, Length int64
+}
+
+// A MediaVariant is an alternative rendition of a HLS stream.
+type MediaVariant struct {
+	// URL is the URL of the media playlist of the rendition.
+	URL *url.URL
+
+	// Bandwidth is the peak bit rate of the rendition in bits per second.
+	Bandwidth int64
+}
+
+// ParseHLS parses the HLS playlist read from r. Relative URLs are resolved
+// against the given base URL, typically the URL of the playlist.
+//
+// Segments with EXT-X-BYTERANGE and EXT-X-MAP tags are supported. Encrypted
+// streams are not supported and return an error. For live playlists, only the
+// segments currently listed are returned.
+func ParseHLS(r io.Reader, base *url.URL) (*MediaPlaylist, error) {
+	p := &MediaPlaylist{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	var (
+		line        int
+		variant     *MediaVariant
+		segment     MediaSegment
+		rangeSet    bool
+		next        = make(map[string]int64) // end of the last range of each URL
+		initSegment MediaSegment
+	)
+	resolve := func(ref string) (*url.URL, error) {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return nil, fmt.Errorf("malformed HLS playlist URI: %s", ref)
+		}
+		return u, nil
+	}
+	for scanner.Scan() {
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if line == 1 {
+			if s != "#EXTM3U" {
+				return nil, fmt.Errorf("not a HLS playlist")
+			}
+			continue
+		}
+		if s == "" {
+			continue
+		}
+		if !strings.HasPrefix(s, "#") {
+			u, err := resolve(s)
+			if err != nil {
+				return nil, err
+			}
+			if variant != nil {
+				variant.URL = u
+				p.Variants = append(p.Variants, *variant)
+				variant = nil
+				continue
+			}
+			segment.URL = u
+			if rangeSet {
+				if segment.Offset < 0 {
+					segment.Offset = next[u.String()]
+				}
+				next[u.String()] = segment.Offset + segment.Length
+			}
+			p.Segments = append(p.Segments, segment)
+			segment, rangeSet = MediaSegment{}, false
+			continue
+		}
+		tag, value, _ := strings.Cut(s, ":")
+		switch tag {
+		case "#EXT-X-STREAM-INF":
+			attrs := parseM3UAttributes(value)
+			bandwidth, _ := strconv.ParseInt(attrs["BANDWIDTH"], 10, 64)
+			variant = &MediaVariant{Bandwidth: bandwidth}
+
+		case "#EXT-X-BYTERANGE":
+			var err error
+			segment.Offset, segment.Length, err = parseM3URange(value)
+			if err != nil {
+				return nil, err
+			}
+			rangeSet = true
+
+		case "#EXT-X-MAP":
+			attrs := parseM3UAttributes(value)
+			u, err := resolve(attrs["URI"])
+			if err != nil {
+				return nil, err
+			}
+			m := MediaSegment{URL: u}
+			if v := attrs["BYTERANGE"]; v != "" {
+				if m.Offset, m.Length, err = parseM3URange(v); err != nil {
+					return nil, err
+				}
+				if m.Offset < 0 {
+					return nil, fmt.Errorf("malformed HLS byte range: %s", v)
+				}
+			}
+			if initSegment.URL == nil || initSegment.URL.String() != m.URL.String() || initSegment.Offset != m.Offset || initSegment.Length != m.Length {
+				initSegment = m
+				p.Segments = append(p.Segments, m)
+			}
+
+		case "#EXT-X-KEY":
+			if method := parseM3UAttributes(value)["METHOD"]; method != "NONE" {
+				return nil, fmt.Errorf("encrypted HLS streams are not supported: %s", method)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if line == 0 {
+		return nil, fmt.Errorf("not a HLS playlist")
+	}
+	return p, nil
+}
+
+// parseM3URange parses the value of an EXT-X-BYTERANGE tag, formatted as
+// <length>[@<offset>]. The offset is -1 if it is not given.
+func parseM3URange(v string) (offset, length int64, err error) {
+	n, o, ok := strings.Cut(v, "@")
+	length, err = strconv.ParseInt(n, 10, 64)
+	if err != nil || length <= 0 {
+		return 0, 0, fmt.Errorf("malformed HLS byte range: %s", v)
+	}
+	if !ok {
+		return -1, length, nil
+	}
+	offset, err = strconv.ParseInt(o, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, 0, fmt.Errorf("malformed HLS byte range: %s", v)
+	}
+	return offset, length, nil
+}
+
+// parseM3UAttributes parses the attribute list of a HLS tag, such as
+// BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2".
+func parseM3UAttributes(v string) map[string]string {
+	attrs := make(map[string]string)
+	for v != "" {
+		k, rest, ok := strings.Cut(v, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+			rest = strings.TrimPrefix(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		attrs[strings.TrimSpace(k)] = value
+		v = strings.TrimSpace(rest)
+	}
+	return attrs
+}
+
+// dashMPD is the subset of a DASH media presentation description which
+// describes the segments of static presentations.
+type dashMPD struct {
+	Type     string       `xml:"type,attr"`
+	Duration string       `xml:"mediaPresentationDuration,attr"`
+	BaseURL  string       `xml:"BaseURL"`
+	Periods  []dashPeriod `xml:"Period"`
+}
+
+type dashPeriod struct {
+	Duration       string              `xml:"duration,attr"`
+	BaseURL        string              `xml:"BaseURL"`
+	AdaptationSets []dashAdaptationSet `xml:"AdaptationSet"`
+}
+
+type dashAdaptationSet struct {
+	MimeType        string               `xml:"mimeType,attr"`
+	ContentType     string               `xml:"contentType,attr"`
+	BaseURL         string               `xml:"BaseURL"`
+	SegmentTemplate *dashSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *dashSegmentList     `xml:"SegmentList"`
+	Representations []dashRepresentation `xml:"Representation"`
+}
+
+type dashRepresentation struct {
+	ID              string               `xml:"id,attr"`
+	Bandwidth       int64                `xml:"bandwidth,attr"`
+	MimeType        string               `xml:"mimeType,attr"`
+	BaseURL         string               `xml:"BaseURL"`
+	SegmentTemplate *dashSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *dashSegmentList     `xml:"SegmentList"`
+}
+
+type dashSegmentTemplate struct {
+	Media          string `xml:"media,attr"`
+	Initialization string `xml:"initialization,attr"`
+	StartNumber    *int64 `xml:"startNumber,attr"`
+	Timescale      int64  `xml:"timescale,attr"`
+	Duration       int64  `xml:"duration,attr"`
+	Timeline       []struct {
+		T *int64 `xml:"t,attr"`
+		D int64  `xml:"d,attr"`
+		R int64  `xml:"r,attr"`
+	} `xml:"SegmentTimeline>S"`
+}
+
+type dashSegmentList struct {
+	Initialization *dashURL  `xml:"Initialization"`
+	SegmentURLs    []dashURL `xml:"SegmentURL"`
+}
+
+// dashURL is the URL and byte range of an Initialization or SegmentURL
+// element.
+type dashURL struct {
+	SourceURL  string `xml:"sourceURL,attr"`
+	Range      string `xml:"range,attr"`
+	Media      string `xml:"media,attr"`
+	MediaRange string `xml:"mediaRange,attr"`
+}
+
+// ParseDASH parses the DASH manifest read from r. Relative URLs are resolved
+// against the given base URL, typically the URL of the manifest.
+//
+// In each period, the representation with the highest bandwidth of the first
+// video adaptation set is selected, or of the first adaptation set if there
+// is no video. Segments given by a SegmentTemplate, with or without a
+// SegmentTimeline, a SegmentList or a single BaseURL are supported. Separate
+// audio adaptation sets are not muxed into the stream. Dynamic (live)
+// manifests are not supported and return an error.
+func ParseDASH(r io.Reader, base *url.URL) (*MediaPlaylist, error) {
+	var mpd dashMPD
+	if err := xml.NewDecoder(r).Decode(&mpd); err != nil {
+		return nil, fmt.Errorf("error reading DASH manifest: %w", err)
+	}
+	if mpd.Type == "dynamic" {
+		return nil, fmt.Errorf("live DASH streams are not supported")
+	}
+	base, err := dashBase(base, mpd.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	p := &MediaPlaylist{}
+	for _, period := range mpd.Periods {
+		periodBase, err := dashBase(base, period.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		duration := period.Duration
+		if duration == "" && len(mpd.Periods) == 1 {
+			duration = mpd.Duration
+		}
+		var set *dashAdaptationSet
+		for i, as := range period.AdaptationSets {
+			if len(as.Representations) == 0 {
+				continue
+			}
+			if set == nil {
+				set = &period.AdaptationSets[i]
+			}
+			if dashIsVideo(as.ContentType, as.MimeType) || dashIsVideo("", as.Representations[0].MimeType) {
+				set = &period.AdaptationSets[i]
+				break
+			}
+		}
+		if set == nil {
+			continue
+		}
+		rep := &set.Representations[0]
+		for i := range set.Representations {
+			if set.Representations[i].Bandwidth > rep.Bandwidth {
+				rep = &set.Representations[i]
+			}
+		}
+		setBase, err := dashBase(periodBase, set.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		repBase, err := dashBase(setBase, rep.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		segments, err := dashSegments(set, rep, repBase, duration)
+		if err != nil {
+			return nil, err
+		}
+		p.Segments = append(p.Segments, segments...)
+	}
+	if len(p.Segments) == 0 {
+		return nil, fmt.Errorf("no segments in DASH manifest")
+	}
+	return p, nil
+}
+
+// dashIsVideo returns true if the given contentType or mimeType attribute of
+// an adaptation set or representation is video.
+func dashIsVideo(contentType, mimeType string) bool {
+	return contentType == "video" || strings.HasPrefix(mimeType, "video/")
+}
+
+// dashBase resolves the given BaseURL element against the given base URL.
+func dashBase(base *url.URL, ref string) (*url.URL, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return base, nil
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return nil, fmt.Errorf("malformed DASH BaseURL: %s", ref)
+	}
+	return u, nil
+}
+
+// dashSegments returns the segments of the given representation of the given
+// adaptation set, in a period of the given duration.
+func dashSegments(set *dashAdaptationSet, rep *dashRepresentation, base *url.URL, duration string) ([]MediaSegment, error) {
+	var segments []MediaSegment
+	add := func(ref, byteRange string) error {
+		u, err := base.Parse(ref)
+		if err != nil {
+			return fmt.Errorf("malformed DASH segment URL: %s", ref)
+		}
+		s := MediaSegment{URL: u}
+		if byteRange != "" {
+			start, end, ok := strings.Cut(byteRange, "-")
+			a, err1 := strconv.ParseInt(start, 10, 64)
+			b, err2 := strconv.ParseInt(end, 10, 64)
+			if !ok || err1 != nil || err2 != nil || b < a {
+				return fmt.Errorf("malformed DASH byte range: %s", byteRange)
+			}
+			s.Offset, s.Length = a, b-a+1
+		}
+		segments = append(segments, s)
+		return nil
+	}
+
+	list := rep.SegmentList
+	if list == nil {
+		list = set.SegmentList
+	}
+	tmpl := dashMergeTemplate(rep.SegmentTemplate, set.SegmentTemplate)
+	switch {
+	case list != nil:
+		if init := list.Initialization; init != nil {
+			if err := add(init.SourceURL, init.Range); err != nil {
+				return nil, err
+			}
+		}
+		for _, s := range list.SegmentURLs {
+			if err := add(s.Media, s.MediaRange); err != nil {
+				return nil, err
+			}
+		}
+
+	case tmpl != nil:
+		if tmpl.Initialization != "" {
+			if err := add(dashExpand(tmpl.Initialization, rep, 0, 0), ""); err != nil {
+				return nil, err
+			}
+		}
+		number := int64(1)
+		if tmpl.StartNumber != nil {
+			number = *tmpl.StartNumber
+		}
+		if len(tmpl.Timeline) > 0 {
+			var t int64
+			for _, s := range tmpl.Timeline {
+				if s.T != nil {
+					t = *s.T
+				}
+				if s.R < 0 || s.D <= 0 {
+					return nil, fmt.Errorf("unsupported DASH segment timeline")
+				}
+				for i := int64(0); i <= s.R; i++ {
+					if err := add(dashExpand(tmpl.Media, rep, number, t), ""); err != nil {
+						return nil, err
+					}
+					number++
+					t += s.D
+				}
+			}
+			break
+		}
+		seconds, err := parseISODuration(duration)
+		if err != nil || tmpl.Duration <= 0 {
+			return nil, fmt.Errorf("unknown number of DASH segments")
+		}
+		timescale := tmpl.Timescale
+		if timescale <= 0 {
+			timescale = 1
+		}
+		n := int64(math.Ceil(seconds * float64(timescale) / float64(tmpl.Duration)))
+		for i := int64(0); i < n; i++ {
+			if err := add(dashExpand(tmpl.Media, rep, number+i, i*tmpl.Duration), ""); err != nil {
+				return nil, err
+			}
+		}
+
+	default:
+		// a single file, including any initialization segment
+		segments = append(segments, MediaSegment{URL: base})
+	}
+	return segments, nil
+}
+
+// dashMergeTemplate returns the SegmentTemplate of a representation, with
+// any unset attributes inherited from the SegmentTemplate of its adaptation
+// set.
+func dashMergeTemplate(rep, set *dashSegmentTemplate) *dashSegmentTemplate {
+	if rep == nil {
+		return set
+	}
+	if set == nil {
+		return rep
+	}
+	t := *rep
+	if t.Media == "" {
+		t.Media = set.Media
+	}
+	if t.Initialization == "" {
+		t.Initialization = set.Initialization
+	}
+	if t.StartNumber == nil {
+		t.StartNumber = set.StartNumber
+	}
+	if t.Timescale == 0 {
+		t.Timescale = set.Timescale
+	}
+	if t.Duration == 0 {
+		t.Duration = set.Duration
+	}
+	if len(t.Timeline) == 0 {
+		t.Timeline = set.Timeline
+	}
+	return &t
+}
+
+// dashExpand expands the identifiers of a SegmentTemplate, such as
+// $RepresentationID$ and $Number%05d$.
+func dashExpand(tmpl string, rep *dashRepresentation, number, time int64) string {
+	var b strings.Builder
+	for {
+		i := strings.Index(tmpl, "$")
+		if i < 0 {
+			break
+		}
+		j := strings.Index(tmpl[i+1:], "$")
+		if j < 0 {
+			break
+		}
+		b.WriteString(tmpl[:i])
+		id := tmpl[i+1 : i+1+j]
+		tmpl = tmpl[i+j+2:]
+		name, format, _ := strings.Cut(id, "%")
+		if format == "" {
+			format = "d"
+		}
+		switch name {
+		case "":
+			b.WriteByte('$')
+		case "RepresentationID":
+			b.WriteString(rep.ID)
+		case "Number":
+			fmt.Fprintf(&b, "%"+format, number)
+		case "Time":
+			fmt.Fprintf(&b, "%"+format, time)
+		case "Bandwidth":
+			fmt.Fprintf(&b, "%"+format, rep.Bandwidth)
+		default:
+			b.WriteString("$" + id + "$")
+		}
+	}
+	b.WriteString(tmpl)
+	return b.String()
+}
+
+// parseISODuration parses an ISO 8601 duration of days, hours, minutes and
+// seconds, such as PT1H2M3.5S, into seconds.
+func parseISODuration(v string) (float64, error) {
+	s := strings.TrimPrefix(v, "P")
+	if s == v || s == "" {
+		return 0, fmt.Errorf("malformed duration: %s", v)
+	}
+	var seconds float64
+	inTime := false
+	for s != "" {
+		if s[0] == 'T' {
+			inTime = true
+			s = s[1:]
+			continue
+		}
+		i := strings.IndexAny(s, "DHMS")
+		if i <= 0 {
+			return 0, fmt.Errorf("malformed duration: %s", v)
+		}
+		n, err := strconv.ParseFloat(s[:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("malformed duration: %s", v)
+		}
+		switch {
+		case s[i] == 'D' && !inTime:
+			seconds += n * 86400
+		case s[i] == 'H' && inTime:
+			seconds += n * 3600
+		case s[i] == 'M' && inTime:
+			seconds += n * 60
+		case s[i] == 'S' && inTime:
+			seconds += n
+		default:
+			return 0, fmt.Errorf("malformed duration: %s", v)
+		}
+		s = s[i+1:]
+	}
+	return seconds, nil
+}
+
+// GetMediaPlaylist downloads and parses the HLS playlist or DASH manifest at
+// the given URL using the DefaultClient. See Client.GetMediaPlaylist.
+func GetMediaPlaylist(urlStr string) (*MediaPlaylist, error) {
+	return DefaultClient.GetMediaPlaylist(context.Background(), urlStr)
+}
+
+// GetMediaPlaylist downloads and parses the HLS playlist or DASH manifest at
+// the given URL. DASH manifests are recognized by their Content-Type or .mpd
+// extension. For HLS master playlists, the media playlist of the variant with
+// the highest bandwidth is downloaded and returned.
+func (c *Client) GetMediaPlaylist(ctx context.Context, urlStr string) (*MediaPlaylist, error) {
+	for depth := 0; ; depth++ {
+		hreq, err := http.NewRequest("GET", urlStr, nil)
+		if err != nil {
+			return nil, err
+		}
+		hresp, err := c.doHTTPRequest(hreq.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(io.LimitReader(hresp.Body, 16<<20))
+		hresp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+			return nil, StatusCodeError(hresp.StatusCode)
+		}
+		base := hresp.Request.URL
+		if strings.HasPrefix(hresp.Header.Get("Content-Type"), "application/dash+xml") ||
+			path.Ext(hreq.URL.Path) == ".mpd" {
+			return ParseDASH(bytes.NewReader(b), base)
+		}
+		p, err := ParseHLS(bytes.NewReader(b), base)
+		if err != nil || len(p.Variants) == 0 {
+			return p, err
+		}
+		if depth > 0 {
+			return nil, fmt.Errorf("HLS master playlist refers to master playlist")
+		}
+		best := p.Variants[0]
+		for _, v := range p.Variants {
+			if v.Bandwidth > best.Bandwidth {
+				best = v
+			}
+		}
+		urlStr = best.URL.String()
+	}
+}
+
+// Requests returns a Request for each segment of the MediaPlaylist, to be
+// downloaded into the given directory. The segment files are named by their
+// index, so that they sort in order, such as 000000.ts. Segments with a byte
+// range are requested with Request.RangeOffset and Request.RangeLength.
+func (p *MediaPlaylist) Requests(dir string) ([]*Request, error) {
+	reqs := make([]*Request, 0, len(p.Segments))
+	for i, s := range p.Segments {
+		ext := path.Ext(s.URL.Path)
+		if len(ext) > 8 || strings.ContainsAny(ext, `/\`) {
+			ext = ""
+		}
+		req, err := NewRequest(filepath.Join(dir, fmt.Sprintf("%06d%s", i, ext)), s.URL.String())
+		if err != nil {
+			return nil, err
+		}
+		req.RangeOffset = s.Offset
+		req.RangeLength = s.Length
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// mediaSegmentsDir returns the directory in which the segments of the media
+// stream downloaded to the given destination path are stored until they are
+// concatenated. E.g. /tmp/video.mp4.segments for /tmp/video.mp4.
+func mediaSegmentsDir(filename string) string {
+	return filename + ".segments"
+}
+
+// GetMedia downloads the stream of the HLS playlist or DASH manifest at the
+// given URL into the given destination file, using the DefaultClient and
+// defaultMediaWorkers workers. See Client.GetMedia.
+func GetMedia(dst, urlStr string) error {
+	return DefaultClient.GetMedia(context.Background(), dst, urlStr, 0)
+}
+
+// defaultMediaWorkers is the number of concurrent segment downloads of
+// GetMedia if no number of workers is given, so that long playlists do not
+// open a connection per segment to the same origin.
+const defaultMediaWorkers = 4
+
+// GetMedia downloads the stream of the HLS playlist or DASH manifest at the
+// given URL into the given destination file. The segments of the stream are
+// downloaded with DoBatch, using the given number of workers, or 4 if it is
+// less than one, into a temporary directory alongside the destination file and
+// are concatenated once all are complete. Streams are not remuxed, so the
+// destination file is a MPEG transport stream or fragmented MP4 file, as
+// published.
+//
+// Only a single rendition of the stream is downloaded: the variant with the
+// highest bandwidth of a HLS master playlist, or the representation with the
+// highest bandwidth of the first video adaptation set of a DASH manifest.
+// Audio and subtitle renditions which are published separately, such as with
+// EXT-X-MEDIA or DASH audio adaptation sets, are not downloaded, so the
+// destination file has no audio unless it is muxed into the video segments.
+//
+// If the download fails, the completed segments are kept, so that calling
+// GetMedia again with the same destination file only downloads the remaining
+// segments and resumes incomplete segments. The first error of any segment is
+// returned.
+func (c *Client) GetMedia(ctx context.Context, dst, urlStr string, workers int) error {
+	p, err := c.GetMediaPlaylist(ctx, urlStr)
+	if err != nil {
+		return err
+	}
+	if len(p.Segments) == 0 {
+		return fmt.Errorf("no segments in media playlist")
+	}
+	dir := mediaSegmentsDir(dst)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	reqs, err := p.Requests(dir)
+	if err != nil {
+		return err
+	}
+	for i, req := range reqs {
+		reqs[i] = req.WithContext(ctx)
+	}
+	if workers < 1 {
+		workers = defaultMediaWorkers
+	}
+	var firstErr error
+	for resp := range c.DoBatch(workers, reqs...) {
+		if err := resp.Err(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	// concatenate the segments
+	tmp := partFilename("", dst)
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	for _, req := range reqs {
+		if err = appendFile(f, req.Filename); err != nil {
+			break
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = renameFile(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// appendFile appends the content of the file at the given path to w.
+func appendFile(w io.Writer, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,297 @@
+package grab
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestParseHLS tests that the segments of HLS media playlists are parsed,
+// including byte ranges and initialization segments.
+func TestParseHLS(t *testing.T) {
+	base, _ := url.Parse("https://example.com/video/index.m3u8")
+	p, err := ParseHLS(strings.NewReader(`#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-TARGETDURATION:6
+#EXT-X-MAP:URI="init.mp4",BYTERANGE="100@0"
+#EXTINF:6.0,
+#EXT-X-BYTERANGE:1000@100
+media.mp4
+#EXTINF:6.0,
+#EXT-X-BYTERANGE:500
+media.mp4
+#EXT-X-KEY:METHOD=NONE
+#EXTINF:6.0,
+/other/seg3.m4s
+#EXT-X-ENDLIST
+`), base)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	expect := []string{
+		"https://example.com/video/init.mp4 0 100",
+		"https://example.com/video/media.mp4 100 1000",
+		"https://example.com/video/media.mp4 1100 500",
+		"https://example.com/other/seg3.m4s 0 0",
+	}
+	if len(p.Segments) != len(expect) {
+		t.Fatalf("expected %d segments, got: %d", len(expect), len(p.Segments))
+	}
+	for i, s := range p.Segments {
+		if v := fmt.Sprintf("%s %d %d", s.URL, s.Offset, s.Length); v != expect[i] {
+			t.Errorf("expected segment %q, got: %q", expect[i], v)
+		}
+	}
+
+	p, err = ParseHLS(strings.NewReader(`#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=640x360
+low/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=5000000
+high/index.m3u8
+`), base)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(p.Variants) != 2 || p.Variants[1].Bandwidth != 5000000 || p.Variants[1].URL.String() != "https://example.com/video/high/index.m3u8" {
+		t.Errorf("unexpected variants: %v", p.Variants)
+	}
+
+	for _, doc := range []string{
+		"",
+		"not a playlist",
+		"#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\"\nseg.ts\n",
+		"#EXTM3U\n#EXT-X-BYTERANGE:abc\nseg.ts\n",
+	} {
+		if _, err := ParseHLS(strings.NewReader(doc), base); err == nil {
+			t.Errorf("expected error for playlist: %q", doc)
+		}
+	}
+}
+
+// TestParseDASH tests that the segments of the selected representation of
+// DASH manifests are parsed.
+func TestParseDASH(t *testing.T) {
+	base, _ := url.Parse("https://example.com/video/manifest.mpd")
+	tests := []struct {
+		mpd    string
+		expect []string
+	}{
+		{
+			`<MPD type="static" mediaPresentationDuration="PT10.5S">
+  <Period>
+    <AdaptationSet contentType="audio">
+      <Representation id="audio" bandwidth="128000"><BaseURL>audio.mp4</BaseURL></Representation>
+    </AdaptationSet>
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate media="$RepresentationID$/$Number%03d$.m4s" initialization="$RepresentationID$/init.mp4" timescale="1000" duration="4000" startNumber="0"/>
+      <Representation id="low" bandwidth="500000"/>
+      <Representation id="high" bandwidth="2000000"/>
+    </AdaptationSet>
+  </Period>
+</MPD>`,
+			[]string{"high/init.mp4", "high/000.m4s", "high/001.m4s", "high/002.m4s"},
+		},
+		{
+			`<MPD>
+  <BaseURL>https://cdn.example.com/v/</BaseURL>
+  <Period>
+    <AdaptationSet>
+      <Representation id="a" bandwidth="1">
+        <SegmentTemplate media="seg-$Time$.m4s" initialization="init-$Bandwidth$.mp4">
+          <SegmentTimeline><S t="10" d="5" r="1"/><S d="3"/></SegmentTimeline>
+        </SegmentTemplate>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`,
+			[]string{"https://cdn.example.com/v/init-1.mp4", "https://cdn.example.com/v/seg-10.m4s", "https://cdn.example.com/v/seg-15.m4s", "https://cdn.example.com/v/seg-20.m4s"},
+		},
+		{
+			`<MPD>
+  <Period>
+    <BaseURL>p1/</BaseURL>
+    <AdaptationSet>
+      <Representation id="a">
+        <SegmentList>
+          <Initialization sourceURL="file.mp4" range="0-99"/>
+          <SegmentURL media="file.mp4" mediaRange="100-199"/>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+  <Period>
+    <AdaptationSet>
+      <Representation id="b"><BaseURL>single.mp4</BaseURL></Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`,
+			[]string{"p1/file.mp4[0:100]", "p1/file.mp4[100:100]", "single.mp4"},
+		},
+	}
+	for _, test := range tests {
+		p, err := ParseDASH(strings.NewReader(test.mpd), base)
+		if err != nil {
+			t.Errorf("error: %v", err)
+			continue
+		}
+		var segments []string
+		for _, s := range p.Segments {
+			v := strings.TrimPrefix(s.URL.String(), "https://example.com/video/")
+			if s.Length > 0 {
+				v += fmt.Sprintf("[%d:%d]", s.Offset, s.Length)
+			}
+			segments = append(segments, v)
+		}
+		if strings.Join(segments, " ") != strings.Join(test.expect, " ") {
+			t.Errorf("expected segments %v, got: %v", test.expect, segments)
+		}
+	}
+
+	for _, doc := range []string{
+		`<MPD type="dynamic"><Period><AdaptationSet><Representation><BaseURL>a</BaseURL></Representation></AdaptationSet></Period></MPD>`,
+		`<MPD><Period><AdaptationSet><Representation><SegmentTemplate media="$Number$"/></Representation></AdaptationSet></Period></MPD>`,
+		`<MPD></MPD>`,
+		`not xml`,
+	} {
+		if _, err := ParseDASH(strings.NewReader(doc), base); err == nil {
+			t.Errorf("expected error for manifest: %s", doc)
+		}
+	}
+}
+
+func TestParseISODuration(t *testing.T) {
+	tests := map[string]float64{
+		"PT10S":      10,
+		"PT1H2M3.5S": 3723.5,
+		"P1DT1S":     86401,
+		"PT0.25S":    0.25,
+		"P":          -1,
+		"T1S":        -1,
+		"PT1D":       -1,
+		"P1Y":        -1,
+		"PTxS":       -1,
+	}
+	for v, expect := range tests {
+		n, err := parseISODuration(v)
+		if expect < 0 {
+			if err == nil {
+				t.Errorf("%s: expected error", v)
+			}
+			continue
+		}
+		if err != nil || n != expect {
+			t.Errorf("%s: expected %v, got: %v (%v)", v, expect, n, err)
+		}
+	}
+}
+
+// TestGetMedia tests that the segments of a HLS stream are downloaded and
+// concatenated into a single file, and that interrupted downloads resume at
+// segment granularity.
+func TestGetMedia(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	segments := [][]byte{[]byte("init"), []byte("first segment "), []byte("second segment "), []byte("third segment")}
+	expect := bytes.Join(segments, nil)
+	var requests, failing int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/master.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\nlow.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=2\nmedia/high.m3u8\n")
+		case "/media/high.m3u8":
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:1,\nseg1.m4s\n#EXTINF:1,\nseg2.m4s\n#EXTINF:1,\nseg3.m4s\n#EXT-X-ENDLIST\n")
+		case "/dash.mpd":
+			fmt.Fprint(w, `<MPD mediaPresentationDuration="PT3S"><Period><AdaptationSet><SegmentTemplate media="media/seg$Number$.m4s" initialization="media/init.mp4" duration="1"/><Representation id="v"/></AdaptationSet></Period></MPD>`)
+		default:
+			var i int
+			if r.URL.Path == "/media/init.mp4" {
+				i = 0
+			} else if _, err := fmt.Sscanf(r.URL.Path, "/media/seg%d.m4s", &i); err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			if r.Method == "GET" {
+				atomic.AddInt32(&requests, 1)
+			}
+			if i > 0 && i == int(atomic.LoadInt32(&failing)) {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			http.ServeContent(w, r, "", time.Unix(0, 0), bytes.NewReader(segments[i]))
+		}
+	}))
+	defer s.Close()
+
+	for _, manifest := range []string{"/master.m3u8", "/dash.mpd"} {
+		filename := filepath.Join(dir, "video.mp4")
+		os.Remove(filename)
+
+		// fail the second segment
+		atomic.StoreInt32(&failing, 2)
+		err := DefaultClient.GetMedia(context.Background(), filename, s.URL+manifest, 1)
+		if err == nil {
+			t.Fatalf("%s: expected error", manifest)
+		}
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("%s: expected destination file not to be created", manifest)
+		}
+
+		// resume
+		atomic.StoreInt32(&failing, 0)
+		atomic.StoreInt32(&requests, 0)
+		if err := DefaultClient.GetMedia(context.Background(), filename, s.URL+manifest, 2); err != nil {
+			t.Fatalf("%s: error: %v", manifest, err)
+		}
+		if n := atomic.LoadInt32(&requests); n != 1 {
+			t.Errorf("%s: expected only failed segment to be downloaded, got %d requests", manifest, n)
+		}
+		testContent(t, filename, expect)
+		if _, err := os.Stat(mediaSegmentsDir(filename)); !os.IsNotExist(err) {
+			t.Errorf("%s: expected segments to be removed", manifest)
+		}
+	}
+}
+
+// TestGetMediaWorkers tests that the segments of a stream are downloaded by a
+// bounded number of workers by default.
+func TestGetMediaWorkers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	segments := newConcurrencyServer(nil)
+	defer segments.Close()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "#EXTM3U\n")
+		for i := 0; i < 12; i++ {
+			fmt.Fprintf(w, "#EXTINF:1,\n%s/seg%d.ts\n", segments.URL, i)
+		}
+		fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+	}))
+	defer s.Close()
+
+	filename := filepath.Join(dir, "video.ts")
+	if err := DefaultClient.GetMedia(context.Background(), filename, s.URL+"/index.m3u8", 0); err != nil {
+		t.Fatal(err)
+	}
+	segments.mu.Lock()
+	defer segments.mu.Unlock()
+	if segments.max != defaultMediaWorkers {
+		t.Errorf("expected %d concurrent segment downloads, got: %d", defaultMediaWorkers, segments.max)
+	}
+}
@@ -0,0 +1,108 @@
+package grab
+
+import (
+	"encoding/hex"
+	"os"
+	"reflect"
+	"runtime"
+)
+
+// A FileOwner is the numeric user and group ID of the owner of a file, as
+// given to os.Chown. An ID of -1 is not changed.
+type FileOwner struct {
+	Uid int
+	Gid int
+}
+
+// fileMode returns the permissions with which the destination file of the
+// Request is created.
+func (r *Request) fileMode() os.FileMode {
+	if r.FileMode != 0 {
+		return r.FileMode.Perm()
+	}
+	return 0644
+}
+
+// dirMode returns the permissions with which the parent directories of the
+// destination file of the Request are created.
+func (r *Request) dirMode() os.FileMode {
+	if r.DirMode != 0 {
+		return r.DirMode.Perm()
+	}
+	if r.FileMode == 0 {
+		return 0755
+	}
+	mode := r.FileMode.Perm()
+	return mode | mode&0444>>2
+}
+
+// setOwner changes the owner of the given file, if owner is not nil.
+func setOwner(filename string, owner *FileOwner) error {
+	if owner == nil || runtime.GOOS == "windows" {
+		return nil
+	}
+	return os.Chown(filename, owner.Uid, owner.Gid)
+}
+
+// writeMetadata sets the permissions and owner of the completed destination
+// file, if Request.FileMode or Request.Owner are set, and records its
+// provenance in extended attributes, if Request.RecordOrigin is set.
+func writeMetadata(resp *Response) error {
+	req := resp.Request
+	if req.FileMode != 0 {
+		if err := os.Chmod(resp.Filename, req.FileMode.Perm()); err != nil {
+			return err
+		}
+	}
+	if err := setOwner(resp.Filename, req.Owner); err != nil {
+		return err
+	}
+	if !req.RecordOrigin {
+		return nil
+	}
+	for _, attr := range originAttributes(resp) {
+		if err := setXattr(resp.Filename, attr[0], attr[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// originAttributes returns the names and values of the extended attributes
+// described by Request.RecordOrigin.
+func originAttributes(resp *Response) [][2]string {
+	var attrs [][2]string
+	u := *resp.Request.URL()
+	if resp.HTTPResponse != nil && resp.HTTPResponse.Request != nil {
+		// the final URL, after redirects
+		u = *resp.HTTPResponse.Request.URL
+	}
+	u.User = nil
+	attrs = append(attrs, [2]string{"user.xdg.origin.url", u.String()})
+	if v := resp.Request.HTTPRequest.Header.Get("Referer"); v != "" {
+		attrs = append(attrs, [2]string{"user.xdg.referrer.url", v})
+	}
+	if resp.HTTPResponse != nil {
+		if v := resp.HTTPResponse.Header.Get("ETag"); v != "" {
+			attrs = append(attrs, [2]string{"user.grab.etag", v})
+		}
+	}
+	for _, sum := range resp.Checksums {
+		if name := hashName(sum); name != "" && sum.OK() {
+			attrs = append(attrs, [2]string{"user.grab.checksum." + name, hex.EncodeToString(sum.Actual)})
+		}
+	}
+	return attrs
+}
+
+// hashName returns the name of the hash algorithm of the given checksum, as
+// used in URL fragments, or an empty string if the algorithm is unknown.
+func hashName(sum Checksum) string {
+	for _, h := range fragmentHashes {
+		hh := h.new()
+		if reflect.TypeOf(hh) == reflect.TypeOf(sum.Hash) && hh.Size() == sum.Hash.Size() {
+			return h.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,20 @@
+package grab
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// setXattr sets the extended attribute of the given file. File systems which
+// do not support extended attributes are ignored.
+func setXattr(filename, name, value string) error {
+	err := syscall.Setxattr(filename, name, []byte(value), 0)
+	if errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "setxattr", Path: filename, Err: err}
+	}
+	return nil
+}
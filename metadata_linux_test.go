@@ -0,0 +1,54 @@
+package grab
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+)
+
+// TestRecordOrigin tests that the provenance of the destination file is
+// recorded in its extended attributes with Request.RecordOrigin.
+func TestRecordOrigin(t *testing.T) {
+	filename := ".testRecordOrigin"
+	defer os.Remove(filename)
+	if err := ioutil.WriteFile(filename, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Setxattr(filename, "user.test", []byte("x"), 0); err != nil {
+		if errors.Is(err, syscall.ENOTSUP) {
+			t.Skip("extended attributes are not supported by the file system")
+		}
+		t.Fatal(err)
+	}
+	os.Remove(filename)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		w.Write([]byte("hello"))
+	}))
+	defer s.Close()
+
+	req, _ := NewRequest(filename, s.URL+"/example.txt")
+	req.RecordOrigin = true
+	if err := DefaultClient.Do(req).Err(); err != nil {
+		t.Fatal(err)
+	}
+	for name, expect := range map[string]string{
+		"user.xdg.origin.url": s.URL + "/example.txt",
+		"user.grab.etag":      `"abc"`,
+	} {
+		b := make([]byte, 256)
+		n, err := syscall.Getxattr(filename, name, b)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if actual := string(b[:n]); actual != expect {
+			t.Errorf("%s: expected %q, got: %q", name, expect, actual)
+		}
+	}
+}
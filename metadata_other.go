@@ -0,0 +1,8 @@
+//go:build !linux
+
+package grab
+
+// setXattr is not supported on this platform.
+func setXattr(filename, name, value string) error {
+	return nil
+}
@@ -0,0 +1,253 @@
+package grab
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// metalinkHashes are the hash algorithms supported in Metalink files, in
+// descending order of strength. Names are normalized with metalinkHashName.
+var metalinkHashes = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha512", sha512.New},
+	{"sha384", sha512.New384},
+	{"sha256", sha256.New},
+	{"sha1", sha1.New},
+	{"md5", md5.New},
+}
+
+// A Metalink describes a set of files which may each be downloaded from
+// multiple mirrors, as published in Metalink files (.meta4 files of RFC 5854,
+// or .metalink files of Metalink 3.0) by many software distribution mirrors.
+//
+// Use Metalink.Requests to create a Request for each file, complete with its
+// mirrors, expected size and checksum.
+type Metalink struct {
+	Files []MetalinkFile
+}
+
+// A MetalinkFile is a file described in a Metalink.
+type MetalinkFile struct {
+	// Name is the relative path of the file, separated by forward slashes.
+	Name string
+
+	// Size is the size of the file in bytes, or zero if unknown.
+	Size int64
+
+	// Hashes maps the name of each hash algorithm, such as "sha256", to the
+	// expected checksum of the file.
+	Hashes map[string][]byte
+
+	// Pieces lists the checksums of fixed-size pieces of the file, if given
+	// with a supported hash algorithm, or nil.
+	Pieces *Pieces
+
+	// URLs lists the URLs from which the file may be downloaded, in order of
+	// priority.
+	URLs []*url.URL
+}
+
+// metalinkXML matches the document elements of both RFC 5854 and Metalink 3.0.
+type metalinkXML struct {
+	Files   []metalinkFileXML `xml:"file"`
+	V3Files []metalinkFileXML `xml:"files>file"`
+}
+
+type metalinkFileXML struct {
+	Name     string              `xml:"name,attr"`
+	Size     int64               `xml:"size"`
+	Hashes   []metalinkHashXML   `xml:"hash"`
+	V3Hashes []metalinkHashXML   `xml:"verification>hash"`
+	Pieces   []metalinkPiecesXML `xml:"pieces"`
+	V3Pieces []metalinkPiecesXML `xml:"verification>pieces"`
+	URLs     []metalinkURLXML    `xml:"url"`
+	V3URLs   []metalinkURLXML    `xml:"resources>url"`
+}
+
+type metalinkHashXML struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type metalinkPiecesXML struct {
+	Type   string   `xml:"type,attr"`
+	Length int64    `xml:"length,attr"`
+	Hashes []string `xml:"hash"`
+}
+
+type metalinkURLXML struct {
+	Priority   int    `xml:"priority,attr"`
+	Preference int    `xml:"preference,attr"`
+	URL        string `xml:",chardata"`
+}
+
+// priority returns the priority of the URL, where lower values are preferred.
+// URLs without a priority are tried last.
+func (c metalinkURLXML) priority() int {
+	if c.Priority > 0 {
+		return c.Priority
+	}
+	if c.Preference > 0 {
+		// Metalink 3.0 preferences are 1-100, where higher is preferred
+		return 101 - c.Preference
+	}
+	return 1 << 20
+}
+
+// ParseMetalink parses a Metalink file in the format of RFC 5854 (.meta4) or
+// Metalink 3.0 (.metalink) from the given io.Reader. Only HTTP and HTTPS URLs
+// are included. Checksums of unsupported hash algorithms, and malformed
+// checksums, are ignored. An error is returned if any file has no name.
+func ParseMetalink(r io.Reader) (*Metalink, error) {
+	var doc metalinkXML
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+	m := &Metalink{}
+	for _, fx := range append(doc.Files, doc.V3Files...) {
+		name := cleanManifestName(fx.Name)
+		if fx.Name == "" || name == "." {
+			return nil, fmt.Errorf("malformed metalink: file has no name")
+		}
+		f := MetalinkFile{
+			Name:   name,
+			Size:   fx.Size,
+			Hashes: make(map[string][]byte),
+		}
+		for _, hx := range append(fx.Hashes, fx.V3Hashes...) {
+			alg := metalinkHashName(hx.Type)
+			for _, h := range metalinkHashes {
+				if h.name != alg {
+					continue
+				}
+				sum, err := hex.DecodeString(strings.TrimSpace(hx.Value))
+				if err == nil && len(sum) == h.new().Size() {
+					f.Hashes[alg] = sum
+				}
+			}
+		}
+		f.Pieces = metalinkPieces(append(fx.Pieces, fx.V3Pieces...))
+		urls := append(fx.URLs, fx.V3URLs...)
+		sort.SliceStable(urls, func(i, j int) bool {
+			return urls[i].priority() < urls[j].priority()
+		})
+		for _, ux := range urls {
+			u, err := url.Parse(strings.TrimSpace(ux.URL))
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				continue
+			}
+			f.URLs = append(f.URLs, u)
+		}
+		m.Files = append(m.Files, f)
+	}
+	return m, nil
+}
+
+// metalinkPieces returns the piece checksums of the strongest supported hash
+// algorithm in the given pieces elements, or nil if there are none.
+// Malformed piece checksums are ignored.
+func metalinkPieces(pieces []metalinkPiecesXML) *Pieces {
+	for _, h := range metalinkHashes {
+		for _, px := range pieces {
+			if metalinkHashName(px.Type) != h.name || px.Length <= 0 || len(px.Hashes) == 0 {
+				continue
+			}
+			p := &Pieces{New: h.new, Size: px.Length}
+			for _, v := range px.Hashes {
+				sum, err := hex.DecodeString(strings.TrimSpace(v))
+				if err != nil || len(sum) != h.new().Size() {
+					p = nil
+					break
+				}
+				p.Sums = append(p.Sums, sum)
+			}
+			if p != nil {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// metalinkHashName normalizes the name of a hash algorithm in a Metalink, such
+// as "sha-256" in RFC 5854 or "sha256" in Metalink 3.0.
+func metalinkHashName(name string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(name), "-", "", -1))
+}
+
+// GetMetalink downloads and parses the Metalink file at the given URL using
+// the DefaultClient. See ParseMetalink.
+func GetMetalink(urlStr string) (*Metalink, error) {
+	return DefaultClient.GetMetalink(context.Background(), urlStr)
+}
+
+// GetMetalink downloads and parses the Metalink file at the given URL. The
+// Metalink is read into memory and is not saved to local storage. See
+// ParseMetalink.
+func (c *Client) GetMetalink(ctx context.Context, urlStr string) (*Metalink, error) {
+	hreq, err := http.NewRequest("GET", urlStr, nil)
+	if err != nil {
+		return nil, err
+	}
+	hresp, err := c.doHTTPRequest(hreq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+		return nil, StatusCodeError(hresp.StatusCode)
+	}
+	return ParseMetalink(hresp.Body)
+}
+
+// Requests returns a Request for each file in the Metalink, to be downloaded
+// into the given destination directory. The first URL of each file is
+// requested and any other URLs are set as Request.Mirrors. Request.Size is set
+// to the size of each file, if known, and the checksum of the strongest
+// supported hash algorithm is added with AddChecksum, with the given value of
+// deleteOnError. Request.Pieces is set to any piece checksums of each file.
+//
+// An error is returned if any file has no URLs, or if its name is an absolute
+// path or a path outside of the destination directory.
+func (m *Metalink) Requests(dst string, deleteOnError bool) ([]*Request, error) {
+	reqs := make([]*Request, 0, len(m.Files))
+	for _, f := range m.Files {
+		if len(f.URLs) == 0 {
+			return nil, fmt.Errorf("metalink file has no supported URLs: %s", f.Name)
+		}
+		filename, err := extractPath(dst, f.Name)
+		if err != nil {
+			return nil, fmt.Errorf("unsafe metalink file name: %s", f.Name)
+		}
+		req, err := NewRequest(filename, f.URLs[0].String())
+		if err != nil {
+			return nil, err
+		}
+		req.Label = f.Name
+		req.Mirrors = f.URLs[1:]
+		req.Size = f.Size
+		req.Pieces = f.Pieces
+		for _, h := range metalinkHashes {
+			if sum, ok := f.Hashes[h.name]; ok {
+				req.AddChecksum(h.new(), sum, deleteOnError)
+				break
+			}
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
@@ -0,0 +1,139 @@
+package grab
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseMetalink(t *testing.T) {
+	meta4 := `<?xml version="1.0" encoding="UTF-8"?>
+<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="dir/example.iso">
+    <size>4096</size>
+    <hash type="md5">b4b480d3fbd6b326d3b6e16fdbe6e4fc</hash>
+    <hash type="sha-256">` + strings.Repeat("ab", 32) + `</hash>
+    <hash type="sha-1">bad</hash>
+    <pieces length="2048" type="sha-256">
+      <hash>` + strings.Repeat("01", 32) + `</hash>
+      <hash>` + strings.Repeat("02", 32) + `</hash>
+    </pieces>
+    <url location="de" priority="2">http://de.example.com/example.iso</url>
+    <url>http://example.com/example.iso</url>
+    <url priority="1">https://us.example.com/example.iso</url>
+    <url priority="1">ftp://ftp.example.com/example.iso</url>
+  </file>
+</metalink>`
+	v3 := `<?xml version="1.0" encoding="UTF-8"?>
+<metalink version="3.0" xmlns="http://www.metalinker.org/">
+  <files>
+    <file name="example.iso">
+      <size>4096</size>
+      <verification>
+        <hash type="sha256">` + strings.Repeat("ab", 32) + `</hash>
+        <pieces length="2048" type="sha256">
+          <hash piece="0">` + strings.Repeat("01", 32) + `</hash>
+          <hash piece="1">` + strings.Repeat("02", 32) + `</hash>
+        </pieces>
+      </verification>
+      <resources>
+        <url type="http" preference="10">http://de.example.com/example.iso</url>
+        <url type="http" preference="100">https://us.example.com/example.iso</url>
+      </resources>
+    </file>
+  </files>
+</metalink>`
+
+	for _, doc := range []string{meta4, v3} {
+		m, err := ParseMetalink(strings.NewReader(doc))
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if len(m.Files) != 1 {
+			t.Fatalf("expected 1 file, got: %d", len(m.Files))
+		}
+		f := m.Files[0]
+		if f.Size != 4096 {
+			t.Errorf("expected size 4096, got: %d", f.Size)
+		}
+		if hex.EncodeToString(f.Hashes["sha256"]) != strings.Repeat("ab", 32) {
+			t.Errorf("expected sha256 checksum, got: %v", f.Hashes)
+		}
+		if _, ok := f.Hashes["sha1"]; ok {
+			t.Errorf("expected malformed checksum to be ignored")
+		}
+		if p := f.Pieces; p == nil || p.Size != 2048 || len(p.Sums) != 2 ||
+			hex.EncodeToString(p.Sums[1]) != strings.Repeat("02", 32) {
+			t.Errorf("expected sha256 piece checksums, got: %v", p)
+		}
+		if len(f.URLs) < 2 || f.URLs[0].Host != "us.example.com" || f.URLs[1].Host != "de.example.com" {
+			t.Errorf("expected URLs in order of priority, got: %v", f.URLs)
+		}
+		for _, u := range f.URLs {
+			if u.Scheme == "ftp" {
+				t.Errorf("expected unsupported URL to be ignored: %v", u)
+			}
+		}
+	}
+
+	if _, err := ParseMetalink(strings.NewReader(`<metalink><file><url>http://example.com/</url></file></metalink>`)); err == nil {
+		t.Errorf("expected error for file without name")
+	}
+}
+
+// TestMetalinkRequests tests that files described by a Metalink are
+// downloaded from their mirrors and validated.
+func TestMetalinkRequests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	sum := sha256.Sum256(content)
+	doc := fmt.Sprintf(`<metalink xmlns="urn:ietf:params:xml:ns:metalink">
+  <file name="dir/example">
+    <size>4096</size>
+    <hash type="sha-256">%x</hash>
+    <url priority="1">%s?status=404</url>
+    <url priority="2">%s?size=4096</url>
+  </file>
+</metalink>`, sum, ts.URL, ts.URL)
+
+	m, err := ParseMetalink(strings.NewReader(doc))
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	reqs, err := m.Requests(dir, true)
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	resp := DefaultClient.Do(reqs[0])
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if resp.Filename != filepath.Join(dir, "dir", "example") {
+		t.Errorf("unexpected filename: %s", resp.Filename)
+	}
+	if len(resp.Checksums) != 1 || !resp.Checksums[0].OK() {
+		t.Errorf("expected checksum to be validated")
+	}
+	if b, _ := ioutil.ReadFile(resp.Filename); !bytes.Equal(b, content) {
+		t.Errorf("unexpected content")
+	}
+
+	m.Files[0].Name = "../evil"
+	if _, err := m.Requests(dir, true); err == nil {
+		t.Errorf("expected error for unsafe file name")
+	}
+}
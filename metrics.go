@@ -0,0 +1,239 @@
+package grab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultThroughputBuckets are the upper bounds, in bytes per second, of the
+// buckets of the throughput histogram of Metrics.
+var DefaultThroughputBuckets = []float64{
+	64 << 10,
+	256 << 10,
+	1 << 20,
+	4 << 20,
+	16 << 20,
+	64 << 20,
+	256 << 20,
+	1 << 30,
+}
+
+// Failure classes of Metrics.
+const (
+	FailureNetwork    = "network"
+	FailureValidation = "validation"
+	FailureFilesystem = "filesystem"
+	FailureCanceled   = "canceled"
+	FailureOther      = "other"
+)
+
+// Metrics records metrics of the file transfers of a Client, for monitoring
+// the health of downloads. To record metrics, set Client.Metrics to a Metrics
+// created with NewMetrics. A Metrics may be shared by multiple clients.
+//
+// Metrics implements http.Handler, serving the metrics in the Prometheus text
+// exposition format so that they can be scraped without any other
+// dependencies:
+//
+//	client.Metrics = grab.NewMetrics()
+//	http.Handle("/metrics", client.Metrics)
+//
+// To register the metrics with an existing Prometheus registry, implement a
+// prometheus.Collector which reports the values of Snapshot as const metrics.
+//
+// All Metrics method calls are thread-safe.
+type Metrics struct {
+	mu              sync.Mutex
+	bytes           int64
+	active          int64
+	completed       int64
+	failures        map[string]int64
+	buckets         []float64
+	bucketCounts    []uint64
+	throughputSum   float64
+	throughputCount uint64
+}
+
+// MetricsSnapshot is a snapshot of the values of Metrics.
+type MetricsSnapshot struct {
+	// BytesDownloaded is the total number of bytes transferred from remote
+	// servers, by every attempt of every transfer, excluding resumed bytes.
+	BytesDownloaded int64
+
+	// ActiveTransfers is the number of transfers in progress.
+	ActiveTransfers int64
+
+	// Completed is the number of transfers which completed successfully.
+	Completed int64
+
+	// Failures is the number of failed transfers by failure class, which is
+	// one of FailureNetwork, FailureValidation, FailureFilesystem,
+	// FailureCanceled or FailureOther.
+	Failures map[string]int64
+
+	// ThroughputBuckets are the upper bounds of the buckets of the histogram
+	// of the average throughput of each successful transfer, in bytes per
+	// second. ThroughputCounts are the cumulative number of transfers in each
+	// bucket.
+	ThroughputBuckets []float64
+	ThroughputCounts  []uint64
+
+	// ThroughputSum and ThroughputCount are the sum and number of all
+	// throughput observations.
+	ThroughputSum   float64
+	ThroughputCount uint64
+}
+
+// NewMetrics returns a new Metrics using DefaultThroughputBuckets.
+func NewMetrics() *Metrics {
+	return NewMetricsWithBuckets(DefaultThroughputBuckets)
+}
+
+// NewMetricsWithBuckets returns a new Metrics with the given upper bounds of
+// the buckets of the throughput histogram, in bytes per second.
+func NewMetricsWithBuckets(buckets []float64) *Metrics {
+	b := append([]float64(nil), buckets...)
+	sort.Float64s(b)
+	return &Metrics{
+		failures:     make(map[string]int64),
+		buckets:      b,
+		bucketCounts: make([]uint64, len(b)),
+	}
+}
+
+// Snapshot returns the current values of the metrics.
+func (c *Metrics) Snapshot() MetricsSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := MetricsSnapshot{
+		BytesDownloaded:   c.bytes,
+		ActiveTransfers:   c.active,
+		Completed:         c.completed,
+		Failures:          make(map[string]int64, len(c.failures)),
+		ThroughputBuckets: append([]float64(nil), c.buckets...),
+		ThroughputCounts:  make([]uint64, len(c.bucketCounts)),
+		ThroughputSum:     c.throughputSum,
+		ThroughputCount:   c.throughputCount,
+	}
+	for k, v := range c.failures {
+		s.Failures[k] = v
+	}
+	var n uint64
+	for i, v := range c.bucketCounts {
+		n += v
+		s.ThroughputCounts[i] = n
+	}
+	return s
+}
+
+// ServeHTTP serves the metrics in the Prometheus text exposition format.
+func (c *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	c.WriteTo(w)
+}
+
+// WriteTo writes the metrics to w in the Prometheus text exposition format.
+func (c *Metrics) WriteTo(w io.Writer) (n int64, err error) {
+	s := c.Snapshot()
+	printf := func(format string, a ...interface{}) {
+		if err != nil {
+			return
+		}
+		var m int
+		m, err = fmt.Fprintf(w, format, a...)
+		n += int64(m)
+	}
+	printf("# HELP grab_bytes_downloaded_total Total bytes transferred from remote servers.\n")
+	printf("# TYPE grab_bytes_downloaded_total counter\n")
+	printf("grab_bytes_downloaded_total %d\n", s.BytesDownloaded)
+	printf("# HELP grab_active_transfers Number of transfers in progress.\n")
+	printf("# TYPE grab_active_transfers gauge\n")
+	printf("grab_active_transfers %d\n", s.ActiveTransfers)
+	printf("# HELP grab_transfers_completed_total Number of transfers completed successfully.\n")
+	printf("# TYPE grab_transfers_completed_total counter\n")
+	printf("grab_transfers_completed_total %d\n", s.Completed)
+	printf("# HELP grab_transfer_failures_total Number of failed transfers by class.\n")
+	printf("# TYPE grab_transfer_failures_total counter\n")
+	for _, class := range []string{FailureNetwork, FailureValidation, FailureFilesystem, FailureCanceled, FailureOther} {
+		printf("grab_transfer_failures_total{class=%q} %d\n", class, s.Failures[class])
+	}
+	printf("# HELP grab_transfer_throughput_bytes_per_second Average throughput of successful transfers.\n")
+	printf("# TYPE grab_transfer_throughput_bytes_per_second histogram\n")
+	for i, le := range s.ThroughputBuckets {
+		printf("grab_transfer_throughput_bytes_per_second_bucket{le=%q} %d\n",
+			strconv.FormatFloat(le, 'g', -1, 64), s.ThroughputCounts[i])
+	}
+	printf("grab_transfer_throughput_bytes_per_second_bucket{le=\"+Inf\"} %d\n", s.ThroughputCount)
+	printf("grab_transfer_throughput_bytes_per_second_sum %s\n", strconv.FormatFloat(s.ThroughputSum, 'g', -1, 64))
+	printf("grab_transfer_throughput_bytes_per_second_count %d\n", s.ThroughputCount)
+	return
+}
+
+// transferStarted records the start of a transfer. Metrics methods used by the
+// Client are no-ops if c is nil.
+func (c *Metrics) transferStarted() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active++
+}
+
+// bytesDownloaded records bytes transferred by an attempt.
+func (c *Metrics) bytesDownloaded(n int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bytes += n
+}
+
+// transferFinished records the result of a finalized transfer.
+func (c *Metrics) transferFinished(resp *Response) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active--
+	if resp.err != nil {
+		c.failures[failureClass(resp.err)]++
+		return
+	}
+	c.completed++
+	n := resp.bytesTransferred()
+	d := resp.End.Sub(resp.Start).Seconds()
+	if n == 0 || d <= 0 {
+		// the file was already complete
+		return
+	}
+	bps := float64(n) / d
+	c.throughputSum += bps
+	c.throughputCount++
+	if i := sort.SearchFloat64s(c.buckets, bps); i < len(c.buckets) {
+		c.bucketCounts[i]++
+	}
+}
+
+// failureClass returns the failure class of the given error.
+func failureClass(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return FailureCanceled
+	case errors.Is(err, ErrNetwork):
+		return FailureNetwork
+	case errors.Is(err, ErrValidation):
+		return FailureValidation
+	case errors.Is(err, ErrFilesystem):
+		return FailureFilesystem
+	}
+	return FailureOther
+}
@@ -0,0 +1,73 @@
+package grab
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestMetrics tests that the metrics of a Client are recorded and served in
+// the Prometheus text format.
+func TestMetrics(t *testing.T) {
+	filename := ".testMetrics"
+	defer os.Remove(filename)
+
+	client := NewClient()
+	client.Metrics = NewMetrics()
+
+	req, _ := NewRequest(filename, ts.URL+"?size=4096")
+	testComplete(t, client.Do(req))
+
+	req, _ = NewRequest(filename+"404", ts.URL+"?status=404")
+	client.Do(req).Wait()
+
+	req, _ = NewRequest(filename, ts.URL+"?size=4096")
+	req.SetChecksum(sha256.New(), []byte{0}, false)
+	client.Do(req).Wait()
+
+	s := client.Metrics.Snapshot()
+	if s.BytesDownloaded != 4096 {
+		t.Errorf("expected 4096 bytes downloaded, got: %d", s.BytesDownloaded)
+	}
+	if s.ActiveTransfers != 0 {
+		t.Errorf("expected no active transfers, got: %d", s.ActiveTransfers)
+	}
+	if s.Completed != 1 {
+		t.Errorf("expected 1 completed transfer, got: %d", s.Completed)
+	}
+	if s.Failures[FailureNetwork] != 1 || s.Failures[FailureValidation] != 1 {
+		t.Errorf("expected network and validation failures, got: %v", s.Failures)
+	}
+	if s.ThroughputCount != 1 || s.ThroughputCounts[len(s.ThroughputCounts)-1] > 1 {
+		t.Errorf("expected one throughput observation, got: %d", s.ThroughputCount)
+	}
+
+	w := httptest.NewRecorder()
+	client.Metrics.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		"grab_bytes_downloaded_total 4096",
+		"grab_active_transfers 0",
+		"grab_transfers_completed_total 1",
+		`grab_transfer_failures_total{class="network"} 1`,
+		`grab_transfer_failures_total{class="validation"} 1`,
+		`grab_transfer_throughput_bytes_per_second_bucket{le="+Inf"} 1`,
+		"grab_transfer_throughput_bytes_per_second_count 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected metrics to contain %q", line)
+		}
+	}
+
+	var buf bytes.Buffer
+	if n, err := client.Metrics.WriteTo(&buf); err != nil || n != int64(buf.Len()) || buf.String() != body {
+		t.Errorf("expected WriteTo to write %d bytes, got: %d, %v", buf.Len(), n, err)
+	}
+}
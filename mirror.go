@@ -0,0 +1,232 @@
+package grab
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// urls returns the URL of the Request, followed by the URLs of any mirrors.
+func (r *Request) urls() []*url.URL {
+	urls := make([]*url.URL, 0, len(r.Mirrors)+1)
+	urls = append(urls, r.URL())
+	return append(urls, r.Mirrors...)
+}
+
+// mirrorRequest returns a copy of the HTTP request of the Response for the
+// given mirror URL, with the header of the request when the transfer started.
+// Any Range or If-Range header set by a previous attempt is removed. As with
+// redirects followed by net/http, credentials and cookies are not sent to
+// mirrors other than the original host and its subdomains.
+func (c *Response) mirrorRequest(u *url.URL) *http.Request {
+	req := c.Request.HTTPRequest
+	hreq := new(http.Request)
+	*hreq = *req
+	hreq.URL = u
+	hreq.Host = ""
+	header := c.header
+	if header == nil {
+		header = req.Header
+	}
+	hreq.Header = cloneHeader(header)
+	hreq.Header.Del("Range")
+	hreq.Header.Del("If-Range")
+	if !sameOrSubdomain(u.Hostname(), c.origin) {
+		for _, k := range sensitiveHeaders {
+			hreq.Header.Del(k)
+		}
+	}
+	return hreq
+}
+
+// sensitiveHeaders are the headers which are removed from requests to mirrors
+// on other hosts.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Cookie2",
+	"Proxy-Authorization",
+	"Www-Authenticate",
+}
+
+// sameOrSubdomain returns true if the given host is the given origin host or
+// a subdomain of it.
+func sameOrSubdomain(host, origin string) bool {
+	host, origin = strings.ToLower(host), strings.ToLower(origin)
+	return host == origin || strings.HasSuffix(host, "."+origin)
+}
+
+// validators identify the version of a remote file using the size and
+// validator headers returned by the remote server.
+type validators struct {
+	url          string
+	size         int64
+	etag         string
+	lastModified string
+}
+
+// newValidators returns the validators of the remote file for the given
+// Response.
+func newValidators(resp *Response) *validators {
+	v := &validators{size: resp.Size}
+	if u := resp.Request.URL(); u != nil {
+		v.url = u.String()
+	}
+	if resp.HTTPResponse != nil {
+		v.etag = resp.HTTPResponse.Header.Get("ETag")
+		v.lastModified = resp.HTTPResponse.Header.Get("Last-Modified")
+	}
+	return v
+}
+
+// matches returns true if the remote file of the given Response has the same
+// size as the remote file identified by c and at least one matching validator
+// header, with no conflicting validators. If the remote file has the same URL,
+// such as when a transfer is retried, no matching validator is required.
+func (c *validators) matches(resp *Response) bool {
+	v := newValidators(resp)
+	if v.size != c.size {
+		return false
+	}
+	match := v.url == c.url
+	for _, pair := range [][2]string{
+		{c.etag, v.etag},
+		{c.lastModified, v.lastModified},
+	} {
+		if pair[0] == "" || pair[1] == "" {
+			continue
+		}
+		if pair[0] != pair[1] {
+			return false
+		}
+		match = true
+	}
+	return match
+}
+
+// ifRange returns the value of an If-Range header which ensures that a ranged
+// request is only honored if the remote file is unchanged, or an empty string
+// if no strong validator is known.
+func (c *validators) ifRange() string {
+	if c.etag != "" && !strings.HasPrefix(c.etag, "W/") {
+		return c.etag
+	}
+	return c.lastModified
+}
+
+// nextMirror is called when a transfer fails because of an error from the
+// remote server, or while communicating with it.
+//
+// If another mirror is available and the Response has not been canceled, the
+// Response is reset for a new attempt using the next mirror and the next
+// stateFunc is statFileInfo.
+//
+// If no more mirrors are available, the next stateFunc is retry. If the
+// Response has been canceled, or the error was returned by a RequestHook or
+// ResponseHook, the next stateFunc is closeResponse.
+func (c *Client) nextMirror(resp *Response) stateFunc {
+	if resp.ctx.Err() != nil {
+		return c.closeResponse
+	}
+	if err, ok := unwrapHookError(resp.err); ok {
+		resp.err = err
+		return c.closeResponse
+	}
+	if resp.attemptTimedOut() {
+		resp.err = ErrAttemptTimeout
+	}
+	c.recordCircuit(resp, resp.err)
+	if resp.mirror+1 >= len(resp.mirrors) {
+		return c.retry
+	}
+	resp.mirror++
+	c.logf(resp, slog.LevelInfo, "trying next mirror",
+		"mirror", resp.mirrors[resp.mirror].String(),
+		"error", resp.err)
+	c.reset(resp, resp.mirrorRequest(resp.mirrors[resp.mirror]))
+	return c.statFileInfo
+}
+
+// reset discards the state of a failed attempt to transfer a file so that it
+// can be attempted again using the given http.Request. The destination
+// filename and any progress written to the destination file are preserved.
+func (c *Client) reset(resp *Response, req *http.Request) {
+	closeWriter(resp)
+	resp.closeResponseBody()
+	resp.newAttempt()
+	resp.Request.HTTPRequest = req
+	resp.HTTPResponse = nil
+	resp.err = nil
+	resp.fi = nil
+	resp.state = nil
+	resp.segments = nil
+	resp.hasher = nil
+	resp.hashStates = nil
+	resp.optionsKnown = false
+	resp.committed = false
+	resp.conditional = nil
+	resp.goodPieces = nil
+	resp.deltaTried = false
+	resp.CanResume = false
+	resp.DidResume = false
+	resp.DidResumeState = false
+	resp.Size = 0
+	resp.ContentEncoding = ""
+	resp.EncodedSize = 0
+	resp.progressMu.Lock()
+	resp.encoded = nil
+	resp.bytesResumed = 0
+	resp.transfer = nil
+	resp.progressMu.Unlock()
+}
+
+// probeMirrors sends a HEAD request to the URL of the Request for the given
+// Response and to each of its mirrors, concurrently. The URLs are returned in
+// order of response latency. URLs which fail to respond successfully are
+// returned last, in their original order.
+func (c *Client) probeMirrors(resp *Response) []*url.URL {
+	type probe struct {
+		index int
+		d     time.Duration
+		ok    bool
+	}
+	urls := resp.mirrors
+	probes := make([]probe, len(urls))
+	wg := sync.WaitGroup{}
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u *url.URL) {
+			defer wg.Done()
+			hreq := resp.mirrorRequest(u).WithContext(resp.ctx)
+			hreq.Method = "HEAD"
+			start := time.Now()
+			hresp, err := c.doTransferRequest(resp, hreq)
+			probes[i] = probe{index: i, d: time.Since(start)}
+			if err != nil {
+				return
+			}
+			hresp.Body.Close()
+			probes[i].ok = hresp.StatusCode >= 200 && hresp.StatusCode <= 299
+		}(i, u)
+	}
+	wg.Wait()
+
+	sort.SliceStable(probes, func(i, j int) bool {
+		if probes[i].ok != probes[j].ok {
+			return probes[i].ok
+		}
+		if !probes[i].ok {
+			return false
+		}
+		return probes[i].d < probes[j].d
+	})
+	sorted := make([]*url.URL, len(urls))
+	for i, p := range probes {
+		sorted[i] = urls[p.index]
+	}
+	return sorted
+}
@@ -0,0 +1,127 @@
+package grab
+
+import (
+	"sort"
+	"unicode/utf8"
+)
+
+// Hangul syllables are composed and decomposed algorithmically, as specified
+// by section 3.12 of the Unicode Standard.
+const (
+	hangulSBase  = 0xAC00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11A7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+	hangulNCount = hangulVCount * hangulTCount
+	hangulSCount = hangulLCount * hangulNCount
+)
+
+// nfcCompositions maps each pair of characters to its primary composite.
+var nfcCompositions = func() map[[2]rune]rune {
+	m := make(map[[2]rune]rune, len(nfcDecompositions))
+	for r, d := range nfcDecompositions {
+		if d[1] != 0 && !nfcExclusions[r] {
+			m[d] = r
+		}
+	}
+	return m
+}()
+
+// combiningClass returns the canonical combining class of the given
+// character.
+func combiningClass(r rune) uint8 {
+	i := sort.Search(len(nfcCombiningClasses), func(i int) bool {
+		return nfcCombiningClasses[i].hi >= r
+	})
+	if i < len(nfcCombiningClasses) && nfcCombiningClasses[i].lo <= r {
+		return nfcCombiningClasses[i].ccc
+	}
+	return 0
+}
+
+// decompose appends the full canonical decomposition of the given character
+// to b.
+func decompose(b []rune, r rune) []rune {
+	if s := r - hangulSBase; s >= 0 && s < hangulSCount {
+		b = append(b, hangulLBase+s/hangulNCount, hangulVBase+(s%hangulNCount)/hangulTCount)
+		if t := s % hangulTCount; t != 0 {
+			b = append(b, hangulTBase+t)
+		}
+		return b
+	}
+	d, ok := nfcDecompositions[r]
+	if !ok {
+		return append(b, r)
+	}
+	b = decompose(b, d[0])
+	if d[1] != 0 {
+		b = decompose(b, d[1])
+	}
+	return b
+}
+
+// compose returns the primary composite of the given pair of characters, if
+// any.
+func compose(a, b rune) (rune, bool) {
+	if l, v := a-hangulLBase, b-hangulVBase; l >= 0 && l < hangulLCount && v >= 0 && v < hangulVCount {
+		return hangulSBase + (l*hangulVCount+v)*hangulTCount, true
+	}
+	if s, t := a-hangulSBase, b-hangulTBase; s >= 0 && s < hangulSCount && s%hangulTCount == 0 && t > 0 && t < hangulTCount {
+		return a + t, true
+	}
+	r, ok := nfcCompositions[[2]rune{a, b}]
+	return r, ok
+}
+
+// normalizeNFC returns the given string in Unicode Normalization Form C, so
+// that filenames which are decomposed, as by macOS, and composed, as by most
+// other systems, are byte-identical. Invalid UTF-8 is left unchanged.
+func normalizeNFC(s string) string {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii || !utf8.ValidString(s) {
+		return s
+	}
+
+	// decompose and reorder combining marks by their combining class
+	var b []rune
+	for _, r := range s {
+		b = decompose(b, r)
+	}
+	for i := 1; i < len(b); i++ {
+		for j := i; j > 0; j-- {
+			c := combiningClass(b[j])
+			if c == 0 || combiningClass(b[j-1]) <= c {
+				break
+			}
+			b[j], b[j-1] = b[j-1], b[j]
+		}
+	}
+
+	// compose each character with the last starter, unless blocked
+	starter, last := -1, uint8(0)
+	out := b[:0]
+	for _, r := range b {
+		c := combiningClass(r)
+		if starter >= 0 && (last < c || last == 0 && len(out)-1 == starter) {
+			if p, ok := compose(out[starter], r); ok {
+				out[starter] = p
+				continue
+			}
+		}
+		if c == 0 {
+			starter = len(out)
+		}
+		last = c
+		out = append(out, r)
+	}
+	return string(out)
+}
@@ -0,0 +1,65 @@
+package grab
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNormalizeNFC(t *testing.T) {
+	tests := map[string]string{
+		"example.zip":            "example.zip",
+		"caf\u00e9.txt":          "caf\u00e9.txt",
+		"cafe\u0301.txt":         "caf\u00e9.txt",
+		"\u304b\u3099":           "\u304c",       // Japanese kana with dakuten
+		"\u1100\u1161\u11a8":     "\uac01",       // Hangul jamo
+		"a\u0323\u0302":          "\u1ead",       // marks in canonical order
+		"a\u0302\u0323":          "\u1ead",       // marks in reverse order
+		"\u212b":                 "\u00c5",       // singleton decomposition
+		"\u0915\u093c":           "\u0915\u093c", // composition exclusion
+		"\ufb01":                 "\ufb01",       // compatibility ligatures are kept
+		"o\u0303\u0308":          "\u1e4f",
+		"invalid\xffe\u0301.txt": "invalid\xffe\u0301.txt",
+	}
+	for s, expect := range tests {
+		if actual := normalizeNFC(s); actual != expect {
+			t.Errorf("%+q: expected %+q, got: %+q", s, expect, actual)
+		}
+	}
+}
+
+// TestNormalizeFilename tests that filenames given by remote servers are
+// normalized to NFC unless Request.NoNormalizeFilename is set.
+func TestNormalizeFilename(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", "attachment; filename*=UTF-8''cafe%CC%81.txt")
+		w.Write([]byte("content"))
+	}))
+	defer s.Close()
+
+	for _, noNormalize := range []bool{false, true} {
+		req, _ := NewRequest(dir, s.URL)
+		req.NoNormalizeFilename = noNormalize
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		expect := "café.txt"
+		if noNormalize {
+			expect = "café.txt"
+		}
+		if resp.Filename != filepath.Join(dir, expect) {
+			t.Errorf("expected %+q, got: %+q", expect, filepath.Base(resp.Filename))
+		}
+		os.Remove(resp.Filename)
+	}
+}
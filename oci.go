@@ -0,0 +1,339 @@
+package grab
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OCIConfig configures the transfer of blobs at oci://registry/repository
+// URLs from OCI and Docker registries, such as ghcr.io or Amazon ECR. See
+// Client.OCI.
+type OCIConfig struct {
+	// Credentials, if not nil, returns the username and password used to
+	// authenticate with the given registry host, such as a GitHub user and
+	// access token for ghcr.io. If nil, or if the returned username and
+	// password are empty, registries are accessed anonymously.
+	Credentials func(ctx context.Context, host string) (username, password string, err error)
+
+	// PlainHTTP specifies that registries are accessed with HTTP rather than
+	// HTTPS, as for local development registries.
+	PlainHTTP bool
+}
+
+// ociReference is the parsed reference of an oci:// URL.
+type ociReference struct {
+	host, repository string
+
+	// digest is the digest of the blob, such as "sha256:<hex>", if the URL
+	// refers to a blob directly. Otherwise, the blob is the layer of the
+	// manifest with the given tag.
+	digest, tag string
+
+	// title selects the layer of a manifest with more than one layer by its
+	// org.opencontainers.image.title annotation.
+	title string
+}
+
+// parseOCIReference parses an oci:// URL, such as
+// oci://ghcr.io/owner/repo@sha256:<hex> for a blob, or
+// oci://ghcr.io/owner/repo:v1.0?title=tool-linux-amd64 for a layer of the
+// manifest tagged v1.0. The tag defaults to "latest".
+func parseOCIReference(u *url.URL) (*ociReference, error) {
+	ref := &ociReference{host: u.Host, title: u.Query().Get("title")}
+	name := strings.TrimPrefix(u.Path, "/")
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.digest = name[:i], name[i+1:]
+		if h, _ := ociHash(ref.digest); h == nil {
+			return nil, fmt.Errorf("unsupported OCI digest: %s", ref.digest)
+		}
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.tag = name[:i], name[i+1:]
+	} else {
+		ref.tag = "latest"
+	}
+	if ref.host == "" || name == "" || ref.tag == "" && ref.digest == "" {
+		return nil, fmt.Errorf("malformed OCI URL: %s", u)
+	}
+	ref.repository = name
+	return ref, nil
+}
+
+// ociHash returns a new hash.Hash for the algorithm of the given digest, and
+// the expected sum, or nil if the digest is malformed or not supported.
+func ociHash(digest string) (h hash.Hash, sum []byte) {
+	alg, v, _ := strings.Cut(digest, ":")
+	switch alg {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, nil
+	}
+	sum, err := hex.DecodeString(v)
+	if err != nil || len(sum) != h.Size() {
+		return nil, nil
+	}
+	return h, sum
+}
+
+// ociDescriptor is the descriptor of a layer in an image manifest.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// ociManifest is an OCI image manifest, or a Docker image manifest v2.
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+
+	// Manifests is set for image indexes, which are not supported.
+	Manifests []json.RawMessage `json:"manifests"`
+}
+
+// ociManifestTypes are the media types of the manifests accepted when
+// resolving a tag.
+var ociManifestTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ociRoundTrip sends the given request for an oci:// URL to the registry. The
+// digest of the blob, from the URL or from its descriptor in the manifest, is
+// given in the Docker-Content-Digest header of the response for validation.
+func ociRoundTrip(c *Client, req *http.Request) (*http.Response, error) {
+	config := c.OCI
+	if config == nil {
+		config = &OCIConfig{}
+	}
+	ref, err := parseOCIReference(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	scheme := "https"
+	if config.PlainHTTP {
+		scheme = "http"
+	}
+	base := scheme + "://" + ref.host + "/v2/" + ref.repository
+	var title string
+	if ref.digest == "" {
+		layer, err := c.ociLayer(req.Context(), config, ref, base)
+		if err != nil {
+			return nil, err
+		}
+		ref.digest = layer.Digest
+		title = layer.Annotations["org.opencontainers.image.title"]
+	}
+
+	u, err := url.Parse(base + "/blobs/" + ref.digest)
+	if err != nil {
+		return nil, err
+	}
+	hreq := req.Clone(req.Context())
+	hreq.URL = u
+	hreq.Host = u.Host
+	hresp, err := c.ociDo(hreq, config, ref)
+	if err != nil {
+		return nil, err
+	}
+	hresp.Header.Set("Docker-Content-Digest", ref.digest)
+	if title != "" && hresp.Header.Get("Content-Disposition") == "" {
+		hresp.Header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": title}))
+	}
+	return hresp, nil
+}
+
+// ociLayer resolves the tag of the given reference to the descriptor of a
+// layer of its manifest. The manifest must have a single layer, or a layer
+// with the title of the reference.
+func (c *Client) ociLayer(ctx context.Context, config *OCIConfig, ref *ociReference, base string) (*ociDescriptor, error) {
+	hreq, err := http.NewRequest("GET", base+"/manifests/"+ref.tag, nil)
+	if err != nil {
+		return nil, err
+	}
+	hreq = hreq.WithContext(ctx)
+	hreq.Header.Set("Accept", strings.Join(ociManifestTypes, ", "))
+	if c.UserAgent != "" {
+		hreq.Header.Set("User-Agent", c.UserAgent)
+	}
+	hresp, err := c.ociDo(hreq, config, ref)
+	if err != nil {
+		return nil, err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+		return nil, StatusCodeError(hresp.StatusCode)
+	}
+	var m ociManifest
+	if err := json.NewDecoder(io.LimitReader(hresp.Body, 4<<20)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("error reading OCI manifest: %w", err)
+	}
+	if len(m.Manifests) > 0 {
+		return nil, fmt.Errorf("OCI image indexes are not supported: %s:%s", ref.repository, ref.tag)
+	}
+	var layer *ociDescriptor
+	for i, l := range m.Layers {
+		if ref.title != "" && l.Annotations["org.opencontainers.image.title"] != ref.title {
+			continue
+		}
+		if layer != nil {
+			return nil, fmt.Errorf("OCI manifest has more than one layer: %s:%s", ref.repository, ref.tag)
+		}
+		layer = &m.Layers[i]
+	}
+	if layer == nil {
+		return nil, fmt.Errorf("no matching layer in OCI manifest: %s:%s", ref.repository, ref.tag)
+	}
+	if h, _ := ociHash(layer.Digest); h == nil {
+		return nil, fmt.Errorf("unsupported OCI digest: %s", layer.Digest)
+	}
+	return layer, nil
+}
+
+// ociDo sends the given request to a registry, authenticating as challenged
+// by the registry with a 401 Unauthorized response. Bearer tokens are issued
+// by the token service of the registry, for pulls from the repository of the
+// given reference, and are reused by later requests of the Client.
+func (c *Client) ociDo(hreq *http.Request, config *OCIConfig, ref *ociReference) (*http.Response, error) {
+	key := ref.host + "/" + ref.repository
+	if token, ok := c.ociTokens.Load(key); ok {
+		hreq.Header.Set("Authorization", "Bearer "+token.(string))
+	}
+	hresp, err := c.sendHTTP(hreq)
+	if err != nil || hresp.StatusCode != http.StatusUnauthorized {
+		return hresp, err
+	}
+	scheme, params := parseChallenge(hresp.Header.Get("WWW-Authenticate"))
+	var username, password string
+	if config.Credentials != nil {
+		username, password, err = config.Credentials(hreq.Context(), ref.host)
+		if err != nil {
+			hresp.Body.Close()
+			return nil, err
+		}
+	}
+	hreq = hreq.Clone(hreq.Context())
+	switch {
+	case scheme == "bearer" && params["realm"] != "":
+		hresp.Body.Close()
+		if params["scope"] == "" {
+			params["scope"] = "repository:" + ref.repository + ":pull"
+		}
+		token, err := c.ociToken(hreq, params, username, password)
+		if err != nil {
+			return nil, err
+		}
+		c.ociTokens.Store(key, token)
+		hreq.Header.Set("Authorization", "Bearer "+token)
+	case scheme == "basic" && username != "":
+		hresp.Body.Close()
+		hreq.SetBasicAuth(username, password)
+	default:
+		return hresp, nil
+	}
+	return c.sendHTTP(hreq)
+}
+
+// ociToken requests a bearer token from the token service given in the
+// parameters of a Bearer challenge, authenticating with the given username
+// and password, if any.
+func (c *Client) ociToken(req *http.Request, params map[string]string, username, password string) (string, error) {
+	u, err := url.Parse(params["realm"])
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	for _, k := range []string{"service", "scope"} {
+		if params[k] != "" {
+			q.Set(k, params[k])
+		}
+	}
+	u.RawQuery = q.Encode()
+	hreq, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	hreq = hreq.WithContext(req.Context())
+	hreq.Header.Set("User-Agent", req.Header.Get("User-Agent"))
+	if username != "" || password != "" {
+		hreq.SetBasicAuth(username, password)
+	}
+	hresp, err := c.sendHTTP(hreq)
+	if err != nil {
+		return "", err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error authenticating with OCI registry: %w", StatusCodeError(hresp.StatusCode))
+	}
+	var v struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(hresp.Body, 1<<20)).Decode(&v); err != nil {
+		return "", fmt.Errorf("error authenticating with OCI registry: %w", err)
+	}
+	if v.Token == "" {
+		v.Token = v.AccessToken
+	}
+	if v.Token == "" {
+		return "", fmt.Errorf("error authenticating with OCI registry: no token issued")
+	}
+	return v.Token, nil
+}
+
+// parseChallenge parses the lower-cased authentication scheme and the
+// parameters of a WWW-Authenticate header with a single challenge, such as
+// Bearer realm="https://ghcr.io/token",service="ghcr.io".
+func parseChallenge(v string) (scheme string, params map[string]string) {
+	params = make(map[string]string)
+	scheme, v, _ = strings.Cut(strings.TrimSpace(v), " ")
+	for {
+		v = strings.TrimLeft(v, " ,")
+		k, rest, ok := strings.Cut(v, "=")
+		if !ok {
+			break
+		}
+		k = strings.ToLower(strings.TrimSpace(k))
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			rest = rest[1:]
+			var b strings.Builder
+			for len(rest) > 0 && rest[0] != '"' {
+				if rest[0] == '\\' && len(rest) > 1 {
+					rest = rest[1:]
+				}
+				b.WriteByte(rest[0])
+				rest = rest[1:]
+			}
+			value, rest = b.String(), strings.TrimPrefix(rest, `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[k] = strings.TrimSpace(value)
+		v = rest
+	}
+	return strings.ToLower(scheme), params
+}
+
+// ociChecksum returns the checksum of a blob, given by its digest in the
+// Docker-Content-Digest header of the given response.
+func ociChecksum(hresp *http.Response) *Checksum {
+	h, sum := ociHash(hresp.Header.Get("Docker-Content-Digest"))
+	if h == nil {
+		return nil
+	}
+	return &Checksum{Hash: h, Expected: sum}
+}
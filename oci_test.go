@@ -0,0 +1,133 @@
+package grab
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestOCI tests that blobs and artifact layers are transferred from an OCI
+// registry after the token handshake and validated using their digest.
+func TestOCI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	bad := "sha256:" + strings.Repeat("00", 32)
+
+	var s *httptest.Server
+	var tokens int32
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			atomic.AddInt32(&tokens, 1)
+			if r.URL.Query().Get("scope") != "repository:owner/tool:pull" || r.URL.Query().Get("service") != "registry" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if user, pass, _ := r.BasicAuth(); user != "user" || pass != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": "t0ken"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer t0ken" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+s.URL+`/token",service="registry",scope="repository:owner/tool:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v2/owner/tool/manifests/v1":
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			json.NewEncoder(w).Encode(ociManifest{Layers: []ociDescriptor{
+				{Digest: digest, Size: 4096, Annotations: map[string]string{"org.opencontainers.image.title": "tool-linux"}},
+				{Digest: bad, Size: 4096, Annotations: map[string]string{"org.opencontainers.image.title": "tool-darwin"}},
+			}})
+		case "/v2/owner/tool/blobs/" + digest, "/v2/owner/tool/blobs/" + bad:
+			http.ServeContent(w, r, "", time.Unix(0, 0), bytes.NewReader(content))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	client := NewClient()
+	client.OCI = &OCIConfig{
+		PlainHTTP: true,
+		Credentials: func(ctx context.Context, host string) (string, string, error) {
+			return "user", "secret", nil
+		},
+	}
+	host := strings.TrimPrefix(s.URL, "http://")
+	tests := []struct {
+		url      string
+		filename string
+		err      error
+	}{
+		{"oci://" + host + "/owner/tool@" + digest, "blob", nil},
+		{"oci://" + host + "/owner/tool:v1?title=tool-linux", "tool-linux", nil},
+		{"oci://" + host + "/owner/tool:v1?title=tool-darwin", "tool-darwin", ErrBadChecksum},
+		{"oci://" + host + "/owner/tool@" + bad, "bad", ErrBadChecksum},
+	}
+	for _, test := range tests {
+		filename := dir
+		if test.filename == "blob" || test.filename == "bad" {
+			filename = filepath.Join(dir, test.filename)
+		}
+		req, _ := NewRequest(filename, test.url)
+		resp := client.Do(req)
+		if err := resp.Err(); err != test.err {
+			t.Errorf("%s: expected error %v, got: %v", test.url, test.err, err)
+			continue
+		}
+		if resp.Filename != filepath.Join(dir, test.filename) {
+			t.Errorf("%s: expected filename %s, got: %s", test.url, test.filename, resp.Filename)
+		}
+		if test.err == nil {
+			testContent(t, resp.Filename, content)
+			if resp.Digest == nil || !resp.Digest.OK() {
+				t.Errorf("%s: expected digest to be validated", test.url)
+			}
+		}
+	}
+	if n := atomic.LoadInt32(&tokens); n != 1 {
+		t.Errorf("expected token to be reused, got %d token requests", n)
+	}
+
+	for _, u := range []string{
+		"oci://" + host + "/owner/tool:v1",
+		"oci://" + host + "/owner/tool:v2",
+		"oci://" + host + "/owner/tool@md5:00",
+	} {
+		req, _ := NewRequest(dir, u)
+		if err := client.Do(req).Err(); err == nil {
+			t.Errorf("%s: expected error", u)
+		}
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://ghcr.io/token", service="ghcr.io",scope="repository:a/b:pull,push",error=insufficient_scope`)
+	if scheme != "bearer" || params["realm"] != "https://ghcr.io/token" || params["service"] != "ghcr.io" || params["scope"] != "repository:a/b:pull,push" || params["error"] != "insufficient_scope" {
+		t.Errorf("unexpected challenge: %s %v", scheme, params)
+	}
+}
@@ -0,0 +1,130 @@
+package grab
+
+import (
+	"context"
+	"encoding/hex"
+	"hash"
+	"net/url"
+)
+
+// A RequestOption configures a Request created with NewRequestWithOptions.
+type RequestOption func(*Request) error
+
+// NewRequestWithOptions returns a new file transfer Request suitable for use
+// with Client.Do, configured by each of the given options in order. It returns
+// the first error returned by NewRequest or any of the options.
+//
+// Options are equivalent to setting fields or calling methods of the Request
+// returned by NewRequest, so both styles may be mixed.
+func NewRequestWithOptions(dst, urlStr string, opts ...RequestOption) (*Request, error) {
+	req, err := NewRequest(dst, urlStr)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		if err := opt(req); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// WithContext sets the Context of the Request. See Request.WithContext.
+func WithContext(ctx context.Context) RequestOption {
+	return func(r *Request) error {
+		if ctx == nil {
+			panic("nil context")
+		}
+		r.ctx = ctx
+		r.HTTPRequest = r.HTTPRequest.WithContext(ctx)
+		return nil
+	}
+}
+
+// WithHeader adds the given value to the HTTP header of the Request with the
+// given key.
+func WithHeader(key, value string) RequestOption {
+	return func(r *Request) error {
+		r.HTTPRequest.Header.Add(key, value)
+		return nil
+	}
+}
+
+// WithChecksum adds a checksum to validate the downloaded file. See
+// Request.AddChecksum.
+func WithChecksum(h hash.Hash, sum []byte, deleteOnError bool) RequestOption {
+	return func(r *Request) error {
+		r.AddChecksum(h, sum, deleteOnError)
+		return nil
+	}
+}
+
+// WithChecksumHex is like WithChecksum but accepts the expected checksum as a
+// hex encoded string, as published by most download sites.
+func WithChecksumHex(h hash.Hash, sum string, deleteOnError bool) RequestOption {
+	return func(r *Request) error {
+		b, err := hex.DecodeString(sum)
+		if err != nil {
+			return err
+		}
+		r.AddChecksum(h, b, deleteOnError)
+		return nil
+	}
+}
+
+// WithMirrors adds alternative URLs from which the file may be downloaded. See
+// Request.Mirrors.
+func WithMirrors(urls ...string) RequestOption {
+	return func(r *Request) error {
+		for _, s := range urls {
+			u, err := url.Parse(s)
+			if err != nil {
+				return err
+			}
+			r.Mirrors = append(r.Mirrors, u)
+		}
+		return nil
+	}
+}
+
+// WithSize sets the expected size of the file transfer. See Request.Size.
+func WithSize(size int64) RequestOption {
+	return func(r *Request) error {
+		r.Size = size
+		return nil
+	}
+}
+
+// WithRateLimit limits the transfer rate of the Request to the given number of
+// bytes per second, using a TokenBucket.
+func WithRateLimit(bytesPerSecond int) RequestOption {
+	return func(r *Request) error {
+		r.RateLimiter = NewTokenBucket(bytesPerSecond, 0)
+		return nil
+	}
+}
+
+// WithRetries sets the maximum number of attempts to transfer the file,
+// including the first attempt. If the Request has no RetryPolicy, a policy is
+// created using the default backoff and retryable errors. Otherwise, a copy
+// of the existing policy is modified.
+func WithRetries(maxAttempts int) RequestOption {
+	return func(r *Request) error {
+		p := &RetryPolicy{}
+		if r.RetryPolicy != nil {
+			*p = *r.RetryPolicy
+		}
+		p.MaxAttempts = maxAttempts
+		r.RetryPolicy = p
+		return nil
+	}
+}
+
+// WithPriority sets the priority of the Request in a batch. See
+// Request.SetPriority.
+func WithPriority(priority int) RequestOption {
+	return func(r *Request) error {
+		r.SetPriority(priority)
+		return nil
+	}
+}
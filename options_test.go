@@ -0,0 +1,76 @@
+package grab
+
+import (
+	"context"
+	"crypto/sha256"
+	"testing"
+	"time"
+)
+
+// TestNewRequestWithOptions tests that each RequestOption configures the
+// Request and that errors are returned.
+func TestNewRequestWithOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := NewRequestWithOptions(".testNewRequestWithOptions", "http://example.com/example.zip",
+		WithContext(ctx),
+		WithHeader("X-Test", "a"),
+		WithHeader("X-Test", "b"),
+		WithChecksumHex(sha256.New(), "c3ab8ff13720e8ad9047dd39466b3c8974e592c2fa383d4a3960714caef0c4f2", true),
+		WithMirrors("http://mirror.example.com/example.zip"),
+		WithSize(1024),
+		WithRateLimit(1024),
+		WithRetries(3),
+		WithPriority(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Context() != ctx || req.HTTPRequest.Context() != ctx {
+		t.Errorf("expected context to be set")
+	}
+	if v := req.HTTPRequest.Header["X-Test"]; len(v) != 2 {
+		t.Errorf("expected two header values, got: %v", v)
+	}
+	if len(req.checksums) != 1 || !req.deleteOnError || len(req.checksums[0].Expected) != sha256.Size {
+		t.Errorf("expected checksum to be set")
+	}
+	if len(req.Mirrors) != 1 || req.Mirrors[0].Host != "mirror.example.com" {
+		t.Errorf("expected mirror to be set, got: %v", req.Mirrors)
+	}
+	if req.Size != 1024 {
+		t.Errorf("expected size 1024, got: %d", req.Size)
+	}
+	if req.RateLimiter == nil {
+		t.Errorf("expected rate limiter to be set")
+	}
+	if req.RetryPolicy == nil || req.RetryPolicy.MaxAttempts != 3 {
+		t.Errorf("expected retry policy to be set")
+	}
+	if req.Priority() != 2 {
+		t.Errorf("expected priority 2, got: %d", req.Priority())
+	}
+
+	t.Run("WithRetries", func(t *testing.T) {
+		p := &RetryPolicy{MaxAttempts: 2, MaxRetryAfter: time.Second}
+		req, _ := NewRequest("", "http://example.com/example.zip")
+		req.RetryPolicy = p
+		if err := WithRetries(5)(req); err != nil {
+			t.Fatal(err)
+		}
+		if p.MaxAttempts != 2 {
+			t.Errorf("expected existing policy to be unchanged")
+		}
+		if req.RetryPolicy.MaxAttempts != 5 || req.RetryPolicy.MaxRetryAfter != time.Second {
+			t.Errorf("expected copy of existing policy, got: %+v", req.RetryPolicy)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		if _, err := NewRequestWithOptions("", "http://example.com/example.zip", WithChecksumHex(sha256.New(), "xyz", false)); err == nil {
+			t.Errorf("expected error for invalid hex checksum")
+		}
+		if _, err := NewRequestWithOptions("", "http://example.com/example.zip", WithMirrors(":")); err == nil {
+			t.Errorf("expected error for invalid mirror URL")
+		}
+	})
+}
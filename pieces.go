@@ -0,0 +1,171 @@
+package grab
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+// Pieces describes the expected checksums of consecutive, fixed-size pieces
+// of a file, as published in Metalink files and used by peer-to-peer
+// protocols. Piece checksums allow a resumed file to be verified before it is
+// resumed, and allow corrupt pieces of a completed file to be transferred
+// again individually, rather than transferring the entire file again.
+type Pieces struct {
+	// New returns a new hash.Hash of the algorithm used to compute the
+	// checksum of each piece, such as sha256.New.
+	New func() hash.Hash
+
+	// Size is the size in bytes of each piece. The last piece may be smaller.
+	Size int64
+
+	// Sums lists the expected checksum of each piece, in order.
+	Sums [][]byte
+}
+
+// count returns the number of pieces of a file of the given size.
+func (c *Pieces) count(size int64) int {
+	return int((size + c.Size - 1) / c.Size)
+}
+
+// verify returns true if the piece with the given index of the given file
+// matches its expected checksum. The given buffer is used to read the piece
+// and must be at least Pieces.Size bytes.
+func (c *Pieces) verify(f io.ReaderAt, i int, size int64, b []byte) (bool, error) {
+	off := int64(i) * c.Size
+	n := c.Size
+	if off+n > size {
+		n = size - off
+	}
+	if _, err := f.ReadAt(b[:n], off); err != nil {
+		return false, err
+	}
+	h := c.New()
+	h.Write(b[:n])
+	return bytes.Equal(h.Sum(nil), c.Sums[i]), nil
+}
+
+// hasPieces returns true if the piece checksums of the given Request apply to
+// its destination file.
+func (r *Request) hasPieces() bool {
+	return r.Pieces != nil && r.Pieces.Size > 0 && r.writer == nil && !r.hasRange()
+}
+
+// resumePieces verifies each complete piece of the existing destination file
+// of the given Response, up to the given offset, and returns the offset from
+// which the transfer should resume. The file is resumed from the end of the
+// last complete piece, so that the remainder of the file is transferred in
+// whole pieces. Pieces which fail verification are transferred again by
+// checkPieces once the transfer is complete.
+func (c *Client) resumePieces(resp *Response, offset int64) (int64, error) {
+	p := resp.Request.Pieces
+	n := int(offset / p.Size)
+	if n > len(p.Sums) {
+		return 0, ErrBadLength
+	}
+	f, err := os.Open(resp.path())
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	b := make([]byte, p.Size)
+	resp.goodPieces = make(map[int]bool)
+	for i := 0; i < n; i++ {
+		ok, err := p.verify(f, i, offset, b)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			resp.goodPieces[i] = true
+		}
+	}
+	c.logf(resp, slog.LevelDebug, "verified resumed pieces",
+		"pieces", n,
+		"good", len(resp.goodPieces))
+	aligned := int64(n) * p.Size
+	if aligned < offset {
+		if err := os.Truncate(resp.path(), aligned); err != nil {
+			return 0, err
+		}
+	}
+	return aligned, nil
+}
+
+// checkPieces verifies each piece of the destination file of the given
+// Response that was not already verified by resumePieces and transfers any
+// corrupt pieces again from the remote server. ErrBadChecksum is returned if a
+// piece remains corrupt once it is transferred again.
+func (c *Client) checkPieces(resp *Response) error {
+	p := resp.Request.Pieces
+	f, err := os.OpenFile(resp.path(), os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	size := fi.Size()
+	if p.count(size) != len(p.Sums) {
+		return ErrBadLength
+	}
+
+	b := make([]byte, p.Size)
+	for i := range p.Sums {
+		if resp.goodPieces[i] {
+			continue
+		}
+		ok, err := p.verify(f, i, size, b)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		c.logf(resp, slog.LevelInfo, "transferring corrupt piece again", "piece", i)
+		if err := c.getPiece(resp, f, i, size, b); err != nil {
+			return err
+		}
+		if ok, err = p.verify(f, i, size, b); err != nil {
+			return err
+		}
+		if !ok {
+			return ErrBadChecksum
+		}
+		resp.RepairedPieces = append(resp.RepairedPieces, i)
+	}
+	return nil
+}
+
+// getPiece transfers the piece with the given index of the file of the given
+// Response from the remote server and writes it to the given file.
+func (c *Client) getPiece(resp *Response, f io.WriterAt, i int, size int64, b []byte) error {
+	p := resp.Request.Pieces
+	off := int64(i) * p.Size
+	n := p.Size
+	if off+n > size {
+		n = size - off
+	}
+	req := resp.Request.HTTPRequest.WithContext(resp.ctx)
+	req.Header = cloneHeader(req.Header)
+	req.Header.Del("If-Range")
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	hresp, err := c.doTransferRequest(resp, req)
+	if err != nil {
+		return err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode != http.StatusPartialContent {
+		return ErrServerNoRange
+	}
+	if _, err := io.ReadFull(hresp.Body, b[:n]); err != nil {
+		return err
+	}
+	_, err = f.WriteAt(b[:n], off)
+	return err
+}
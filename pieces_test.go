@@ -0,0 +1,115 @@
+package grab
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// testPieces returns the piece checksums of the given content.
+func testPieces(content []byte, size int64) *Pieces {
+	p := &Pieces{New: sha256.New, Size: size}
+	for off := int64(0); off < int64(len(content)); off += size {
+		end := off + size
+		if end > int64(len(content)) {
+			end = int64(len(content))
+		}
+		sum := sha256.Sum256(content[off:end])
+		p.Sums = append(p.Sums, sum[:])
+	}
+	return p
+}
+
+// TestPieces tests that corrupt pieces are detected with Request.Pieces and
+// transferred again individually.
+func TestPieces(t *testing.T) {
+	filename := ".testPieces"
+	defer os.Remove(filename)
+
+	content := make([]byte, 4000)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	sum := sha256.Sum256(content)
+
+	// corrupt the second piece of complete transfers
+	var ranges []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if v := r.Header.Get("Range"); v != "" {
+			ranges = append(ranges, v)
+			ts.Config.Handler.ServeHTTP(w, r)
+			return
+		}
+		b := append([]byte(nil), content...)
+		b[1500] ^= 0xff
+		w.Write(b)
+	}))
+	defer s.Close()
+
+	t.Run("Repair", func(t *testing.T) {
+		ranges = nil
+		req, _ := NewRequest(filename, s.URL+"?size=4000")
+		req.Pieces = testPieces(content, 1024)
+		req.SetChecksum(sha256.New(), sum[:], false)
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if len(resp.RepairedPieces) != 1 || resp.RepairedPieces[0] != 1 {
+			t.Errorf("expected piece 1 to be repaired, got: %v", resp.RepairedPieces)
+		}
+		if len(ranges) != 1 || ranges[0] != "bytes=1024-2047" {
+			t.Errorf("expected one ranged request for piece 1, got: %v", ranges)
+		}
+		testContent(t, filename, content)
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		// an incomplete file with a corrupt first piece
+		b := append([]byte(nil), content[:2500]...)
+		b[10] ^= 0xff
+		if err := ioutil.WriteFile(filename, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+		req, _ := NewRequest(filename, ts.URL+"?size=4000")
+		req.Pieces = testPieces(content, 1024)
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if !resp.DidResume || resp.bytesResumed != 2048 {
+			t.Errorf("expected transfer to resume from 2048, got: %d", resp.bytesResumed)
+		}
+		if len(resp.RepairedPieces) != 1 || resp.RepairedPieces[0] != 0 {
+			t.Errorf("expected piece 0 to be repaired, got: %v", resp.RepairedPieces)
+		}
+		testContent(t, filename, content)
+	})
+
+	t.Run("BadPiece", func(t *testing.T) {
+		os.Remove(filename)
+		p := testPieces(content, 1024)
+		p.Sums[3] = make([]byte, sha256.Size)
+		req, _ := NewRequest(filename, ts.URL+"?size=4000")
+		req.Pieces = p
+		if err := DefaultClient.Do(req).Err(); err != ErrBadChecksum {
+			t.Errorf("expected ErrBadChecksum, got: %v", err)
+		}
+	})
+}
+
+// testContent fails the test if the given file does not have the given
+// content.
+func testContent(t *testing.T, filename string, expect []byte) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, expect) {
+		t.Errorf("unexpected content in %s", filename)
+	}
+}
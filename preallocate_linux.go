@@ -0,0 +1,29 @@
+package grab
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE.
+const fallocKeepSize = 0x1
+
+// preallocate allocates disk space for the given byte range of the given file,
+// without changing its size, so that a partially written file is never
+// mistaken for a complete one. File systems which do not support
+// preallocation are ignored. If there is not enough free space, ErrNoSpace is
+// returned.
+func preallocate(f *os.File, offset, n int64) error {
+	err := syscall.Fallocate(int(f.Fd()), fallocKeepSize, offset, n)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		return ErrNoSpace
+	}
+	if err != nil {
+		return &os.PathError{Op: "fallocate", Path: f.Name(), Err: err}
+	}
+	return nil
+}
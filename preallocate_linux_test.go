@@ -0,0 +1,44 @@
+package grab
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// TestPreallocate tests that disk space is allocated for the destination file
+// of a transfer with Request.Preallocate, without changing its size.
+func TestPreallocate(t *testing.T) {
+	filename := ".testPreallocate"
+	defer os.Remove(filename)
+
+	for _, segments := range []int{1, 4} {
+		req, _ := NewRequest(filename, ts.URL+"?size=1048576")
+		req.Preallocate = true
+		req.Segments = segments
+		var size, allocated int64
+		req.BeforeCopy = func(resp *Response) error {
+			fi, err := os.Stat(filename)
+			if err != nil {
+				return err
+			}
+			size, allocated = fi.Size(), fi.Sys().(*syscall.Stat_t).Blocks*512
+			return nil
+		}
+		resp := DefaultClient.Do(req)
+		testComplete(t, resp)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		os.Remove(filename)
+		if allocated == 0 {
+			t.Skip("file system does not support preallocation")
+		}
+		if size != 0 {
+			t.Errorf("expected size to be unchanged, got: %d", size)
+		}
+		if allocated < 1048576 {
+			t.Errorf("expected 1048576 bytes to be allocated, got: %d", allocated)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package grab
+
+import "os"
+
+// preallocate is not supported on this platform.
+func preallocate(f *os.File, offset, n int64) error {
+	return nil
+}
@@ -0,0 +1,57 @@
+package grab
+
+import "time"
+
+// defaultProgressInterval is the interval at which progress is reported to the
+// function given to Request.NotifyProgress, if Request.ProgressInterval is
+// zero.
+const defaultProgressInterval = time.Second
+
+// NotifyProgress sets a function to be called periodically with the number of
+// bytes of the file that have been written, including any resumed bytes, and
+// the total expected size of the file, while the file is transferring. It is
+// called at each Request.ProgressInterval and once more when each attempt to
+// transfer the file ends, so the final call of a successful transfer reports
+// the complete file.
+//
+// The function is called from a goroutine of the transfer, never concurrently,
+// and should return quickly. If the size of the file is unknown, total is
+// zero. To disable notifications, call NotifyProgress with nil.
+func (r *Request) NotifyProgress(f func(written, total int64)) {
+	r.progressFunc = f
+}
+
+// watchProgress calls the progress function of the Request at each
+// Request.ProgressInterval until the returned stop function is called, which
+// calls it a final time.
+func (c *Response) watchProgress() (stop func()) {
+	f := c.Request.progressFunc
+	if f == nil {
+		return func() {}
+	}
+	interval := c.Request.ProgressInterval
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				f(c.BytesComplete(), c.Size)
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		f(c.BytesComplete(), c.Size)
+	}
+}
@@ -0,0 +1,47 @@
+package grab
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestNotifyProgress tests that progress is reported periodically while a file
+// is transferring, and when the transfer completes.
+func TestNotifyProgress(t *testing.T) {
+	ts := slowServer(16384, 1024)
+	defer ts.Close()
+
+	filename := ".testNotifyProgress"
+	defer os.Remove(filename)
+
+	mu := sync.Mutex{}
+	calls := make([][2]int64, 0)
+	req, _ := NewRequest(filename, ts.URL)
+	req.ProgressInterval = 20 * time.Millisecond
+	req.NotifyProgress(func(written, total int64) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, [2]int64{written, total})
+	})
+	resp := DefaultClient.Do(req)
+	testComplete(t, resp)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) < 3 {
+		t.Fatalf("expected periodic progress notifications, got: %v", calls)
+	}
+	for i, c := range calls {
+		if c[1] != 16384 {
+			t.Errorf("expected total of 16384, got: %d", c[1])
+		}
+		if i > 0 && c[0] < calls[i-1][0] {
+			t.Errorf("expected progress to increase, got: %v", calls)
+		}
+	}
+	if last := calls[len(calls)-1]; last[0] != 16384 {
+		t.Errorf("expected final notification of complete file, got: %v", last)
+	}
+}
@@ -0,0 +1,195 @@
+package grab
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A QueueStore persists the state of a download queue started with
+// Client.StartQueue, so that pending and partially completed downloads
+// survive a restart of the process.
+//
+// Grab provides FileQueueStore, which stores the queue in a JSON file. Stores
+// backed by a database, such as BoltDB or SQLite, may be implemented by
+// serializing the BatchSnapshot to a single record.
+type QueueStore interface {
+	// Load returns the last saved snapshot of the queue. If the queue has never
+	// been saved, Load returns an empty snapshot and a nil error.
+	Load() (BatchSnapshot, error)
+
+	// Save replaces the saved snapshot of the queue.
+	Save(BatchSnapshot) error
+}
+
+// FileQueueStore is a QueueStore which stores the queue as JSON in the named
+// file. The file is replaced atomically each time the queue is saved.
+type FileQueueStore string
+
+// Load reads the queue from the file, if it exists.
+func (c FileQueueStore) Load() (BatchSnapshot, error) {
+	var s BatchSnapshot
+	b, err := ioutil.ReadFile(string(c))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+// Save writes the queue to a temporary file and renames it to replace the
+// file.
+func (c FileQueueStore) Save(s BatchSnapshot) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	filename := string(c)
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), filename); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// queueState is the persistence state of a BatchResponse started with
+// StartQueue.
+type queueState struct {
+	store QueueStore
+
+	// completed are the items which completed before the queue was loaded.
+	// They are saved again so that they remain skipped if requested again.
+	completed []BatchItem
+
+	mu  sync.Mutex // serializes calls to store.Save
+	err error
+}
+
+// StartQueue is like StartBatch, but the state of the queue is saved to the
+// given QueueStore as each transfer starts and completes. Any requests saved
+// by a previous process which did not complete successfully are loaded from
+// the store and started ahead of the given requests, resuming any partially
+// completed files.
+//
+// A given request which matches the URL and destination of a saved request is
+// not started again if the saved request completed. Otherwise, the given
+// request is started in place of the saved request, so that settings which are
+// not saved, such as checksums and HTTP headers, apply. A given request with a
+// directory as its Filename matches a saved request for a file in that
+// directory.
+//
+// StartQueue returns an error if the store cannot be loaded. Errors saving the
+// queue are returned by BatchResponse.Err.
+func (c *Client) StartQueue(store QueueStore, workers int, requests ...*Request) (*BatchResponse, error) {
+	snap, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	q := &queueState{store: store}
+	given := append([]*Request(nil), requests...)
+	pending := make([]*Request, 0, len(snap.Items)+len(requests))
+	for _, item := range snap.Items {
+		var req *Request
+		for i, r := range given {
+			if item.matches(r) {
+				req = r
+				given = append(given[:i], given[i+1:]...)
+				break
+			}
+		}
+		if item.State == BatchItemComplete {
+			q.completed = append(q.completed, item)
+			continue
+		}
+		if req == nil {
+			if req, err = item.request(); err != nil {
+				return nil, err
+			}
+		}
+		pending = append(pending, req)
+	}
+	pending = append(pending, given...)
+
+	b := newBatchResponse(pending)
+	b.store = q
+	b.save()
+	b.Responses = c.doBatch(b, workers, pending)
+	return b, nil
+}
+
+// matches returns true if the given Request transfers the URL of the item to
+// its destination. The Filename of a started item is the resolved destination
+// of its transfer, which matches a Request with the directory of the file as
+// its Filename.
+func (c BatchItem) matches(req *Request) bool {
+	if u := req.URL(); u == nil || u.String() != c.URL {
+		return false
+	}
+	if c.Filename == req.Filename {
+		return true
+	}
+	dir := req.Filename
+	if dir == "" {
+		dir = "."
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return false
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	filename, err := filepath.Abs(c.Filename)
+	return err == nil && (filename == dir || filepath.Dir(filename) == dir)
+}
+
+// save saves the current state of a batch started with StartQueue to its
+// QueueStore.
+func (c *BatchResponse) save() {
+	q := c.store
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	snap := c.Snapshot()
+	snap.Items = append(append([]BatchItem(nil), q.completed...), snap.Items...)
+	if err := q.store.Save(snap); err != nil && q.err == nil {
+		q.err = err
+	}
+}
+
+// Err blocks until every transfer in the batch is finalized and returns the
+// first error that occurred saving the queue to its QueueStore, if the batch
+// was started with StartQueue. Errors of each transfer are returned by the
+// Err method of each Response.
+func (c *BatchResponse) Err() error {
+	<-c.Done
+	if c.store == nil {
+		return nil
+	}
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	return c.store.err
+}
@@ -0,0 +1,144 @@
+package grab
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// TestStartQueue tests that a queue saved by a previous batch is reloaded,
+// skipping completed requests.
+func TestStartQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var available int32
+	var gets int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/flaky" && atomic.LoadInt32(&available) == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+		}
+		w.Header().Set("Content-Length", "1024")
+		w.Write(make([]byte, 1024))
+	}))
+	defer ts.Close()
+
+	store := FileQueueStore(filepath.Join(dir, "queue.json"))
+	newRequests := func() []*Request {
+		a, _ := NewRequest(filepath.Join(dir, "a"), ts.URL+"/a")
+		b, _ := NewRequest(filepath.Join(dir, "flaky"), ts.URL+"/flaky")
+		return []*Request{a, b}
+	}
+
+	b, err := DefaultClient.StartQueue(store, 1, newRequests()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Err(); err != nil {
+		t.Fatalf("error saving queue: %v", err)
+	}
+	snap, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snap.Items) != 2 || snap.Items[0].State != BatchItemComplete || snap.Items[1].State != BatchItemFailed {
+		t.Fatalf("unexpected saved queue: %+v", snap.Items)
+	}
+
+	// restart the queue with the same requests
+	atomic.StoreInt32(&available, 1)
+	atomic.StoreInt32(&gets, 0)
+	b, err = DefaultClient.StartQueue(store, 1, newRequests()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Requests) != 1 || b.Requests[0].URL().Path != "/flaky" {
+		t.Fatalf("expected only the failed request to be started, got: %d", len(b.Requests))
+	}
+	for resp := range b.Responses {
+		testComplete(t, resp)
+	}
+	if err := b.Err(); err != nil {
+		t.Fatalf("error saving queue: %v", err)
+	}
+	if n := atomic.LoadInt32(&gets); n != 1 {
+		t.Errorf("expected 1 GET request, got: %d", n)
+	}
+	snap, err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, item := range snap.Items {
+		if item.State != BatchItemComplete {
+			t.Errorf("expected %s to be complete, got: %s", item.URL, item.State)
+		}
+	}
+}
+
+// TestStartQueueDirectory tests that a saved request for a file is matched by
+// a given request for its directory, and that the given request is started in
+// its place.
+func TestStartQueueDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var gets, authorized int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+			if r.Header.Get("Authorization") == "Bearer token" {
+				atomic.AddInt32(&authorized, 1)
+			}
+		}
+		w.Header().Set("Content-Length", "1024")
+		w.Write(make([]byte, 1024))
+	}))
+	defer ts.Close()
+
+	store := FileQueueStore(filepath.Join(dir, "queue.json"))
+	err = store.Save(BatchSnapshot{Items: []BatchItem{{
+		URL:           ts.URL + "/probe.bin",
+		Filename:      filepath.Join(dir, "probe.bin"),
+		State:         BatchItemActive,
+		BytesComplete: 512,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "probe.bin"), make([]byte, 512), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := NewRequest(dir, ts.URL+"/probe.bin")
+	req.HTTPRequest.Header.Set("Authorization", "Bearer token")
+	b, err := DefaultClient.StartQueue(store, 2, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Requests) != 1 || b.Requests[0] != req {
+		t.Fatalf("expected only the given request to be started, got: %d", len(b.Requests))
+	}
+	for resp := range b.Responses {
+		testComplete(t, resp)
+	}
+	if n := atomic.LoadInt32(&gets); n != 1 {
+		t.Errorf("expected 1 GET request, got: %d", n)
+	}
+	if n := atomic.LoadInt32(&authorized); n != 1 {
+		t.Errorf("expected the headers of the given request to be sent")
+	}
+}
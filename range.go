@@ -0,0 +1,95 @@
+package grab
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// hasRange returns true if the Request specifies a range of bytes of the remote
+// file to transfer.
+func (r *Request) hasRange() bool {
+	return r.RangeOffset > 0 || r.RangeLength > 0
+}
+
+// requestRange sets the Range header of the Request for the given Response to
+// transfer the requested range of the remote file, following any bytes already
+// written to an existing destination file.
+//
+// If the existing file already contains the entire range, the next stateFunc
+// is checksumFile. Otherwise, the next stateFunc is getRequest.
+func (c *Client) requestRange(resp *Response) stateFunc {
+	req := resp.Request
+	var n int64
+	if resp.fi != nil && !req.NoResume {
+		n = resp.fi.Size()
+	}
+	if req.RangeLength > 0 {
+		if n > req.RangeLength {
+			resp.err = ErrBadLength
+			return c.closeResponse
+		}
+		if n == req.RangeLength {
+			resp.DidResume = true
+			resp.Size = n
+			resp.setBytesResumed(n)
+			return c.checksumFile
+		}
+	}
+
+	start := req.RangeOffset + n
+	if req.RangeLength > 0 {
+		req.HTTPRequest.Header.Set("Range",
+			fmt.Sprintf("bytes=%d-%d", start, req.RangeOffset+req.RangeLength-1))
+	} else {
+		req.HTTPRequest.Header.Set("Range", fmt.Sprintf("bytes=%d-", start))
+	}
+	req.HTTPRequest.Header.Del("If-Range")
+	resp.DidResume = n > 0
+	resp.setBytesResumed(n)
+	return c.getRequest
+}
+
+// checkContentRange returns an error if the remote server did not respond to
+// the ranged request of the given Response with the requested range.
+func checkContentRange(resp *Response) error {
+	req := resp.Request
+	if resp.HTTPResponse.StatusCode != http.StatusPartialContent {
+		return ErrServerNoRange
+	}
+	var start, end, size int64
+	if _, err := fmt.Sscanf(
+		resp.HTTPResponse.Header.Get("Content-Range"),
+		"bytes %d-%d/%d",
+		&start, &end, &size); err != nil {
+		return ErrServerNoRange
+	}
+	if start != req.RangeOffset+resp.bytesResumed {
+		return ErrServerNoRange
+	}
+	if req.RangeLength > 0 && end != req.RangeOffset+req.RangeLength-1 {
+		return ErrBadLength
+	}
+	return nil
+}
+
+// rangeEnded completes or fails the ranged transfer of the given Response
+// after the remote server responded with 416 Range Not Satisfiable. The
+// transfer is complete only if the remote file ends at the last byte already
+// written to the destination file of an open-ended range. The next stateFunc
+// is checksumFile or closeResponse.
+func (c *Client) rangeEnded(resp *Response) stateFunc {
+	resp.closeResponseBody()
+	var size int64
+	if _, err := fmt.Sscanf(
+		resp.HTTPResponse.Header.Get("Content-Range"),
+		"bytes */%d",
+		&size); err == nil &&
+		resp.Request.RangeLength == 0 &&
+		resp.bytesResumed > 0 &&
+		size == resp.Request.RangeOffset+resp.bytesResumed {
+		resp.Size = resp.bytesResumed
+		return c.checksumFile
+	}
+	resp.err = ErrBadLength
+	return c.closeResponse
+}
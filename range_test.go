@@ -0,0 +1,105 @@
+package grab
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestRequestRange tests that only the requested range of bytes of a remote
+// file is transferred.
+func TestRequestRange(t *testing.T) {
+	filename := ".testRequestRange"
+	defer os.Remove(filename)
+
+	// the content of the test server is byte(offset)
+	expect := func(offset, length int) []byte {
+		b := make([]byte, length)
+		for i := range b {
+			b[i] = byte(offset + i)
+		}
+		return b
+	}
+	testContent := func(b []byte) {
+		actual, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, b) {
+			t.Errorf("expected %d bytes of content, got: %d bytes", len(b), len(actual))
+		}
+	}
+
+	tests := []struct {
+		Name     string
+		Offset   int64
+		Length   int64
+		Existing int
+		Expect   []byte
+	}{
+		{"Range", 1000, 500, 0, expect(1000, 500)},
+		{"OpenEnded", 4000, 0, 0, expect(4000, 96)},
+		{"Resume", 1000, 500, 100, expect(1000, 500)},
+		{"ResumeOpenEnded", 4000, 0, 50, expect(4000, 96)},
+		{"Complete", 1000, 500, 500, expect(1000, 500)},
+		{"CompleteOpenEnded", 4000, 0, 96, expect(4000, 96)},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			os.Remove(filename)
+			if test.Existing > 0 {
+				if err := ioutil.WriteFile(filename, test.Expect[:test.Existing], 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			req, _ := NewRequest(filename, ts.URL+"?size=4096")
+			req.RangeOffset = test.Offset
+			req.RangeLength = test.Length
+			resp := DefaultClient.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if resp.Size != int64(len(test.Expect)) {
+				t.Errorf("expected size %d, got: %d", len(test.Expect), resp.Size)
+			}
+			if resp.DidResume != (test.Existing > 0) {
+				t.Errorf("expected DidResume to be %v", test.Existing > 0)
+			}
+			testContent(test.Expect)
+		})
+	}
+
+	t.Run("NoRange", func(t *testing.T) {
+		os.Remove(filename)
+		req, _ := NewRequest(filename, ts.URL+"?size=4096&ranged=false")
+		req.RangeOffset = 1000
+		if err := DefaultClient.Do(req).Err(); err != ErrServerNoRange {
+			t.Errorf("expected ErrServerNoRange, got: %v", err)
+		}
+	})
+
+	t.Run("BeyondEnd", func(t *testing.T) {
+		os.Remove(filename)
+		req, _ := NewRequest(filename, ts.URL+"?size=4096")
+		req.RangeOffset = 4000
+		req.RangeLength = 500
+		if err := DefaultClient.Do(req).Err(); err != ErrBadLength {
+			t.Errorf("expected ErrBadLength, got: %v", err)
+		}
+	})
+
+	t.Run("Writer", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		req, _ := NewRequest("", ts.URL+"?size=4096")
+		req.SetWriter(b)
+		req.RangeOffset = 10
+		req.RangeLength = 10
+		if err := DefaultClient.Do(req).Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if !bytes.Equal(b.Bytes(), expect(10, 10)) {
+			t.Errorf("expected range to be written to writer")
+		}
+	})
+}
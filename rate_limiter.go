@@ -1,12 +1,332 @@
 package grab
 
-import "context"
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
 
 // RateLimiter is an interface that must be satisfied by any third-party rate
 // limiters that may be used to limit download transfer speeds.
 //
-// A recommended token bucket implementation can be found at
+// A ready-made token bucket implementation is provided by NewTokenBucket. An
+// alternative implementation can be found at
 // https://godoc.org/golang.org/x/time/rate#Limiter.
 type RateLimiter interface {
 	WaitN(ctx context.Context, n int) (err error)
 }
+
+// TokenBucket is a RateLimiter that implements the token bucket algorithm.
+// Tokens, each representing one byte, are added to the bucket at a fixed rate,
+// up to a maximum burst size. Transfers wait until enough tokens have
+// accumulated to cover each read.
+//
+// A TokenBucket may be shared by multiple requests to limit their combined
+// transfer rate. TokenBuckets are safe for concurrent use by multiple
+// goroutines.
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a TokenBucket that limits transfers to the given
+// number of bytes per second, allowing bursts of up to burst bytes. If burst
+// is less than one, the burst size defaults to one second of transfer at the
+// given rate.
+//
+// The bucket starts full.
+func NewTokenBucket(bytesPerSecond, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = bytesPerSecond
+	}
+	return &TokenBucket{
+		rate:   float64(bytesPerSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// SetRate changes the rate and burst size of the bucket, with the same
+// semantics as NewTokenBucket. Transfers that are already waiting for tokens
+// are not affected.
+func (c *TokenBucket) SetRate(bytesPerSecond, burst int) {
+	if burst < 1 {
+		burst = bytesPerSecond
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refill(time.Now())
+	c.rate = float64(bytesPerSecond)
+	c.burst = float64(burst)
+	if c.tokens > c.burst {
+		c.tokens = c.burst
+	}
+}
+
+// WaitN blocks until n tokens are available or the given context is canceled.
+// Requests for more tokens than the burst size of the bucket are allowed, but
+// must wait until the bucket has recovered from the deficit before any further
+// requests are granted.
+//
+// If the rate of the bucket is less than one, WaitN never blocks.
+func (c *TokenBucket) WaitN(ctx context.Context, n int) error {
+	c.mu.Lock()
+	if c.rate < 1 {
+		c.mu.Unlock()
+		return nil
+	}
+	c.refill(time.Now())
+	c.tokens -= float64(n)
+	if c.tokens >= 0 {
+		c.mu.Unlock()
+		return nil
+	}
+	wait := time.Duration(-c.tokens / c.rate * float64(time.Second))
+	c.mu.Unlock()
+
+	t := time.NewTimer(wait)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		// return the unused tokens
+		c.mu.Lock()
+		c.tokens += float64(n)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// unlimited returns true if the rate of the bucket is less than one, such that
+// WaitN never blocks.
+func (c *TokenBucket) unlimited() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rate < 1
+}
+
+// refill adds tokens to the bucket for the time elapsed since the last refill.
+// The caller must hold c.mu.
+func (c *TokenBucket) refill(now time.Time) {
+	c.tokens += now.Sub(c.last).Seconds() * c.rate
+	if c.tokens > c.burst {
+		c.tokens = c.burst
+	}
+	c.last = now
+}
+
+// multiLimiter is a RateLimiter that waits for each of its RateLimiters in
+// turn.
+type multiLimiter []RateLimiter
+
+func (c multiLimiter) WaitN(ctx context.Context, n int) error {
+	for _, lim := range c {
+		if err := lim.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c multiLimiter) unlimited() bool {
+	for _, lim := range c {
+		if !unlimited(lim) {
+			return false
+		}
+	}
+	return true
+}
+
+// fairQueue grants the tokens of a shared TokenBucket to the fairLimiters of
+// concurrent transfers by weighted fair queuing, so that a transfer receives a
+// share of the rate of the bucket in proportion to its weight, however many
+// other transfers are waiting and however large their buffers are.
+//
+// Each quantum requested by a transfer is tagged with a virtual start time,
+// which is the later of the current virtual time and the virtual finish time
+// of the previous quantum of the transfer. The finish time advances by the
+// size of the quantum divided by the weight of the transfer. Quanta are
+// granted one at a time, in order of their start times, so a transfer which
+// has just started is served ahead of those which have already consumed their
+// share.
+type fairQueue struct {
+	lim *TokenBucket
+
+	mu      sync.Mutex
+	vtime   float64 // start time of the quantum being granted
+	busy    bool
+	waiting fairWaiters
+	seq     uint64
+}
+
+// fairIdle is how long a transfer must go without requesting tokens from a
+// fairQueue before it is considered idle. The virtual time of a transfer which
+// requests tokens again sooner, such as after writing its last read to disk,
+// is not advanced to the virtual time of the queue, so that it keeps its
+// share.
+const fairIdle = 100 * time.Millisecond
+
+// fairWaiter is a quantum waiting in a fairQueue.
+type fairWaiter struct {
+	start float64
+	seq   uint64
+	ready chan struct{}
+	index int
+}
+
+// fairWaiters is a heap of fairWaiters, ordered by start time and then by
+// arrival.
+type fairWaiters []*fairWaiter
+
+func (c fairWaiters) Len() int { return len(c) }
+
+func (c fairWaiters) Less(i, j int) bool {
+	if c[i].start != c[j].start {
+		return c[i].start < c[j].start
+	}
+	return c[i].seq < c[j].seq
+}
+
+func (c fairWaiters) Swap(i, j int) {
+	c[i], c[j] = c[j], c[i]
+	c[i].index = i
+	c[j].index = j
+}
+
+func (c *fairWaiters) Push(x interface{}) {
+	w := x.(*fairWaiter)
+	w.index = len(*c)
+	*c = append(*c, w)
+}
+
+func (c *fairWaiters) Pop() interface{} {
+	old := *c
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*c = old[:len(old)-1]
+	return w
+}
+
+// waitN waits for n tokens of the TokenBucket of the queue on behalf of the
+// given fairLimiter, in quanta which are granted in turn with the quanta of
+// other transfers.
+func (c *fairQueue) waitN(ctx context.Context, f *fairLimiter, n int) error {
+	c.mu.Lock()
+	start := f.finish
+	if start < c.vtime && time.Since(f.last) > fairIdle {
+		// the transfer was idle and does not bank its unused share
+		start = c.vtime
+	}
+	held := false
+	for n > 0 {
+		q := n
+		if q > f.quantum {
+			q = f.quantum
+		}
+		n -= q
+		f.finish = start + float64(q)/f.weight
+		if held && (len(c.waiting) == 0 || c.waiting[0].start > start) {
+			// keep the turn for the next quantum
+			c.vtime = start
+			c.mu.Unlock()
+		} else if !held && !c.busy {
+			c.busy = true
+			c.vtime = start
+			c.mu.Unlock()
+		} else {
+			c.seq++
+			w := &fairWaiter{start: start, seq: c.seq, ready: make(chan struct{})}
+			heap.Push(&c.waiting, w)
+			if held {
+				c.handoff()
+			}
+			c.mu.Unlock()
+			if err := c.wait(ctx, w); err != nil {
+				return err
+			}
+		}
+		held = true
+		if err := c.lim.WaitN(ctx, q); err != nil {
+			c.mu.Lock()
+			c.handoff()
+			c.mu.Unlock()
+			return err
+		}
+		c.mu.Lock()
+		start = f.finish
+	}
+	if held {
+		c.handoff()
+	}
+	f.last = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// wait blocks until the given waiter is granted the turn or the given context
+// is canceled.
+func (c *fairQueue) wait(ctx context.Context, w *fairWaiter) error {
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w.index >= 0 {
+		heap.Remove(&c.waiting, w.index)
+	} else {
+		// the turn was granted concurrently - pass it on
+		c.handoff()
+	}
+	return ctx.Err()
+}
+
+// handoff passes the turn to the waiting quantum with the earliest start time.
+// The caller must hold c.mu and the turn.
+func (c *fairQueue) handoff() {
+	if len(c.waiting) == 0 {
+		c.busy = false
+		return
+	}
+	w := heap.Pop(&c.waiting).(*fairWaiter)
+	c.vtime = w.start
+	close(w.ready)
+}
+
+// fairLimiter is a RateLimiter that requests tokens from a shared fairQueue
+// in small quanta, so that concurrent transfers with large buffers are
+// interleaved and each receives a share of the rate of the queue in
+// proportion to its weight.
+//
+// A fairLimiter is created for each attempt of a transfer, and is shared by
+// the segments of segmented transfers.
+type fairLimiter struct {
+	q       *fairQueue
+	quantum int
+	weight  float64
+
+	// finish is the virtual finish time of the last quantum requested by the
+	// transfer, and last is the time at which its last request was granted -
+	// guarded by the mutex of q.
+	finish float64
+	last   time.Time
+}
+
+func (c *fairLimiter) unlimited() bool {
+	return c.q.lim.unlimited()
+}
+
+func (c *fairLimiter) WaitN(ctx context.Context, n int) error {
+	if c.q.lim.unlimited() {
+		return nil
+	}
+	return c.q.waitN(ctx, c, n)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sync"
 	"testing"
 	"time"
 )
@@ -15,10 +16,6 @@ type testRateLimiter struct {
 	r, n int
 }
 
-func NewLimiter(r int) RateLimiter {
-	return &testRateLimiter{r: r}
-}
-
 func (c *testRateLimiter) WaitN(ctx context.Context, n int) (err error) {
 	c.n += n
 	time.Sleep(
@@ -62,12 +59,126 @@ func TestRateLimiter(t *testing.T) {
 func ExampleRateLimiter() {
 	req, _ := NewRequest("", "http://www.golang-book.com/public/pdf/gobook.pdf")
 
-	// Attach a 1Mbps rate limiter, like the token bucket implementation from
-	// golang.org/x/time/rate.
-	req.RateLimiter = NewLimiter(1048576)
+	// Attach a 1Mbps token bucket rate limiter
+	req.RateLimiter = NewTokenBucket(1048576, 0)
 
 	resp := DefaultClient.Do(req)
 	if err := resp.Err(); err != nil {
 		log.Fatal(err)
 	}
 }
+
+func TestTokenBucket(t *testing.T) {
+	t.Run("Transfer", func(t *testing.T) {
+		// download a 64KB file at 128KBps with a 16KB burst
+		// should take > 375ms
+		filesize := 65536
+		filename := ".testTokenBucket"
+		defer os.Remove(filename)
+
+		req, err := NewRequest(filename, fmt.Sprintf("%s?size=%d", ts.URL, filesize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.BufferSize = 4096
+		req.RateLimiter = NewTokenBucket(131072, 16384)
+
+		resp := DefaultClient.Do(req)
+		if err = resp.Err(); err != nil {
+			t.Fatal(err)
+		}
+		testComplete(t, resp)
+		if resp.Duration() < 350*time.Millisecond {
+			t.Errorf("expected transfer to take >375ms, took %v", resp.Duration())
+		}
+	})
+
+	t.Run("WithCancel", func(t *testing.T) {
+		lim := NewTokenBucket(1024, 1024)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if err := lim.WaitN(ctx, 1024); err != nil {
+			t.Fatalf("expected burst to be granted immediately, got: %v", err)
+		}
+		if err := lim.WaitN(ctx, 1024); err != context.DeadlineExceeded {
+			t.Fatalf("expected %v, got: %v", context.DeadlineExceeded, err)
+		}
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		lim := NewTokenBucket(0, 0)
+		for i := 0; i < 10; i++ {
+			if err := lim.WaitN(context.Background(), 1048576); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestGlobalRateLimit(t *testing.T) {
+	// download two 64KB files concurrently at a combined 256KBps with a 25KB
+	// burst - both should take > 350ms
+	filesize := 65536
+	client := NewClient()
+	client.SetGlobalRateLimit(262144)
+
+	reqs := make([]*Request, 2)
+	for i := range reqs {
+		filename := fmt.Sprintf(".testGlobalRateLimit%d", i)
+		defer os.Remove(filename)
+		reqs[i], _ = NewRequest(filename, fmt.Sprintf("%s?size=%d", ts.URL, filesize))
+		reqs[i].BufferSize = 32768
+	}
+	for resp := range client.DoBatch(0, reqs...) {
+		if err := resp.Err(); err != nil {
+			t.Fatal(err)
+		}
+		testComplete(t, resp)
+		if resp.Duration() < 350*time.Millisecond {
+			t.Errorf("expected transfer to take >350ms, took %v", resp.Duration())
+		}
+	}
+}
+
+// TestFairQueue tests that concurrent transfers share the global rate limit
+// in proportion to their weights, and that a new transfer is not starved by
+// transfers which are already waiting.
+func TestFairQueue(t *testing.T) {
+	q := &fairQueue{lim: NewTokenBucket(4194304, 4096)}
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	weights := []float64{1, 1, 1, 3}
+	granted := make([]int, len(weights))
+	var wg sync.WaitGroup
+	for i, weight := range weights {
+		wg.Add(1)
+		go func(i int, lim *fairLimiter) {
+			defer wg.Done()
+			// large reads, as with a large buffer
+			for lim.WaitN(ctx, 16384) == nil {
+				granted[i] += 16384
+			}
+		}(i, &fairLimiter{q: q, quantum: 4096, weight: weight})
+	}
+
+	// a small file started while the queue is busy is granted promptly
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	small := &fairLimiter{q: q, quantum: 4096, weight: 1}
+	if err := small.WaitN(ctx, 8192); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 50*time.Millisecond {
+		t.Errorf("expected small transfer to be granted promptly, took %v", d)
+	}
+	wg.Wait()
+
+	for i := 1; i < 3; i++ {
+		if r := float64(granted[i]) / float64(granted[0]); r < 0.5 || r > 2 {
+			t.Errorf("expected equal shares for equal weights, got: %v", granted)
+		}
+	}
+	if r := float64(granted[3]) / float64(granted[0]); r < 2 || r > 4 {
+		t.Errorf("expected weight 3 to receive three times the share of weight 1, got: %v", granted)
+	}
+}
@@ -0,0 +1,101 @@
+package grab
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A Redirect is a redirect response which was followed to transfer a file.
+type Redirect struct {
+	// URL is the URL which responded with the redirect.
+	URL *url.URL
+
+	// StatusCode is the status code of the redirect response, such as 302.
+	StatusCode int
+
+	// Location is the URL to which the request was redirected.
+	Location *url.URL
+}
+
+// redirectChain returns the redirects which were followed by the HTTP client
+// to receive the given response, in order.
+func redirectChain(hresp *http.Response) []Redirect {
+	var chain []Redirect
+	for req := hresp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		prev := req.Response
+		if prev.Request == nil {
+			break
+		}
+		chain = append(chain, Redirect{
+			URL:        prev.Request.URL,
+			StatusCode: prev.StatusCode,
+			Location:   req.URL,
+		})
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain
+}
+
+// setRedirects sets Response.FinalURL and Response.Redirects for the current
+// HTTP response.
+func (c *Response) setRedirects() {
+	if c.HTTPResponse.Request == nil {
+		return
+	}
+	c.FinalURL = c.HTTPResponse.Request.URL
+	c.Redirects = redirectChain(c.HTTPResponse)
+}
+
+// A RedirectPolicy restricts the redirects which are followed to transfer the
+// file of a Request, in addition to the CheckRedirect policy of the
+// http.Client of the Client. Redirects which are forbidden fail the transfer
+// with ErrRedirectPolicy, without being retried.
+type RedirectPolicy struct {
+	// MaxRedirects is the maximum number of redirects which are followed. If
+	// negative, no redirects are followed. Default: 10.
+	MaxRedirects int
+
+	// NoDowngrade forbids redirects from https to http URLs.
+	NoDowngrade bool
+
+	// SameHost forbids redirects to other hosts than the host of the URL of
+	// the Request, such as from example.com to cdn.example.net.
+	SameHost bool
+
+	// StripAuthorization specifies that the Authorization header should be
+	// removed when a request is redirected to another host, including
+	// subdomains, which the http.Client only does for other domains.
+	StripAuthorization bool
+}
+
+// checkRedirect returns a CheckRedirect function of an http.Client which
+// enforces the policy, before calling next, if not nil.
+func (c *RedirectPolicy) checkRedirect(next func(*http.Request, []*http.Request) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		prev := via[len(via)-1]
+		max := c.MaxRedirects
+		if max == 0 {
+			max = 10
+		}
+		if max < 0 || len(via) > max {
+			return fmt.Errorf("%w: stopped after %d redirects", ErrRedirectPolicy, len(via)-1)
+		}
+		if c.NoDowngrade && prev.URL.Scheme == "https" && req.URL.Scheme != "https" {
+			return fmt.Errorf("%w: redirect from %s downgrades to %s", ErrRedirectPolicy, prev.URL.Redacted(), req.URL.Redacted())
+		}
+		if c.SameHost && !strings.EqualFold(req.URL.Host, via[0].URL.Host) {
+			return fmt.Errorf("%w: redirect to another host: %s", ErrRedirectPolicy, req.URL.Redacted())
+		}
+		if c.StripAuthorization && !strings.EqualFold(req.URL.Host, prev.URL.Host) {
+			req.Header.Del("Authorization")
+		}
+		if next != nil {
+			return next(req, via)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,119 @@
+package grab
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestRedirects tests that the redirects followed to transfer a file are
+// recorded in Response.Redirects and Response.FinalURL.
+func TestRedirects(t *testing.T) {
+	filename := ".testRedirects"
+	defer os.Remove(filename)
+	mux := http.NewServeMux()
+	mux.Handle("/a", http.RedirectHandler("/b", http.StatusMovedPermanently))
+	mux.Handle("/b", http.RedirectHandler("/c", http.StatusFound))
+	mux.HandleFunc("/c", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	for _, u := range []string{"/a", "/c"} {
+		req, _ := NewRequest(filename, s.URL+u)
+		req.NoResume = true
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if resp.FinalURL == nil || resp.FinalURL.String() != s.URL+"/c" {
+			t.Errorf("%s: expected final URL %s/c, got: %v", u, s.URL, resp.FinalURL)
+		}
+		var expect []Redirect
+		if u == "/a" {
+			expect = []Redirect{{StatusCode: 301}, {StatusCode: 302}}
+		}
+		if len(resp.Redirects) != len(expect) {
+			t.Fatalf("%s: expected %d redirects, got: %d", u, len(expect), len(resp.Redirects))
+		}
+		hops := []string{"/a", "/b", "/c"}
+		for i, r := range resp.Redirects {
+			if r.StatusCode != expect[i].StatusCode {
+				t.Errorf("expected status %d, got: %d", expect[i].StatusCode, r.StatusCode)
+			}
+			if r.URL.Path != hops[i] || r.Location.Path != hops[i+1] {
+				t.Errorf("expected redirect from %s to %s, got: %v to %v", hops[i], hops[i+1], r.URL, r.Location)
+			}
+		}
+	}
+}
+
+// TestRedirectPolicy tests that redirects forbidden by Request.RedirectPolicy
+// fail the transfer with ErrRedirectPolicy.
+func TestRedirectPolicy(t *testing.T) {
+	filename := ".testRedirectPolicy"
+	defer os.Remove(filename)
+	var auth string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		w.Write([]byte("hello"))
+	})
+	other := httptest.NewServer(mux)
+	defer other.Close()
+	mux.Handle("/other", http.RedirectHandler(other.URL+"/file", http.StatusFound))
+	mux.Handle("/2", http.RedirectHandler("/1", http.StatusFound))
+	mux.Handle("/1", http.RedirectHandler("/file", http.StatusFound))
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	tests := []struct {
+		path   string
+		policy RedirectPolicy
+		ok     bool
+		auth   string
+	}{
+		{"/2", RedirectPolicy{}, true, "Bearer token"},
+		{"/2", RedirectPolicy{MaxRedirects: 2}, true, "Bearer token"},
+		{"/2", RedirectPolicy{MaxRedirects: 1}, false, ""},
+		{"/1", RedirectPolicy{MaxRedirects: -1}, false, ""},
+		{"/other", RedirectPolicy{}, true, "Bearer token"},
+		{"/other", RedirectPolicy{SameHost: true}, false, ""},
+		{"/other", RedirectPolicy{StripAuthorization: true}, true, ""},
+		{"/1", RedirectPolicy{StripAuthorization: true}, true, "Bearer token"},
+	}
+	for i, test := range tests {
+		auth = ""
+		req, _ := NewRequest(filename, s.URL+test.path)
+		req.NoResume = true
+		req.HTTPRequest.Header.Set("Authorization", "Bearer token")
+		req.RedirectPolicy = &test.policy
+		resp := DefaultClient.Do(req)
+		err := resp.Err()
+		if test.ok && err != nil {
+			t.Errorf("%d: %v", i, err)
+		} else if !test.ok && !errors.Is(err, ErrRedirectPolicy) {
+			t.Errorf("%d: expected ErrRedirectPolicy, got: %v", i, err)
+		}
+		if auth != test.auth {
+			t.Errorf("%d: expected Authorization %q, got: %q", i, test.auth, auth)
+		}
+		if !test.ok && resp.Attempts() != 1 {
+			t.Errorf("%d: expected no retries, got %d attempts", i, resp.Attempts())
+		}
+	}
+
+	// downgrade
+	tls := httptest.NewTLSServer(http.RedirectHandler(s.URL+"/file", http.StatusFound))
+	defer tls.Close()
+	client := NewClient()
+	client.HTTPClient = tls.Client()
+	req, _ := NewRequest(filename, tls.URL)
+	req.RedirectPolicy = &RedirectPolicy{NoDowngrade: true}
+	if err := client.Do(req).Err(); !errors.Is(err, ErrRedirectPolicy) {
+		t.Errorf("expected ErrRedirectPolicy, got: %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package grab
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Resolver resolves the hostnames of the connections of a Client, instead
+// of the DNS resolver of the system, so that transfers can bypass broken or
+// censored DNS, or so that tests can map hostnames to local servers. A
+// *net.Resolver is a Resolver. See StaticResolver and DoHResolver.
+type Resolver interface {
+	// LookupHost returns the IP addresses of the given host.
+	LookupHost(ctx context.Context, host string) (addrs []string, err error)
+}
+
+// A StaticResolver is a Resolver of static host mappings, as with /etc/hosts.
+type StaticResolver struct {
+	// Hosts maps each hostname to its addresses. Hostnames are matched
+	// without regard to case.
+	Hosts map[string][]string
+
+	// Fallback, if not nil, resolves hostnames which are not in Hosts.
+	// Otherwise, unmapped hostnames are resolved by net.DefaultResolver.
+	Fallback Resolver
+}
+
+// LookupHost returns the addresses of the given host in Hosts, or of the
+// Fallback resolver.
+func (c *StaticResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	host = strings.TrimSuffix(host, ".")
+	if addrs, ok := c.Hosts[host]; ok {
+		return addrs, nil
+	}
+	for k, addrs := range c.Hosts {
+		if strings.EqualFold(strings.TrimSuffix(k, "."), host) {
+			return addrs, nil
+		}
+	}
+	if c.Fallback != nil {
+		return c.Fallback.LookupHost(ctx, host)
+	}
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// A DoHResolver is a Resolver which queries the A and AAAA records of each
+// hostname from a DNS-over-HTTPS server, as specified by RFC 8484. Answers are
+// cached until their TTL expires. IPv4 addresses are returned first.
+type DoHResolver struct {
+	// URL is the URL of the DNS query endpoint, such as
+	// https://cloudflare-dns.com/dns-query or https://8.8.8.8/dns-query.
+	URL string
+
+	// HTTPClient is the client used to send queries. If nil,
+	// http.DefaultClient is used. The hostname of URL is resolved by the
+	// transport of HTTPClient, not by the DoHResolver.
+	HTTPClient *http.Client
+
+	mu    sync.Mutex
+	cache map[string]dohAnswer
+}
+
+// dohAnswer is a cached answer of a DoHResolver.
+type dohAnswer struct {
+	addrs   []string
+	expires time.Time
+}
+
+// DNS record types and response codes queried by a DoHResolver.
+const (
+	dnsTypeA         = 1
+	dnsTypeAAAA      = 28
+	dnsRcodeNXDomain = 3
+)
+
+// LookupHost returns the IPv4 and IPv6 addresses of the given host.
+func (c *DoHResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	name := strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(name); ip != nil {
+		return []string{name}, nil
+	}
+	c.mu.Lock()
+	if a, ok := c.cache[name]; ok && time.Now().Before(a.expires) {
+		c.mu.Unlock()
+		return a.addrs, nil
+	}
+	c.mu.Unlock()
+
+	type result struct {
+		addrs []string
+		ttl   uint32
+		err   error
+	}
+	var results [2]result
+	var wg sync.WaitGroup
+	for i, qtype := range []uint16{dnsTypeA, dnsTypeAAAA} {
+		wg.Add(1)
+		go func(i int, qtype uint16) {
+			defer wg.Done()
+			r := &results[i]
+			r.addrs, r.ttl, r.err = c.query(ctx, name, qtype)
+		}(i, qtype)
+	}
+	wg.Wait()
+
+	var addrs []string
+	ttl := uint32(0)
+	for _, r := range results {
+		if r.err != nil {
+			return nil, &net.DNSError{Err: r.err.Error(), Name: host, Server: c.URL}
+		}
+		if len(r.addrs) > 0 && (len(addrs) == 0 || r.ttl < ttl) {
+			ttl = r.ttl
+		}
+		addrs = append(addrs, r.addrs...)
+	}
+	if len(addrs) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, Server: c.URL, IsNotFound: true}
+	}
+	c.mu.Lock()
+	if c.cache == nil {
+		c.cache = make(map[string]dohAnswer)
+	}
+	c.cache[name] = dohAnswer{addrs: addrs, expires: time.Now().Add(time.Duration(ttl) * time.Second)}
+	c.mu.Unlock()
+	return addrs, nil
+}
+
+// query sends a DNS query for the records of the given type and name, and
+// returns the addresses in the answer and their minimum TTL.
+func (c *DoHResolver) query(ctx context.Context, name string, qtype uint16) ([]string, uint32, error) {
+	msg, err := dnsQuery(name, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+	u := c.URL
+	if strings.Contains(u, "?") {
+		u += "&"
+	} else {
+		u += "?"
+	}
+	u += "dns=" + base64.RawURLEncoding.EncodeToString(msg)
+	hreq, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	hreq.Header.Set("Accept", "application/dns-message")
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	hresp, err := hc.Do(hreq)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DNS-over-HTTPS query failed: %w", StatusCodeError(hresp.StatusCode))
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(hresp.Body, 65535))
+	if err != nil {
+		return nil, 0, err
+	}
+	return dnsAnswer(b, qtype)
+}
+
+// dnsQuery returns a DNS query message for the records of the given type and
+// name, with an ID of zero, as recommended for caching by RFC 8484.
+func dnsQuery(name string, qtype uint16) ([]byte, error) {
+	var b bytes.Buffer
+	b.Write([]byte{0, 0, 1, 0, 0, 1, 0, 0, 0, 0, 0, 0}) // recursion desired, one question
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, fmt.Errorf("invalid hostname: %s", name)
+		}
+		b.WriteByte(byte(len(label)))
+		b.WriteString(label)
+	}
+	b.WriteByte(0)
+	binary.Write(&b, binary.BigEndian, [2]uint16{qtype, 1}) // class IN
+	return b.Bytes(), nil
+}
+
+// dnsAnswer returns the addresses of the records of the given type in the
+// given DNS response message, and their minimum TTL. Records of other types,
+// such as the CNAME records of aliases, are ignored.
+func dnsAnswer(b []byte, qtype uint16) ([]string, uint32, error) {
+	malformed := fmt.Errorf("malformed DNS response")
+	if len(b) < 12 {
+		return nil, 0, malformed
+	}
+	switch rcode := b[3] & 0x0f; rcode {
+	case 0:
+	case dnsRcodeNXDomain:
+		return nil, 0, nil
+	default:
+		return nil, 0, fmt.Errorf("DNS query failed with response code %d", rcode)
+	}
+	qdcount := int(binary.BigEndian.Uint16(b[4:]))
+	ancount := int(binary.BigEndian.Uint16(b[6:]))
+	off := 12
+	for i := 0; i < qdcount; i++ {
+		if off = skipDNSName(b, off); off < 0 || off+4 > len(b) {
+			return nil, 0, malformed
+		}
+		off += 4
+	}
+	var addrs []string
+	var ttl uint32
+	for i := 0; i < ancount; i++ {
+		if off = skipDNSName(b, off); off < 0 || off+10 > len(b) {
+			return nil, 0, malformed
+		}
+		rtype := binary.BigEndian.Uint16(b[off:])
+		rttl := binary.BigEndian.Uint32(b[off+4:])
+		n := int(binary.BigEndian.Uint16(b[off+8:]))
+		off += 10
+		if off+n > len(b) {
+			return nil, 0, malformed
+		}
+		if rtype == qtype && (rtype == dnsTypeA && n == net.IPv4len || rtype == dnsTypeAAAA && n == net.IPv6len) {
+			if len(addrs) == 0 || rttl < ttl {
+				ttl = rttl
+			}
+			addrs = append(addrs, net.IP(b[off:off+n]).String())
+		}
+		off += n
+	}
+	return addrs, ttl, nil
+}
+
+// skipDNSName returns the offset following the possibly compressed domain
+// name at the given offset of a DNS message, or -1 if it is malformed.
+func skipDNSName(b []byte, off int) int {
+	for off < len(b) {
+		n := int(b[off])
+		switch {
+		case n == 0:
+			return off + 1
+		case n&0xc0 == 0xc0:
+			if off+2 > len(b) {
+				return -1
+			}
+			return off + 2
+		case n&0xc0 != 0:
+			return -1
+		}
+		off += n + 1
+	}
+	return -1
+}
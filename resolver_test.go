@@ -0,0 +1,152 @@
+package grab
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// testDoHServer returns a DNS-over-HTTPS server which answers queries for the
+// A and AAAA records of the given hosts, and the number of queries it has
+// answered.
+func testDoHServer(hosts map[string][]string) (*httptest.Server, *int32) {
+	queries := new(int32)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q, err := base64.RawURLEncoding.DecodeString(r.URL.Query().Get("dns"))
+		if err != nil || len(q) < 17 || r.Header.Get("Accept") != "application/dns-message" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		atomic.AddInt32(queries, 1)
+		var labels []string
+		off := 12
+		for q[off] != 0 {
+			labels = append(labels, string(q[off+1:off+1+int(q[off])]))
+			off += int(q[off]) + 1
+		}
+		qtype := binary.BigEndian.Uint16(q[off+1:])
+		resp := append([]byte(nil), q[:off+5]...)
+		resp[2] |= 0x80 // response
+		addrs, ok := hosts[strings.Join(labels, ".")]
+		if !ok {
+			resp[3] |= dnsRcodeNXDomain
+		}
+		var ancount uint16
+		for _, a := range addrs {
+			ip := net.ParseIP(a)
+			rtype, rdata := uint16(dnsTypeAAAA), []byte(ip.To16())
+			if ip.To4() != nil {
+				rtype, rdata = dnsTypeA, []byte(ip.To4())
+			}
+			if rtype != qtype {
+				continue
+			}
+			ancount++
+			resp = append(resp, 0xc0, 12) // pointer to the question
+			resp = binary.BigEndian.AppendUint16(resp, rtype)
+			resp = binary.BigEndian.AppendUint16(resp, 1)
+			resp = binary.BigEndian.AppendUint32(resp, 300)
+			resp = binary.BigEndian.AppendUint16(resp, uint16(len(rdata)))
+			resp = append(resp, rdata...)
+		}
+		binary.BigEndian.PutUint16(resp[6:], ancount)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(resp)
+	}))
+	return s, queries
+}
+
+// TestResolver tests that the hostnames of transfers are resolved with the
+// Resolver of the Client.
+func TestResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer s.Close()
+	u, _ := url.Parse(s.URL)
+
+	doh, queries := testDoHServer(map[string][]string{
+		"files.example.test": {"127.0.0.1"},
+		"dual.example.test":  {"::1", "127.0.0.1"},
+	})
+	defer doh.Close()
+
+	tests := []struct {
+		name     string
+		resolver Resolver
+		hosts    []string
+	}{
+		{"static", &StaticResolver{Hosts: map[string][]string{
+			"Files.Example.Test": {"127.0.0.1"},
+			"dual.example.test.": {"127.0.0.2", "127.0.0.1"},
+		}, Fallback: &DoHResolver{URL: doh.URL}}, []string{"files.example.test", "FILES.example.test", "dual.example.test"}},
+		{"doh", &DoHResolver{URL: doh.URL + "/dns-query"}, []string{"files.example.test", "files.example.test", "dual.example.test"}},
+	}
+	for _, test := range tests {
+		client := NewClient()
+		client.Resolver = test.resolver
+		for i, host := range test.hosts {
+			urlStr := "http://" + net.JoinHostPort(host, u.Port()) + "/file"
+			req, _ := NewRequest(filepath.Join(dir, test.name, host, string(rune('0'+i))), urlStr)
+			resp := client.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatalf("%s: %s: %v", test.name, host, err)
+			}
+			testContent(t, resp.Filename, []byte(net.JoinHostPort(host, u.Port())))
+		}
+		req, _ := NewRequest(dir, "http://"+net.JoinHostPort("missing.example.test", u.Port())+"/file")
+		var dnsErr *net.DNSError
+		if err := client.Do(req).Err(); !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			t.Errorf("%s: expected not found error, got: %v", test.name, err)
+		}
+	}
+	// the answers for files.example.test are cached
+	if n := atomic.LoadInt32(queries); n != 8 {
+		t.Errorf("expected 8 DNS queries, got: %d", n)
+	}
+}
+
+func TestDNSAnswer(t *testing.T) {
+	msg, err := dnsQuery("example.com", dnsTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a CNAME record, and an A record of the alias
+	resp := append(append([]byte(nil), msg...),
+		0xc0, 12, 0, 5, 0, 1, 0, 0, 0, 60, 0, 6, 3, 'w', 'w', 'w', 0xc0, 12,
+		0xc0, 29, 0, 1, 0, 1, 0, 0, 0, 30, 0, 4, 192, 0, 2, 1)
+	resp[2] |= 0x80
+	resp[7] = 2
+	addrs, ttl, err := dnsAnswer(resp, dnsTypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addrs) != 1 || addrs[0] != "192.0.2.1" || ttl != 30 {
+		t.Errorf("unexpected answer: %v, %d", addrs, ttl)
+	}
+	if _, _, err := dnsAnswer(resp[:len(resp)-2], dnsTypeA); err == nil {
+		t.Errorf("expected error for truncated response")
+	}
+	if _, err := dnsQuery("invalid..example.com", dnsTypeA); err == nil {
+		t.Errorf("expected error for invalid hostname")
+	}
+	if _, err := (&DoHResolver{URL: "http://127.0.0.1:0/"}).LookupHost(context.Background(), "127.0.0.1"); err != nil {
+		t.Errorf("expected IP addresses to be returned as is, got: %v", err)
+	}
+}
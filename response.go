@@ -3,9 +3,13 @@ package grab
 import (
 	"context"
 	"io"
+	"io/ioutil"
+	"math"
 	"net/http"
+	"net/url"
 	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -26,13 +30,32 @@ type Response struct {
 	// grab.
 	HTTPResponse *http.Response
 
+	// FinalURL is the URL from which the file is transferred, after following
+	// any redirects. Redirects lists each redirect which was followed to reach
+	// FinalURL, in order. Both are set by each response of the remote server
+	// which describes the file, so they reflect the mirror which served the
+	// file.
+	FinalURL  *url.URL
+	Redirects []Redirect
+
 	// Filename specifies the path where the file transfer is stored in local
-	// storage.
+	// storage. On Windows, paths which are too long for MAX_PATH are given in
+	// their extended-length form, such as \\?\C:\..., or \\?\UNC\... for
+	// UNC paths.
 	Filename string
 
 	// Size specifies the total expected size of the file transfer.
 	Size int64
 
+	// ContentEncoding specifies the Content-Encoding of the content that was
+	// decoded by a transfer with Request.DecodeContent, or is empty if the
+	// content was not encoded.
+	ContentEncoding string
+
+	// EncodedSize specifies the size of the encoded content if ContentEncoding
+	// is set and the size is known. Otherwise, EncodedSize is zero.
+	EncodedSize int64
+
 	// Start specifies the time at which the file transfer started.
 	Start time.Time
 
@@ -49,6 +72,53 @@ type Response struct {
 	// transfer.
 	DidResume bool
 
+	// Skipped specifies that a file already existed at the destination path
+	// and was left untouched, according to Request.CollisionPolicy or
+	// Request.SkipPolicy.
+	Skipped bool
+
+	// NotModified specifies that the remote server responded to a conditional
+	// request with 304 Not Modified, so the existing file at the destination
+	// path was left untouched. See Request.Conditional.
+	NotModified bool
+
+	// Cached specifies that the file was copied from the Cache of the Client,
+	// rather than downloaded.
+	Cached bool
+
+	// DidResumeState specifies that the file transfer resumed a previously
+	// interrupted transfer using the state persisted by Request.PersistState.
+	DidResumeState bool
+
+	// Checksums describes the validation of the downloaded file using each of
+	// the checksums configured on the Request, in the order they were
+	// configured. Checksums is set once validation is complete.
+	Checksums []Checksum
+
+	// Digest describes the validation of the downloaded file using the digest
+	// sent by the remote server, if Request.VerifyDigest is set and the server
+	// sent a supported digest, or using the checksum given by the object store
+	// from which the file was transferred, such as the ETag of an S3 object.
+	// Digest is set once validation is complete.
+	Digest *Checksum
+
+	// DecompressedChecksums describes the validation of the decompressed
+	// content of a file transferred with Request.Decompress, using each of the
+	// checksums added with Request.AddDecompressedChecksum.
+	DecompressedChecksums []Checksum
+
+	// BytesReused is the number of bytes of the file which were copied from the
+	// existing destination file with Request.Delta, rather than transferred.
+	BytesReused int64
+
+	// RepairedPieces lists the indexes of the pieces of the file which failed
+	// verification with Request.Pieces and were transferred again.
+	RepairedPieces []int
+
+	// ExtractedFiles lists the paths of the files extracted into
+	// Request.ExtractDir.
+	ExtractedFiles []string
+
 	// Done is closed once the transfer is finalized, either successfully or with
 	// errors. Errors are available via Response.Err
 	Done chan struct{}
@@ -60,14 +130,99 @@ type Response struct {
 	// Response.
 	cancel context.CancelFunc
 
+	// attemptCtx is a Context derived from ctx that controls cancelation of
+	// the current attempt to transfer the file, so that a failed attempt can
+	// be abandoned without canceling the Response.
+	attemptCtx    context.Context
+	cancelAttempt context.CancelFunc
+
+	// stalled is set to 1 if the current attempt was canceled because the
+	// transfer stalled. See Request.StallTimeout.
+	stalled int32
+
+	// outOfSpace is set to 1 if the current attempt was canceled because the
+	// destination file system is running out of space. See watchSpace.
+	outOfSpace int32
+
+	// gate is closed while the transfer is paused.
+	gate *gate
+
 	// fi is the FileInfo for the destination file if it already existed before
 	// transfer started.
 	fi os.FileInfo
 
+	// state is the persisted state of a previously interrupted transfer of the
+	// destination file, if Request.PersistState is set.
+	state *transferState
+
+	// hasher computes the checksums of the transfer as it is written, if
+	// Request.StreamChecksum is set. hashStates are the persisted states of the
+	// hashes of a previously interrupted transfer.
+	hasher     *hashWriter
+	hashStates [][]byte
+
+	// segments are the byte ranges which remain to be transferred when resuming
+	// a segmented transfer.
+	segments []*segment
+
+	// noSegments is set if the server ignored the byte ranges of a segmented
+	// transfer, so that the file is transferred as a single stream instead.
+	noSegments bool
+
+	// mirrors are the URLs from which the file may be transferred, in the order
+	// they will be tried. mirror is the index of the URL currently in use.
+	mirrors []*url.URL
+	mirror  int
+
+	// header is the header of the HTTP request of the Request when the
+	// transfer started, and origin is the host of its URL. Requests to
+	// mirrors and signatures on other hosts are sent without the credentials
+	// of the header. See mirrorRequest.
+	header http.Header
+	origin string
+
+	// written identifies the version of the remote file which was written to
+	// the destination file by a previous attempt, before failing over to a
+	// mirror.
+	written *validators
+
 	// optionsKnown indicates that a HEAD request has been completed and the
 	// capabilities of the remote server are known.
 	optionsKnown bool
 
+	// committed indicates that the file at the destination path, rather than
+	// the temporary file of an AtomicWrite, is the file being transferred or
+	// validated.
+	committed bool
+
+	// reserved is the destination path reserved for the transfer, if the
+	// CollisionPolicy of the Request is CollisionAutoRename.
+	reserved string
+
+	// conditional are the stored validators of the existing file, which are
+	// sent with the next GET request. conditionalTried is set once a
+	// conditional request has been considered, so that it is only attempted
+	// once.
+	conditional      *storedValidators
+	conditionalTried bool
+
+	// goodPieces records the pieces of a resumed file which were verified by
+	// resumePieces. piecesChecked is set once checkPieces has verified the
+	// pieces of the completed file.
+	goodPieces    map[int]bool
+	piecesChecked bool
+
+	// inspected is set once the InspectHook of the Request has been called.
+	inspected bool
+
+	// deltaTried is set once the existing destination file has been
+	// considered for an update with Request.Delta.
+	deltaTried bool
+
+	// cacheTried is set once the Cache of the Client has been searched for the
+	// file, so that it is only searched once.
+	cacheTried bool
+
 	// writer is the file handle used to write the downloaded file to local
 	// storage
 	writer io.WriteCloser
@@ -78,13 +233,40 @@ type Response struct {
 
 	// transfer is responsible for copying data from the remote server to a local
 	// file, tracking progress and allowing for cancelation.
-	transfer *transfer
+	transfer transferer
+
+	// attempts is the number of attempts that have been made to transfer the
+	// file, including the current attempt. retryAt is the time of the next
+	// attempt while waiting to retry. See Request.RetryPolicy.
+	attempts int
+	retryAt  time.Time
+
+	// trace records the Timings of the GET request of the current attempt.
+	trace *timingTrace
+
+	// span is the span of the transfer, if the Client has a Tracer.
+	span Span
+
+	// progressMu guards bytesResumed, transfer, attempts, retryAt and trace,
+	// which may be replaced while the transfer is in progress if it fails over
+	// to a mirror or is retried.
+	progressMu sync.Mutex
 
 	// bytesPerSecond specifies the number of bytes that have been transferred in
 	// the last 1-second window.
 	bytesPerSecond   float64
 	bytesPerSecondMu sync.Mutex
 
+	// smoothedBytesPerSecond is an exponentially weighted moving average of
+	// bytesPerSecond, guarded by bytesPerSecondMu. smoothed is set once the
+	// first sample is taken.
+	smoothedBytesPerSecond float64
+	smoothed               bool
+
+	// encoded counts the bytes of encoded content received by a transfer with
+	// Request.DecodeContent. Guarded by progressMu.
+	encoded *countingReader
+
 	// bufferSize specifies the size in bytes of the transfer buffer.
 	bufferSize int
 
@@ -112,6 +294,32 @@ func (c *Response) Cancel() error {
 	return c.Err()
 }
 
+// Pause suspends the file transfer until Resume is called. Pause returns
+// immediately, though the transfer may continue to write the contents of its
+// buffers before it is suspended.
+//
+// The connection to the remote server is held open while the transfer is
+// paused. Some servers may close idle connections, causing the transfer to
+// fail with an error when it is resumed.
+//
+// If the transfer has not yet started, it will be paused as soon as it starts.
+// Pausing a completed transfer has no effect.
+func (c *Response) Pause() {
+	c.gate.close()
+}
+
+// Resume continues a file transfer that was suspended by Pause, without
+// sending a new request to the remote server.
+func (c *Response) Resume() {
+	c.gate.open()
+}
+
+// IsPaused returns true if the file transfer has been suspended by Pause and
+// has not yet completed.
+func (c *Response) IsPaused() bool {
+	return !c.IsComplete() && c.gate.isClosed()
+}
+
 // Wait blocks until the download is completed.
 func (c *Response) Wait() {
 	<-c.Done
@@ -125,11 +333,45 @@ func (c *Response) Err() error {
 	return c.err
 }
 
+// Open blocks the calling goroutine until the underlying file transfer is
+// completed and then opens the downloaded file for reading. The caller must
+// close the returned file.
+//
+// If the transfer failed, its error is returned. Content written to an
+// io.Writer set with Request.SetWriter cannot be opened.
+func (c *Response) Open() (io.ReadCloser, error) {
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	if c.Request.writer != nil {
+		return nil, errWriterOpen
+	}
+	return os.Open(c.Filename)
+}
+
+// Bytes blocks the calling goroutine until the underlying file transfer is
+// completed and then reads the entire content of the downloaded file. See
+// Open.
+func (c *Response) Bytes() ([]byte, error) {
+	f, err := c.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
 // BytesComplete returns the total number of bytes which have been copied to
 // the destination, including any bytes that were resumed from a previous
 // download.
 func (c *Response) BytesComplete() int64 {
-	return c.bytesResumed + c.transfer.N()
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	n := c.bytesResumed
+	if c.transfer != nil {
+		n += c.transfer.N()
+	}
+	return n
 }
 
 // BytesPerSecond returns the number of bytes transferred in the last second. If
@@ -137,7 +379,7 @@ func (c *Response) BytesComplete() int64 {
 // download is returned.
 func (c *Response) BytesPerSecond() float64 {
 	if c.IsComplete() {
-		return float64(c.transfer.N()) / c.Duration().Seconds()
+		return float64(c.bytesTransferred()) / c.Duration().Seconds()
 	}
 	c.bytesPerSecondMu.Lock()
 	defer c.bytesPerSecondMu.Unlock()
@@ -146,7 +388,13 @@ func (c *Response) BytesPerSecond() float64 {
 
 // Progress returns the ratio of total bytes that have been downloaded. Multiply
 // the returned value by 100 to return the percentage completed.
+//
+// If the content of the file is decoded and its decoded size is unknown, the
+// ratio of encoded bytes that have been received is returned instead.
 func (c *Response) Progress() float64 {
+	if c.Size == 0 && c.EncodedSize > 0 && !c.IsComplete() {
+		return float64(c.BytesEncoded()) / float64(c.EncodedSize)
+	}
 	if c.Size == 0 {
 		return 0
 	}
@@ -177,10 +425,60 @@ func (c *Response) ETA() time.Time {
 	if bps == 0 {
 		return time.Time{}
 	}
-	secs := float64(c.Size-bt) / bps
+	secs := float64(c.estimatedSize()-bt) / bps
 	return time.Now().Add(time.Duration(secs) * time.Second)
 }
 
+// estimatedSize returns Response.Size or, if the decoded size of encoded
+// content is unknown, an estimate based on the ratio of encoded bytes that
+// have been received.
+func (c *Response) estimatedSize() int64 {
+	if c.Size != 0 || c.EncodedSize <= 0 {
+		return c.Size
+	}
+	p := c.Progress()
+	if p == 0 {
+		return 0
+	}
+	return int64(float64(c.BytesComplete()) / p)
+}
+
+// SmoothedBytesPerSecond returns an exponentially weighted moving average of
+// the transfer rate, sampled each second, which is less sensitive to brief
+// fluctuations than BytesPerSecond and more responsive to recent changes than
+// the average for the life of the download. The weight of each sample decays
+// over Request.SmoothingWindow. Time spent paused is not sampled.
+//
+// If the download is already complete, the average bytes/sec for the life of
+// the download is returned.
+func (c *Response) SmoothedBytesPerSecond() float64 {
+	if c.IsComplete() {
+		return float64(c.bytesTransferred()) / c.Duration().Seconds()
+	}
+	c.bytesPerSecondMu.Lock()
+	defer c.bytesPerSecondMu.Unlock()
+	return c.smoothedBytesPerSecond
+}
+
+// SmoothedETA returns the estimated time at which the download will complete,
+// given the current SmoothedBytesPerSecond. If the transfer has already
+// completed, the actual end time will be returned.
+func (c *Response) SmoothedETA() time.Time {
+	if c.IsComplete() {
+		return c.End
+	}
+	bps := c.SmoothedBytesPerSecond()
+	if bps == 0 {
+		return time.Time{}
+	}
+	secs := float64(c.estimatedSize()-c.BytesComplete()) / bps
+	return time.Now().Add(time.Duration(secs * float64(time.Second)))
+}
+
+// defaultSmoothingWindow is the window of SmoothedBytesPerSecond, if
+// Request.SmoothingWindow is zero.
+const defaultSmoothingWindow = 10 * time.Second
+
 // watchBps watches the progress of a transfer and maintains statistics.
 func (c *Response) watchBps() {
 	var prev int64
@@ -198,17 +496,136 @@ func (c *Response) watchBps() {
 			d := now.Sub(then)
 			then = now
 
-			cur := c.transfer.N()
+			cur := c.bytesTransferred()
 			bs := cur - prev
+			if bs < 0 {
+				// transfer restarted from a mirror
+				bs = cur
+			}
 			prev = cur
-
-			c.bytesPerSecondMu.Lock()
-			c.bytesPerSecond = float64(bs) / d.Seconds()
-			c.bytesPerSecondMu.Unlock()
+			c.sampleBps(float64(bs)/d.Seconds(), d, c.gate.isClosed())
 		}
 	}
 }
 
+// sampleBps records a sample of the transfer rate, measured over the given
+// duration. Samples taken while paused do not update the smoothed rate.
+func (c *Response) sampleBps(bps float64, d time.Duration, paused bool) {
+	c.bytesPerSecondMu.Lock()
+	defer c.bytesPerSecondMu.Unlock()
+	c.bytesPerSecond = bps
+	if paused {
+		return
+	}
+	if !c.smoothed {
+		c.smoothedBytesPerSecond = bps
+		c.smoothed = true
+		return
+	}
+	window := c.Request.SmoothingWindow
+	if window <= 0 {
+		window = defaultSmoothingWindow
+	}
+	alpha := 1 - math.Exp(-d.Seconds()/window.Seconds())
+	c.smoothedBytesPerSecond += alpha * (bps - c.smoothedBytesPerSecond)
+}
+
+// Attempts returns the number of attempts that have been made to transfer the
+// file, including the current attempt. Attempts is greater than one only if the
+// transfer was retried according to a RetryPolicy.
+func (c *Response) Attempts() int {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	return c.attempts
+}
+
+// RetryAt returns the time at which the transfer will be attempted again, if
+// the transfer failed and is waiting to be retried according to a
+// RetryPolicy. Otherwise, RetryAt returns the zero time.
+func (c *Response) RetryAt() time.Time {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	return c.retryAt
+}
+
+// waitRetry blocks until the given time, while reporting it via RetryAt. It
+// returns false if the Response is canceled before then.
+func (c *Response) waitRetry(t time.Time) bool {
+	c.progressMu.Lock()
+	c.retryAt = t
+	c.progressMu.Unlock()
+	defer func() {
+		c.progressMu.Lock()
+		c.retryAt = time.Time{}
+		c.progressMu.Unlock()
+	}()
+
+	timer := time.NewTimer(t.Sub(time.Now()))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// bytesTransferred returns the number of bytes copied by this transfer,
+// excluding any bytes that were resumed from a previous download.
+func (c *Response) bytesTransferred() int64 {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	if c.transfer == nil {
+		return 0
+	}
+	return c.transfer.N()
+}
+
+// setBytesResumed sets the number of bytes resumed from a previous download.
+func (c *Response) setBytesResumed(n int64) {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	c.bytesResumed = n
+}
+
+// newAttempt creates the Context of a new attempt to transfer the file,
+// canceling the Context of any previous attempt.
+func (c *Response) newAttempt() {
+	if c.cancelAttempt != nil {
+		c.cancelAttempt()
+	}
+	if d := c.Request.AttemptTimeout; d > 0 {
+		c.attemptCtx, c.cancelAttempt = context.WithTimeout(c.ctx, d)
+	} else {
+		c.attemptCtx, c.cancelAttempt = context.WithCancel(c.ctx)
+	}
+	atomic.StoreInt32(&c.stalled, 0)
+	atomic.StoreInt32(&c.outOfSpace, 0)
+}
+
+// attemptTimedOut returns true if the current attempt exceeded
+// Request.AttemptTimeout, while the Response itself is not canceled.
+func (c *Response) attemptTimedOut() bool {
+	return c.ctx.Err() == nil && c.attemptCtx.Err() == context.DeadlineExceeded
+}
+
+// discardResume discards any progress resumed from a previous download, so
+// that the transfer restarts from the beginning of the file.
+func (c *Response) discardResume() {
+	c.goodPieces = nil
+	c.DidResume = false
+	c.DidResumeState = false
+	c.hashStates = nil
+	c.setBytesResumed(0)
+}
+
+// setTransfer sets the transfer which copies the remote file.
+func (c *Response) setTransfer(t transferer) {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	c.transfer = t
+}
+
 func (c *Response) requestMethod() string {
 	if c == nil || c.HTTPResponse == nil || c.HTTPResponse.Request == nil {
 		return ""
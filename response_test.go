@@ -1,9 +1,13 @@
 package grab
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"math"
 	"os"
 	"testing"
+	"time"
 )
 
 // testComplete validates that a completed Response has all the desired fields.
@@ -75,3 +79,116 @@ func TestResponseProgress(t *testing.T) {
 		t.Errorf("Expected to transfer %v bytes, got %v", size, s)
 	}
 }
+
+// TestResponsePause tests that an in-progress transfer can be paused and
+// resumed without sending a new request.
+func TestResponsePause(t *testing.T) {
+	filename := ".testResponsePause"
+	defer os.Remove(filename)
+
+	req, _ := NewRequest(filename, ts.URL)
+	req.BeforeCopy = func(resp *Response) error {
+		resp.Pause()
+		return nil
+	}
+	resp := DefaultClient.Do(req)
+
+	// transfer should stall while paused
+	time.Sleep(100 * time.Millisecond)
+	if !resp.IsPaused() {
+		t.Fatalf("expected Response.IsPaused to return true")
+	}
+	n := resp.BytesComplete()
+	time.Sleep(100 * time.Millisecond)
+	if resp.IsComplete() || resp.BytesComplete() != n {
+		t.Fatalf("expected paused transfer to make no progress")
+	}
+
+	resp.Resume()
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if resp.IsPaused() {
+		t.Errorf("expected Response.IsPaused to return false")
+	}
+	testComplete(t, resp)
+
+	t.Run("WithCancel", func(t *testing.T) {
+		req, _ := NewRequest(filename, ts.URL)
+		req.NoResume = true
+		req.BeforeCopy = func(resp *Response) error {
+			resp.Pause()
+			return nil
+		}
+		resp := DefaultClient.Do(req)
+		if err := resp.Cancel(); err != context.Canceled {
+			t.Errorf("expected %v, got: %v", context.Canceled, err)
+		}
+	})
+}
+
+// TestSmoothedBytesPerSecond tests that the smoothed transfer rate is an
+// exponentially weighted moving average of each sample.
+func TestSmoothedBytesPerSecond(t *testing.T) {
+	req, _ := NewRequest("", "http://example.com/example.zip")
+	req.SmoothingWindow = time.Second
+	resp := &Response{Request: req, Done: make(chan struct{}), Size: 1000}
+
+	resp.sampleBps(100, time.Second, false)
+	if bps := resp.SmoothedBytesPerSecond(); bps != 100 {
+		t.Errorf("expected first sample to initialize average, got: %v", bps)
+	}
+
+	// samples while paused are ignored
+	resp.sampleBps(0, time.Second, true)
+	if bps := resp.SmoothedBytesPerSecond(); bps != 100 {
+		t.Errorf("expected paused sample to be ignored, got: %v", bps)
+	}
+	if bps := resp.BytesPerSecond(); bps != 0 {
+		t.Errorf("expected BytesPerSecond of last sample, got: %v", bps)
+	}
+
+	resp.sampleBps(200, time.Second, false)
+	expect := 100 + (1-math.Exp(-1))*100
+	if bps := resp.SmoothedBytesPerSecond(); math.Abs(bps-expect) > 1e-9 {
+		t.Errorf("expected %v, got: %v", expect, bps)
+	}
+	eta := resp.SmoothedETA()
+	d := time.Until(eta)
+	if d < 5500*time.Millisecond || d > 6500*time.Millisecond {
+		t.Errorf("expected ETA in about 6.1s, got: %v", d)
+	}
+}
+
+// TestResponseBytes tests that the content of a downloaded file can be read
+// with Response.Open and Response.Bytes.
+func TestResponseBytes(t *testing.T) {
+	filename := ".testResponseBytes"
+	defer os.Remove(filename)
+
+	req, _ := NewRequest(filename, ts.URL+"?size=4096")
+	resp := DefaultClient.Do(req)
+	b, err := resp.Bytes()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if len(b) != 4096 {
+		t.Errorf("expected 4096 bytes, got: %d", len(b))
+	}
+	f, err := resp.Open()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	f.Close()
+
+	req, _ = NewRequest(filename, ts.URL+"?status=404")
+	if _, err := DefaultClient.Do(req).Bytes(); err != StatusCodeError(404) {
+		t.Errorf("expected StatusCodeError(404), got: %v", err)
+	}
+
+	req, _ = NewRequest(filename, ts.URL+"?size=4096")
+	req.SetWriter(&bytes.Buffer{})
+	if _, err := DefaultClient.Do(req).Open(); err != errWriterOpen {
+		t.Errorf("expected errWriterOpen, got: %v", err)
+	}
+}
@@ -0,0 +1,315 @@
+package grab
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A BackoffFunc returns the duration to wait before the given retry attempt of
+// a failed transfer. The first retry is attempt 1.
+type BackoffFunc func(attempt int) time.Duration
+
+// ExponentialBackoff returns a BackoffFunc which doubles the wait before each
+// retry attempt, starting at base, up to a maximum of max. A random jitter of
+// up to half of each wait is subtracted so that many clients which failed at
+// the same time do not retry in lockstep.
+func ExponentialBackoff(base, max time.Duration) BackoffFunc {
+	return func(attempt int) time.Duration {
+		d := base
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		if d <= 0 {
+			return 0
+		}
+		return d - time.Duration(rand.Int63n(int64(d)/2+1))
+	}
+}
+
+// DefaultRetryableStatusCodes are the HTTP status codes which indicate a
+// transient failure of the remote server, if RetryPolicy.RetryableStatusCodes
+// is nil.
+var DefaultRetryableStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// A RetryPolicy specifies how transfers which fail because of a transient
+// error are retried. A retried transfer is resumed from the bytes already
+// written to the destination file, if the remote file has not changed and the
+// remote server supports ranged requests.
+//
+// Each attempt makes one pass over the URL of a Request and any of its
+// Mirrors. The Client waits between attempts while the Response is
+// incomplete, or while the caller of Client.Do is blocked if the transfer had
+// not yet started.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts to transfer a file,
+	// including the first attempt. A value less than two disables retries.
+	MaxAttempts int
+
+	// Backoff returns the duration to wait before each retry attempt. If nil,
+	// ExponentialBackoff(time.Second, 30*time.Second) is used.
+	Backoff BackoffFunc
+
+	// RetryableStatusCodes are the HTTP status codes that may be retried. If
+	// nil, DefaultRetryableStatusCodes is used.
+	RetryableStatusCodes []int
+
+	// ShouldRetry, if not nil, decides whether the given error may be
+	// retried, instead of the default classification. By default, network
+	// errors, timeouts, unexpected EOFs, ErrStalled, ErrAttemptTimeout,
+	// ErrHostCircuitOpen and RetryableStatusCodes are retried. Certificate
+	// verification failures and pin mismatches are never retried.
+	ShouldRetry func(err error) bool
+
+	// MaxRetryAfter is the maximum duration to wait before a retry attempt if
+	// the remote server responds with 429 Too Many Requests or 503 Service
+	// Unavailable and a Retry-After header. The Retry-After header is used
+	// instead of Backoff. If zero, the Retry-After header is always honored.
+	MaxRetryAfter time.Duration
+
+	// Budget, if not nil, limits the retries of all transfers which share
+	// the RetryPolicy, such as the requests of a batch sent by a Client with
+	// a RetryPolicy. See RetryBudget.
+	Budget *RetryBudget
+}
+
+// A RetryBudget limits the total number of retries, and the total time spent
+// waiting before retries, of many transfers, so that a pathological batch fails
+// fast instead of multiplying the retries of each request into hours of churn.
+// Once the budget is exhausted, transfers fail with the error of their last
+// attempt, wrapped with ErrRetryBudgetExhausted. A RetryBudget is safe for
+// concurrent use.
+type RetryBudget struct {
+	// MaxRetries is the maximum number of retries of all transfers. If zero,
+	// the number of retries is not limited.
+	MaxRetries int
+
+	// MaxRetryTime is the maximum total duration of the waits before the
+	// retries of all transfers. If zero, the duration is not limited.
+	MaxRetryTime time.Duration
+
+	mu      sync.Mutex
+	retries int
+	waited  time.Duration
+}
+
+// take reserves a retry which waits for the given duration from the budget,
+// and returns false if the budget is exhausted.
+func (c *RetryBudget) take(d time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.MaxRetries > 0 && c.retries >= c.MaxRetries ||
+		c.MaxRetryTime > 0 && c.waited+d > c.MaxRetryTime {
+		return false
+	}
+	c.retries++
+	c.waited += d
+	return true
+}
+
+// Used returns the number of retries and the total duration of the waits
+// before retries which have been taken from the budget.
+func (c *RetryBudget) Used() (retries int, waited time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.retries, c.waited
+}
+
+var defaultBackoff = ExponentialBackoff(time.Second, 30*time.Second)
+
+// backoff returns the duration to wait before the given retry attempt.
+func (c *RetryPolicy) backoff(attempt int) time.Duration {
+	if c.Backoff == nil {
+		return defaultBackoff(attempt)
+	}
+	return c.Backoff(attempt)
+}
+
+// retryAfter returns the duration that the remote server of the given
+// Response asked the client to wait before a retry attempt, using a
+// Retry-After header in a 429 or 503 response.
+func (c *RetryPolicy) retryAfter(resp *Response, now time.Time) (time.Duration, bool) {
+	if resp.HTTPResponse == nil {
+		return 0, false
+	}
+	switch resp.HTTPResponse.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return 0, false
+	}
+	d, ok := parseRetryAfter(resp.HTTPResponse.Header.Get("Retry-After"), now)
+	if !ok {
+		return 0, false
+	}
+	if c.MaxRetryAfter > 0 && d > c.MaxRetryAfter {
+		d = c.MaxRetryAfter
+	}
+	return d, true
+}
+
+// parseRetryAfter parses the value of a Retry-After header, given as a number
+// of seconds or an HTTP date.
+func parseRetryAfter(s string, now time.Time) (time.Duration, bool) {
+	if s == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(s)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// retryable returns true if the given error may be retried.
+func (c *RetryPolicy) retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if c.ShouldRetry != nil {
+		return c.ShouldRetry(err)
+	}
+	if errors.Is(err, ErrRedirectPolicy) || errors.Is(err, ErrInsecure) || isCertificateError(err) {
+		return false
+	}
+	var (
+		statusErr StatusCodeError
+		urlErr    *url.Error
+		opErr     *net.OpError
+		netErr    net.Error
+	)
+	switch {
+	case errors.As(err, &statusErr):
+		codes := c.RetryableStatusCodes
+		if codes == nil {
+			codes = DefaultRetryableStatusCodes
+		}
+		for _, code := range codes {
+			if int(statusErr) == code {
+				return true
+			}
+		}
+		return false
+
+	case errors.As(err, &urlErr), errors.As(err, &opErr):
+		return true
+
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, ErrStalled) ||
+		errors.Is(err, ErrAttemptTimeout) ||
+		errors.Is(err, ErrHostCircuitOpen)
+}
+
+// isCertificateError returns true if the given error, or any error it wraps,
+// indicates that the certificate of the remote server could not be verified or
+// did not match a pin. Such errors are not resolved by retrying.
+func isCertificateError(err error) bool {
+	var (
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		hostnameErr  x509.HostnameError
+	)
+	return errors.Is(err, ErrPinMismatch) ||
+		errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) ||
+		errors.As(err, &invalidErr) ||
+		errors.As(err, &hostnameErr)
+}
+
+// retryPolicy returns the RetryPolicy for the given Request, or nil if
+// retries are disabled.
+func (c *Client) retryPolicy(req *Request) *RetryPolicy {
+	if req.RetryPolicy != nil {
+		return req.RetryPolicy
+	}
+	return c.RetryPolicy
+}
+
+// retry is called when a transfer has failed because of an error from the
+// remote server, or while communicating with it, and no more mirrors are
+// available.
+//
+// If the error may be retried and more attempts are permitted by the
+// RetryPolicy, the Client waits for the backoff duration, or the duration
+// given by a Retry-After header, and the next stateFunc is statFileInfo, using
+// the first URL of the Request.
+//
+// Otherwise, or if the context of the Request would expire before the next
+// attempt, or the RetryBudget of the policy is exhausted, the next stateFunc
+// is closeResponse.
+func (c *Client) retry(resp *Response) stateFunc {
+	p := c.retryPolicy(resp.Request)
+	if p == nil || resp.Attempts() >= p.MaxAttempts || !p.retryable(resp.err) {
+		return c.closeResponse
+	}
+
+	now := time.Now()
+	d, ok := p.retryAfter(resp, now)
+	if !ok {
+		d = p.backoff(resp.Attempts())
+	}
+	if c.CircuitBreaker != nil && errors.Is(resp.err, ErrHostCircuitOpen) {
+		// wait for the circuit of the first URL to let an attempt through
+		if t := c.CircuitBreaker.reopens(resp.mirrors[0].Host); t.Sub(now) > d {
+			d = t.Sub(now)
+		}
+	}
+	if deadline, ok := resp.ctx.Deadline(); ok && now.Add(d).After(deadline) {
+		// fail now with the error of the last attempt
+		return c.closeResponse
+	}
+	if p.Budget != nil && !p.Budget.take(d) {
+		c.logf(resp, slog.LevelWarn, "retry budget exhausted",
+			"attempt", resp.Attempts(),
+			"error", resp.err)
+		resp.err = fmt.Errorf("%w: %w", ErrRetryBudgetExhausted, resp.err)
+		return c.closeResponse
+	}
+
+	c.logf(resp, slog.LevelInfo, "retrying transfer",
+		"attempt", resp.Attempts()+1,
+		"delay", d,
+		"error", resp.err)
+	if !resp.waitRetry(now.Add(d)) {
+		resp.err = resp.ctx.Err()
+		return c.closeResponse
+	}
+	resp.progressMu.Lock()
+	resp.attempts++
+	resp.progressMu.Unlock()
+	resp.mirror = 0
+	c.reset(resp, resp.mirrorRequest(resp.mirrors[0]))
+	return c.statFileInfo
+}
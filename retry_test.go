@@ -0,0 +1,371 @@
+package grab
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestExponentialBackoff tests that backoff durations grow exponentially,
+// within the bounds of the jitter and the maximum.
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(100*time.Millisecond, time.Second)
+	tests := []struct {
+		Attempt int
+		Max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+	for _, test := range tests {
+		for i := 0; i < 100; i++ {
+			d := backoff(test.Attempt)
+			if d > test.Max || d < test.Max/2 {
+				t.Fatalf("expected backoff for attempt %d between %v and %v, got: %v", test.Attempt, test.Max/2, test.Max, d)
+			}
+		}
+	}
+}
+
+// TestRetryPolicy tests that transfers which fail because of transient errors
+// are retried.
+func TestRetryPolicy(t *testing.T) {
+	noBackoff := func(int) time.Duration { return 0 }
+
+	// failingServer returns the given status code for the first n requests
+	failingServer := func(n int32, code int) *httptest.Server {
+		var count int32
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&count, 1) <= n {
+				w.WriteHeader(code)
+				return
+			}
+			http.Redirect(w, r, ts.URL+"?size=4096", http.StatusFound)
+		}))
+	}
+
+	tests := []struct {
+		Name        string
+		Failures    int32
+		Code        int
+		MaxAttempts int
+		Attempts    int
+		Err         error
+	}{
+		{"Success", 2, http.StatusServiceUnavailable, 3, 3, nil},
+		{"Exhausted", 2, http.StatusServiceUnavailable, 2, 2, StatusCodeError(http.StatusServiceUnavailable)},
+		{"NotRetryable", 2, http.StatusNotFound, 3, 1, StatusCodeError(http.StatusNotFound)},
+		{"Disabled", 2, http.StatusServiceUnavailable, 0, 1, StatusCodeError(http.StatusServiceUnavailable)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			s := failingServer(test.Failures, test.Code)
+			defer s.Close()
+			filename := ".testRetryPolicy" + test.Name
+			defer os.Remove(filename)
+			req, _ := NewRequest(filename, s.URL)
+			req.RetryPolicy = &RetryPolicy{
+				MaxAttempts: test.MaxAttempts,
+				Backoff:     noBackoff,
+			}
+			resp := DefaultClient.Do(req)
+			if err := resp.Err(); err != test.Err {
+				t.Fatalf("expected error %v, got: %v", test.Err, err)
+			}
+			if n := resp.Attempts(); n != test.Attempts {
+				t.Errorf("expected %d attempts, got: %d", test.Attempts, n)
+			}
+		})
+	}
+
+	t.Run("ClientPolicy", func(t *testing.T) {
+		s := failingServer(1, http.StatusBadGateway)
+		defer s.Close()
+		filename := ".testRetryPolicyClient"
+		defer os.Remove(filename)
+		client := NewClient()
+		client.RetryPolicy = &RetryPolicy{MaxAttempts: 2, Backoff: noBackoff}
+		req, _ := NewRequest(filename, s.URL)
+		if err := client.Do(req).Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+	})
+
+	t.Run("Resume", func(t *testing.T) {
+		filename := ".testRetryPolicyResume"
+		defer os.Remove(filename)
+		req, _ := NewRequest(filename, fmt.Sprintf("%s?size=%d", ts.URL, 1048576))
+		req.RetryPolicy = &RetryPolicy{
+			MaxAttempts: 2,
+			Backoff:     noBackoff,
+			ShouldRetry: func(err error) bool { return err == errTestInterrupt },
+		}
+		interrupted := false
+		req.GetReader = func(r io.Reader) (io.Reader, error) {
+			if interrupted {
+				return r, nil
+			}
+			interrupted = true
+			return &failingReader{r: r, n: 65536}, nil
+		}
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if !resp.DidResume {
+			t.Errorf("expected retried transfer to resume")
+		}
+		testComplete(t, resp)
+	})
+
+	t.Run("Certificate", func(t *testing.T) {
+		s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello"))
+		}))
+		defer s.Close()
+		roots := x509.NewCertPool()
+		roots.AddCert(s.Certificate())
+		other := sha256.Sum256([]byte("other"))
+		breaker := &CircuitBreaker{FailureThreshold: 1, Cooldown: time.Hour}
+		budget := &RetryBudget{MaxRetries: 1}
+
+		for _, test := range []struct {
+			Name   string
+			URL    string
+			Policy *TLSPolicy
+		}{
+			{"UnknownAuthority", s.URL, nil},
+			{"Hostname", strings.Replace(s.URL, "127.0.0.1", "localhost", 1), &TLSPolicy{RootCAs: roots}},
+			{"Pin", s.URL, &TLSPolicy{RootCAs: roots, PinnedKeys: [][]byte{other[:]}}},
+		} {
+			filename := ".testRetryPolicyCertificate"
+			defer os.Remove(filename)
+			client := NewClient()
+			client.CircuitBreaker = breaker
+			req, _ := NewRequest(filename, test.URL)
+			req.NoResume = true
+			req.TLS = test.Policy
+			req.RetryPolicy = &RetryPolicy{
+				MaxAttempts: 3,
+				Backoff:     noBackoff,
+				Budget:      budget,
+			}
+			resp := client.Do(req)
+			if err := resp.Err(); err == nil || errors.Is(err, ErrHostCircuitOpen) {
+				t.Errorf("%s: expected certificate error, got: %v", test.Name, err)
+			}
+			if n := resp.Attempts(); n != 1 {
+				t.Errorf("%s: expected 1 attempt, got: %d", test.Name, n)
+			}
+		}
+		if budget.retries != 0 {
+			t.Errorf("expected no retries from the budget, got: %d", budget.retries)
+		}
+	})
+
+	t.Run("CancelBackoff", func(t *testing.T) {
+		s := failingServer(1, http.StatusServiceUnavailable)
+		defer s.Close()
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := NewRequest(".testRetryPolicyCancel", s.URL)
+		req = req.WithContext(ctx)
+		req.RetryPolicy = &RetryPolicy{
+			MaxAttempts: 2,
+			Backoff:     func(int) time.Duration { return time.Hour },
+		}
+		time.AfterFunc(50*time.Millisecond, cancel)
+		if err := DefaultClient.Do(req).Err(); err != context.Canceled {
+			t.Fatalf("expected error %v, got: %v", context.Canceled, err)
+		}
+	})
+}
+
+// TestRetryAfter tests that the Retry-After header of 429 and 503 responses is
+// honored before a retry attempt.
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for s, expect := range map[string]time.Duration{
+		"120":                           2 * time.Minute,
+		"0":                             0,
+		"Sun, 01 Jan 2017 00:00:30 GMT": 30 * time.Second,
+		"Sat, 31 Dec 2016 00:00:00 GMT": 0,
+	} {
+		d, ok := parseRetryAfter(s, now)
+		if !ok || d != expect {
+			t.Errorf("expected Retry-After %q to be %v, got: %v (%v)", s, expect, d, ok)
+		}
+	}
+	for _, s := range []string{"", "-1", "soon"} {
+		if _, ok := parseRetryAfter(s, now); ok {
+			t.Errorf("expected Retry-After %q to be invalid", s)
+		}
+	}
+
+	var count int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		http.Redirect(w, r, ts.URL+"?size=4096", http.StatusFound)
+	}))
+	defer s.Close()
+
+	t.Run("Wait", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		filename := ".testRetryAfter"
+		defer os.Remove(filename)
+		req, _ := NewRequest(filename, s.URL)
+		req.RetryPolicy = &RetryPolicy{
+			MaxAttempts:   2,
+			Backoff:       func(int) time.Duration { return time.Hour },
+			MaxRetryAfter: 100 * time.Millisecond,
+		}
+		start := time.Now()
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if d := time.Since(start); d < 100*time.Millisecond || d > 10*time.Second {
+			t.Errorf("expected to wait for Retry-After, waited: %v", d)
+		}
+		if !resp.RetryAt().IsZero() {
+			t.Errorf("expected RetryAt to be zero after retry")
+		}
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+		req, _ := NewRequest(".testRetryAfterDeadline", s.URL)
+		req = req.WithContext(ctx)
+		req.RetryPolicy = &RetryPolicy{MaxAttempts: 2}
+		start := time.Now()
+		err := DefaultClient.Do(req).Err()
+		if err != StatusCodeError(http.StatusTooManyRequests) {
+			t.Fatalf("expected error %v, got: %v", StatusCodeError(http.StatusTooManyRequests), err)
+		}
+		if d := time.Since(start); d > 400*time.Millisecond {
+			t.Errorf("expected to fail before the deadline, waited: %v", d)
+		}
+	})
+}
+
+// TestAttemptTimeout tests that each attempt is bounded by AttemptTimeout,
+// while retries continue until the deadline of the Request context.
+func TestAttemptTimeout(t *testing.T) {
+	// the first request is slower than the attempt timeout
+	var count int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		http.Redirect(w, r, ts.URL+"?size=4096", http.StatusFound)
+	}))
+	defer s.Close()
+
+	t.Run("Timeout", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		req, _ := NewRequest(".testAttemptTimeout", s.URL)
+		req.AttemptTimeout = 100 * time.Millisecond
+		if err := DefaultClient.Do(req).Err(); err != ErrAttemptTimeout {
+			t.Fatalf("expected error %v, got: %v", ErrAttemptTimeout, err)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		atomic.StoreInt32(&count, 0)
+		filename := ".testAttemptTimeoutRetry"
+		defer os.Remove(filename)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, _ := NewRequest(filename, s.URL)
+		req = req.WithContext(ctx)
+		req.AttemptTimeout = 100 * time.Millisecond
+		req.RetryPolicy = &RetryPolicy{
+			MaxAttempts: 2,
+			Backoff:     func(int) time.Duration { return 0 },
+		}
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if n := resp.Attempts(); n != 2 {
+			t.Errorf("expected 2 attempts, got: %d", n)
+		}
+	})
+}
+
+// TestRetryBudget tests that the retries of a batch stop once the RetryBudget
+// shared by its requests is exhausted.
+func TestRetryBudget(t *testing.T) {
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer s.Close()
+
+	budget := &RetryBudget{MaxRetries: 3}
+	client := NewClient()
+	client.RetryPolicy = &RetryPolicy{
+		MaxAttempts: 10,
+		Backoff:     func(int) time.Duration { return time.Millisecond },
+		Budget:      budget,
+	}
+	var reqs []*Request
+	for i := 0; i < 4; i++ {
+		req, _ := NewRequest("", s.URL)
+		req.SetWriter(io.Discard)
+		reqs = append(reqs, req)
+	}
+	for resp := range client.DoBatch(2, reqs...) {
+		err := resp.Err()
+		if !errors.Is(err, ErrRetryBudgetExhausted) || !errors.Is(err, StatusCodeError(http.StatusServiceUnavailable)) {
+			t.Errorf("expected retry budget to be exhausted, got: %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&requests); n != 7 {
+		t.Errorf("expected 7 requests, got: %d", n)
+	}
+	if retries, waited := budget.Used(); retries != 3 || waited != 3*time.Millisecond {
+		t.Errorf("unexpected budget use: %d retries, %v", retries, waited)
+	}
+
+	// the total wait before retries is limited
+	budget = &RetryBudget{MaxRetryTime: 25 * time.Millisecond}
+	req, _ := NewRequest("", s.URL)
+	req.SetWriter(io.Discard)
+	req.RetryPolicy = &RetryPolicy{
+		MaxAttempts: 10,
+		Backoff:     func(int) time.Duration { return 10 * time.Millisecond },
+		Budget:      budget,
+	}
+	if err := client.Do(req).Err(); !errors.Is(err, ErrRetryBudgetExhausted) {
+		t.Errorf("expected retry budget to be exhausted, got: %v", err)
+	}
+	if retries, _ := budget.Used(); retries != 2 {
+		t.Errorf("expected 2 retries, got: %d", retries)
+	}
+}
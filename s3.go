@@ -0,0 +1,228 @@
+package grab
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config configures the transfer of files at s3://bucket/key URLs from
+// Amazon S3 or an S3-compatible object store. Requests are signed with AWS
+// Signature Version 4. See Client.S3.
+type S3Config struct {
+	// Region is the region of the buckets, such as "us-east-1". If empty, the
+	// AWS_REGION or AWS_DEFAULT_REGION environment variable is used, or
+	// "us-east-1" if neither is set.
+	Region string
+
+	// Endpoint is the base URL of an S3-compatible object store, such as
+	// "http://localhost:9000". If empty, the regional endpoint of Amazon S3 is
+	// used.
+	Endpoint string
+
+	// PathStyle specifies that the bucket name is given in the path of each
+	// request, rather than as a subdomain of the endpoint. Most S3-compatible
+	// object stores require path-style requests.
+	PathStyle bool
+
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials used to
+	// sign each request. If AccessKeyID is empty, the AWS_ACCESS_KEY_ID,
+	// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables are
+	// used. If no credentials are found, requests are not signed, which allows
+	// access to public buckets only.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// s3EmptyHash is the hex encoded SHA256 hash of an empty request body.
+const s3EmptyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// region returns the configured region.
+func (c *S3Config) region() string {
+	for _, v := range []string{c.Region, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION")} {
+		if v != "" {
+			return v
+		}
+	}
+	return "us-east-1"
+}
+
+// credentials returns the configured credentials.
+func (c *S3Config) credentials() (id, secret, token string) {
+	if c.AccessKeyID != "" {
+		return c.AccessKeyID, c.SecretAccessKey, c.SessionToken
+	}
+	return os.Getenv("AWS_ACCESS_KEY_ID"),
+		os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		os.Getenv("AWS_SESSION_TOKEN")
+}
+
+// objectURL returns the HTTP URL of the object at the given s3:// URL.
+func (c *S3Config) objectURL(u *url.URL) (*url.URL, error) {
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("malformed S3 URL: %s", u)
+	}
+	base := &url.URL{Scheme: "https", Host: "s3." + c.region() + ".amazonaws.com"}
+	if c.Endpoint != "" {
+		var err error
+		if base, err = url.Parse(c.Endpoint); err != nil {
+			return nil, err
+		}
+	}
+	obj := *base
+	obj.RawQuery = u.RawQuery
+	if c.PathStyle {
+		obj.Path = strings.TrimSuffix(base.Path, "/") + "/" + bucket + "/" + key
+	} else {
+		obj.Host = bucket + "." + base.Host
+		obj.Path = strings.TrimSuffix(base.Path, "/") + "/" + key
+	}
+	return &obj, nil
+}
+
+// s3Request returns a copy of the given request for an s3:// URL, addressed
+// to the object store and signed with the configured credentials.
+func s3Request(req *http.Request, config *S3Config) (*http.Request, error) {
+	if config == nil {
+		config = &S3Config{}
+	}
+	u, err := config.objectURL(req.URL)
+	if err != nil {
+		return nil, err
+	}
+	hreq := req.Clone(req.Context())
+	hreq.URL = u
+	hreq.Host = u.Host
+	if id, secret, token := config.credentials(); id != "" {
+		signS3(hreq, config.region(), id, secret, token, time.Now())
+	}
+	return hreq, nil
+}
+
+// signS3 signs the given request for the given region with AWS Signature
+// Version 4, at the given time. The Host and Range headers, and all x-amz-*
+// headers, are signed.
+func signS3(req *http.Request, region, id, secret, token string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	date := t.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3EmptyHash)
+	if token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	// canonical headers
+	headers := map[string]string{"host": req.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "range" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// canonical query
+	q := req.URL.Query()
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			params = append(params, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+
+	canonical := strings.Join([]string{
+		req.Method,
+		s3Escape(req.URL.Path, false),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		s3EmptyHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := []byte("AWS4" + secret)
+	for _, v := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, v)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%x",
+		id, scope, signedHeaders, hmacSHA256(key, stringToSign)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape URI encodes the given string for a canonical request. All bytes
+// except unreserved characters are encoded, and slashes are encoded only if
+// slash is set.
+func s3Escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !slash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3RoundTrip signs the given request for an s3:// URL and sends it to the
+// object store configured by Client.S3.
+func s3RoundTrip(c *Client, req *http.Request) (*http.Response, error) {
+	hreq, err := s3Request(req, c.S3)
+	if err != nil {
+		return nil, err
+	}
+	return c.sendHTTP(hreq)
+}
+
+// s3Checksum returns the MD5 checksum of an S3 object given by its ETag, or
+// nil if the ETag is not the MD5 checksum of the object, as is the case for
+// objects uploaded in multiple parts or encrypted with SSE-KMS or SSE-C.
+func s3Checksum(hresp *http.Response) *Checksum {
+	h := hresp.Header
+	if h.Get("X-Amz-Server-Side-Encryption") == "aws:kms" ||
+		h.Get("X-Amz-Server-Side-Encryption-Customer-Algorithm") != "" {
+		return nil
+	}
+	sum, err := hex.DecodeString(strings.Trim(h.Get("ETag"), `"`))
+	if err != nil || len(sum) != md5.Size {
+		return nil
+	}
+	return &Checksum{Hash: md5.New(), Expected: sum}
+}
@@ -0,0 +1,92 @@
+package grab
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxFilenameLength is the maximum length in bytes of the filenames returned
+// by SanitizeFilename, which is the limit of most file systems.
+const maxFilenameLength = 255
+
+// windowsReservedNames are the device names which may not be used as the base
+// name of a file on Windows, regardless of extension.
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizeFilename is the default sanitizer of the filenames given by remote
+// servers and URLs. See Request.FilenameSanitizer. It returns a filename
+// which is valid on Windows, macOS and Linux alike, so that a file has the
+// same name on every platform:
+//
+// Path separators, control characters and the characters <>:"|?* are
+// replaced with underscores. Leading spaces and trailing dots and spaces are
+// removed. Windows device names, such as CON or nul.txt, are prefixed with an
+// underscore. Filenames longer than 255 bytes are truncated, preserving their
+// extension. ErrNoFilename is returned if no filename remains.
+func SanitizeFilename(name string) (string, error) {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f || r == utf8.RuneError:
+			return '_'
+		case strings.ContainsRune(`/\<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimLeft(name, " ")
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "", ErrNoFilename
+	}
+	base := name
+	if i := strings.Index(base, "."); i >= 0 {
+		base = base[:i]
+	}
+	if windowsReservedNames[strings.ToUpper(strings.TrimRight(base, " "))] {
+		name = "_" + name
+	}
+	if len(name) > maxFilenameLength {
+		ext := path.Ext(name)
+		if len(ext) > maxFilenameLength/2 {
+			ext = ""
+		}
+		stem := name[:maxFilenameLength-len(ext)]
+		for !utf8.ValidString(stem) {
+			stem = stem[:len(stem)-1]
+		}
+		name = strings.TrimRight(stem, ". ") + ext
+	}
+	return name, nil
+}
+
+// sanitizeFilename sanitizes the given filename, given by the remote server or
+// the URL of the given Request, with Request.FilenameSanitizer or
+// SanitizeFilename, after normalizing it to NFC unless
+// Request.NoNormalizeFilename is set. The sanitized filename must still be a
+// single path element.
+func sanitizeFilename(req *Request, name string) (string, error) {
+	if !req.NoNormalizeFilename {
+		name = normalizeNFC(name)
+	}
+	sanitize := req.FilenameSanitizer
+	if sanitize == nil {
+		sanitize = SanitizeFilename
+	}
+	name, err := sanitize(name)
+	if err != nil {
+		return "", err
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\x00") ||
+		strings.ContainsRune(name, filepath.Separator) || name == "" {
+		return "", ErrNoFilename
+	}
+	return name, nil
+}
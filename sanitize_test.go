@@ -0,0 +1,96 @@
+package grab
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := map[string]string{
+		"example.zip":          "example.zip",
+		"日本.txt":               "日本.txt",
+		`..\..\evil.exe`:       ".._.._evil.exe",
+		"a<b>c:d\"e|f?g*h.txt": "a_b_c_d_e_f_g_h.txt",
+		"tab\tnew\nline":       "tab_new_line",
+		"  leading.txt":        "leading.txt",
+		"trailing. . ":         "trailing",
+		"CON":                  "_CON",
+		"nul.txt":              "_nul.txt",
+		"Com1.tar.gz":          "_Com1.tar.gz",
+		"console.txt":          "console.txt",
+		"invalid\xffutf8":      "invalid_utf8",
+	}
+	for name, expect := range tests {
+		actual, err := SanitizeFilename(name)
+		if err != nil || actual != expect {
+			t.Errorf("%q: expected %q, got: %q (%v)", name, expect, actual, err)
+		}
+	}
+	for _, name := range []string{"", "...", "  ", ". ."} {
+		if _, err := SanitizeFilename(name); err != ErrNoFilename {
+			t.Errorf("%q: expected ErrNoFilename, got: %v", name, err)
+		}
+	}
+
+	long := strings.Repeat("é", 200) + ".tar.gz"
+	actual, _ := SanitizeFilename(long)
+	if len(actual) > maxFilenameLength || !strings.HasSuffix(actual, ".gz") || !utf8.ValidString(actual) {
+		t.Errorf("expected long filename to be truncated, got: %q (%d bytes)", actual, len(actual))
+	}
+}
+
+// TestFilenameSanitizer tests that filenames given by remote servers are
+// sanitized with Request.FilenameSanitizer.
+func TestFilenameSanitizer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var disposition string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", disposition)
+		fmt.Fprint(w, "content")
+	}))
+	defer s.Close()
+
+	tests := []struct {
+		disposition string
+		sanitizer   func(string) (string, error)
+		expect      string
+	}{
+		{`attachment; filename="nul.txt"`, nil, "_nul.txt"},
+		{`attachment; filename="a:b.txt"`, nil, "a_b.txt"},
+		{`attachment; filename="example.txt"`, func(s string) (string, error) { return strings.ToUpper(s), nil }, "EXAMPLE.TXT"},
+		{`attachment; filename="a.txt"`, func(s string) (string, error) { return "../a.txt", nil }, ""},
+		{`attachment; filename="a.txt"`, func(s string) (string, error) { return "", ErrNoFilename }, ""},
+	}
+	for _, test := range tests {
+		disposition = test.disposition
+		req, _ := NewRequest(dir, s.URL)
+		req.FilenameSanitizer = test.sanitizer
+		resp := DefaultClient.Do(req)
+		if test.expect == "" {
+			if err := resp.Err(); err != ErrNoFilename {
+				t.Errorf("%s: expected ErrNoFilename, got: %v", test.disposition, err)
+			}
+			continue
+		}
+		if err := resp.Err(); err != nil {
+			t.Errorf("%s: error: %v", test.disposition, err)
+			continue
+		}
+		if resp.Filename != filepath.Join(dir, test.expect) {
+			t.Errorf("%s: expected %s, got: %s", test.disposition, test.expect, resp.Filename)
+		}
+		os.Remove(resp.Filename)
+	}
+}
@@ -0,0 +1,146 @@
+package grab
+
+import (
+	"context"
+	"time"
+)
+
+// A BandwidthSchedule sets the global rate limit of a Client by the time of
+// day, such as to transfer at full speed overnight and at 1 MB/s during office
+// hours on a shared link. The schedule is re-evaluated during long transfers.
+// See Client.SetBandwidthSchedule.
+type BandwidthSchedule struct {
+	// Windows are the periods of the day with their own rate limit. The first
+	// window which contains the current time applies.
+	Windows []BandwidthWindow
+
+	// BytesPerSecond is the rate limit outside of all Windows. If less than
+	// one, transfers are not limited outside of the Windows.
+	BytesPerSecond int
+
+	// Location is the time zone of the Windows. If nil, time.Local is used.
+	Location *time.Location
+}
+
+// A BandwidthWindow is a period of the day in a BandwidthSchedule.
+type BandwidthWindow struct {
+	// Start and End are the times of day at which the window starts and ends,
+	// as the time since midnight, such as 7 * time.Hour for 07:00. A window
+	// whose End is before its Start spans midnight, and a window whose Start
+	// and End are equal spans the whole day.
+	Start, End time.Duration
+
+	// Weekdays, if not empty, are the days on which the window starts.
+	// Otherwise, the window applies every day.
+	Weekdays []time.Weekday
+
+	// BytesPerSecond is the rate limit during the window. If less than one,
+	// transfers are not limited during the window.
+	BytesPerSecond int
+}
+
+// contains returns true if the given time of day, on the given weekday, is
+// within the window.
+func (c *BandwidthWindow) contains(day time.Weekday, clock time.Duration) bool {
+	switch {
+	case c.Start == c.End:
+		return c.on(day)
+	case c.Start < c.End:
+		return clock >= c.Start && clock < c.End && c.on(day)
+	case clock >= c.Start:
+		return c.on(day)
+	case clock < c.End:
+		// the window started the day before
+		return c.on((day + 6) % 7)
+	}
+	return false
+}
+
+// on returns true if the window starts on the given weekday.
+func (c *BandwidthWindow) on(day time.Weekday) bool {
+	if len(c.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range c.Weekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// RateAt returns the rate limit of the schedule at the given time, in bytes
+// per second, or zero if transfers are not limited.
+func (c *BandwidthSchedule) RateAt(t time.Time) int {
+	loc := c.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+	h, m, s := t.Clock()
+	clock := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	rate := c.BytesPerSecond
+	for i := range c.Windows {
+		if c.Windows[i].contains(t.Weekday(), clock) {
+			rate = c.Windows[i].BytesPerSecond
+			break
+		}
+	}
+	if rate < 1 {
+		return 0
+	}
+	return rate
+}
+
+// scheduleInterval is the interval at which the BandwidthSchedule of a Client
+// is re-evaluated while files are transferred.
+const scheduleInterval = time.Second
+
+// SetBandwidthSchedule sets the global rate limit of the client according to
+// the given schedule, instead of a fixed SetGlobalRateLimit. The schedule is
+// re-evaluated while files are transferred, so the limit of transfers that are
+// in progress changes at the boundaries of its windows. A nil schedule
+// removes the limit.
+func (c *Client) SetBandwidthSchedule(s *BandwidthSchedule) {
+	c.scheduleMu.Lock()
+	c.schedule = s
+	c.scheduleChecked = time.Time{}
+	c.scheduleMu.Unlock()
+	if s == nil {
+		c.setGlobalRate(0)
+		return
+	}
+	c.applySchedule(time.Now())
+}
+
+// applySchedule sets the global rate limit of the client to the rate of its
+// BandwidthSchedule at the given time, if the schedule was not evaluated in
+// the last scheduleInterval.
+func (c *Client) applySchedule(now time.Time) {
+	c.scheduleMu.Lock()
+	s := c.schedule
+	if s == nil || !c.scheduleChecked.IsZero() && now.Sub(c.scheduleChecked) < scheduleInterval {
+		c.scheduleMu.Unlock()
+		return
+	}
+	c.scheduleChecked = now
+	c.setGlobalRate(s.RateAt(now))
+	c.scheduleMu.Unlock()
+}
+
+// scheduleLimiter is a RateLimiter which applies the BandwidthSchedule of a
+// Client before waiting for the global rate limit.
+type scheduleLimiter struct {
+	c   *Client
+	lim RateLimiter
+}
+
+func (c scheduleLimiter) WaitN(ctx context.Context, n int) error {
+	c.c.applySchedule(time.Now())
+	return c.lim.WaitN(ctx, n)
+}
+
+func (c scheduleLimiter) unlimited() bool {
+	c.c.applySchedule(time.Now())
+	return unlimited(c.lim)
+}
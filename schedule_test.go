@@ -0,0 +1,89 @@
+package grab
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBandwidthSchedule(t *testing.T) {
+	s := &BandwidthSchedule{
+		Windows: []BandwidthWindow{
+			{Start: 22 * time.Hour, End: 7 * time.Hour, Weekdays: []time.Weekday{time.Friday}, BytesPerSecond: 0},
+			{Start: 0, End: 7 * time.Hour},
+			{Start: 12 * time.Hour, End: 13 * time.Hour, BytesPerSecond: 4 << 20},
+			{Weekdays: []time.Weekday{time.Sunday}, BytesPerSecond: 2 << 20},
+		},
+		BytesPerSecond: 1 << 20,
+		Location:       time.UTC,
+	}
+	tests := []struct {
+		time   string
+		expect int
+	}{
+		{"2026-10-14T00:00:00Z", 0},       // Wednesday night
+		{"2026-10-14T06:59:59Z", 0},       // Wednesday night
+		{"2026-10-14T07:00:00Z", 1 << 20}, // office hours
+		{"2026-10-14T12:30:00Z", 4 << 20}, // lunch
+		{"2026-10-14T23:00:00Z", 1 << 20}, // Wednesday evening
+		{"2026-10-16T23:00:00Z", 0},       // Friday night
+		{"2026-10-18T02:00:00+02:00", 0},  // Saturday night, in UTC
+		{"2026-10-18T08:00:00Z", 2 << 20}, // Sunday
+	}
+	for _, test := range tests {
+		tm, err := time.Parse(time.RFC3339, test.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := s.RateAt(tm); actual != test.expect {
+			t.Errorf("%s: expected %d bytes per second, got: %d", test.time, test.expect, actual)
+		}
+	}
+}
+
+// TestClientBandwidthSchedule tests that the global rate limit of a Client
+// follows its BandwidthSchedule while files are transferred.
+func TestClientBandwidthSchedule(t *testing.T) {
+	// a time zone in which it is now 03:00
+	now := time.Now().UTC()
+	clock := now.Sub(now.Truncate(24 * time.Hour))
+	zone := time.FixedZone("", int((3*time.Hour-clock)/time.Second))
+	schedule := &BandwidthSchedule{
+		Windows:        []BandwidthWindow{{Start: 0, End: 7 * time.Hour}},
+		BytesPerSecond: 1 << 20,
+		Location:       zone,
+	}
+	client := NewClient()
+	client.SetBandwidthSchedule(schedule)
+	rate := func() int {
+		lim := client.globalLimiter()
+		lim.mu.Lock()
+		defer lim.mu.Unlock()
+		return int(lim.rate)
+	}
+	if r := rate(); r != 0 {
+		t.Errorf("expected no rate limit at night, got: %d", r)
+	}
+
+	later := now.Add(5 * time.Hour)
+	client.applySchedule(later)
+	if r := rate(); r != 1<<20 {
+		t.Errorf("expected rate limit of 1MB/s during the day, got: %d", r)
+	}
+
+	// transfers re-evaluate the schedule at most once per interval
+	schedule.BytesPerSecond = 2 << 20
+	client.applySchedule(later.Add(scheduleInterval / 2))
+	if r := rate(); r != 1<<20 {
+		t.Errorf("expected schedule not to be re-evaluated within an interval, got: %d", r)
+	}
+	client.applySchedule(later.Add(scheduleInterval))
+	if r := rate(); r != 2<<20 {
+		t.Errorf("expected rate limit of 2MB/s, got: %d", r)
+	}
+
+	client.SetGlobalRateLimit(1024)
+	client.applySchedule(later.Add(time.Hour))
+	if r := rate(); r != 1024 {
+		t.Errorf("expected schedule to be removed by SetGlobalRateLimit, got: %d", r)
+	}
+}
@@ -0,0 +1,105 @@
+package grab
+
+import (
+	"net/http"
+	"strings"
+)
+
+// A schemeHandler transfers files at the URLs of a protocol or object store
+// other than HTTP, by translating http.Requests for its URL scheme.
+type schemeHandler struct {
+	// roundTrip sends the given request for a URL of the scheme, typically by
+	// serving it directly or by rewriting it for the HTTPClient of the Client.
+	roundTrip func(c *Client, req *http.Request) (*http.Response, error)
+
+	// checksum, if not nil, returns the checksum of the remote file given in
+	// the headers of the given response, or nil if there is none. See
+	// Response.Digest.
+	checksum func(hresp *http.Response) *Checksum
+}
+
+// schemeHandlers are the handlers of each URL scheme supported by a Client,
+// in addition to http and https.
+var schemeHandlers = map[string]schemeHandler{
+	"data": {roundTrip: dataRoundTrip},
+	"file": {roundTrip: func(c *Client, req *http.Request) (*http.Response, error) {
+		return fileTransport{}.RoundTrip(req)
+	}},
+	"ftp":    {roundTrip: ftpRoundTrip},
+	"ftps":   {roundTrip: ftpRoundTrip},
+	"sftp":   {roundTrip: sftpRoundTrip},
+	"s3":     {roundTrip: s3RoundTrip, checksum: s3Checksum},
+	"gs":     {roundTrip: gcsRoundTrip, checksum: gcsChecksum},
+	"azblob": {roundTrip: azureRoundTrip, checksum: azureChecksum},
+	"oci":    {roundTrip: ociRoundTrip, checksum: ociChecksum},
+
+	"http+unix":  {roundTrip: unixRoundTrip},
+	"https+unix": {roundTrip: unixRoundTrip},
+}
+
+func ftpRoundTrip(c *Client, req *http.Request) (*http.Response, error) {
+	return ftpTransport{}.RoundTrip(req)
+}
+
+func sftpRoundTrip(c *Client, req *http.Request) (*http.Response, error) {
+	return sftpTransport{dial: c.DialSFTP}.RoundTrip(req)
+}
+
+// objectChecksum returns the checksum of the remote file of the given
+// Response, as given by the object store from which it was transferred, or
+// nil if there is none.
+func objectChecksum(resp *Response) *Checksum {
+	u := resp.Request.URL()
+	if h, ok := schemeHandlers[u.Scheme]; ok && h.checksum != nil {
+		return h.checksum(resp.HTTPResponse)
+	}
+	if u.Query().Get("X-Amz-Signature") != "" {
+		// presigned S3 URL
+		return s3Checksum(resp.HTTPResponse)
+	}
+	return nil
+}
+
+// A SchemeHandlerFunc is a function which sends requests for the URLs of a
+// scheme registered with Client.RegisterScheme.
+type SchemeHandlerFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip calls f(req).
+func (f SchemeHandlerFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// RegisterScheme registers the given http.RoundTripper as the handler of
+// requests for URLs of the given scheme, such as "ipfs", replacing any
+// built-in handler of the scheme. This allows the Client to transfer files
+// from other protocols and artifact stores, with the same resume, checksum,
+// progress and batch features as files on HTTP servers.
+//
+// The handler is sent each GET and HEAD request of a transfer with the
+// original URL and must respond as a HTTP server would. Transfers are resumed
+// and segmented if the handler responds to HEAD requests with an Accept-Ranges
+// header of "bytes" and to ranged requests with 206 Partial Content. Handlers
+// of gateways typically rewrite each request to a HTTP URL and send it with
+// the Transport of the HTTPClient of the Client. To remove a handler, register
+// a nil handler.
+func (c *Client) RegisterScheme(scheme string, h http.RoundTripper) {
+	c.schemesMu.Lock()
+	defer c.schemesMu.Unlock()
+	scheme = strings.ToLower(scheme)
+	if h == nil {
+		delete(c.schemes, scheme)
+		return
+	}
+	if c.schemes == nil {
+		c.schemes = make(map[string]http.RoundTripper)
+	}
+	c.schemes[scheme] = h
+}
+
+// registeredScheme returns the handler registered for the given scheme with
+// RegisterScheme, if any.
+func (c *Client) registeredScheme(scheme string) http.RoundTripper {
+	c.schemesMu.RLock()
+	defer c.schemesMu.RUnlock()
+	return c.schemes[scheme]
+}
@@ -0,0 +1,67 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// TestRegisterScheme tests that files at URLs of custom schemes are resumed,
+// validated and segmented using handlers registered with RegisterScheme.
+func TestRegisterScheme(t *testing.T) {
+	filename := ".testRegisterScheme"
+	defer os.Remove(filename)
+
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	sum := sha256.Sum256(content)
+
+	// a gateway which rewrites ipfs://<cid> to the test server
+	client := NewClient()
+	var requests int
+	client.RegisterScheme("IPFS", SchemeHandlerFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		if req.URL.Host != "cid" {
+			t.Errorf("expected original URL, got: %v", req.URL)
+		}
+		u, _ := url.Parse(ts.URL + "?size=4096")
+		hreq := req.Clone(req.Context())
+		hreq.URL, hreq.Host = u, u.Host
+		return client.HTTPClient.Transport.RoundTrip(hreq)
+	}))
+
+	if err := ioutil.WriteFile(filename, content[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := NewRequest(filename, "ipfs://cid/file")
+	req.SetChecksum(sha256.New(), sum[:], false)
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if !resp.DidResume || requests == 0 {
+		t.Errorf("expected transfer to be resumed using the handler")
+	}
+	testContent(t, filename, content)
+
+	// built-in handlers may be replaced
+	client.RegisterScheme("file", SchemeHandlerFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		rec.WriteHeader(http.StatusTeapot)
+		return rec.Result(), nil
+	}))
+	req, _ = NewRequest(filename+".file", "file:///dev/null")
+	if err := client.Do(req).Err(); err != StatusCodeError(http.StatusTeapot) {
+		t.Errorf("expected status code %d, got: %v", http.StatusTeapot, err)
+	}
+	client.RegisterScheme("file", nil)
+	if h := client.registeredScheme("file"); h != nil {
+		t.Errorf("expected handler to be removed")
+	}
+}
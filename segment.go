@@ -0,0 +1,186 @@
+package grab
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// segment is a byte range of a remote file that is transferred over its own
+// connection as part of a segmented download.
+type segment struct {
+	n     int64 // bytes transferred; must be 64bit aligned on 386
+	start int64 // first byte offset
+	end   int64 // last byte offset, inclusive
+}
+
+// size returns the length of the segment in bytes.
+func (c *segment) size() int64 {
+	return c.end - c.start + 1
+}
+
+// N returns the number of bytes of the segment that have been transferred.
+func (c *segment) N() int64 {
+	return atomic.LoadInt64(&c.n)
+}
+
+// splitSegments divides a file of the given size into at most n contiguous
+// segments of roughly equal length.
+func splitSegments(size int64, n int) []*segment {
+	if int64(n) > size {
+		n = int(size)
+	}
+	if n < 1 {
+		n = 1
+	}
+	segs := make([]*segment, n)
+	step := size / int64(n)
+	for i := 0; i < n; i++ {
+		start := int64(i) * step
+		segs[i] = &segment{start: start, end: start + step - 1}
+	}
+	segs[n-1].end = size - 1
+	return segs
+}
+
+// offsetWriter writes sequentially to an io.WriterAt, beginning at the offset
+// of a segment, and records the progress of the segment and of the transfer.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+	seg *segment
+	n   *int64
+}
+
+func (c *offsetWriter) Write(p []byte) (int, error) {
+	n, err := c.w.WriteAt(p, c.off)
+	c.off += int64(n)
+	atomic.AddInt64(&c.seg.n, int64(n))
+	atomic.AddInt64(c.n, int64(n))
+	return n, err
+}
+
+// segmentedTransfer copies a remote file to local storage by requesting
+// multiple byte ranges of the file concurrently, each over its own connection.
+type segmentedTransfer struct {
+	n          int64 // must be 64bit aligned on 386
+	ctx        context.Context
+	gate       *gate
+	lim        RateLimiter
+	do         func(*http.Request) (*http.Response, error)
+	req        *http.Request
+	w          io.WriterAt
+	getReader  func(io.Reader) (io.Reader, error)
+	bufferSize int
+	segments   []*segment
+}
+
+func newSegmentedTransfer(ctx context.Context, gate *gate, lim RateLimiter, do func(*http.Request) (*http.Response, error), req *http.Request, dst io.WriterAt, getReader func(io.Reader) (io.Reader, error), bufferSize int, segments []*segment) *segmentedTransfer {
+	return &segmentedTransfer{
+		ctx:        ctx,
+		gate:       gate,
+		lim:        lim,
+		do:         do,
+		req:        req,
+		w:          dst,
+		getReader:  getReader,
+		bufferSize: bufferSize,
+		segments:   segments,
+	}
+}
+
+// copy transfers all segments concurrently and blocks until they are complete.
+// If any segment fails, all other segments are canceled and the first error is
+// returned.
+func (c *segmentedTransfer) copy() (written int64, err error) {
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+
+	var once sync.Once
+	wg := sync.WaitGroup{}
+	for _, seg := range c.segments {
+		wg.Add(1)
+		go func(seg *segment) {
+			defer wg.Done()
+			if serr := c.copySegment(ctx, seg); serr != nil {
+				once.Do(func() {
+					err = serr
+					cancel()
+				})
+			}
+		}(seg)
+	}
+	wg.Wait()
+	return c.N(), err
+}
+
+// copySegment requests a single byte range of the remote file and writes it to
+// its offset in the destination.
+func (c *segmentedTransfer) copySegment(ctx context.Context, seg *segment) error {
+	req := c.req.WithContext(ctx)
+	req.Header = cloneHeader(c.req.Header)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", seg.start, seg.end))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return ErrServerNoRange
+	}
+	if resp.ContentLength >= 0 && resp.ContentLength != seg.size() {
+		return ErrBadLength
+	}
+
+	r, err := c.getReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	w := &offsetWriter{w: c.w, off: seg.start, seg: seg, n: &c.n}
+	t := newTransfer(ctx, c.gate, c.lim, w, io.LimitReader(r, seg.size()), c.bufferSize)
+	n, err := t.copy()
+	if err != nil {
+		return err
+	}
+	if n != seg.size() {
+		return ErrBadLength
+	}
+	return nil
+}
+
+// remaining returns the byte ranges of all segments which have not yet been
+// transferred, in ascending order.
+func (c *segmentedTransfer) remaining() []*segment {
+	segs := make([]*segment, 0, len(c.segments))
+	for _, seg := range c.segments {
+		if n := seg.N(); n < seg.size() {
+			segs = append(segs, &segment{start: seg.start + n, end: seg.end})
+		}
+	}
+	return segs
+}
+
+// N returns the number of bytes transferred across all segments.
+func (c *segmentedTransfer) N() (n int64) {
+	if c == nil {
+		return 0
+	}
+	n = atomic.LoadInt64(&c.n)
+	return
+}
+
+// cloneHeader returns a deep copy of the given http.Header.
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		v2 := make([]string, len(v))
+		copy(v2, v)
+		h2[k] = v2
+	}
+	return h2
+}
@@ -0,0 +1,88 @@
+package grab
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// An SFTPClient is a session with an SFTP server, used to transfer files at
+// sftp:// URLs. grab does not implement the SSH protocol itself: SFTPClient
+// is typically implemented with a small adapter around the Client of
+// github.com/pkg/sftp, connected using golang.org/x/crypto/ssh with the keys,
+// agent and known hosts of the caller. See Client.DialSFTP.
+type SFTPClient interface {
+	// Stat returns the os.FileInfo of the file at the given path.
+	Stat(path string) (os.FileInfo, error)
+
+	// Open opens the file at the given path for reading.
+	Open(path string) (SFTPFile, error)
+
+	// Close closes the session. It is called once each transfer is complete.
+	Close() error
+}
+
+// An SFTPFile is a remote file opened by an SFTPClient. Ranges of the file are
+// read with ReadAt, so that transfers may be resumed.
+type SFTPFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+// errNoSFTP is returned for sftp:// URLs if Client.DialSFTP is not set.
+var errNoSFTP = errors.New("grab: sftp:// URLs require Client.DialSFTP")
+
+// sftpTransport is a http.RoundTripper for sftp:// URLs, which serves files
+// from the SFTPClient returned by dial as a HTTP server would. Files are
+// transferred with the same progress, checksum and resume semantics as files
+// on HTTP servers.
+type sftpTransport struct {
+	dial func(ctx context.Context, u *url.URL) (SFTPClient, error)
+}
+
+func (c sftpTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if c.dial == nil {
+		return nil, errNoSFTP
+	}
+	client, err := c.dial(req.Context(), req.URL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := serveFile(req, func() (os.FileInfo, fileReader, error) {
+		fi, err := client.Stat(req.URL.Path)
+		if err != nil || fi.IsDir() {
+			return fi, nil, err
+		}
+		f, err := client.Open(req.URL.Path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return fi, f, nil
+	})
+	if err != nil || resp.Body == http.NoBody {
+		client.Close()
+		return resp, err
+	}
+
+	// the session is closed with the body
+	resp.Body = &sftpBody{ReadCloser: resp.Body, client: client}
+	return resp, nil
+}
+
+// sftpBody is the content of a file opened by an SFTPClient. Closing the body
+// closes the file and the session.
+type sftpBody struct {
+	io.ReadCloser
+	client SFTPClient
+}
+
+func (c *sftpBody) Close() error {
+	err := c.ReadCloser.Close()
+	if cerr := c.client.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package grab
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// testSFTPClient is an SFTPClient which serves files from a local directory.
+type testSFTPClient struct {
+	dir    string
+	closed *int32
+}
+
+func (c *testSFTPClient) Stat(path string) (os.FileInfo, error) {
+	return os.Stat(filepath.Join(c.dir, filepath.FromSlash(path)))
+}
+
+func (c *testSFTPClient) Open(path string) (SFTPFile, error) {
+	return os.Open(filepath.Join(c.dir, filepath.FromSlash(path)))
+}
+
+func (c *testSFTPClient) Close() error {
+	atomic.AddInt32(c.closed, 1)
+	return nil
+}
+
+// TestSFTP tests that files at sftp:// URLs are transferred and resumed using
+// Client.DialSFTP.
+func TestSFTP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	sum := sha256.Sum256(content)
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var dialed, closed int32
+	client := NewClient()
+	client.DialSFTP = func(ctx context.Context, u *url.URL) (SFTPClient, error) {
+		atomic.AddInt32(&dialed, 1)
+		if u.User.Username() != "deploy" {
+			t.Errorf("expected user deploy, got: %s", u.User.Username())
+		}
+		return &testSFTPClient{dir: dir, closed: &closed}, nil
+	}
+
+	filename := filepath.Join(dir, "download")
+	if err := ioutil.WriteFile(filename, content[:1000], 0644); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := NewRequest(filename, "sftp://deploy@example.com/file")
+	req.SetChecksum(sha256.New(), sum[:], false)
+	resp := client.Do(req)
+	if err := resp.Err(); err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if !resp.DidResume || resp.bytesTransferred() != 3096 {
+		t.Errorf("expected transfer to resume, transferred: %d", resp.bytesTransferred())
+	}
+	testContent(t, filename, content)
+	if d, c := atomic.LoadInt32(&dialed), atomic.LoadInt32(&closed); d == 0 || d != c {
+		t.Errorf("expected every session to be closed, dialed %d, closed %d", d, c)
+	}
+
+	req, _ = NewRequest(filename+".missing", "sftp://deploy@example.com/missing")
+	if err := client.Do(req).Err(); !IsStatusCodeError(err) {
+		t.Errorf("expected status code error, got: %v", err)
+	}
+
+	req, _ = NewRequest(filename+".missing", "sftp://deploy@example.com/file")
+	if err := DefaultClient.Do(req).Err(); err != errNoSFTP {
+		t.Errorf("expected errNoSFTP, got: %v", err)
+	}
+}
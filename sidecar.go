@@ -0,0 +1,112 @@
+package grab
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// checksumSidecars are the extensions of the checksum files probed by
+// Request.DiscoverChecksum, in order, and the hash algorithm of each.
+var checksumSidecars = []struct {
+	ext string
+	new func() hash.Hash
+}{
+	{".sha512", sha512.New},
+	{".sha512sum", sha512.New},
+	{".sha256", sha256.New},
+	{".sha256sum", sha256.New},
+	{".sha1", sha1.New},
+	{".md5", md5.New},
+}
+
+// maxSidecarSize is the maximum number of bytes read from a checksum file.
+const maxSidecarSize = 64 * 1024
+
+// discoverChecksum probes the checksum files published alongside the remote
+// file of the given Response and adds the first checksum found to the
+// Request. Errors are logged and the transfer continues without a checksum.
+func (c *Client) discoverChecksum(resp *Response) {
+	u := resp.Request.URL()
+	for _, sidecar := range checksumSidecars {
+		su := *u
+		su.Path += sidecar.ext
+		su.RawPath = ""
+		su.Fragment = ""
+		hreq, err := http.NewRequest("GET", su.String(), nil)
+		if err != nil {
+			return
+		}
+		hreq.Header = cloneHeader(resp.Request.HTTPRequest.Header)
+		hreq.Header.Del("Range")
+		hreq.Header.Del("If-Range")
+		hresp, err := c.doHTTPRequest(hreq.WithContext(resp.ctx))
+		if err != nil {
+			c.logf(resp, slog.LevelDebug, "cannot probe checksum file", "error", err)
+			return
+		}
+		h := sidecar.new()
+		var sum []byte
+		if hresp.StatusCode == http.StatusOK {
+			sum = parseSidecar(io.LimitReader(hresp.Body, maxSidecarSize), path.Base(u.Path), h.Size())
+		}
+		hresp.Body.Close()
+		if sum != nil {
+			c.logf(resp, slog.LevelDebug, "discovered checksum", "checksum_url", su.String())
+			resp.Request.AddChecksum(h, sum, false)
+			return
+		}
+	}
+	c.logf(resp, slog.LevelDebug, "no checksum file found")
+}
+
+// parseSidecar returns the checksum of the file with the given name from a
+// checksum file, or nil if none is found. Checksum files may contain a bare
+// hex encoded checksum, GNU-style lines of a checksum and a filename, or
+// BSD-style lines such as "SHA256 (file.iso) = <hex>". If the file lists a
+// single checksum, it is used regardless of the listed filename.
+func parseSidecar(r io.Reader, name string, size int) []byte {
+	var sums [][]byte
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var v, file string
+		if i := strings.LastIndex(text, ") = "); i > 0 {
+			// BSD style
+			v = text[i+4:]
+			if j := strings.Index(text, " ("); j >= 0 && j < i {
+				file = text[j+2 : i]
+			}
+		} else {
+			fields := strings.Fields(text)
+			v = fields[0]
+			if len(fields) > 1 {
+				file = strings.TrimPrefix(fields[len(fields)-1], "*")
+			}
+		}
+		sum, err := hex.DecodeString(strings.TrimSpace(v))
+		if err != nil || len(sum) != size {
+			continue
+		}
+		if file != "" && path.Base(cleanManifestName(file)) == name {
+			return sum
+		}
+		sums = append(sums, sum)
+	}
+	if len(sums) == 1 {
+		return sums[0]
+	}
+	return nil
+}
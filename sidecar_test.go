@@ -0,0 +1,89 @@
+package grab
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestParseSidecar tests that checksums are parsed from the formats of common
+// checksum files.
+func TestParseSidecar(t *testing.T) {
+	sum := sha256.Sum256([]byte("test"))
+	other := sha256.Sum256([]byte("other"))
+	tests := []struct {
+		content string
+		expect  []byte
+	}{
+		{fmt.Sprintf("%x\n", sum), sum[:]},
+		{fmt.Sprintf("%x  file.iso\n", sum), sum[:]},
+		{fmt.Sprintf("%x *renamed.iso\n", sum), sum[:]},
+		{fmt.Sprintf("# comment\n%x  other.iso\n%x  dir/file.iso\n", other, sum), sum[:]},
+		{fmt.Sprintf("SHA256 (file.iso) = %x\n", sum), sum[:]},
+		{fmt.Sprintf("%x  other.iso\n%x  another.iso\n", other, sum), nil},
+		{"not a checksum\n", nil},
+		{"", nil},
+	}
+	for _, test := range tests {
+		actual := parseSidecar(strings.NewReader(test.content), "file.iso", sha256.Size)
+		if !bytes.Equal(actual, test.expect) {
+			t.Errorf("%q: expected %x, got: %x", test.content, test.expect, actual)
+		}
+	}
+}
+
+// TestDiscoverChecksum tests that checksums are discovered from checksum files
+// published alongside the remote file.
+func TestDiscoverChecksum(t *testing.T) {
+	filename := ".testDiscoverChecksum"
+	defer os.Remove(filename)
+
+	content := make([]byte, 1024)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	sum := sha256.Sum256(content)
+	var sidecar, body string
+	var probed []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/file.iso" {
+			ts.Config.Handler.ServeHTTP(w, r)
+			return
+		}
+		probed = append(probed, r.URL.Path)
+		if r.URL.Path != "/file.iso"+sidecar {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "%s  file.iso\n", body)
+	}))
+	defer s.Close()
+
+	for _, test := range []struct {
+		sidecar, body string
+		err           error
+	}{
+		{".sha256sum", fmt.Sprintf("%x", sum), nil},
+		{".sha256", fmt.Sprintf("%x", make([]byte, sha256.Size)), ErrBadChecksum},
+		{".none", "", nil},
+	} {
+		os.Remove(filename)
+		sidecar, body, probed = test.sidecar, test.body, nil
+		req, _ := NewRequest(filename, s.URL+"/file.iso?size=1024")
+		req.DiscoverChecksum = true
+		if err := DefaultClient.Do(req).Err(); err != test.err {
+			t.Errorf("%s: expected error %v, got: %v", test.sidecar, test.err, err)
+		}
+		if test.body != "" && len(req.checksums) != 1 {
+			t.Errorf("%s: expected checksum to be discovered", test.sidecar)
+		}
+		if test.body == "" && len(probed) != len(checksumSidecars) {
+			t.Errorf("expected %d checksum files to be probed, got: %v", len(checksumSidecars), probed)
+		}
+	}
+}
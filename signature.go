@@ -0,0 +1,131 @@
+package grab
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+)
+
+// maxSignatureSize is the maximum size of a detached signature that will be
+// downloaded to verify a file.
+const maxSignatureSize = 1 << 20
+
+// A SignatureVerifier verifies a detached signature of a downloaded file, such
+// as an OpenPGP signature published alongside the file as file.tar.gz.asc or
+// file.tar.gz.sig.
+//
+// Grab does not implement any signature schemes itself. A SignatureVerifier
+// for OpenPGP signatures may be implemented using a keyring and
+// openpgp.CheckArmoredDetachedSignature from golang.org/x/crypto/openpgp, or by
+// calling an external program such as gpg --verify.
+type SignatureVerifier interface {
+	// Verify returns nil if signature is a valid signature of the content read
+	// from signed, made by a trusted key.
+	Verify(signed, signature io.Reader) error
+}
+
+// SignatureVerifierFunc is an adapter to allow the use of ordinary functions
+// as a SignatureVerifier.
+type SignatureVerifierFunc func(signed, signature io.Reader) error
+
+// Verify calls f(signed, signature).
+func (f SignatureVerifierFunc) Verify(signed, signature io.Reader) error {
+	return f(signed, signature)
+}
+
+// SetSignature sets the URL of a detached signature of the requested file and
+// the SignatureVerifier used to verify it. Once the file is downloaded and has
+// passed any checksum validation, the signature is downloaded using a copy of
+// HTTPRequest and verified. If the signature cannot be downloaded, the
+// Response fails with the error of the signature request. If the signature is
+// invalid, the Response fails with ErrBadSignature.
+//
+// If deleteOnError is true, the downloaded file will be deleted automatically
+// if the signature is invalid.
+//
+// To disable signature verification, call SetSignature with a nil
+// SignatureVerifier.
+func (r *Request) SetSignature(v SignatureVerifier, sigURL string, deleteOnError bool) error {
+	if v == nil {
+		r.signatureURL, r.verifier, r.deleteOnBadSignature = nil, nil, false
+		return nil
+	}
+	u, err := url.Parse(sigURL)
+	if err != nil {
+		return err
+	}
+	r.signatureURL = r.URL().ResolveReference(u)
+	r.verifier = v
+	r.deleteOnBadSignature = deleteOnError
+	return nil
+}
+
+// verifySignature downloads the detached signature of the downloaded file and
+// verifies it, if configured with Request.SetSignature. If the signature is
+// valid, or not configured, the next stateFunc is commitFile. Otherwise, the
+// next stateFunc is closeResponse.
+func (c *Client) verifySignature(resp *Response) stateFunc {
+	req := resp.Request
+	if req.verifier == nil {
+		return c.commitFile
+	}
+	if resp.Filename == "" {
+		panic("filename not set")
+	}
+
+	sig, err := c.getSignature(resp)
+	if err != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+
+	f, err := os.Open(resp.path())
+	if err != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+	err = req.verifier.Verify(f, bytes.NewReader(sig))
+	f.Close()
+	if err == nil {
+		return c.commitFile
+	}
+
+	resp.err = ErrBadSignature
+	if req.deleteOnBadSignature {
+		if err := os.Remove(resp.path()); err != nil {
+			// err should be os.PathError and include file path
+			resp.err = fmt.Errorf(
+				"cannot remove downloaded file with bad signature: %w",
+				err)
+		}
+	}
+	return c.closeResponse
+}
+
+// getSignature downloads the detached signature configured for the given
+// Response into memory.
+func (c *Client) getSignature(resp *Response) ([]byte, error) {
+	hreq := resp.mirrorRequest(resp.Request.signatureURL)
+	hreq.Method = "GET"
+	hresp, err := c.doTransferRequest(resp, hreq.WithContext(resp.ctx))
+	if err != nil {
+		err, _ = unwrapHookError(err)
+		return nil, err
+	}
+	defer hresp.Body.Close()
+	if hresp.StatusCode < 200 || hresp.StatusCode > 299 {
+		return nil, StatusCodeError(hresp.StatusCode)
+	}
+
+	b := &bytes.Buffer{}
+	n, err := io.Copy(b, io.LimitReader(hresp.Body, maxSignatureSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > maxSignatureSize {
+		return nil, ErrBadLength
+	}
+	return b.Bytes(), nil
+}
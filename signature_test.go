@@ -0,0 +1,92 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestSetSignature tests that downloaded files are verified using a detached
+// signature.
+func TestSetSignature(t *testing.T) {
+	// the test "signature" of a file is its hex encoded SHA-256 checksum
+	sigs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/good.sig":
+			fmt.Fprint(w, "fbbab289f7f94b25736c58be46a994c441fd02552cc6022352e3d86d2fab7c83")
+		case "/bad.sig":
+			fmt.Fprint(w, "bad")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer sigs.Close()
+
+	verifier := SignatureVerifierFunc(func(signed, signature io.Reader) error {
+		h := sha256.New()
+		if _, err := io.Copy(h, signed); err != nil {
+			return err
+		}
+		b, err := ioutil.ReadAll(signature)
+		if err != nil {
+			return err
+		}
+		if string(b) != hex.EncodeToString(h.Sum(nil)) {
+			return errors.New("bad signature")
+		}
+		return nil
+	})
+
+	tests := []struct {
+		Name   string
+		SigURL string
+		Err    error
+	}{
+		{"Good", sigs.URL + "/good.sig", nil},
+		{"Bad", sigs.URL + "/bad.sig", ErrBadSignature},
+		{"Missing", sigs.URL + "/missing.sig", StatusCodeError(http.StatusNotFound)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			filename := ".testSetSignature" + test.Name
+			defer os.Remove(filename)
+			req, _ := NewRequest(filename, ts.URL)
+			if err := req.SetSignature(verifier, test.SigURL, true); err != nil {
+				t.Fatal(err)
+			}
+			if err := DefaultClient.Do(req).Err(); err != test.Err {
+				t.Fatalf("expected error %v, got: %v", test.Err, err)
+			}
+			_, err := os.Stat(filename)
+			if test.Err == ErrBadSignature && !os.IsNotExist(err) {
+				t.Errorf("signature failure not cleaned up: %s", filename)
+			}
+		})
+	}
+
+	t.Run("AfterChecksum", func(t *testing.T) {
+		filename := ".testSetSignatureAfterChecksum"
+		defer os.Remove(filename)
+		called := false
+		req, _ := NewRequest(filename, ts.URL)
+		req.SetChecksum(sha256.New(), []byte{0x01}, false)
+		req.SetSignature(SignatureVerifierFunc(func(signed, signature io.Reader) error {
+			called = true
+			return nil
+		}), sigs.URL+"/good.sig", false)
+		if err := DefaultClient.Do(req).Err(); err != ErrBadChecksum {
+			t.Fatalf("expected error %v, got: %v", ErrBadChecksum, err)
+		}
+		if called {
+			t.Errorf("expected signature not to be verified after checksum failure")
+		}
+	})
+}
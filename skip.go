@@ -0,0 +1,112 @@
+package grab
+
+import (
+	"net/http"
+	"time"
+)
+
+// A SkipPolicy specifies when a file which already exists at the destination
+// path of a Request is considered to be downloaded already, so that it is not
+// transferred again. This makes repeated batches an efficient sync of the
+// remote files.
+//
+// A SkipPolicy is applied only if the CollisionPolicy of the Request is
+// CollisionResume or CollisionOverwrite. If the existing file is considered
+// downloaded, the transfer completes successfully with Response.Skipped set.
+// Otherwise, the existing file is replaced by a new copy of the remote file.
+type SkipPolicy int
+
+const (
+	// SkipNever specifies that existing files are handled only by the
+	// CollisionPolicy of the Request. This is the default.
+	SkipNever SkipPolicy = iota
+
+	// SkipIfSameSize considers an existing file to be downloaded if its size
+	// matches the size of the remote file, given by Request.Size or the
+	// response to a HEAD request.
+	SkipIfSameSize
+
+	// SkipIfNotModified considers an existing file to be downloaded if the
+	// Last-Modified time of the remote file, given by the response to a HEAD
+	// request, is not newer than the modification time of the existing file.
+	SkipIfNotModified
+
+	// SkipIfChecksumMatch considers an existing file to be downloaded if it
+	// matches every checksum configured with Request.SetChecksum or
+	// Request.AddChecksum. If the Request has no checksums, existing files are
+	// always replaced.
+	SkipIfChecksumMatch
+)
+
+// skipExisting applies the SkipPolicy of the Request for the given Response to
+// the existing file at the destination path, described by Response.fi. If the
+// SkipPolicy is SkipNever or does not apply, skipExisting returns nil.
+func (c *Client) skipExisting(resp *Response) stateFunc {
+	req := resp.Request
+	switch req.collisionPolicy() {
+	case CollisionResume, CollisionOverwrite:
+	default:
+		return nil
+	}
+
+	var ok bool
+	switch req.SkipPolicy {
+	case SkipIfSameSize:
+		size := req.Size
+		if size == 0 {
+			if !resp.optionsKnown {
+				return c.headRequest
+			}
+			if resp.HTTPResponse != nil {
+				size = resp.HTTPResponse.ContentLength
+			}
+		}
+		ok = size > 0 && size == resp.fi.Size()
+
+	case SkipIfNotModified:
+		if !resp.optionsKnown {
+			return c.headRequest
+		}
+		if resp.HTTPResponse != nil {
+			lastmod, err := time.Parse(http.TimeFormat, resp.HTTPResponse.Header.Get("Last-Modified"))
+			ok = err == nil && !lastmod.After(resp.fi.ModTime())
+		}
+
+	case SkipIfChecksumMatch:
+		if len(req.checksums) == 0 {
+			break
+		}
+		hs := hashes(req.checksums)
+		for _, h := range hs {
+			h.Reset()
+		}
+		var sums [][]byte
+		sums, resp.err = checksum(req.Context(), resp.path(), hs)
+		for _, h := range hs {
+			h.Reset()
+		}
+		if resp.err != nil {
+			return c.closeResponse
+		}
+		ok = true
+		checksums := make([]Checksum, len(req.checksums))
+		for i, sum := range req.checksums {
+			sum.Actual = sums[i]
+			checksums[i] = sum
+			if !sum.OK() {
+				ok = false
+			}
+		}
+		if ok {
+			resp.Checksums = checksums
+		}
+
+	default:
+		return nil
+	}
+
+	if ok {
+		return c.skipFile(resp)
+	}
+	return c.overwriteFile(resp)
+}
@@ -0,0 +1,79 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestSkipPolicy tests that existing files are skipped or replaced according
+// to the SkipPolicy of a Request.
+func TestSkipPolicy(t *testing.T) {
+	// expected content of the remote file
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	sum := sha256.Sum256(content)
+	modTime := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+
+	tests := []struct {
+		Name     string
+		Policy   SkipPolicy
+		Existing []byte
+		URL      string
+		Skipped  bool
+	}{
+		{"SameSize", SkipIfSameSize, make([]byte, 4096), "?size=4096", true},
+		{"DifferentSize", SkipIfSameSize, make([]byte, 1024), "?size=4096", false},
+		{"NotModified", SkipIfNotModified, make([]byte, 1024),
+			fmt.Sprintf("?size=4096&lastmod=%d", modTime.Add(-time.Hour).Unix()), true},
+		{"Modified", SkipIfNotModified, make([]byte, 4096),
+			fmt.Sprintf("?size=4096&lastmod=%d", modTime.Add(time.Hour).Unix()), false},
+		{"NoLastModified", SkipIfNotModified, make([]byte, 4096), "?size=4096", false},
+		{"ChecksumMatch", SkipIfChecksumMatch, content, "?size=4096", true},
+		{"ChecksumMismatch", SkipIfChecksumMatch, make([]byte, 4096), "?size=4096", false},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			filename := ".testSkipPolicy"
+			defer os.Remove(filename)
+			if err := ioutil.WriteFile(filename, test.Existing, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(filename, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+
+			req, _ := NewRequest(filename, ts.URL+test.URL)
+			req.SkipPolicy = test.Policy
+			if test.Policy == SkipIfChecksumMatch {
+				req.SetChecksum(sha256.New(), sum[:], false)
+			}
+			resp := DefaultClient.Do(req)
+			if err := resp.Err(); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			if resp.Skipped != test.Skipped {
+				t.Errorf("expected Skipped to be %v", test.Skipped)
+			}
+			b, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expect := content
+			if test.Skipped {
+				expect = test.Existing
+			}
+			if string(b) != string(expect) {
+				t.Errorf("unexpected content of destination file")
+			}
+			if test.Policy == SkipIfChecksumMatch && !resp.Checksums[0].OK() {
+				t.Errorf("expected checksum to be validated")
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package grab
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// defaultMinimumSpeedWindow is the window over which Request.MinimumSpeed is
+// measured, if Request.MinimumSpeedWindow is zero.
+const defaultMinimumSpeedWindow = 10 * time.Second
+
+// watchStall monitors the progress of an in-progress transfer until the
+// returned stop function is called. If the transfer receives no bytes for
+// Request.StallTimeout, or its average speed over Request.MinimumSpeedWindow
+// drops below Request.MinimumSpeed, the current attempt is canceled and
+// isStalled returns true.
+//
+// Time spent paused is not counted towards either limit.
+func (c *Response) watchStall() (stop func()) {
+	timeout := c.Request.StallTimeout
+	minSpeed := c.Request.MinimumSpeed
+	window := c.Request.MinimumSpeedWindow
+	if window <= 0 {
+		window = defaultMinimumSpeedWindow
+	}
+	if timeout <= 0 && minSpeed <= 0 {
+		return func() {}
+	}
+
+	// check progress at least ten times per limit
+	interval := window
+	if timeout > 0 && (minSpeed <= 0 || timeout < window) {
+		interval = timeout
+	}
+	interval /= 10
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	} else if interval > time.Second {
+		interval = time.Second
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		lastN := c.bytesTransferred()
+		lastProgress := time.Now()
+		windowN, windowStart := lastN, lastProgress
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-t.C:
+				n := c.bytesTransferred()
+				if c.gate.isClosed() {
+					// restart the clock while paused
+					lastN, lastProgress = n, now
+					windowN, windowStart = n, now
+					continue
+				}
+				if n != lastN {
+					lastN, lastProgress = n, now
+				}
+				stalled := timeout > 0 && now.Sub(lastProgress) >= timeout
+				if d := now.Sub(windowStart); minSpeed > 0 && d >= window {
+					if float64(n-windowN)/d.Seconds() < float64(minSpeed) {
+						stalled = true
+					}
+					windowN, windowStart = n, now
+				}
+				if stalled {
+					atomic.StoreInt32(&c.stalled, 1)
+					c.cancelAttempt()
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// isStalled returns true if the current attempt was canceled by watchStall.
+func (c *Response) isStalled() bool {
+	return atomic.LoadInt32(&c.stalled) == 1
+}
@@ -0,0 +1,122 @@
+package grab
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowServer returns a test server which serves a file of the given size, in
+// chunks of the given size every 10ms.
+func slowServer(size, chunk int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", size))
+		if r.Method == "HEAD" {
+			return
+		}
+		for n := 0; n < size; n += chunk {
+			if n+chunk > size {
+				chunk = size - n
+			}
+			if _, err := w.Write(make([]byte, chunk)); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+}
+
+// TestStallTimeout tests that transfers which stop receiving data are aborted
+// with ErrStalled.
+func TestStallTimeout(t *testing.T) {
+	// hangingServer sends part of a file and then hangs for the first n
+	// requests
+	hangingServer := func(n int32) *httptest.Server {
+		var count int32
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "HEAD" || atomic.AddInt32(&count, 1) > n {
+				http.Redirect(w, r, ts.URL+"?size=4096", http.StatusFound)
+				return
+			}
+			w.Header().Set("Content-Length", "4096")
+			w.Write(make([]byte, 128))
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}))
+	}
+
+	t.Run("Stalled", func(t *testing.T) {
+		s := hangingServer(1)
+		defer s.Close()
+		filename := ".testStallTimeout"
+		defer os.Remove(filename)
+		req, _ := NewRequest(filename, s.URL)
+		req.StallTimeout = 100 * time.Millisecond
+		if err := DefaultClient.Do(req).Err(); err != ErrStalled {
+			t.Fatalf("expected error %v, got: %v", ErrStalled, err)
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		s := hangingServer(1)
+		defer s.Close()
+		filename := ".testStallTimeoutRetry"
+		defer os.Remove(filename)
+		req, _ := NewRequest(filename, s.URL)
+		req.StallTimeout = 100 * time.Millisecond
+		req.RetryPolicy = &RetryPolicy{
+			MaxAttempts: 2,
+			Backoff:     func(int) time.Duration { return 0 },
+		}
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if n := resp.Attempts(); n != 2 {
+			t.Errorf("expected 2 attempts, got: %d", n)
+		}
+	})
+
+	t.Run("Paused", func(t *testing.T) {
+		s := slowServer(4096, 64)
+		defer s.Close()
+		filename := ".testStallTimeoutPaused"
+		defer os.Remove(filename)
+		req, _ := NewRequest(filename, s.URL)
+		req.StallTimeout = 100 * time.Millisecond
+		resp := DefaultClient.Do(req)
+		resp.Pause()
+		time.Sleep(300 * time.Millisecond)
+		resp.Resume()
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if resp.BytesComplete() != 4096 {
+			t.Errorf("expected 4096 bytes, got: %d", resp.BytesComplete())
+		}
+	})
+}
+
+// TestMinimumSpeed tests that transfers slower than the minimum speed are
+// aborted with ErrStalled.
+func TestMinimumSpeed(t *testing.T) {
+	s := slowServer(1048576, 64)
+	defer s.Close()
+	filename := ".testMinimumSpeed"
+	defer os.Remove(filename)
+	req, _ := NewRequest(filename, s.URL)
+	req.MinimumSpeed = 100000
+	req.MinimumSpeedWindow = 200 * time.Millisecond
+	start := time.Now()
+	if err := DefaultClient.Do(req).Err(); err != ErrStalled {
+		t.Fatalf("expected error %v, got: %v", ErrStalled, err)
+	}
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Errorf("expected transfer to be aborted after the window, got: %v", d)
+	}
+}
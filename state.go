@@ -0,0 +1,198 @@
+package grab
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// transferState records the progress of an incomplete file transfer so that it
+// can be safely resumed later, even by another process.
+//
+// The state is stored in a hidden sidecar file alongside the destination file.
+// See stateFilename.
+type transferState struct {
+	// URL is the requested URL of the remote file.
+	URL string `json:"url"`
+
+	// ETag and LastModified are the validators returned by the remote server
+	// when the transfer started. They are used to confirm that the remote file
+	// has not changed before a transfer is resumed.
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+
+	// Size is the total size of the remote file.
+	Size int64 `json:"size"`
+
+	// BytesWritten is the number of bytes that have been written to the
+	// destination file.
+	BytesWritten int64 `json:"bytesWritten"`
+
+	// Segments are the byte ranges that remain to be transferred, if the
+	// transfer was segmented.
+	Segments []stateSegment `json:"segments,omitempty"`
+
+	// HashStates are the marshaled states of each checksum hash after
+	// BytesWritten bytes, if Request.StreamChecksum is set and all hashes
+	// implement encoding.BinaryMarshaler. They allow a resumed transfer to
+	// validate its checksums without reading the existing bytes of the
+	// destination file.
+	HashStates [][]byte `json:"hashStates,omitempty"`
+}
+
+// stateSegment is an inclusive byte range of a segmented transfer that has not
+// yet been written to the destination file.
+type stateSegment struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+// stateFilename returns the path of the state file for the given destination
+// path. E.g. /tmp/.example.zip.grab for /tmp/example.zip.
+func stateFilename(filename string) string {
+	dir, base := filepath.Split(filename)
+	return filepath.Join(dir, "."+base+".grab")
+}
+
+// readState reads the persisted state for the given destination path. If no
+// state file exists, a nil state and nil error are returned.
+func readState(filename string) (*transferState, error) {
+	b, err := ioutil.ReadFile(stateFilename(filename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	st := &transferState{}
+	if err := json.Unmarshal(b, st); err != nil {
+		// a corrupt state file is treated as a mismatch by validateState
+		return &transferState{}, nil
+	}
+	return st, nil
+}
+
+// writeState persists the given state for the given destination path. The
+// state file is replaced atomically so that a crash can never leave a
+// truncated state file behind.
+func writeState(filename string, st *transferState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	name := stateFilename(filename)
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// removeState deletes the persisted state for the given destination path, if
+// it exists.
+func removeState(filename string) error {
+	err := os.Remove(stateFilename(filename))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// matches returns true if the persisted state describes the same remote file
+// as the given Response, which must have already received the headers of the
+// remote file.
+func (c *transferState) matches(resp *Response) bool {
+	if c.Size != resp.Size {
+		return false
+	}
+	match := false
+	for _, u := range resp.mirrors {
+		if c.URL == u.String() {
+			match = true
+		}
+	}
+	if !match {
+		return false
+	}
+	if resp.HTTPResponse != nil {
+		h := resp.HTTPResponse.Header
+		if c.ETag != "" && c.ETag != h.Get("ETag") {
+			return false
+		}
+		if c.LastModified != "" && c.LastModified != h.Get("Last-Modified") {
+			return false
+		}
+	}
+	return c.BytesWritten <= c.Size
+}
+
+// segments returns the remaining byte ranges of a segmented transfer.
+func (c *transferState) segments() []*segment {
+	segs := make([]*segment, len(c.Segments))
+	for i, seg := range c.Segments {
+		segs[i] = &segment{start: seg.Start, end: seg.End}
+	}
+	return segs
+}
+
+// currentState returns the current state of an in-progress transfer.
+func (c *Response) currentState() *transferState {
+	st := &transferState{
+		URL:          c.Request.URL().String(),
+		Size:         c.Size,
+		BytesWritten: c.BytesComplete(),
+	}
+	if c.HTTPResponse != nil {
+		st.ETag = c.HTTPResponse.Header.Get("ETag")
+		st.LastModified = c.HTTPResponse.Header.Get("Last-Modified")
+	}
+	if c.hasher != nil {
+		// the hash may lag behind the bytes written to the destination file
+		n, states := c.hasher.snapshot()
+		c.progressMu.Lock()
+		st.BytesWritten = c.bytesResumed + n
+		c.progressMu.Unlock()
+		st.HashStates = states
+	}
+	if t, ok := c.transfer.(*segmentedTransfer); ok {
+		st.BytesWritten = c.Size
+		for _, seg := range t.remaining() {
+			st.Segments = append(st.Segments, stateSegment{Start: seg.start, End: seg.end})
+			st.BytesWritten -= seg.size()
+		}
+	}
+	return st
+}
+
+// watchState periodically persists the state of an in-progress transfer until
+// the returned stop function is called. Stop blocks until any pending write is
+// complete.
+func (c *Response) watchState() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				// errors are ignored until the final state is written; the
+				// file is flushed first so that the state never records
+				// bytes which were not flushed
+				if f, ok := c.writer.(*syncFile); ok {
+					f.sync()
+				}
+				writeState(c.path(), c.currentState())
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
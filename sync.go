@@ -0,0 +1,101 @@
+package grab
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// A SyncPolicy specifies when the data written to a destination file is
+// flushed to stable storage, by calling fsync, so that it survives a crash of
+// the operating system or a loss of power.
+type SyncPolicy int
+
+const (
+	// SyncNone leaves flushing to the operating system. This is the default,
+	// and the fastest policy.
+	SyncNone SyncPolicy = iota
+
+	// SyncOnClose flushes the destination file once it is fully written,
+	// before it is validated, and when a transfer is interrupted.
+	SyncOnClose
+
+	// SyncPeriodic flushes the destination file each time
+	// Request.SyncInterval bytes have been written, in addition to the
+	// flushes of SyncOnClose.
+	SyncPeriodic
+)
+
+// defaultSyncInterval is the number of bytes written between flushes of
+// SyncPeriodic, if Request.SyncInterval is zero.
+const defaultSyncInterval = 16 << 20
+
+// syncFile is a destination file which is flushed to stable storage according
+// to a SyncPolicy.
+type syncFile struct {
+	*os.File
+	policy   SyncPolicy
+	interval int64
+
+	mu sync.Mutex
+	n  int64 // bytes written since last sync
+}
+
+// newSyncFile returns the given destination file, wrapped to apply the
+// SyncPolicy of the given Request.
+func newSyncFile(f *os.File, req *Request) *syncFile {
+	interval := req.SyncInterval
+	if interval <= 0 {
+		interval = defaultSyncInterval
+	}
+	return &syncFile{File: f, policy: req.Sync, interval: interval}
+}
+
+func (c *syncFile) Write(p []byte) (int, error) {
+	n, err := c.File.Write(p)
+	return n, c.wrote(int64(n), err)
+}
+
+func (c *syncFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := c.File.WriteAt(p, off)
+	return n, c.wrote(int64(n), err)
+}
+
+// ReadFrom implements io.ReaderFrom so that copies into the file may still use
+// the zero-copy fast path of os.File.
+func (c *syncFile) ReadFrom(r io.Reader) (int64, error) {
+	n, err := c.File.ReadFrom(r)
+	return n, c.wrote(n, err)
+}
+
+// wrote counts bytes written to the file and flushes the file once each
+// interval of SyncPeriodic.
+func (c *syncFile) wrote(n int64, err error) error {
+	if err != nil || c.policy != SyncPeriodic {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.n += n; c.n < c.interval {
+		return nil
+	}
+	c.n = 0
+	return c.File.Sync()
+}
+
+// sync flushes the file, unless the policy is SyncNone.
+func (c *syncFile) sync() error {
+	if c.policy == SyncNone {
+		return nil
+	}
+	return c.File.Sync()
+}
+
+// Close flushes and closes the file.
+func (c *syncFile) Close() error {
+	err := c.sync()
+	if cerr := c.File.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
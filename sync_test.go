@@ -0,0 +1,51 @@
+package grab
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// TestSyncPolicy tests that files are transferred with each SyncPolicy.
+func TestSyncPolicy(t *testing.T) {
+	filename := ".testSyncPolicy"
+	defer os.Remove(filename)
+
+	for _, policy := range []SyncPolicy{SyncNone, SyncOnClose, SyncPeriodic} {
+		for _, segments := range []int{1, 4} {
+			req, _ := NewRequest(filename, ts.URL+"?size=1048576")
+			req.Sync = policy
+			req.SyncInterval = 64 * 1024
+			req.Segments = segments
+			resp := DefaultClient.Do(req)
+			testComplete(t, resp)
+			if err := resp.Err(); err != nil {
+				t.Fatalf("error: %v", err)
+			}
+			testSize(t, filename, 1048576)
+			os.Remove(filename)
+		}
+	}
+}
+
+func TestSyncFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	req := &Request{Sync: SyncPeriodic, SyncInterval: 1000}
+	w := newSyncFile(f, req)
+	for i, expect := range []int64{600, 0, 600, 0} {
+		if _, err := w.Write(make([]byte, 600)); err != nil {
+			t.Fatal(err)
+		}
+		if w.n != expect {
+			t.Errorf("%d: expected %d unsynced bytes, got: %d", i, expect, w.n)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("error closing file: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package grab
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// FilenameTemplateData is the data with which the templates of
+// FilenameTemplate are executed.
+type FilenameTemplateData struct {
+	// Host is the host name of the requested URL, without any port.
+	Host string
+
+	// Dir is the directory of the path of the requested URL, without leading
+	// or trailing slashes, such as "pub/iso" for /pub/iso/example.iso.
+	Dir string
+
+	// Filename is the filename which would otherwise be used, as given by
+	// the Content-Disposition header of the response or by the URL,
+	// normalized to NFC and sanitized with SanitizeFilename.
+	Filename string
+
+	// Base is Filename without its extension and Ext is the extension of
+	// Filename, including the dot, if any.
+	Base, Ext string
+
+	// Response is the response of the remote server, for access to its URL
+	// and headers, such as {{.Response.Header.Get "ETag"}}.
+	Response *http.Response
+}
+
+// FilenameTemplate returns a function for Request.FilenameFunc which
+// determines the destination path of each file by executing the given
+// text/template with a FilenameTemplateData, such as
+// "{{.Host}}/{{.Dir}}/{{.Filename}}", so that batch downloads can be laid out
+// in directory hierarchies derived from their URLs or response headers. An
+// error is returned if the template cannot be parsed.
+func FilenameTemplate(text string) (func(hresp *http.Response) (string, error), error) {
+	t, err := template.New("filename").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return func(hresp *http.Response) (string, error) {
+		filename, err := guessFilename(hresp)
+		if err != nil {
+			return "", err
+		}
+		if filename, err = SanitizeFilename(normalizeNFC(filename)); err != nil {
+			return "", err
+		}
+		ext := path.Ext(filename)
+		data := &FilenameTemplateData{
+			Host:     hresp.Request.URL.Hostname(),
+			Dir:      strings.Trim(path.Dir(hresp.Request.URL.Path), "/."),
+			Filename: filename,
+			Base:     strings.TrimSuffix(filename, ext),
+			Ext:      ext,
+			Response: hresp,
+		}
+		var b strings.Builder
+		if err := t.Execute(&b, data); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}, nil
+}
+
+// requestedFilename returns the destination path of the file of the given
+// Response, if the Request gave a directory or no destination path, using
+// Request.FilenameFunc or otherwise the filename given by the remote server
+// or the URL. Paths returned by FilenameFunc must be relative and may not
+// leave the directory of the Request.
+func requestedFilename(resp *Response) (string, error) {
+	req := resp.Request
+	if req.FilenameFunc == nil {
+		filename, err := guessFilename(resp.HTTPResponse)
+		if err != nil {
+			return "", err
+		}
+		if filename, err = sanitizeFilename(req, filename); err != nil {
+			return "", err
+		}
+		if req.InferExtension {
+			filename = contentTypeExtension(resp.HTTPResponse, filename)
+		}
+		return filepath.Join(req.Filename, filename), nil
+	}
+	name, err := req.FilenameFunc(resp.HTTPResponse)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(name, "\x00") || filepath.Clean(name) == "." || strings.HasSuffix(name, "/") {
+		return "", ErrNoFilename
+	}
+	filename, err := extractPath(req.Filename, name)
+	if err != nil {
+		return "", fmt.Errorf("unsafe destination path: %s", name)
+	}
+	return filename, nil
+}
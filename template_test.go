@@ -0,0 +1,68 @@
+package grab
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFilenameTemplate tests that destination paths are derived from the
+// requested URL and the response with Request.FilenameFunc.
+func TestFilenameTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		template string
+		url      string
+		expect   string
+		err      bool
+	}{
+		{"{{.Host}}/{{.Dir}}/{{.Filename}}", "/pub/iso/example.iso", "127.0.0.1/pub/iso/example.iso", false},
+		{"{{.Host}}/{{.Dir}}/{{.Filename}}", "/example.iso", "127.0.0.1/example.iso", false},
+		{"{{.Base}}-{{.Response.Header.Get \"Content-Length\"}}{{.Ext}}", "/dl?filename=example.tar.gz&size=8", "example.tar-8.gz", false},
+		{"../{{.Filename}}", "/example.iso", "", true},
+		{"{{.Missing}}", "/example.iso", "", true},
+		{"", "/example.iso", "", true},
+	}
+	for _, test := range tests {
+		f, err := FilenameTemplate(test.template)
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		req, _ := NewRequest(dir, ts.URL+test.url)
+		req.FilenameFunc = f
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); (err != nil) != test.err {
+			t.Errorf("%s: expected error %v, got: %v", test.template, test.err, err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		expect := filepath.Join(dir, filepath.FromSlash(test.expect))
+		if resp.Filename != expect {
+			t.Errorf("%s: expected %s, got: %s", test.template, expect, resp.Filename)
+		}
+		testSize(t, expect, resp.Size)
+	}
+
+	if _, err := FilenameTemplate("{{.Host"); err == nil {
+		t.Errorf("expected error for malformed template")
+	}
+
+	req, _ := NewRequest(dir, ts.URL+"/example.iso")
+	req.FilenameFunc = func(hresp *http.Response) (string, error) {
+		return strings.ToUpper(hresp.Request.URL.Path[1:]), nil
+	}
+	resp := DefaultClient.Do(req)
+	if err := resp.Err(); err != nil || resp.Filename != filepath.Join(dir, "EXAMPLE.ISO") {
+		t.Errorf("expected callback filename, got: %s %v", resp.Filename, err)
+	}
+}
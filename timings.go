@@ -0,0 +1,127 @@
+package grab
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// Timings describes the time spent in each phase of establishing a HTTP
+// connection and receiving the response to the GET request of a file transfer.
+// They may be used to diagnose whether a slow transfer is caused by the
+// network or by the remote server.
+//
+// Phases which did not occur, such as DNS lookups of IP addresses or TLS
+// handshakes of plain HTTP requests, have zero duration. If an existing
+// connection was reused, only ServerProcessing and TimeToFirstByte are set.
+type Timings struct {
+	// DNSLookup is the duration of the DNS lookup of the remote host.
+	DNSLookup time.Duration
+
+	// Connect is the duration of establishing the TCP connection.
+	Connect time.Duration
+
+	// TLSHandshake is the duration of the TLS handshake.
+	TLSHandshake time.Duration
+
+	// ServerProcessing is the duration between writing the request and
+	// receiving the first byte of the response.
+	ServerProcessing time.Duration
+
+	// TimeToFirstByte is the duration between starting the request and
+	// receiving the first byte of the response, including all of the above.
+	TimeToFirstByte time.Duration
+
+	// ConnReused is true if the request was sent over a connection which was
+	// reused from a previous request.
+	ConnReused bool
+}
+
+// timingTrace records the Timings of a HTTP request using httptrace.
+type timingTrace struct {
+	mu                                                 sync.Mutex
+	start, dnsStart, connStart, tlsStart, wroteRequest time.Time
+	timings                                            Timings
+}
+
+// withContext returns a Context derived from ctx which records the timings of
+// a HTTP request sent with it.
+func (c *timingTrace) withContext(ctx context.Context) context.Context {
+	lock := func(f func(now time.Time)) {
+		now := time.Now()
+		c.mu.Lock()
+		f(now)
+		c.mu.Unlock()
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: func(string) {
+			lock(func(now time.Time) { c.start = now })
+		},
+		DNSStart: func(httptrace.DNSStartInfo) {
+			lock(func(now time.Time) { c.dnsStart = now })
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			lock(func(now time.Time) { c.timings.DNSLookup = now.Sub(c.dnsStart) })
+		},
+		ConnectStart: func(string, string) {
+			lock(func(now time.Time) {
+				// dual-stack dials may start more than one connection
+				if c.connStart.IsZero() {
+					c.connStart = now
+				}
+			})
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err != nil {
+				return
+			}
+			lock(func(now time.Time) { c.timings.Connect = now.Sub(c.connStart) })
+		},
+		TLSHandshakeStart: func() {
+			lock(func(now time.Time) { c.tlsStart = now })
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			lock(func(now time.Time) { c.timings.TLSHandshake = now.Sub(c.tlsStart) })
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			lock(func(time.Time) { c.timings.ConnReused = info.Reused })
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			lock(func(now time.Time) { c.wroteRequest = now })
+		},
+		GotFirstResponseByte: func() {
+			lock(func(now time.Time) {
+				c.timings.ServerProcessing = now.Sub(c.wroteRequest)
+				c.timings.TimeToFirstByte = now.Sub(c.start)
+			})
+		},
+	})
+}
+
+// Timings returns the Timings of the most recent GET request sent to transfer
+// the file. Timings are not recorded for the requests of segmented transfers,
+// or if no GET request was required because the file was already complete.
+func (c *Response) Timings() Timings {
+	c.progressMu.Lock()
+	trace := c.trace
+	c.progressMu.Unlock()
+	if trace == nil {
+		return Timings{}
+	}
+	trace.mu.Lock()
+	defer trace.mu.Unlock()
+	return trace.timings
+}
+
+// traceContext returns a Context derived from the Context of the current
+// attempt which records the Timings of the GET request sent with it, replacing
+// the Timings of any previous request.
+func (c *Response) traceContext() context.Context {
+	trace := &timingTrace{}
+	c.progressMu.Lock()
+	c.trace = trace
+	c.progressMu.Unlock()
+	return trace.withContext(c.attemptCtx)
+}
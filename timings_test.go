@@ -0,0 +1,43 @@
+package grab
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestTimings tests that the timings of the GET request of a transfer are
+// recorded.
+func TestTimings(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		w.Header().Set("Content-Length", "1024")
+		w.Write(make([]byte, 1024))
+	}))
+	defer ts.Close()
+
+	filename := ".testTimings"
+	defer os.Remove(filename)
+
+	client := NewClient()
+	client.HTTPClient = ts.Client()
+	req, _ := NewRequest(filename, ts.URL)
+	resp := client.Do(req)
+	testComplete(t, resp)
+
+	timings := resp.Timings()
+	if timings.ConnReused {
+		t.Errorf("expected new connection")
+	}
+	if timings.Connect <= 0 || timings.TLSHandshake <= 0 {
+		t.Errorf("expected connect and TLS handshake timings, got: %+v", timings)
+	}
+	if timings.ServerProcessing < 10*time.Millisecond {
+		t.Errorf("expected server processing of at least 10ms, got: %v", timings.ServerProcessing)
+	}
+	if timings.TimeToFirstByte < timings.Connect+timings.TLSHandshake+timings.ServerProcessing {
+		t.Errorf("expected time to first byte to include all phases, got: %+v", timings)
+	}
+}
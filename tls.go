@@ -0,0 +1,128 @@
+package grab
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+)
+
+// A TLSPolicy configures the TLS connections of the HTTPS requests of a
+// Client or Request, without building a http.Transport for each case. The
+// http.Client of the Client must use a *http.Transport, or the default
+// transport, which is cloned for each TLSPolicy. A TLSPolicy must not be
+// modified once it is in use.
+type TLSPolicy struct {
+	// MinVersion is the minimum TLS version which is accepted, such as
+	// tls.VersionTLS13. It is only applied if it is more restrictive than the
+	// TLS configuration of the transport.
+	MinVersion uint16
+
+	// RootCAs, if not nil, replaces the root certificate authorities which are
+	// trusted to verify the certificates of remote servers.
+	RootCAs *x509.CertPool
+
+	// Certificates, if not empty, are the client certificates presented to
+	// remote servers which request them.
+	Certificates []tls.Certificate
+
+	// PinnedKeys and PinnedCertificates, if not empty, are the SHA-256 hashes
+	// of the DER encoded SubjectPublicKeyInfo, and of the DER encoded
+	// certificates, of which at least one must be in the verified certificate
+	// chain of the remote server, as with the pin-sha256 of HPKP, so that a
+	// compromised certificate authority cannot impersonate the server. If
+	// certificates are not verified, only the certificate of the server is
+	// considered. Connections to servers which do not match fail with
+	// ErrPinMismatch.
+	PinnedKeys         [][]byte
+	PinnedCertificates [][]byte
+}
+
+// apply applies the policy to the given TLS configuration.
+func (c *TLSPolicy) apply(cfg *tls.Config) {
+	if c == nil {
+		return
+	}
+	if c.MinVersion > cfg.MinVersion {
+		cfg.MinVersion = c.MinVersion
+	}
+	if c.RootCAs != nil {
+		cfg.RootCAs = c.RootCAs
+	}
+	if len(c.Certificates) > 0 {
+		cfg.Certificates = c.Certificates
+	}
+	if len(c.PinnedKeys) > 0 || len(c.PinnedCertificates) > 0 {
+		// the transport may be shared by any policy with the same fingerprint
+		pins := TLSPolicy{PinnedKeys: c.PinnedKeys, PinnedCertificates: c.PinnedCertificates}
+		next := cfg.VerifyConnection
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			if !pins.matchPins(cs) {
+				return ErrPinMismatch
+			}
+			if next != nil {
+				return next(cs)
+			}
+			return nil
+		}
+	}
+}
+
+// fingerprint returns a digest of the content of the policy, so that
+// transports are cloned once for all policies with the same content, rather
+// than for each *TLSPolicy. RootCAs is identified by its address, since a
+// CertPool cannot be enumerated. The fingerprint of a nil policy is empty.
+func (c *TLSPolicy) fingerprint() string {
+	if c == nil {
+		return ""
+	}
+	h := sha256.New()
+	write := func(b []byte) {
+		binary.Write(h, binary.BigEndian, uint32(len(b)))
+		h.Write(b)
+	}
+	fmt.Fprintf(h, "%d %p %d %d %d|", c.MinVersion, c.RootCAs, len(c.Certificates), len(c.PinnedKeys), len(c.PinnedCertificates))
+	for _, cert := range c.Certificates {
+		binary.Write(h, binary.BigEndian, uint32(len(cert.Certificate)))
+		for _, der := range cert.Certificate {
+			write(der)
+		}
+	}
+	for _, pin := range c.PinnedKeys {
+		write(pin)
+	}
+	for _, pin := range c.PinnedCertificates {
+		write(pin)
+	}
+	return string(h.Sum(nil))
+}
+
+// matchPins returns true if any certificate of the verified chains of the
+// given connection, or the certificate of the server if the chains were not
+// verified, matches a pin of the policy.
+func (c *TLSPolicy) matchPins(cs tls.ConnectionState) bool {
+	var certs []*x509.Certificate
+	for _, chain := range cs.VerifiedChains {
+		certs = append(certs, chain...)
+	}
+	if len(cs.VerifiedChains) == 0 && len(cs.PeerCertificates) > 0 {
+		certs = cs.PeerCertificates[:1]
+	}
+	for _, cert := range certs {
+		key := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		sum := sha256.Sum256(cert.Raw)
+		for _, pin := range c.PinnedKeys {
+			if bytes.Equal(pin, key[:]) {
+				return true
+			}
+		}
+		for _, pin := range c.PinnedCertificates {
+			if bytes.Equal(pin, sum[:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package grab
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// testCertificate returns a new self-signed certificate for TLS clients.
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "grab"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// TestTLSPolicy tests that the TLSPolicy of a Client and Request configure
+// the TLS connections of a transfer.
+func TestTLSPolicy(t *testing.T) {
+	filename := ".testTLSPolicy"
+	defer os.Remove(filename)
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/cert" && len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	s.TLS = &tls.Config{ClientAuth: tls.RequestClientCert, MaxVersion: tls.VersionTLS12}
+	s.StartTLS()
+	defer s.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(s.Certificate())
+
+	client := NewClient()
+	do := func(path string, p *TLSPolicy) error {
+		req, _ := NewRequest(filename, s.URL+path)
+		req.NoResume = true
+		req.TLS = p
+		return client.Do(req).Err()
+	}
+	if err := do("/", nil); err == nil {
+		t.Errorf("expected error for untrusted certificate")
+	}
+	client.TLS = &TLSPolicy{RootCAs: roots}
+	if err := do("/", nil); err != nil {
+		t.Errorf("trusted certificate: %v", err)
+	}
+	if err := do("/cert", nil); !errors.Is(err, StatusCodeError(http.StatusForbidden)) {
+		t.Errorf("expected 403 without client certificate, got: %v", err)
+	}
+	if err := do("/cert", &TLSPolicy{Certificates: []tls.Certificate{testCertificate(t)}}); err != nil {
+		t.Errorf("client certificate: %v", err)
+	}
+	if err := do("/", &TLSPolicy{MinVersion: tls.VersionTLS13}); err == nil {
+		t.Errorf("expected error for TLS version below minimum")
+	}
+}
+
+// TestHTTPSOnly tests that requests and redirects to http:// URLs fail with
+// ErrInsecure if Client.HTTPSOnly is set.
+func TestHTTPSOnly(t *testing.T) {
+	filename := ".testHTTPSOnly"
+	defer os.Remove(filename)
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer plain.Close()
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/downgrade" {
+			http.Redirect(w, r, plain.URL, http.StatusFound)
+			return
+		}
+		w.Write([]byte("hello"))
+	}))
+	defer s.Close()
+
+	client := NewClient()
+	client.HTTPClient = s.Client()
+	client.HTTPSOnly = true
+	for _, test := range []struct {
+		url string
+		ok  bool
+	}{
+		{s.URL, true},
+		{plain.URL, false},
+		{s.URL + "/downgrade", false},
+	} {
+		req, _ := NewRequest(filename, test.url)
+		req.NoResume = true
+		err := client.Do(req).Err()
+		if test.ok && err != nil {
+			t.Errorf("%s: %v", test.url, err)
+		} else if !test.ok && !errors.Is(err, ErrInsecure) {
+			t.Errorf("%s: expected ErrInsecure, got: %v", test.url, err)
+		}
+	}
+}
+
+// TestCertificatePinning tests that connections to servers whose certificate
+// does not match the pins of a TLSPolicy fail with ErrPinMismatch.
+func TestCertificatePinning(t *testing.T) {
+	filename := ".testCertificatePinning"
+	defer os.Remove(filename)
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer s.Close()
+	key := sha256.Sum256(s.Certificate().RawSubjectPublicKeyInfo)
+	cert := sha256.Sum256(s.Certificate().Raw)
+	other := sha256.Sum256([]byte("other"))
+
+	client := NewClient()
+	client.HTTPClient = s.Client()
+	for i, test := range []struct {
+		policy TLSPolicy
+		ok     bool
+	}{
+		{TLSPolicy{PinnedKeys: [][]byte{key[:]}}, true},
+		{TLSPolicy{PinnedCertificates: [][]byte{cert[:]}}, true},
+		{TLSPolicy{PinnedKeys: [][]byte{other[:], key[:]}}, true},
+		{TLSPolicy{PinnedKeys: [][]byte{other[:]}}, false},
+		{TLSPolicy{PinnedKeys: [][]byte{cert[:]}, PinnedCertificates: [][]byte{key[:]}}, false},
+	} {
+		req, _ := NewRequest(filename, s.URL)
+		req.NoResume = true
+		policy := test.policy
+		req.TLS = &policy
+		resp := client.Do(req)
+		err := resp.Err()
+		if test.ok && err != nil {
+			t.Errorf("%d: %v", i, err)
+		} else if !test.ok && (!errors.Is(err, ErrPinMismatch) || resp.Attempts() != 1) {
+			t.Errorf("%d: expected ErrPinMismatch, got: %v", i, err)
+		}
+	}
+
+	// policies with the same content share a transport
+	for i := 0; i < 3; i++ {
+		req, _ := NewRequest(filename, s.URL)
+		req.NoResume = true
+		req.TLS = &TLSPolicy{PinnedKeys: [][]byte{key[:]}}
+		if err := client.Do(req).Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := client.transports.len(); n != 5 {
+		t.Errorf("expected a transport for each of 5 policies, got: %d", n)
+	}
+}
@@ -0,0 +1,110 @@
+package grab
+
+import (
+	"context"
+	"net/http"
+)
+
+// A Tracer starts spans which trace the file transfers of a Client, so that
+// downloads appear in the distributed traces of services that use grab. Set
+// Client.Tracer to enable tracing.
+//
+// Grab does not depend on any tracing library. A Tracer for OpenTelemetry may
+// be implemented by wrapping a trace.Tracer from go.opentelemetry.io/otel:
+//
+//	type otelTracer struct{ t trace.Tracer }
+//
+//	func (c otelTracer) StartSpan(ctx context.Context, name string) (context.Context, grab.Span) {
+//		ctx, span := c.t.Start(ctx, name)
+//		return ctx, otelSpan{span}
+//	}
+//
+// Spans are named "grab.Do", for each call to Client.Do, and "grab.HTTP", for
+// each HTTP request sent to transfer the file. HTTP spans are children of the
+// Do span, as the Context returned by StartSpan is used for each request.
+type Tracer interface {
+	// StartSpan starts a span with the given name as a child of any span in the
+	// given Context and returns a Context containing the new span.
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// A Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute sets an attribute of the span. The value is a string,
+	// int64 or bool.
+	SetAttribute(key string, value interface{})
+
+	// End ends the span, recording the given error, if not nil.
+	End(err error)
+}
+
+// Span attribute keys.
+const (
+	AttrServerAddress  = "server.address"
+	AttrURL            = "url.full"
+	AttrHTTPMethod     = "http.request.method"
+	AttrHTTPStatusCode = "http.response.status_code"
+	AttrFileSize       = "grab.size"
+	AttrAttempts       = "grab.attempts"
+	AttrResumed        = "grab.resumed"
+	AttrChecksum       = "grab.checksum"
+)
+
+// startSpan starts the span of a call to Do, if the Client has a Tracer, and
+// returns the Context for the transfer.
+func (c *Client) startSpan(resp *Response, ctx context.Context) context.Context {
+	if c.Tracer == nil {
+		return ctx
+	}
+	ctx, resp.span = c.Tracer.StartSpan(ctx, "grab.Do")
+	if u := resp.Request.URL(); u != nil {
+		resp.span.SetAttribute(AttrServerAddress, u.Hostname())
+		resp.span.SetAttribute(AttrURL, u.String())
+	}
+	return ctx
+}
+
+// endSpan ends the span of a finalized Response, if any.
+func (c *Client) endSpan(resp *Response) {
+	span := resp.span
+	if span == nil {
+		return
+	}
+	span.SetAttribute(AttrFileSize, resp.Size)
+	span.SetAttribute(AttrAttempts, int64(resp.Attempts()))
+	span.SetAttribute(AttrResumed, resp.DidResume)
+	if resp.HTTPResponse != nil {
+		span.SetAttribute(AttrHTTPStatusCode, int64(resp.HTTPResponse.StatusCode))
+	}
+	if len(resp.Request.checksums) > 0 {
+		result := "none"
+		if resp.Checksums != nil {
+			result = "ok"
+			for _, sum := range resp.Checksums {
+				if !sum.OK() {
+					result = "mismatch"
+				}
+			}
+		}
+		span.SetAttribute(AttrChecksum, result)
+	}
+	span.End(resp.err)
+}
+
+// startHTTPSpan starts the span of a HTTP request sent to transfer the file of
+// the given Response, if the Client has a Tracer. It returns the request with
+// the Context of the span and a function to end the span.
+func (c *Client) startHTTPSpan(resp *Response, req *http.Request) (*http.Request, func(*http.Response, error)) {
+	if c.Tracer == nil {
+		return req, func(*http.Response, error) {}
+	}
+	ctx, span := c.Tracer.StartSpan(req.Context(), "grab.HTTP")
+	span.SetAttribute(AttrHTTPMethod, req.Method)
+	span.SetAttribute(AttrURL, req.URL.String())
+	return req.WithContext(ctx), func(hresp *http.Response, err error) {
+		if hresp != nil {
+			span.SetAttribute(AttrHTTPStatusCode, int64(hresp.StatusCode))
+		}
+		span.End(err)
+	}
+}
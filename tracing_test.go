@@ -0,0 +1,95 @@
+package grab
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"sync"
+	"testing"
+)
+
+type testSpanKey struct{}
+
+// testSpan is a Span which records its attributes and parent.
+type testSpan struct {
+	name   string
+	parent *testSpan
+	attrs  map[string]interface{}
+	ended  bool
+	err    error
+}
+
+func (c *testSpan) SetAttribute(key string, value interface{}) { c.attrs[key] = value }
+func (c *testSpan) End(err error)                              { c.ended, c.err = true, err }
+
+// testTracer is a Tracer which records every span it starts.
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (c *testTracer) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	span := &testSpan{name: name, attrs: make(map[string]interface{})}
+	span.parent, _ = ctx.Value(testSpanKey{}).(*testSpan)
+	c.spans = append(c.spans, span)
+	return context.WithValue(ctx, testSpanKey{}, span), span
+}
+
+// TestTracer tests that a span is started for each transfer and for each of its
+// HTTP requests.
+func TestTracer(t *testing.T) {
+	filename := ".testTracer"
+	defer os.Remove(filename)
+
+	tracer := &testTracer{}
+	client := NewClient()
+	client.Tracer = tracer
+
+	req, _ := NewRequest(filename, ts.URL+"?size=4096")
+	req.SetChecksum(sha256.New(), []byte{0}, false)
+	resp := client.Do(req)
+	if err := resp.Err(); err != ErrBadChecksum {
+		t.Fatalf("expected ErrBadChecksum, got: %v", err)
+	}
+
+	if len(tracer.spans) != 2 {
+		t.Fatalf("expected 2 spans, got: %d", len(tracer.spans))
+	}
+	root := tracer.spans[0]
+	if root.name != "grab.Do" || root.parent != nil {
+		t.Errorf("unexpected root span: %s", root.name)
+	}
+	if !root.ended || root.err != ErrBadChecksum {
+		t.Errorf("expected root span to end with ErrBadChecksum, got: %v", root.err)
+	}
+	for k, v := range map[string]interface{}{
+		AttrFileSize:       int64(4096),
+		AttrAttempts:       int64(1),
+		AttrResumed:        false,
+		AttrHTTPStatusCode: int64(200),
+		AttrChecksum:       "mismatch",
+	} {
+		if root.attrs[k] != v {
+			t.Errorf("expected root attribute %s to be %v, got: %v", k, v, root.attrs[k])
+		}
+	}
+	if root.attrs[AttrServerAddress] != "127.0.0.1" {
+		t.Errorf("unexpected server address: %v", root.attrs[AttrServerAddress])
+	}
+
+	span := tracer.spans[1]
+	if span.name != "grab.HTTP" || span.parent != root {
+		t.Errorf("expected HTTP span to be a child of the root span")
+	}
+	if !span.ended || span.err != nil {
+		t.Errorf("expected HTTP span to end without error, got: %v", span.err)
+	}
+	if span.attrs[AttrHTTPMethod] != "GET" {
+		t.Errorf("expected GET span, got: %v", span.attrs[AttrHTTPMethod])
+	}
+	if span.attrs[AttrHTTPStatusCode] != int64(200) {
+		t.Errorf("unexpected status code: %v", span.attrs[AttrHTTPStatusCode])
+	}
+}
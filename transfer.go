@@ -3,34 +3,94 @@ package grab
 import (
 	"context"
 	"io"
+	"os"
+	"sync"
 	"sync/atomic"
+	"syscall"
 )
 
+// transferer is implemented by any type that copies the content of a remote
+// file to local storage and reports its progress in a thread-safe manner.
+type transferer interface {
+	copy() (written int64, err error)
+	N() int64
+}
+
+// defaultBufferSize is the size in bytes of the transfer buffer if no
+// BufferSize is configured.
+const defaultBufferSize = 32 * 1024
+
+// bufferPools caches transfer buffers for reuse by subsequent transfers. It
+// maps each buffer size to a *sync.Pool of *[]byte.
+var bufferPools sync.Map
+
+// getBuffer returns a buffer of the given size from the buffer pool. The buffer
+// should be returned to the pool with putBuffer once it is no longer used.
+func getBuffer(size int) *[]byte {
+	if size < 1 {
+		size = defaultBufferSize
+	}
+	if p, ok := bufferPools.Load(size); ok {
+		if b, ok := p.(*sync.Pool).Get().(*[]byte); ok {
+			return b
+		}
+	}
+	b := make([]byte, size)
+	return &b
+}
+
+// putBuffer returns a buffer obtained with getBuffer to the buffer pool.
+func putBuffer(b *[]byte) {
+	p, ok := bufferPools.Load(len(*b))
+	if !ok {
+		p, _ = bufferPools.LoadOrStore(len(*b), &sync.Pool{})
+	}
+	p.(*sync.Pool).Put(b)
+}
+
 type transfer struct {
-	n   int64 // must be 64bit aligned on 386
-	ctx context.Context
-	lim RateLimiter
-	w   io.Writer
-	r   io.Reader
-	b   []byte
+	n          int64 // must be 64bit aligned on 386
+	ctx        context.Context
+	gate       *gate
+	lim        RateLimiter
+	w          io.Writer
+	r          io.Reader
+	bufferSize int
+
+	// zeroCopy is true if the transfer used readFrom.
+	zeroCopy bool
 }
 
-func newTransfer(ctx context.Context, lim RateLimiter, dst io.Writer, src io.Reader, buf []byte) *transfer {
+func newTransfer(ctx context.Context, gate *gate, lim RateLimiter, dst io.Writer, src io.Reader, bufferSize int) *transfer {
 	return &transfer{
-		ctx: ctx,
-		lim: lim,
-		w:   dst,
-		r:   src,
-		b:   buf,
+		ctx:        ctx,
+		gate:       gate,
+		lim:        lim,
+		w:          dst,
+		r:          src,
+		bufferSize: bufferSize,
 	}
 }
 
 // copy behaves similarly to io.CopyBuffer except that it checks for cancelation
 // of the given context.Context and reports progress in a thread-safe manner.
+// The transfer buffer is borrowed from the buffer pool for the duration of the
+// copy.
+//
+// If the transfer is not rate limited and the kernel can copy directly from
+// the source to the destination, copy uses readFrom instead, until a rate limit
+// applies.
 func (c *transfer) copy() (written int64, err error) {
-	if c.b == nil {
-		c.b = make([]byte, 32*1024)
+	if c.canReadFrom() {
+		var limited bool
+		written, limited, err = c.readFrom()
+		if !limited {
+			return
+		}
 	}
+	buf := getBuffer(c.bufferSize)
+	defer putBuffer(buf)
+	b := *buf
 	for {
 		select {
 		case <-c.ctx.Done():
@@ -39,15 +99,18 @@ func (c *transfer) copy() (written int64, err error) {
 		default:
 			// keep working
 		}
+		if err = c.gate.wait(c.ctx); err != nil {
+			return
+		}
 		if c.lim != nil {
-			err = c.lim.WaitN(c.ctx, len(c.b))
+			err = c.lim.WaitN(c.ctx, len(b))
 			if err != nil {
 				return
 			}
 		}
-		nr, er := c.r.Read(c.b)
+		nr, er := c.r.Read(b)
 		if nr > 0 {
-			nw, ew := c.w.Write(c.b[0:nr])
+			nw, ew := c.w.Write(b[0:nr])
 			if nw > 0 {
 				written += int64(nw)
 				atomic.StoreInt64(&c.n, written)
@@ -71,6 +134,99 @@ func (c *transfer) copy() (written int64, err error) {
 	return written, err
 }
 
+// zeroCopyChunkSize is the number of bytes copied by readFrom between checks
+// for cancelation and progress updates.
+const zeroCopyChunkSize = 1 << 20
+
+// zeroCopyReader is implemented by readers, such as the bodies of file://
+// responses, which wrap a source that the kernel may copy from directly.
+// zeroCopySource returns the source, positioned at the next unread byte, or
+// nil if there is none.
+type zeroCopyReader interface {
+	zeroCopySource() io.Reader
+}
+
+// unlimited returns true if the given RateLimiter does not currently limit
+// the transfer rate. RateLimiters provided by other packages are assumed to
+// limit the rate.
+func unlimited(lim RateLimiter) bool {
+	if lim == nil {
+		return true
+	}
+	u, ok := lim.(interface{ unlimited() bool })
+	return ok && u.unlimited()
+}
+
+// canReadFrom returns true if the transfer may use readFrom. This requires
+// that the transfer is not rate limited, that the destination implements
+// io.ReaderFrom and that the source is a file or network connection, for which
+// os.File may use copy_file_range, splice or sendfile. Sources which are
+// wrapped in other readers, such as HTTP response bodies or decompressors,
+// gain nothing from io.ReaderFrom and are copied with the transfer buffer. A
+// zeroCopyReader source is replaced with the source it wraps.
+func (c *transfer) canReadFrom() bool {
+	if !unlimited(c.lim) {
+		return false
+	}
+	if _, ok := c.w.(io.ReaderFrom); !ok {
+		return false
+	}
+	r := c.r
+	if z, ok := r.(zeroCopyReader); ok {
+		if r = z.zeroCopySource(); r == nil {
+			return false
+		}
+	}
+	switch src := r.(type) {
+	case *os.File, syscall.Conn:
+	case *io.LimitedReader:
+		if _, ok := src.R.(*os.File); !ok {
+			return false
+		}
+	default:
+		return false
+	}
+	c.r = r
+	return true
+}
+
+// readFrom copies the source to the destination in chunks of
+// zeroCopyChunkSize using io.CopyN, so that the destination's io.ReaderFrom
+// implementation is used without an intermediate buffer. Cancelation, pausing
+// and progress are handled between chunks. If a rate limit applies between
+// chunks, such as a global rate limit set during the transfer, readFrom returns
+// with limited set so that the copy continues with the transfer buffer.
+func (c *transfer) readFrom() (written int64, limited bool, err error) {
+	c.zeroCopy = true
+	for {
+		select {
+		case <-c.ctx.Done():
+			err = c.ctx.Err()
+			return
+		default:
+			// keep working
+		}
+		if err = c.gate.wait(c.ctx); err != nil {
+			return
+		}
+		if !unlimited(c.lim) {
+			return written, true, nil
+		}
+		var n int64
+		n, err = io.CopyN(c.w, c.r, zeroCopyChunkSize)
+		if n > 0 {
+			written += n
+			atomic.StoreInt64(&c.n, written)
+		}
+		if err == io.EOF {
+			return written, false, nil
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
 // N returns the number of bytes transferred.
 func (c *transfer) N() (n int64) {
 	if c == nil {
@@ -79,3 +235,59 @@ func (c *transfer) N() (n int64) {
 	n = atomic.LoadInt64(&c.n)
 	return
 }
+
+// gate blocks the progress of one or more transfers while it is closed. A nil
+// gate is always open.
+type gate struct {
+	mu sync.Mutex
+	ch chan struct{} // non-nil while the gate is closed
+}
+
+// close closes the gate so that all subsequent calls to wait will block until
+// the gate is opened.
+func (c *gate) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ch == nil {
+		c.ch = make(chan struct{})
+	}
+}
+
+// open opens the gate and releases any blocked callers of wait.
+func (c *gate) open() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ch != nil {
+		close(c.ch)
+		c.ch = nil
+	}
+}
+
+// isClosed returns true if the gate is closed.
+func (c *gate) isClosed() bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ch != nil
+}
+
+// wait blocks until the gate is open or the given context is canceled.
+func (c *gate) wait(ctx context.Context) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	ch := c.ch
+	c.mu.Unlock()
+	if ch == nil {
+		return nil
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
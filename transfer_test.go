@@ -0,0 +1,164 @@
+package grab
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestBufferPool tests that transfer buffers of each size are pooled.
+func TestBufferPool(t *testing.T) {
+	for _, size := range []int{0, 8, 4096, defaultBufferSize} {
+		expect := size
+		if expect == 0 {
+			expect = defaultBufferSize
+		}
+		b := getBuffer(size)
+		if len(*b) != expect {
+			t.Errorf("expected buffer of %d bytes, got: %d", expect, len(*b))
+		}
+		putBuffer(b)
+	}
+}
+
+// TestTransferBufferSize tests that transfers copy all content regardless of
+// the buffer size.
+func TestTransferBufferSize(t *testing.T) {
+	src := make([]byte, 10000)
+	for i := range src {
+		src[i] = byte(i)
+	}
+	for _, size := range []int{0, 1, 7, 4096, 65536} {
+		dst := &bytes.Buffer{}
+		tr := newTransfer(context.Background(), nil, nil, dst, bytes.NewReader(src), size)
+		n, err := tr.copy()
+		if err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if n != int64(len(src)) || tr.N() != n || !bytes.Equal(dst.Bytes(), src) {
+			t.Errorf("buffer size %d: expected %d bytes to be copied, got: %d", size, len(src), n)
+		}
+	}
+}
+
+// TestTransferReadFrom tests that transfers between files use the zero-copy
+// fast path.
+func TestTransferReadFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 2*zeroCopyChunkSize+100)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	src, dst := dir+"/src", dir+"/dst"
+	if err := ioutil.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	f, err := os.Create(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newSyncFile(f, &Request{Sync: SyncPeriodic, SyncInterval: zeroCopyChunkSize})
+	defer w.Close()
+
+	tr := newTransfer(context.Background(), nil, NewTokenBucket(1, 1), w, r, 0)
+	if tr.canReadFrom() {
+		t.Errorf("expected rate limited transfer not to use readFrom")
+	}
+	tr = newTransfer(context.Background(), nil, nil, &hashWriter{}, r, 0)
+	if tr.canReadFrom() {
+		t.Errorf("expected transfer to a hash not to use readFrom")
+	}
+	tr = newTransfer(context.Background(), nil, nil, w, r, 0)
+	if !tr.canReadFrom() {
+		t.Fatalf("expected file transfer to use readFrom")
+	}
+	n, err := tr.copy()
+	if err != nil {
+		t.Fatalf("error: %v", err)
+	}
+	if n != int64(len(content)) || tr.N() != n {
+		t.Errorf("expected %d bytes to be copied, got: %d", len(content), n)
+	}
+	if w.n != 100 {
+		t.Errorf("expected 100 unsynced bytes, got: %d", w.n)
+	}
+	if b, _ := ioutil.ReadFile(dst); !bytes.Equal(b, content) {
+		t.Errorf("expected copied content to match source")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tr = newTransfer(ctx, nil, nil, w, r, 0)
+	if _, err := tr.copy(); err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+}
+
+// TestClientReadFrom tests that files at file:// URLs are copied by the
+// zero-copy fast path when they are not rate limited.
+func TestClientReadFrom(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 2*zeroCopyChunkSize+100)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	src := filepath.Join(dir, "src")
+	if err := ioutil.WriteFile(src, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	u := (&url.URL{Scheme: "file", Path: filepath.ToSlash(src)}).String()
+
+	tests := []struct {
+		name     string
+		resumed  int
+		rate     int
+		zeroCopy bool
+	}{
+		{"Copy", 0, 0, true},
+		{"Resume", 1000, 0, true},
+		{"RateLimited", 0, 64 * zeroCopyChunkSize, false},
+	}
+	for _, test := range tests {
+		dst := filepath.Join(dir, test.name)
+		if test.resumed > 0 {
+			if err := ioutil.WriteFile(dst, content[:test.resumed], 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		client := NewClient()
+		client.SetGlobalRateLimit(test.rate)
+		req, _ := NewRequest(dst, u)
+		resp := client.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if resp.DidResume != (test.resumed > 0) {
+			t.Errorf("%s: expected DidResume to be %v", test.name, test.resumed > 0)
+		}
+		if tr := resp.transfer.(*transfer); tr.zeroCopy != test.zeroCopy {
+			t.Errorf("%s: expected zero-copy to be %v", test.name, test.zeroCopy)
+		}
+		if b, _ := ioutil.ReadFile(dst); !bytes.Equal(b, content) {
+			t.Errorf("%s: expected copied content to match source", test.name)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package grab
+
+import (
+	"container/list"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// maxTransports is the number of cloned transports kept by a Client. The idle
+// connections of the least recently used transport are closed when it is
+// evicted.
+const maxTransports = 32
+
+// transportKey identifies a transport cloned for the TLSPolicy of a Client and
+// a Request, by the fingerprints of the policies, and the proxy or Unix domain
+// socket of the Request, so that connections are only reused by requests with
+// the same policy and proxy.
+type transportKey struct {
+	base   http.RoundTripper
+	client string
+	req    string
+	proxy  string
+	unix   string
+}
+
+// transportCache is a bounded cache of cloned transports, keyed by
+// transportKey, which evicts the least recently used transport.
+type transportCache struct {
+	mu    sync.Mutex
+	items map[transportKey]*list.Element
+	order list.List // of *transportEntry, most recently used first
+}
+
+type transportEntry struct {
+	key transportKey
+	t   http.RoundTripper
+}
+
+// load returns the cached transport for the given key.
+func (c *transportCache) load(key transportKey) (http.RoundTripper, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*transportEntry).t, true
+}
+
+// loadOrStore returns the cached transport for the given key, if any, or else
+// caches and returns the given transport. The least recently used transport
+// is evicted if the cache is full.
+func (c *transportCache) loadOrStore(key transportKey, t http.RoundTripper) http.RoundTripper {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(*transportEntry).t
+	}
+	if c.items == nil {
+		c.items = make(map[transportKey]*list.Element)
+	}
+	c.items[key] = c.order.PushFront(&transportEntry{key: key, t: t})
+	for c.order.Len() > maxTransports {
+		e := c.order.Back()
+		c.order.Remove(e)
+		entry := e.Value.(*transportEntry)
+		delete(c.items, entry.key)
+		if ci, ok := entry.t.(interface{ CloseIdleConnections() }); ok {
+			// requests in progress are unaffected
+			ci.CloseIdleConnections()
+		}
+	}
+	return t
+}
+
+// len returns the number of cached transports.
+func (c *transportCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// transport returns the transport of the Client, cloned with the given TLS
+// policies of the Client and the Request, whose fields take precedence, the
+// given proxy, if not nil, and the dialer of the Client, or which connects to
+// the given Unix domain socket, if not empty. See dialContext.
+func (c *Client) transport(client, req *TLSPolicy, proxy *url.URL, unix string) (http.RoundTripper, error) {
+	base := c.HTTPClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	key := transportKey{base: base, client: client.fingerprint(), req: req.fingerprint(), unix: unix}
+	if proxy != nil {
+		key.proxy = proxy.String()
+	}
+	if t, ok := c.transports.load(key); ok {
+		return t, nil
+	}
+	bt, ok := base.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("TLS policies, proxies and dialer options require a *http.Transport, not %T", base)
+	}
+	t := bt.Clone()
+	if bt.TLSClientConfig == nil && bt.DialContext == nil && bt.Dial == nil && bt.DialTLSContext == nil && bt.DialTLS == nil {
+		// the custom dialer and TLS configuration of the clone would
+		// otherwise disable HTTP/2
+		t.ForceAttemptHTTP2 = true
+	}
+	if unix != "" {
+		t.Proxy, t.DialTLSContext, t.DialTLS = nil, nil, nil
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", unix)
+		}
+	} else {
+		t.DialContext = c.dialContext(t.DialContext)
+	}
+	if client != nil || req != nil {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		client.apply(t.TLSClientConfig)
+		req.apply(t.TLSClientConfig)
+	}
+	if proxy != nil {
+		t.Proxy = http.ProxyURL(proxy)
+	}
+	return c.transports.loadOrStore(key, t), nil
+}
+
+// requestKey is the context key of the Request of a HTTP request.
+type requestKey struct{}
+
+// withRequest returns a copy of the given HTTP request, which sends the file
+// of the given Request, so that the policies of the Request are applied by
+// sendHTTP.
+func withRequest(hreq *http.Request, req *Request) *http.Request {
+	if req.RedirectPolicy == nil && req.TLS == nil && req.Proxy == nil && req.UnixSocket == "" {
+		return hreq
+	}
+	return hreq.WithContext(context.WithValue(hreq.Context(), requestKey{}, req))
+}
+
+// sendHTTP sends the given HTTP request with Client.HTTPClient, or a copy of it
+// which enforces Client.HTTPSOnly, Client.TLS, the dialer options of the
+// Client and the RedirectPolicy, TLSPolicy, proxy and Unix domain socket of
+// any Request of the HTTP request, or which attempts HTTP/3. See
+// HTTP3Config.
+func (c *Client) sendHTTP(hreq *http.Request) (*http.Response, error) {
+	var redirects *RedirectPolicy
+	var reqTLS *TLSPolicy
+	var proxy *url.URL
+	var unix string
+	if req, ok := hreq.Context().Value(requestKey{}).(*Request); ok {
+		redirects, reqTLS, proxy, unix = req.RedirectPolicy, req.TLS, req.Proxy, req.UnixSocket
+	}
+	useHTTP3 := c.HTTP3 != nil && c.HTTP3.Transport != nil
+	if redirects == nil && reqTLS == nil && proxy == nil && unix == "" && c.TLS == nil && !c.customDial() && !c.HTTPSOnly && !useHTTP3 {
+		return c.HTTPClient.Do(hreq)
+	}
+	hc := *c.HTTPClient
+	if redirects != nil {
+		hc.CheckRedirect = redirects.checkRedirect(hc.CheckRedirect)
+	}
+	if c.HTTPSOnly {
+		hc.CheckRedirect = checkHTTPSOnly(hc.CheckRedirect)
+	}
+	if c.TLS != nil || reqTLS != nil || proxy != nil || unix != "" || c.customDial() {
+		t, err := c.transport(c.TLS, reqTLS, proxy, unix)
+		if err != nil {
+			return nil, err
+		}
+		hc.Transport = t
+	} else if useHTTP3 {
+		tcp := hc.Transport
+		if tcp == nil {
+			tcp = http.DefaultTransport
+		}
+		hc.Transport = &http3Fallback{c: c, tcp: tcp}
+	}
+	return hc.Do(hreq)
+}
+
+// insecureSchemes are the URL schemes which are refused by Client.HTTPSOnly.
+var insecureSchemes = map[string]bool{"http": true, "ftp": true}
+
+// checkHTTPSOnly returns a CheckRedirect function of an http.Client which
+// forbids redirects to insecure URLs, before calling next, or applying the
+// default limit of 10 redirects if next is nil.
+func checkHTTPSOnly(next func(*http.Request, []*http.Request) error) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if insecureSchemes[req.URL.Scheme] {
+			return fmt.Errorf("%w: redirect to %s", ErrInsecure, req.URL.Redacted())
+		}
+		if next != nil {
+			return next(req, via)
+		}
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		return nil
+	}
+}
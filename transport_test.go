@@ -0,0 +1,180 @@
+package grab
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// TestProxy tests that the HTTP requests of a transfer are sent through the
+// HTTP proxy of Request.Proxy, with its credentials.
+func TestProxy(t *testing.T) {
+	filename := ".testProxy"
+	defer os.Remove(filename)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+		if r.Header.Get("Proxy-Authorization") != auth {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.URL.Host != "example.com" {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+
+	for _, user := range []string{"user:secret", ""} {
+		u, _ := url.Parse(proxy.URL)
+		if user != "" {
+			u.User = url.UserPassword("user", "secret")
+		}
+		req, _ := NewRequest(filename, "http://example.com/file")
+		req.NoResume = true
+		req.Proxy = u
+		err := DefaultClient.Do(req).Err()
+		if user == "" {
+			if !IsStatusCodeError(err) {
+				t.Errorf("expected 407 without credentials, got: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		testContent(t, filename, []byte("proxied"))
+	}
+}
+
+// TestSOCKS5Proxy tests that transfers can be sent through a SOCKS5 proxy
+// given by Request.Proxy.
+func TestSOCKS5Proxy(t *testing.T) {
+	filename := ".testSOCKS5Proxy"
+	defer os.Remove(filename)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	}))
+	defer s.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var connects int32
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&connects, 1)
+			go serveSOCKS5(conn)
+		}
+	}()
+
+	req, _ := NewRequest(filename, s.URL)
+	req.NoResume = true
+	req.Proxy = &url.URL{Scheme: "socks5", Host: ln.Addr().String(), User: url.UserPassword("user", "secret")}
+	if err := DefaultClient.Do(req).Err(); err != nil {
+		t.Fatal(err)
+	}
+	testContent(t, filename, []byte("hello"))
+	if atomic.LoadInt32(&connects) == 0 {
+		t.Errorf("expected a connection through the proxy")
+	}
+}
+
+// serveSOCKS5 serves a SOCKS5 CONNECT request of RFC 1928, authenticated with
+// the username "user" and password "secret" of RFC 1929.
+func serveSOCKS5(conn net.Conn) {
+	defer conn.Close()
+	b := make([]byte, 262)
+	if _, err := io.ReadFull(conn, b[:2]); err != nil || b[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(conn, b[:b[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 2})
+	if _, err := io.ReadFull(conn, b[:2]); err != nil {
+		return
+	}
+	user := make([]byte, b[1])
+	io.ReadFull(conn, user)
+	io.ReadFull(conn, b[:1])
+	pass := make([]byte, b[0])
+	io.ReadFull(conn, pass)
+	if string(user) != "user" || string(pass) != "secret" {
+		conn.Write([]byte{1, 1})
+		return
+	}
+	conn.Write([]byte{1, 0})
+
+	if _, err := io.ReadFull(conn, b[:4]); err != nil || b[1] != 1 {
+		return
+	}
+	var host string
+	switch b[3] {
+	case 1:
+		io.ReadFull(conn, b[:4])
+		host = net.IP(b[:4]).String()
+	case 3:
+		io.ReadFull(conn, b[:1])
+		n := int(b[0])
+		io.ReadFull(conn, b[:n])
+		host = string(b[:n])
+	default:
+		return
+	}
+	io.ReadFull(conn, b[:2])
+	port := binary.BigEndian.Uint16(b[:2])
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+// TestTransportCache tests that the transports cloned for each proxy are
+// bounded, evicting the least recently used.
+func TestTransportCache(t *testing.T) {
+	client := NewClient()
+	client.HTTPClient.Transport = &http.Transport{}
+	proxy := func(i int) *url.URL {
+		return &url.URL{Scheme: "http", Host: fmt.Sprintf("proxy%d.example.com:8080", i)}
+	}
+	first, err := client.transport(nil, nil, proxy(0), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < maxTransports*2; i++ {
+		if _, err := client.transport(nil, nil, proxy(i), ""); err != nil {
+			t.Fatal(err)
+		}
+		// keep the first transport in use
+		if tr, _ := client.transport(nil, nil, proxy(0), ""); tr != first {
+			t.Fatalf("expected recently used transport to be kept")
+		}
+	}
+	if n := client.transports.len(); n != maxTransports {
+		t.Errorf("expected %d transports, got: %d", maxTransports, n)
+	}
+	if _, ok := client.transports.load(transportKey{base: client.HTTPClient.Transport, proxy: proxy(1).String()}); ok {
+		t.Errorf("expected least recently used transport to be evicted")
+	}
+}
@@ -0,0 +1,54 @@
+package grab
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// splitUnixURL returns the socket path and the request path of a
+// http+unix:///path/to/socket:/request/path URL.
+func splitUnixURL(path string) (socket, reqPath string, ok bool) {
+	i := strings.Index(path, ":")
+	if i <= 0 {
+		return "", "", false
+	}
+	socket, reqPath = path[:i], path[i+1:]
+	if reqPath == "" {
+		reqPath = "/"
+	}
+	return socket, reqPath, strings.HasPrefix(reqPath, "/")
+}
+
+// unixRoundTrip sends the given request for a http+unix:// or https+unix://
+// URL to the HTTP server listening on the Unix domain socket of the URL.
+// Redirects are not followed.
+func unixRoundTrip(c *Client, req *http.Request) (*http.Response, error) {
+	if req.URL.Host != "" {
+		return nil, fmt.Errorf("malformed %s URL: expected %s:///path/to/socket:/path", req.URL.Scheme, req.URL.Scheme)
+	}
+	socket, reqPath, ok := splitUnixURL(req.URL.Path)
+	if !ok {
+		return nil, fmt.Errorf("malformed %s URL: expected %s:///path/to/socket:/path", req.URL.Scheme, req.URL.Scheme)
+	}
+	var reqTLS *TLSPolicy
+	if r, ok := req.Context().Value(requestKey{}).(*Request); ok {
+		reqTLS = r.TLS
+	}
+	t, err := c.transport(c.TLS, reqTLS, nil, socket)
+	if err != nil {
+		return nil, err
+	}
+	u := *req.URL
+	u.Scheme = strings.TrimSuffix(u.Scheme, "+unix")
+	u.Host, u.Path, u.RawPath = "localhost", reqPath, ""
+	hreq := req.Clone(req.Context())
+	hreq.URL = &u
+	hreq.Host = ""
+	hresp, err := t.RoundTrip(hreq)
+	if err != nil {
+		return nil, err
+	}
+	hresp.Request = req
+	return hresp, nil
+}
@@ -0,0 +1,70 @@
+package grab
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestUnixSocket tests that files are requested from HTTP servers listening
+// on Unix domain sockets, with http+unix:// URLs and Request.UnixSocket.
+func TestUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "http.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("unix domain sockets are not supported: %v", err)
+	}
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host + r.URL.RequestURI()))
+	}))
+	s.Listener = l
+	s.Start()
+	defer s.Close()
+
+	tests := []struct {
+		url    string
+		socket string
+		expect string
+	}{
+		{"http+unix://" + socket + ":/v1/artifact.tar?tag=1", "", "localhost/v1/artifact.tar?tag=1"},
+		{"http://artifacts.internal/v1/artifact.tar", socket, "artifacts.internal/v1/artifact.tar"},
+	}
+	for i, test := range tests {
+		dst := filepath.Join(dir, string(rune('a'+i)))
+		if err := os.Mkdir(dst, 0755); err != nil {
+			t.Fatal(err)
+		}
+		req, err := NewRequest(dst, test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.UnixSocket = test.socket
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("%s: %v", test.url, err)
+		}
+		if filepath.Base(resp.Filename) != "artifact.tar" {
+			t.Errorf("%s: unexpected filename: %s", test.url, resp.Filename)
+		}
+		testContent(t, resp.Filename, []byte(test.expect))
+	}
+
+	for _, urlStr := range []string{
+		"http+unix://localhost/v1/artifact.tar",
+		"http+unix:///v1/artifact.tar",
+	} {
+		req, _ := NewRequest(dir, urlStr)
+		if err := DefaultClient.Do(req).Err(); err == nil {
+			t.Errorf("%s: expected error for malformed URL", urlStr)
+		}
+	}
+}
@@ -2,14 +2,17 @@ package grab
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"hash"
+	"io/ioutil"
 	"mime"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 )
 
@@ -29,28 +32,82 @@ func setLastModified(resp *http.Response, filename string) error {
 	return os.Chtimes(filename, lastmod, lastmod)
 }
 
-// mkdirp creates all missing parent directories for the destination file path.
-func mkdirp(path string) error {
+// mkdirp creates all missing parent directories for the destination file path
+// of the given Request. If a parent exists but is not a directory,
+// ErrNotDirectory is returned.
+func mkdirp(req *Request, path string) error {
 	dir := filepath.Dir(path)
-	if fi, err := os.Stat(dir); err != nil {
-		if !os.IsNotExist(err) {
-			return fmt.Errorf("error checking destination directory: %v", err)
+	fi, err := os.Stat(dir)
+	if err == nil {
+		if !fi.IsDir() {
+			return &os.PathError{Op: "mkdir", Path: dir, Err: ErrNotDirectory}
 		}
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			return fmt.Errorf("error creating destination directory: %v", err)
-		}
-	} else if !fi.IsDir() {
-		panic("destination path is not directory")
+		return nil
+	}
+	if errors.Is(err, syscall.ENOTDIR) {
+		// a parent of dir is not a directory
+		return mkdirp(req, dir)
+	}
+	if !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+		// the root of a volume, such as a UNC share, cannot be created
+		return fmt.Errorf("error checking destination directory: %w", err)
+	}
+	if err := mkdirp(req, dir); err != nil {
+		return err
+	}
+	if err := mkdir(req, dir); err != nil {
+		return fmt.Errorf("error creating destination directory: %w", err)
+	}
+	return nil
+}
+
+// mkdir creates the given directory, with the permissions and owner given by
+// Request.DirMode, Request.FileMode and Request.Owner. So that concurrent
+// transfers may create the same directories, a directory created meanwhile by
+// another process is not an error. A directory with custom permissions or
+// owner is created and configured with a temporary name, and then renamed, so
+// that it is never observed with other permissions.
+func mkdir(req *Request, dir string) error {
+	if req.DirMode == 0 && req.FileMode == 0 && req.Owner == nil {
+		return existingDir(dir, os.Mkdir(dir, 0755))
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), "."+filepath.Base(dir)+".tmp")
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, req.dirMode()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := setOwner(tmp, req.Owner); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		os.Remove(tmp)
+		return existingDir(dir, err)
 	}
 	return nil
 }
 
+// existingDir returns nil if err is nil or the given directory exists.
+// Otherwise, err is returned.
+func existingDir(dir string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if fi, serr := os.Stat(dir); serr == nil && fi.IsDir() {
+		return nil
+	}
+	return err
+}
+
 // guessFilename returns a filename for the given http.Response.
 func guessFilename(resp *http.Response) (string, error) {
 	filename := resp.Request.URL.Path
 	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
-		if _, params, err := mime.ParseMediaType(cd); err == nil && params["filename"] != "" {
-			if hFilename, err := normalizeFilename(params["filename"]); err == nil {
+		if name := dispositionFilename(cd); name != "" {
+			if hFilename, err := normalizeFilename(name); err == nil {
 				return hFilename, nil
 			}
 		}
@@ -59,15 +116,63 @@ func guessFilename(resp *http.Response) (string, error) {
 	return normalizeFilename(filename)
 }
 
-// normalizeFilename sanitizes and strips filename from unnecessary symbols.
-// If none can be determined ErrNoFilename is returned.
+// contentTypeExtensions are the preferred filename extensions of common media
+// types, where mime.ExtensionsByType would choose an unusual extension or
+// the system has no MIME type database.
+var contentTypeExtensions = map[string]string{
+	"application/gzip":         ".gz",
+	"application/json":         ".json",
+	"application/pdf":          ".pdf",
+	"application/x-bzip2":      ".bz2",
+	"application/x-gzip":       ".gz",
+	"application/x-tar":        ".tar",
+	"application/x-xz":         ".xz",
+	"application/xml":          ".xml",
+	"application/zip":          ".zip",
+	"application/zstd":         ".zst",
+	"audio/mpeg":               ".mp3",
+	"image/gif":                ".gif",
+	"image/jpeg":               ".jpg",
+	"image/png":                ".png",
+	"image/svg+xml":            ".svg",
+	"image/webp":               ".webp",
+	"text/csv":                 ".csv",
+	"text/html":                ".html",
+	"text/plain":               ".txt",
+	"video/mp4":                ".mp4",
+	"application/octet-stream": "",
+}
+
+// contentTypeExtension returns the given filename with the extension of the
+// Content-Type of the given http.Response appended, if the filename has no
+// extension and the media type has a known extension.
+func contentTypeExtension(resp *http.Response, filename string) string {
+	if path.Ext(filename) != "" {
+		return filename
+	}
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return filename
+	}
+	ext, ok := contentTypeExtensions[mediaType]
+	if !ok {
+		if exts, _ := mime.ExtensionsByType(mediaType); len(exts) > 0 {
+			ext = exts[0]
+		}
+	}
+	return filename + ext
+}
+
+// normalizeFilename strips filename from unnecessary symbols, returning the
+// last element of its slash-separated path. If none can be determined
+// ErrNoFilename is returned. Filenames are made safe for local file systems
+// by sanitizeFilename.
 func normalizeFilename(filename string) (string, error) {
-	// sanitize
 	if filename == "" || strings.HasSuffix(filename, "/") || strings.Contains(filename, "\x00") {
 		return "", ErrNoFilename
 	}
 
-	filename = filepath.Base(path.Clean("/" + filename))
+	filename = path.Base(path.Clean("/" + filename))
 	if filename == "" || filename == "." || filename == "/" {
 		return "", ErrNoFilename
 	}
@@ -75,22 +180,26 @@ func normalizeFilename(filename string) (string, error) {
 	return filename, nil
 }
 
-// checksum returns a hash of the given file, using the given hash algorithm.
-func checksum(ctx context.Context, filename string, h hash.Hash) (b []byte, err error) {
+// checksum returns the hashes of the given file, using each of the given hash
+// algorithms in a single pass.
+func checksum(ctx context.Context, filename string, hs []hash.Hash) (b [][]byte, err error) {
 	var f *os.File
 	f, err = os.Open(filename)
 	if err != nil {
 		return
 	}
 	defer func() {
-		err = f.Close()
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
 	}()
 
-	t := newTransfer(ctx, nil, h, f, nil)
+	w := &hashWriter{hs: hs}
+	t := newTransfer(ctx, nil, nil, w, f, 0)
 	if _, err = t.copy(); err != nil {
 		return
 	}
 
-	b = h.Sum(nil)
+	b = w.sums()
 	return
 }
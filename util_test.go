@@ -1,9 +1,16 @@
 package grab
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
 	"testing"
 )
 
@@ -156,3 +163,107 @@ func TestHeaderFilenames(t *testing.T) {
 		}
 	})
 }
+
+func TestContentTypeExtension(t *testing.T) {
+	tests := []struct {
+		filename, contentType, expect string
+	}{
+		{"latest", "application/gzip", "latest.gz"},
+		{"latest", "image/jpeg", "latest.jpg"},
+		{"latest", "text/plain; charset=utf-8", "latest.txt"},
+		{"latest", "application/octet-stream", "latest"},
+		{"latest", "application/x-unknown-type", "latest"},
+		{"latest", "", "latest"},
+		{"example.tar", "application/gzip", "example.tar"},
+	}
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{"Content-Type": {test.contentType}}}
+		if actual := contentTypeExtension(resp, test.filename); actual != test.expect {
+			t.Errorf("%s %s: expected %s, got: %s", test.filename, test.contentType, test.expect, actual)
+		}
+	}
+
+	// Request.InferExtension
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Write([]byte("PK"))
+	}))
+	defer s.Close()
+	for _, infer := range []bool{false, true} {
+		req, _ := NewRequest(dir, s.URL+"/releases/latest")
+		req.InferExtension = infer
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		expect := "latest"
+		if infer {
+			expect = "latest.zip"
+		}
+		if resp.Filename != filepath.Join(dir, expect) {
+			t.Errorf("expected %s, got: %s", expect, resp.Filename)
+		}
+	}
+}
+
+// TestMkdirp tests that mkdirp returns ErrNotDirectory if a parent of the
+// destination path is a file, and that concurrent calls create the same
+// directories with the permissions given by Request.DirMode.
+func TestMkdirp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "grab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := NewRequest(filepath.Join(file, "a", "b"), "http://example.com/")
+	for _, filename := range []string{filepath.Join(file, "a"), filepath.Join(file, "a", "b")} {
+		if err := mkdirp(req, filename); !errors.Is(err, ErrNotDirectory) || !errors.Is(err, ErrFilesystem) {
+			t.Errorf("%s: expected ErrNotDirectory, got: %v", filename, err)
+		}
+	}
+
+	for _, mode := range []os.FileMode{0, 0700} {
+		tree := filepath.Join(dir, fmt.Sprintf("tree%o", mode), "a", "b", "c")
+		var wg sync.WaitGroup
+		errs := make([]error, 16)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				req, _ := NewRequest("", "http://example.com/")
+				req.DirMode = mode
+				errs[i] = mkdirp(req, filepath.Join(tree, fmt.Sprintf("file%d", i)))
+			}(i)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		fi, err := os.Stat(tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.IsDir() {
+			t.Fatalf("%s: expected a directory", tree)
+		}
+		if mode != 0 && runtime.GOOS != "windows" && fi.Mode().Perm() != mode {
+			t.Errorf("%s: expected mode %#o, got: %#o", tree, mode, fi.Mode().Perm())
+		}
+		names, _ := ioutil.ReadDir(filepath.Dir(tree))
+		if len(names) != 1 {
+			t.Errorf("expected no temporary directories, got: %v", names)
+		}
+	}
+}
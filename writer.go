@@ -0,0 +1,85 @@
+package grab
+
+import (
+	"io"
+	"os"
+)
+
+// errWriterSignature indicates that a signature was set on a Request whose
+// destination is an io.Writer, which cannot be read back to verify it.
+var errWriterSignature = newError(ErrValidation, "signatures cannot be verified for io.Writer destinations")
+
+// errWriterOpen indicates that Response.Open was called for a transfer whose
+// destination is an io.Writer.
+var errWriterOpen = newError(ErrFilesystem, "io.Writer destinations cannot be opened")
+
+// openStream prepares the transfer of the remote file of the given Response to
+// the io.Writer set with Request.SetWriter. As the destination cannot be read
+// back, any checksums are computed as the content is written. The next stateFunc
+// is copyFile, which is called later in another goroutine.
+func (c *Client) openStream(resp *Response) stateFunc {
+	req := resp.Request
+	if req.verifier != nil {
+		resp.err = errWriterSignature
+		return c.closeResponse
+	}
+	if resp.bufferSize < 1 {
+		resp.bufferSize = defaultBufferSize
+	}
+
+	r, err := resp.bodyReader()
+	if err != nil {
+		resp.err = err
+		return c.closeResponse
+	}
+
+	w := req.writer
+	if len(req.checksums) > 0 {
+		resp.hasher = &hashWriter{hs: hashes(req.checksums)}
+		w = io.MultiWriter(w, resp.hasher)
+	}
+	w = teeWriters(req, w)
+
+	resp.setTransfer(newTransfer(
+		resp.attemptCtx,
+		resp.gate,
+		c.rateLimiter(resp),
+		w,
+		r,
+		resp.bufferSize))
+	return nil
+}
+
+// teeWriters returns a writer which writes to the given destination and to
+// each writer added with Request.AddWriter.
+func teeWriters(req *Request, w io.Writer) io.Writer {
+	if len(req.writers) == 0 {
+		return w
+	}
+	return io.MultiWriter(append([]io.Writer{w}, req.writers...)...)
+}
+
+// primeWriters writes the existing content of a resumed destination file to
+// each writer added with Request.AddWriter, before the remaining content is
+// transferred.
+func (c *Client) primeWriters(resp *Response) error {
+	req := resp.Request
+	if len(req.writers) == 0 || req.writer != nil || resp.bytesResumed == 0 {
+		return nil
+	}
+	f, err := os.Open(resp.path())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := io.MultiWriter(req.writers...)
+	t := newTransfer(resp.ctx, nil, nil, w, io.LimitReader(f, resp.bytesResumed), 0)
+	n, err := t.copy()
+	if err != nil {
+		return err
+	}
+	if n != resp.bytesResumed {
+		return ErrBadLength
+	}
+	return nil
+}
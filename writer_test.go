@@ -0,0 +1,139 @@
+package grab
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSetWriter tests that files are transferred to the io.Writer set with
+// Request.SetWriter, instead of local storage.
+func TestSetWriter(t *testing.T) {
+	filename := ".testSetWriter"
+	defer os.Remove(filename)
+
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	sum := sha256.Sum256(content)
+
+	t.Run("Buffer", func(t *testing.T) {
+		b := &bytes.Buffer{}
+		req, _ := NewRequest(filename, ts.URL+"?size=4096")
+		req.SetWriter(b)
+		req.SetChecksum(sha256.New(), sum[:], true)
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if resp.BytesComplete() != 4096 || !bytes.Equal(b.Bytes(), content) {
+			t.Errorf("expected content to be written to buffer")
+		}
+		if len(resp.Checksums) != 1 || !resp.Checksums[0].OK() {
+			t.Errorf("expected checksum to be validated")
+		}
+		if _, err := os.Stat(filename); !os.IsNotExist(err) {
+			t.Errorf("expected no file to be created")
+		}
+	})
+
+	t.Run("BadChecksum", func(t *testing.T) {
+		req, _ := NewRequest(filename, ts.URL+"?size=4096")
+		req.SetWriter(io.Discard)
+		req.SetChecksum(sha256.New(), make([]byte, sha256.Size), true)
+		if err := DefaultClient.Do(req).Err(); err != ErrBadChecksum {
+			t.Errorf("expected ErrBadChecksum, got: %v", err)
+		}
+	})
+
+	t.Run("Signature", func(t *testing.T) {
+		req, _ := NewRequest(filename, ts.URL+"?size=4096")
+		req.SetWriter(io.Discard)
+		req.SetSignature(SignatureVerifierFunc(func(signed, signature io.Reader) error {
+			return nil
+		}), ts.URL+"/sig", false)
+		if err := DefaultClient.Do(req).Err(); err != errWriterSignature {
+			t.Errorf("expected signature error, got: %v", err)
+		}
+	})
+
+	t.Run("NoRetry", func(t *testing.T) {
+		// fail after writing some content
+		var requests int32
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&requests, 1)
+			w.Header().Set("Content-Length", "4096")
+			w.Write(content[:1024])
+		}))
+		defer s.Close()
+
+		b := &bytes.Buffer{}
+		req, _ := NewRequest("", s.URL)
+		req.SetWriter(b)
+		req.RetryPolicy = &RetryPolicy{MaxAttempts: 3, Backoff: func(int) time.Duration { return 0 }}
+		if err := DefaultClient.Do(req).Err(); err == nil {
+			t.Fatalf("expected error")
+		}
+		if n := atomic.LoadInt32(&requests); n != 1 {
+			t.Errorf("expected 1 request, got: %d", n)
+		}
+		if b.Len() != 1024 {
+			t.Errorf("expected 1024 bytes to be written, got: %d", b.Len())
+		}
+	})
+}
+
+func TestGetBytes(t *testing.T) {
+	b, err := GetBytes(ts.URL + "?size=4096")
+	if err != nil {
+		t.Fatalf("error in GetBytes(): %v", err)
+	}
+	if len(b) != 4096 {
+		t.Errorf("expected 4096 bytes, got: %d", len(b))
+	}
+}
+
+// TestAddWriter tests that the content of a file transfer is written to each
+// writer added with Request.AddWriter, including the content of a resumed file.
+func TestAddWriter(t *testing.T) {
+	filename := ".testAddWriter"
+	defer os.Remove(filename)
+
+	content := make([]byte, 4096)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	for _, resumed := range []int{0, 1024} {
+		os.Remove(filename)
+		if resumed > 0 {
+			if err := ioutil.WriteFile(filename, content[:resumed], 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		a, b := &bytes.Buffer{}, &bytes.Buffer{}
+		req, _ := NewRequest(filename, ts.URL+"?size=4096")
+		req.AddWriter(a)
+		req.AddWriter(b)
+		req.Segments = 4
+		resp := DefaultClient.Do(req)
+		if err := resp.Err(); err != nil {
+			t.Fatalf("error: %v", err)
+		}
+		if resumed > 0 && !resp.DidResume {
+			t.Errorf("expected transfer to resume")
+		}
+		testSize(t, filename, 4096)
+		if !bytes.Equal(a.Bytes(), content) || !bytes.Equal(b.Bytes(), content) {
+			t.Errorf("expected complete content to be written to each writer")
+		}
+	}
+}